@@ -1,30 +1,86 @@
 package api
 
 import (
+	"crypto/subtle"
+	"encoding/json"
 	"fmt"
+	"image"
 	"net/http"
+	neturl "net/url"
+	"strconv"
 	"strings"
 
 	"github.com/gorilla/mux"
+	"github.com/gsiragusa/short-to-me/audit"
 	"github.com/gsiragusa/short-to-me/config"
 	"github.com/gsiragusa/short-to-me/errors"
 	"github.com/gsiragusa/short-to-me/server"
 	"github.com/gsiragusa/short-to-me/shortener"
+	"github.com/gsiragusa/short-to-me/tenant"
+	"github.com/gsiragusa/short-to-me/webhook"
 	"github.com/sirupsen/logrus"
 )
 
+// listMaxLimit caps the number of short urls listed by a request
+const listMaxLimit = 1000
+
+const (
+	// variantCookie remembers the variant served to a visitor, scoped to the path of the short url
+	variantCookie       = "stm_variant"
+	variantCookieMaxAge = 30 * 24 * 60 * 60
+)
+
 type API struct {
-	le   *logrus.Logger
-	conf *config.AppConfig
-	svc  shortener.Service
+	le      *logrus.Logger
+	conf    *config.AppConfig
+	svc     shortener.Service
+	qrLogo  image.Image
+	keys    Keys
+	domains *domainCache
+	hooks   webhook.Service
+}
+
+// Option configures the optional features of the API
+type Option func(*API)
+
+// WithQrLogo sets the logo that can be drawn at the center of the qr codes
+func WithQrLogo(logo image.Image) Option {
+	return func(api *API) {
+		api.qrLogo = logo
+	}
+}
+
+// WithKeys accepts the api keys of the workspaces, restricting their requests to their workspace
+func WithKeys(keys Keys) Option {
+	return func(api *API) {
+		api.keys = keys
+	}
+}
+
+// WithDomains serves the short urls of the custom domains of the workspaces on their domain
+func WithDomains(domains Domains) Option {
+	return func(api *API) {
+		api.domains = newDomainCache(domains, api.conf.DomainCacheTtl)
+	}
+}
+
+// WithWebhooks manages the webhook endpoints of the workspaces and their deliveries
+func WithWebhooks(hooks webhook.Service) Option {
+	return func(api *API) {
+		api.hooks = hooks
+	}
 }
 
-func NewAPI(le *logrus.Logger, conf *config.AppConfig, svc shortener.Service) *API {
-	return &API{
+func NewAPI(le *logrus.Logger, conf *config.AppConfig, svc shortener.Service, opts ...Option) *API {
+	api := &API{
 		le:   le,
 		conf: conf,
 		svc:  svc,
 	}
+	for _, opt := range opts {
+		opt(api)
+	}
+	return api
 }
 
 // GetRoutes defines the router paths handled by this API
@@ -42,24 +98,126 @@ func (api *API) GetRoutes() []server.RouteConfig {
 			Path:    "/api",
 			Handler: api.readShortUrl,
 		},
+		{
+			Name:    "update-short-url",
+			Method:  http.MethodPatch,
+			Path:    "/api",
+			Handler: api.updateShortUrl,
+		},
 		{
 			Name:    "delete-short-url",
 			Method:  http.MethodDelete,
 			Path:    "/api",
 			Handler: api.deleteShortUrl,
 		},
+		{
+			Name:    "restore-short-url",
+			Method:  http.MethodPost,
+			Path:    "/api/restore",
+			Handler: api.restoreShortUrl,
+		},
 		{
 			Name:    "count-redirects",
 			Method:  http.MethodGet,
 			Path:    "/api/count",
 			Handler: api.countRedirects,
 		},
+		{
+			Name:    "qr-code",
+			Method:  http.MethodGet,
+			Path:    "/api/qr",
+			Handler: api.qrCode,
+		},
+		{
+			Name:    "health",
+			Method:  http.MethodGet,
+			Path:    "/api/health",
+			Handler: api.health,
+		},
+		{
+			Name:    "broken",
+			Method:  http.MethodGet,
+			Path:    "/api/broken",
+			Handler: api.broken,
+		},
+		{
+			Name:    "links",
+			Method:  http.MethodGet,
+			Path:    "/api/links",
+			Handler: api.links,
+		},
+		{
+			Name:    "trash",
+			Method:  http.MethodGet,
+			Path:    "/api/trash",
+			Handler: api.trash,
+		},
+		{
+			Name:    "audit",
+			Method:  http.MethodGet,
+			Path:    "/api/audit",
+			Handler: api.auditLog,
+		},
+		{
+			Name:    "webhooks",
+			Method:  http.MethodGet,
+			Path:    "/api/webhooks",
+			Handler: api.webhooks,
+		},
+		{
+			Name:    "create-webhook",
+			Method:  http.MethodPost,
+			Path:    "/api/webhooks",
+			Handler: api.createWebhook,
+		},
+		{
+			Name:    "delete-webhook",
+			Method:  http.MethodDelete,
+			Path:    "/api/webhooks",
+			Handler: api.deleteWebhook,
+		},
+		{
+			Name:    "deliveries",
+			Method:  http.MethodGet,
+			Path:    "/api/webhooks/deliveries",
+			Handler: api.deliveries,
+		},
+		{
+			Name:    "redeliver",
+			Method:  http.MethodPost,
+			Path:    "/api/webhooks/redeliver",
+			Handler: api.redeliver,
+		},
+		{
+			Name:    "export",
+			Method:  http.MethodGet,
+			Path:    "/api/export",
+			Handler: api.export,
+		},
 		{
 			Name:    "redirect",
 			Method:  http.MethodGet,
 			Path:    "/{shortId}",
 			Handler: api.redirect,
 		},
+		{
+			Name:    "redirect-unlock",
+			Method:  http.MethodPost,
+			Path:    "/{shortId}",
+			Handler: api.redirect,
+		},
+		{
+			Name:    "redirect-path",
+			Method:  http.MethodGet,
+			Path:    "/{shortId}/{path:.*}",
+			Handler: api.redirect,
+		},
+		{
+			Name:    "redirect-path-unlock",
+			Method:  http.MethodPost,
+			Path:    "/{shortId}/{path:.*}",
+			Handler: api.redirect,
+		},
 	}
 }
 
@@ -70,14 +228,91 @@ func (api *API) createShortUrl(w http.ResponseWriter, r *http.Request) error {
 	//
 	// Consumes a url and shortens it
 	// ---
+	// consumes:
+	// - application/json
 	// produces:
 	// - application/json
 	// parameters:
 	// - name: url
 	//   in: query
-	//   description: url to shorten
-	//   required: true
+	//   description: url to shorten, required unless given in the body
+	//   required: false
+	//   type: string
+	// - name: domain
+	//   in: query
+	//   description: custom domain of the workspace serving the short url, the one of the request by default
+	//   required: false
 	//   type: string
+	// - name: body
+	//   in: body
+	//   description: url to shorten and the options of the short url
+	//   required: false
+	//   schema:
+	//     type: object
+	//     properties:
+	//       url:
+	//         type: string
+	//         example: "https://www.google.com"
+	//       password:
+	//         type: string
+	//         description: password that visitors have to provide before being redirected
+	//       max_clicks:
+	//         type: integer
+	//         description: number of redirects after which the short url stops redirecting
+	//       not_before:
+	//         type: string
+	//         format: date-time
+	//         description: the short url doesn't redirect before this time
+	//       not_after:
+	//         type: string
+	//         format: date-time
+	//         description: the short url expires at this time
+	//       pending_url:
+	//         type: string
+	//         description: destination of the visits before not_before
+	//       platform_urls:
+	//         type: object
+	//         description: destinations by platform (ios, android or desktop), replacing url for their visitors
+	//         additionalProperties:
+	//           type: string
+	//       country_urls:
+	//         type: object
+	//         description: destinations by ISO 3166-1 alpha-2 country code, replacing url for their visitors
+	//         additionalProperties:
+	//           type: string
+	//       variants:
+	//         type: array
+	//         description: destinations rotating the visitors redirected to url, according to their weight
+	//         items:
+	//           type: object
+	//           properties:
+	//             name:
+	//               type: string
+	//             url:
+	//               type: string
+	//             weight:
+	//               type: integer
+	//       sticky_variants:
+	//         type: boolean
+	//         description: keeps redirecting a visitor to the first variant it was served, with a cookie
+	//       preview:
+	//         type: boolean
+	//         description: shows the destination to the visitors, who confirm before being redirected
+	//       forward_path:
+	//         type: boolean
+	//         description: redirects /{shortId}/rest/of/path to url followed by /rest/of/path
+	//       query_policy:
+	//         type: string
+	//         enum: [drop, merge, override]
+	//         description: "passes the query of the visits to the destination: drop (default), merge keeping the parameters of the destination, or override them"
+	//       utm:
+	//         type: object
+	//         description: "utm parameters added to the destination, the values can use the {id}, {platform}, {country} and {variant} placeholders"
+	//         additionalProperties:
+	//           type: string
+	//       domain:
+	//         type: string
+	//         description: custom domain of the workspace serving the short url, the one of the request by default
 	//
 	// responses:
 	//   '200':
@@ -101,22 +336,43 @@ func (api *API) createShortUrl(w http.ResponseWriter, r *http.Request) error {
 	//   '500':
 	//     description: Internal Server Error
 
+	if err := api.authenticate(r); err != nil {
+		return server.WriteError(w, r, *err)
+	}
+
 	// parse and validate input
-	url, err := api.parseInput(r)
+	url, opts, err := api.parseCreate(r)
 	if err != nil {
-		return server.WriteError(w, *err)
+		return server.WriteError(w, r, *err)
+	}
+
+	// the short urls created on a custom domain of the workspace are served on it, unless another
+	// domain is chosen
+	if opts.Domain == "" {
+		d, _, err := api.domain(r)
+		if err != nil {
+			return server.WriteError(w, r, *err)
+		}
+		if workspace, ok := tenant.WorkspaceFrom(r.Context()); d != nil && ok && d.Workspace == workspace {
+			opts.Domain = d.Host
+		}
 	}
 
-	encoded, err := api.svc.ShortenUrl(r.Context(), url)
+	encoded, err := api.svc.ShortenUrl(r.Context(), url, opts)
 	if err != nil {
-		return server.WriteError(w, *err)
+		return server.WriteError(w, r, *err)
 	}
 
-	shortUrl := fmt.Sprintf("http://%s/%s", r.Host, encoded)
+	host := r.Host
+	if opts.Domain != "" {
+		host = tenant.NormalizeHost(opts.Domain)
+	}
+	shortUrl := fmt.Sprintf("http://%s/%s", host, encoded)
 	resp := &ResponseApi{
 		Status:    "ok",
 		Operation: "create",
 		Url:       shortUrl,
+		Qr:        fmt.Sprintf("http://%s/api/qr?url=%s", r.Host, neturl.QueryEscape(shortUrl)),
 	}
 	return server.Write(w, http.StatusOK, resp)
 }
@@ -151,6 +407,16 @@ func (api *API) readShortUrl(w http.ResponseWriter, r *http.Request) error {
 	//         url:
 	//           type: string
 	//           example: "https://www.google.com"
+	//         title:
+	//           type: string
+	//         description:
+	//           type: string
+	//         tags:
+	//           type: array
+	//           items:
+	//             type: string
+	//         metadata:
+	//           type: object
 	//   '400':
 	//     description: Not Found
 	//   '404':
@@ -158,21 +424,100 @@ func (api *API) readShortUrl(w http.ResponseWriter, r *http.Request) error {
 	//   '500':
 	//     description: Internal Server Error
 
+	if err := api.authenticate(r); err != nil {
+		return server.WriteError(w, r, *err)
+	}
+
 	// parse and validate input
 	url, err := api.parseInput(r)
 	if err != nil {
-		return server.WriteError(w, *err)
+		return server.WriteError(w, r, *err)
 	}
 
-	res, err := api.svc.RetrieveUrl(r.Context(), url)
+	res, err := api.svc.GetUrl(r.Context(), url)
 	if err != nil {
-		return server.WriteError(w, errors.NewErrorNotFound())
+		return server.WriteError(w, r, *err)
 	}
 
 	resp := &ResponseApi{
 		Status:    "ok",
 		Operation: "read",
-		Url:       res,
+		Url:       res.Url,
+		Labels:    &res.Labels,
+	}
+	return server.Write(w, http.StatusOK, resp)
+}
+
+func (api *API) updateShortUrl(w http.ResponseWriter, r *http.Request) error {
+	// swagger:operation PATCH /api Api updateShortUrl
+	// Update short url
+	//
+	// Changes the title, description, tags or metadata of the short url, the fields missing from the
+	// body are left unchanged. The metadata keys with an empty value are removed
+	// ---
+	// consumes:
+	// - application/json
+	// produces:
+	// - application/json
+	// parameters:
+	// - name: body
+	//   in: body
+	//   required: true
+	//   schema:
+	//     type: object
+	//     properties:
+	//       url:
+	//         type: string
+	//         example: "http://www.example.com/RMAp1Vz"
+	//       title:
+	//         type: string
+	//       description:
+	//         type: string
+	//       tags:
+	//         type: array
+	//         items:
+	//           type: string
+	//       metadata:
+	//         type: object
+	//
+	// responses:
+	//   '200':
+	//     description: "Short url with its labels"
+	//   '400':
+	//     description: Bad Request
+	//   '404':
+	//     description: Not Found
+	//   '500':
+	//     description: Internal Server Error
+
+	if err := api.authenticate(r); err != nil {
+		return server.WriteError(w, r, *err)
+	}
+
+	body := &RequestUpdate{}
+	if err := json.NewDecoder(r.Body).Decode(body); err != nil {
+		api.le.WithError(err).Error("unable to decode request body")
+		e := errors.NewErrorBadRequest().WithDetail("body", "must be a valid json object")
+		return server.WriteError(w, r, e)
+	}
+	if body.Url == "" {
+		body.Url = r.URL.Query().Get("url")
+	}
+	url, err := api.validateUrl(body.Url)
+	if err != nil {
+		return server.WriteError(w, r, *err)
+	}
+
+	res, err := api.svc.UpdateUrl(r.Context(), url, body.LinkUpdate)
+	if err != nil {
+		return server.WriteError(w, r, *err)
+	}
+
+	resp := &ResponseApi{
+		Status:    "ok",
+		Operation: "update",
+		Url:       url,
+		Labels:    &res.Labels,
 	}
 	return server.Write(w, http.StatusOK, resp)
 }
@@ -214,15 +559,19 @@ func (api *API) deleteShortUrl(w http.ResponseWriter, r *http.Request) error {
 	//   '500':
 	//     description: Internal Server Error
 
+	if err := api.authenticate(r); err != nil {
+		return server.WriteError(w, r, *err)
+	}
+
 	// parse and validate input
 	url, err := api.parseInput(r)
 	if err != nil {
-		return server.WriteError(w, *err)
+		return server.WriteError(w, r, *err)
 	}
 
 	err = api.svc.DeleteUrl(r.Context(), url)
 	if err != nil {
-		return server.WriteError(w, errors.NewErrorNotFound())
+		return server.WriteError(w, r, *err)
 	}
 
 	resp := &ResponseApi{
@@ -233,6 +582,66 @@ func (api *API) deleteShortUrl(w http.ResponseWriter, r *http.Request) error {
 	return server.Write(w, http.StatusOK, resp)
 }
 
+func (api *API) restoreShortUrl(w http.ResponseWriter, r *http.Request) error {
+	// swagger:operation POST /api/restore Api restoreShortUrl
+	// Restore short url
+	//
+	// Restores a deleted short url, until it is purged after the retention period
+	// ---
+	// produces:
+	// - application/json
+	// parameters:
+	// - name: url
+	//   in: query
+	//   description: short url to restore
+	//   required: true
+	//   type: string
+	//
+	// responses:
+	//   '200':
+	//     description: "Operation result"
+	//     schema:
+	//       type: object
+	//       properties:
+	//         status:
+	//           type: string
+	//           example: "ok"
+	//         operation:
+	//           type: string
+	//           example: "restore"
+	//         url:
+	//           type: string
+	//           example: "http://www.example.com/RMAp1Vz"
+	//   '400':
+	//     description: Bad Request
+	//   '404':
+	//     description: Not Found
+	//   '500':
+	//     description: Internal Server Error
+
+	if err := api.authenticate(r); err != nil {
+		return server.WriteError(w, r, *err)
+	}
+
+	// parse and validate input
+	url, err := api.parseInput(r)
+	if err != nil {
+		return server.WriteError(w, r, *err)
+	}
+
+	err = api.svc.RestoreUrl(r.Context(), url)
+	if err != nil {
+		return server.WriteError(w, r, *err)
+	}
+
+	resp := &ResponseApi{
+		Status:    "ok",
+		Operation: "restore",
+		Url:       url,
+	}
+	return server.Write(w, http.StatusOK, resp)
+}
+
 func (api *API) countRedirects(w http.ResponseWriter, r *http.Request) error {
 	// swagger:operation GET /api/count Api countRedirects
 	// Count number of redirects
@@ -263,6 +672,24 @@ func (api *API) countRedirects(w http.ResponseWriter, r *http.Request) error {
 	//         url:
 	//           type: integer
 	//           example: 2
+	//         destinations:
+	//           type: object
+	//           description: "Redirects by destination, for short urls with platform urls"
+	//           additionalProperties:
+	//             type: integer
+	//           example: {"ios": 1, "default": 1}
+	//         countries:
+	//           type: object
+	//           description: "Redirects by country of the visitors, when GEO_IP_DB_PATH is set"
+	//           additionalProperties:
+	//             type: integer
+	//           example: {"IT": 2}
+	//         variants:
+	//           type: object
+	//           description: "Redirects by variant served, for short urls with variants"
+	//           additionalProperties:
+	//             type: integer
+	//           example: {"a": 1, "b": 1}
 	//   '400':
 	//     description: Not Found
 	//   '404':
@@ -270,21 +697,28 @@ func (api *API) countRedirects(w http.ResponseWriter, r *http.Request) error {
 	//   '500':
 	//     description: Internal Server Error
 
+	if err := api.authenticate(r); err != nil {
+		return server.WriteError(w, r, *err)
+	}
+
 	// parse and validate input
 	url, err := api.parseInput(r)
 	if err != nil {
-		return server.WriteError(w, *err)
+		return server.WriteError(w, r, *err)
 	}
 
 	res, err := api.svc.CountRedirects(r.Context(), url)
 	if err != nil {
-		return server.WriteError(w, errors.NewErrorNotFound())
+		return server.WriteError(w, r, *err)
 	}
 
 	resp := &ResponseCount{
-		Status:    "ok",
-		Operation: "count",
-		Count:     res,
+		Status:       "ok",
+		Operation:    "count",
+		Count:        res.Total,
+		Destinations: res.Destinations,
+		Countries:    res.Countries,
+		Variants:     res.Variants,
 	}
 	return server.Write(w, http.StatusOK, resp)
 }
@@ -297,6 +731,7 @@ func (api *API) redirect(w http.ResponseWriter, r *http.Request) error {
 	// ---
 	// produces:
 	// - application/json
+	// - text/html
 	// parameters:
 	// - name: shortId
 	//   in: path
@@ -307,6 +742,16 @@ func (api *API) redirect(w http.ResponseWriter, r *http.Request) error {
 	// responses:
 	//   '301':
 	//     description: "Redirects to extended url"
+	//   '200':
+	//     description: "Preview page of a short url with preview"
+	//   '302':
+	//     description: "Redirects to extended url, for short urls with options"
+	//   '401':
+	//     description: "Password form of a protected short url"
+	//   '403':
+	//     description: "Coming soon page of a short url that is not active yet"
+	//   '410':
+	//     description: "The short url expired or reached its max clicks"
 	//   '400':
 	//     description: Not Found
 	//   '404':
@@ -314,35 +759,555 @@ func (api *API) redirect(w http.ResponseWriter, r *http.Request) error {
 	//   '500':
 	//     description: Internal Server Error
 
+	// swagger:operation POST /{shortId} Redirect unlockRedirect
+	// Redirect to the extended url of a protected short url
+	//
+	// Submits the password of a protected short url, redirecting when it is correct
+	// ---
+	// consumes:
+	// - application/x-www-form-urlencoded
+	// produces:
+	// - text/html
+	// parameters:
+	// - name: shortId
+	//   in: path
+	//   description: the id of the short url for redirection
+	//   required: true
+	//   type: string
+	// - name: password
+	//   in: formData
+	//   description: the password of the short url
+	//   required: true
+	//   type: string
+	//
+	// responses:
+	//   '303':
+	//     description: "Redirects to extended url"
+	//   '403':
+	//     description: "Password form, the password was wrong"
+	//   '410':
+	//     description: "The short url reached its max clicks"
+	//   '429':
+	//     description: "Password form, too many wrong passwords"
+
+	// swagger:operation GET /{shortId}+ Redirect previewRedirect
+	// Preview the extended url
+	//
+	// Shows the destination of a short url, with a button to follow it, instead of redirecting
+	// ---
+	// produces:
+	// - text/html
+	// parameters:
+	// - name: shortId
+	//   in: path
+	//   description: the id of the short url to preview
+	//   required: true
+	//   type: string
+	//
+	// responses:
+	//   '200':
+	//     description: "Preview page"
+	//   '404':
+	//     description: Not Found
+
+	// swagger:operation GET /{shortId}/{path} Redirect redirectPath
+	// Redirect to the extended url followed by path
+	//
+	// Redirects to the extended url of a short url with forward_path, with path appended to it
+	// ---
+	// produces:
+	// - text/html
+	// parameters:
+	// - name: shortId
+	//   in: path
+	//   description: the id of the short url for redirection
+	//   required: true
+	//   type: string
+	// - name: path
+	//   in: path
+	//   description: the path appended to the extended url
+	//   required: true
+	//   type: string
+	//
+	// responses:
+	//   '302':
+	//     description: "Redirects to extended url followed by path"
+	//   '404':
+	//     description: "The short url doesn't exist or doesn't forward paths"
+
 	vars := mux.Vars(r)
 	id := vars["shortId"]
 
 	if id == "" {
-		return server.WriteError(w, errors.NewErrorBadRequest())
+		return server.WriteError(w, r, errors.NewErrorBadRequest())
+	}
+
+	// the short ids are resolved in the namespace of the domain of the request
+	d, ok, err := api.domain(r)
+	if err != nil {
+		return server.WriteError(w, r, *err)
+	}
+	if !ok {
+		return api.unknownHost(w, r)
+	}
+
+	visit := shortener.Visit{
+		ClientIp:  server.ClientIp(r),
+		UserAgent: r.UserAgent(),
+	}
+	if d != nil {
+		visit.Domain = d.Host
+	}
+	if r.URL.RawQuery != "" {
+		visit.Query = r.URL.Query()
+	}
+	if _, ok := vars["path"]; ok {
+		// the path is forwarded as it was encoded
+		visit.Path = strings.TrimPrefix(r.URL.EscapedPath(), "/"+id)
+	}
+	if cookie, err := r.Cookie(variantCookie); err == nil {
+		visit.Variant = cookie.Value
+	}
+	if r.Method == http.MethodPost {
+		// the password form or the preview was submitted
+		visit.Password = r.PostFormValue("password")
+		visit.Confirmed = true
 	}
 
-	url, err := api.svc.IncrementRedirect(r.Context(), id)
+	// a + after the id previews any short url
+	preview := strings.HasSuffix(id, "+")
+	id = strings.TrimSuffix(id, "+")
+	if preview && r.Method == http.MethodGet {
+		res, err := api.svc.PreviewUrl(r.Context(), id, visit)
+		if err != nil {
+			return api.writeVisitError(w, r, err)
+		}
+		return api.writePreview(w, r, res, visit)
+	}
+
+	res, err := api.svc.IncrementRedirect(r.Context(), id, visit)
 	if err != nil {
-		return server.WriteError(w, errors.NewErrorNotFound())
+		return api.writeVisitError(w, r, err)
+	}
+	if res.Preview != nil {
+		return api.writePreview(w, r, res.Preview, visit)
+	}
+
+	// only the short urls redirecting every visit to the same destination can be cached
+	status := http.StatusFound
+	switch {
+	case r.Method == http.MethodPost:
+		status = http.StatusSeeOther
+	case res.Permanent:
+		status = http.StatusMovedPermanently
+	}
+	if res.StickyVariant != "" {
+		http.SetCookie(w, &http.Cookie{
+			Name:     variantCookie,
+			Value:    res.StickyVariant,
+			Path:     "/" + id,
+			MaxAge:   variantCookieMaxAge,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
 	}
+	http.Redirect(w, r, res.Url, status)
+	return nil
+}
+
+// writeVisitError writes the pages of the errors the visitors can act on, and the other errors
+func (api *API) writeVisitError(w http.ResponseWriter, r *http.Request, err *errors.Error) error {
+	switch err.Code {
+	case errors.CodePasswordRequired, errors.CodeInvalidPassword, errors.CodeRateLimited:
+		return server.WriteHtml(w, err.HttpStatus, passwordTemplate, passwordPage{Error: err})
+	case errors.CodeLinkNotActive:
+		return server.WriteHtml(w, err.HttpStatus, comingSoonTemplate, nil)
+	}
+	return server.WriteError(w, r, *err)
+}
+
+// writePreview writes the preview page, confirming the visit with the same path and query
+func (api *API) writePreview(w http.ResponseWriter, r *http.Request, preview *shortener.Preview, visit shortener.Visit) error {
+	action := "/" + preview.Id + visit.Path
+	if r.URL.RawQuery != "" {
+		action += "?" + r.URL.RawQuery
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	return server.WriteHtml(w, http.StatusOK, previewTemplate, previewPage{Preview: preview, Action: action})
+}
+
+func (api *API) export(w http.ResponseWriter, r *http.Request) error {
+	// swagger:operation GET /api/export Admin export
+	// Export all short urls
+	//
+	// Streams every stored short url with its redirections count. Requires the admin api key
+	// ---
+	// produces:
+	// - application/x-ndjson
+	// - text/csv
+	// parameters:
+	// - name: Authorization
+	//   in: header
+	//   description: "Bearer followed by the admin api key"
+	//   required: true
+	//   type: string
+	// - name: format
+	//   in: query
+	//   description: export format, jsonl (default) or csv
+	//   required: false
+	//   type: string
+	//
+	// responses:
+	//   '200':
+	//     description: "One short url per line. The X-Export-Skipped trailer counts the short urls with options or on a custom domain, skipped by the csv format"
+	//   '400':
+	//     description: Bad Request
+	//   '401':
+	//     description: Unauthorized
+	//   '403':
+	//     description: Forbidden
+	//   '500':
+	//     description: Internal Server Error
 
-	http.Redirect(w, r, url, http.StatusMovedPermanently)
+	if err := api.authorizeAdmin(r); err != nil {
+		return server.WriteError(w, r, *err)
+	}
+
+	format := r.URL.Query().Get("format")
+	contentType := "application/x-ndjson"
+	switch format {
+	case "", shortener.FormatJSONL:
+		format = shortener.FormatJSONL
+	case shortener.FormatCSV:
+		contentType = "text/csv; charset=utf-8"
+	default:
+		e := errors.NewErrorBadRequest().WithDetail("format", "must be jsonl or csv")
+		return server.WriteError(w, r, e)
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=short-urls.%s", format))
+	// the short urls the format can't carry are only known at the end of the stream
+	w.Header().Set("Trailer", "X-Export-Skipped")
+	res, err := api.svc.ExportUrls(r.Context(), w, format)
+	if err != nil {
+		// the response is streamed, the status can't be changed after the first write
+		api.le.WithError(err).Error("export interrupted")
+		return nil
+	}
+	w.Header().Set("X-Export-Skipped", strconv.Itoa(res.Skipped))
 	return nil
 }
 
+func (api *API) links(w http.ResponseWriter, r *http.Request) error {
+	// swagger:operation GET /api/links Admin links
+	// List the short urls
+	//
+	// Lists the short urls, sorted by id, optionally filtered by their labels. Requires the admin api key
+	// ---
+	// produces:
+	// - application/json
+	// parameters:
+	// - name: Authorization
+	//   in: header
+	//   description: "Bearer followed by the admin api key"
+	//   required: true
+	//   type: string
+	// - name: tag
+	//   in: query
+	//   description: tag of the short urls, repeated to list the short urls having all of them
+	//   required: false
+	//   type: string
+	// - name: meta
+	//   in: query
+	//   description: metadata of the short urls as key:value, repeated to list the short urls having all of them
+	//   required: false
+	//   type: string
+	// - name: q
+	//   in: query
+	//   description: text contained in the title of the short urls, ignoring the case
+	//   required: false
+	//   type: string
+	// - name: offset
+	//   in: query
+	//   description: number of short urls to skip, 0 by default
+	//   required: false
+	//   type: integer
+	// - name: limit
+	//   in: query
+	//   description: maximum number of short urls to list, from 1 to 1000, 100 by default
+	//   required: false
+	//   type: integer
+	//
+	// responses:
+	//   '200':
+	//     description: "Short urls with their labels"
+	//   '400':
+	//     description: Bad Request
+	//   '401':
+	//     description: Unauthorized
+	//   '403':
+	//     description: Forbidden
+	//   '500':
+	//     description: Internal Server Error
+
+	if err := api.authorizeAdmin(r); err != nil {
+		return server.WriteError(w, r, *err)
+	}
+
+	opts, err := parseListOptions(r)
+	if err != nil {
+		return server.WriteError(w, r, *err)
+	}
+
+	urls, err := api.svc.ListUrls(r.Context(), opts)
+	if err != nil {
+		return server.WriteError(w, r, *err)
+	}
+
+	resp := &ResponseLinks{
+		Status:    "ok",
+		Operation: "links",
+		Urls:      urls,
+	}
+	return server.Write(w, http.StatusOK, resp)
+}
+
+func (api *API) trash(w http.ResponseWriter, r *http.Request) error {
+	// swagger:operation GET /api/trash Admin trash
+	// List the deleted short urls
+	//
+	// Lists the deleted short urls that can still be restored, sorted by id. Requires the admin api key
+	// ---
+	// produces:
+	// - application/json
+	// parameters:
+	// - name: Authorization
+	//   in: header
+	//   description: "Bearer followed by the admin api key"
+	//   required: true
+	//   type: string
+	// - name: offset
+	//   in: query
+	//   description: number of short urls to skip, 0 by default
+	//   required: false
+	//   type: integer
+	// - name: limit
+	//   in: query
+	//   description: maximum number of short urls to list, from 1 to 1000, 100 by default
+	//   required: false
+	//   type: integer
+	//
+	// responses:
+	//   '200':
+	//     description: "Deleted short urls with the time they will be purged"
+	//   '400':
+	//     description: Bad Request
+	//   '401':
+	//     description: Unauthorized
+	//   '403':
+	//     description: Forbidden
+	//   '500':
+	//     description: Internal Server Error
+
+	if err := api.authorizeAdmin(r); err != nil {
+		return server.WriteError(w, r, *err)
+	}
+
+	opts, err := parseListOptions(r)
+	if err != nil {
+		return server.WriteError(w, r, *err)
+	}
+	opts.Deleted = true
+
+	urls, err := api.svc.ListUrls(r.Context(), opts)
+	if err != nil {
+		return server.WriteError(w, r, *err)
+	}
+
+	resp := &ResponseTrash{
+		Status:    "ok",
+		Operation: "trash",
+		Urls:      make([]DeletedUrl, 0, len(urls)),
+	}
+	for _, u := range urls {
+		deleted := DeletedUrl{Id: u.Id, Url: u.Url}
+		if u.DeletedAt != nil {
+			deleted.DeletedAt = *u.DeletedAt
+			deleted.PurgeAt = u.DeletedAt.Add(api.conf.DeleteRetention)
+		}
+		resp.Urls = append(resp.Urls, deleted)
+	}
+	return server.Write(w, http.StatusOK, resp)
+}
+
+// authenticate restricts the request to the workspace of its api key. The requests without api key
+// are restricted to the default workspace
+func (api *API) authenticate(r *http.Request) *errors.Error {
+	key := bearer(r)
+	switch {
+	case key == "":
+		tenant.SetWorkspace(r.Context(), tenant.Default)
+		return nil
+	case tenant.IsKey(key):
+		return api.authorizeKey(r, key)
+	default:
+		return api.authorizeAdmin(r)
+	}
+}
+
+// authorizeAdmin verifies that the request carries the admin api key, or the api key of a workspace
+// restricting it to its workspace
+func (api *API) authorizeAdmin(r *http.Request) *errors.Error {
+	key := bearer(r)
+	if tenant.IsKey(key) {
+		return api.authorizeKey(r, key)
+	}
+
+	if api.conf.AdminApiKey == "" {
+		api.le.Error("admin api key is not configured")
+		e := errors.NewErrorForbidden()
+		return &e
+	}
+
+	if subtle.ConstantTimeCompare([]byte(key), []byte(api.conf.AdminApiKey)) != 1 {
+		api.le.Error("invalid admin api key")
+		e := errors.NewErrorUnauthorized()
+		return &e
+	}
+	audit.SetActor(r.Context(), audit.ActorAdmin)
+
+	// the admin requests are not restricted, unless they select a workspace
+	workspace := r.URL.Query().Get("workspace")
+	if workspace == "" {
+		return nil
+	}
+	if !tenant.ValidId(workspace) {
+		e := errors.NewErrorBadRequest().WithDetail("workspace", "must be a valid workspace id")
+		return &e
+	}
+	tenant.SetWorkspace(r.Context(), workspace)
+	return nil
+}
+
+// authorizeKey verifies the api key of a workspace, restricting the request to the workspace
+func (api *API) authorizeKey(r *http.Request, key string) *errors.Error {
+	if api.keys == nil {
+		api.le.Error("workspace api keys are not enabled")
+		e := errors.NewErrorUnauthorized()
+		return &e
+	}
+
+	res, err := api.keys.FindKey(r.Context(), tenant.Hash(key))
+	if err == shortener.ErrNotFound || (err == nil && res.RevokedAt != nil) {
+		api.le.Error("invalid workspace api key")
+		e := errors.NewErrorUnauthorized()
+		return &e
+	}
+	if err != nil {
+		api.le.WithError(err).Error("unable to read the workspace api key")
+		e := errors.NewInternalServerError()
+		return &e
+	}
+
+	tenant.SetWorkspace(r.Context(), res.Workspace)
+	audit.SetActor(r.Context(), audit.ActorKey(res.Id))
+	return nil
+}
+
+// bearer returns the api key of the request, if any
+func bearer(r *http.Request) string {
+	return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
 func (api *API) parseInput(r *http.Request) (string, *errors.Error) {
-	url := r.URL.Query().Get("url")
+	return api.validateUrl(r.URL.Query().Get("url"))
+}
+
+// parseCreate reads the url to shorten and the options of the short url from the json body,
+// falling back to the url query parameter
+func (api *API) parseCreate(r *http.Request) (string, shortener.LinkOptions, *errors.Error) {
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		url, err := api.parseInput(r)
+		return url, shortener.LinkOptions{Domain: r.URL.Query().Get("domain")}, err
+	}
+
+	body := &RequestCreate{}
+	if err := json.NewDecoder(r.Body).Decode(body); err != nil {
+		api.le.WithError(err).Error("unable to decode request body")
+		e := errors.NewErrorBadRequest().WithDetail("body", "must be a valid json object")
+		return "", shortener.LinkOptions{}, &e
+	}
+	if body.Url == "" {
+		body.Url = r.URL.Query().Get("url")
+	}
+
+	url, err := api.validateUrl(body.Url)
+	return url, body.LinkOptions, err
+}
 
+func (api *API) validateUrl(url string) (string, *errors.Error) {
 	// validate input
 	url = strings.TrimSpace(url)
 	if url == "" {
 		api.le.Error("requested url is empty")
-		e := errors.NewErrorBadRequest()
+		e := errors.NewErrorInvalidUrl("is required")
 		return "", &e
 	}
 
 	if !strings.Contains(url, "http") {
 		url = fmt.Sprintf("http://%s", url)
 	}
+
+	parsed, err := neturl.Parse(url)
+	if err != nil || parsed.Host == "" {
+		api.le.Errorf("requested url is malformed: %s", url)
+		e := errors.NewErrorInvalidUrl("must be an absolute http url")
+		return "", &e
+	}
 	return url, nil
 }
+
+// parseListOptions reads the pagination and the filters by label of a listing
+func parseListOptions(r *http.Request) (shortener.ListOptions, *errors.Error) {
+	query := r.URL.Query()
+	opts := shortener.ListOptions{
+		Limit:  100,
+		Tags:   query["tag"],
+		Search: strings.TrimSpace(query.Get("q")),
+	}
+	e := errors.NewErrorBadRequest()
+
+	// the metadata keys have no :, the values can have some
+	for _, v := range query["meta"] {
+		split := strings.SplitN(v, ":", 2)
+		if len(split) != 2 || split[0] == "" || split[1] == "" {
+			e = e.WithDetail("meta", "must be key:value")
+			continue
+		}
+		if opts.Metadata == nil {
+			opts.Metadata = map[string]string{}
+		}
+		opts.Metadata[split[0]] = split[1]
+	}
+
+	if v := query.Get("offset"); v != "" {
+		offset, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || offset < 0 {
+			e = e.WithDetail("offset", "must be a positive integer")
+		}
+		opts.Offset = offset
+	}
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || limit < 1 || limit > listMaxLimit {
+			e = e.WithDetail("limit", "must be an integer from 1 to 1000")
+		}
+		opts.Limit = limit
+	}
+
+	if len(e.Details) > 0 {
+		return opts, &e
+	}
+	return opts, nil
+}
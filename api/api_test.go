@@ -3,15 +3,23 @@ package api
 import (
 	"encoding/json"
 	"fmt"
+	"image/png"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	neturl "net/url"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/gorilla/mux"
+	"github.com/gsiragusa/short-to-me/audit"
 	"github.com/gsiragusa/short-to-me/config"
+	"github.com/gsiragusa/short-to-me/errors"
 	"github.com/gsiragusa/short-to-me/shortener"
+	"github.com/gsiragusa/short-to-me/tenant"
+	"github.com/gsiragusa/short-to-me/webhook"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/require"
 )
@@ -41,7 +49,7 @@ func TestAPI_CreateShortUrl(t *testing.T) {
 
 	req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/api?url=%s", testUrl), nil)
 
-	svc.EXPECT().ShortenUrl(req.Context(), testUrl).Return(shortId, nil)
+	svc.EXPECT().ShortenUrl(req.Context(), testUrl, shortener.LinkOptions{}).Return(shortId, nil)
 
 	resp := httptest.NewRecorder()
 	if err := api.createShortUrl(resp, req); err != nil {
@@ -58,6 +66,7 @@ func TestAPI_CreateShortUrl(t *testing.T) {
 	require.Equal(t, "ok", payload.Status)
 	require.Equal(t, fmt.Sprintf("http://example.com/%s", shortId), payload.Url)
 	require.Equal(t, "create", payload.Operation)
+	require.Equal(t, fmt.Sprintf("http://example.com/api/qr?url=http%%3A%%2F%%2Fexample.com%%2F%s", shortId), payload.Qr)
 }
 
 func TestAPI_ReadShortUrl(t *testing.T) {
@@ -65,7 +74,8 @@ func TestAPI_ReadShortUrl(t *testing.T) {
 
 	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api?url=%s", shortUrl), nil)
 
-	svc.EXPECT().RetrieveUrl(req.Context(), shortUrl).Return(shortId, nil)
+	labels := shortener.Labels{Title: "Spring sale", Tags: []string{"shoes"}, Metadata: map[string]string{"owner": "marketing"}}
+	svc.EXPECT().GetUrl(req.Context(), shortUrl).Return(&shortener.ModelShorten{Id: shortId, Url: testUrl, Labels: labels}, nil)
 
 	resp := httptest.NewRecorder()
 	if err := api.readShortUrl(resp, req); err != nil {
@@ -80,7 +90,78 @@ func TestAPI_ReadShortUrl(t *testing.T) {
 
 	require.Equal(t, "ok", payload.Status)
 	require.Equal(t, "read", payload.Operation)
-	require.Equal(t, shortId, payload.Url)
+	require.Equal(t, testUrl, payload.Url)
+	require.Equal(t, &labels, payload.Labels)
+}
+
+func TestAPI_UpdateShortUrl(t *testing.T) {
+	api, svc := MakeTestApi(t)
+
+	body := fmt.Sprintf(`{"url": %q, "title": "Spring sale", "tags": ["Shoes"], "metadata": {"owner": ""}}`, shortUrl)
+	req := httptest.NewRequest(http.MethodPatch, "/api", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	title, tags := "Spring sale", []string{"Shoes"}
+	update := shortener.LinkUpdate{Title: &title, Tags: &tags, Metadata: map[string]string{"owner": ""}}
+	labels := shortener.Labels{Title: title, Tags: []string{"shoes"}}
+	svc.EXPECT().UpdateUrl(req.Context(), shortUrl, update).
+		Return(&shortener.ModelShorten{Id: shortId, Url: testUrl, Labels: labels}, nil)
+
+	resp := httptest.NewRecorder()
+	if err := api.updateShortUrl(resp, req); err != nil {
+		t.Error(err)
+	}
+
+	verifyStatus(t, http.StatusOK, resp.Code)
+
+	decoder := json.NewDecoder(resp.Body)
+	var payload ResponseApi
+	require.NoError(t, decoder.Decode(&payload))
+
+	require.Equal(t, "update", payload.Operation)
+	require.Equal(t, shortUrl, payload.Url)
+	require.Equal(t, &labels, payload.Labels)
+}
+
+func TestAPI_Links(t *testing.T) {
+	api, svc := MakeTestApi(t)
+	api.conf.AdminApiKey = "secret"
+
+	req := httptest.NewRequest(http.MethodGet, "/api/links?tag=shoes&tag=campaign:spring&meta=owner:marketing&meta=source:https://example.com&q=sale", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+
+	// the metadata keys have no :, the values can have some
+	opts := shortener.ListOptions{
+		Limit:    100,
+		Tags:     []string{"shoes", "campaign:spring"},
+		Metadata: map[string]string{"owner": "marketing", "source": "https://example.com"},
+		Search:   "sale",
+	}
+	listed := []*shortener.ModelShorten{{Id: shortId, Url: testUrl, PasswordHash: "secret-hash", Labels: shortener.Labels{Title: "Spring sale"}}}
+	svc.EXPECT().ListUrls(req.Context(), opts).Return(listed, nil)
+
+	resp := httptest.NewRecorder()
+	if err := api.links(resp, req); err != nil {
+		t.Error(err)
+	}
+
+	verifyStatus(t, http.StatusOK, resp.Code)
+	require.NotContains(t, resp.Body.String(), "secret-hash")
+
+	decoder := json.NewDecoder(resp.Body)
+	var payload ResponseLinks
+	require.NoError(t, decoder.Decode(&payload))
+	urls := []*shortener.ModelShorten{{Id: shortId, Url: testUrl, Labels: shortener.Labels{Title: "Spring sale"}}}
+	require.Equal(t, urls, payload.Urls)
+
+	// metadata filters are key:value pairs
+	req = httptest.NewRequest(http.MethodGet, "/api/links?meta=owner", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+
+	resp = httptest.NewRecorder()
+	_ = api.links(resp, req)
+
+	verifyStatus(t, http.StatusBadRequest, resp.Code)
 }
 
 func TestAPI_DeleteShortUrl(t *testing.T) {
@@ -111,7 +192,7 @@ func TestAPI_CountRedirects(t *testing.T) {
 
 	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/count?url=%s", shortUrl), nil)
 
-	svc.EXPECT().CountRedirects(req.Context(), shortUrl).Return(int64(2), nil)
+	svc.EXPECT().CountRedirects(req.Context(), shortUrl).Return(&shortener.Counts{Total: 2}, nil)
 
 	resp := httptest.NewRecorder()
 	if err := api.countRedirects(resp, req); err != nil {
@@ -135,7 +216,8 @@ func TestAPI_Redirect(t *testing.T) {
 	req := httptest.NewRequest(http.MethodGet, "/123", nil)
 	req = mux.SetURLVars(req, map[string]string{"shortId": "123"})
 
-	svc.EXPECT().IncrementRedirect(req.Context(), "123")
+	redirect := &shortener.Redirect{Url: testUrl, Permanent: true}
+	svc.EXPECT().IncrementRedirect(req.Context(), "123", shortener.Visit{ClientIp: "192.0.2.1"}).Return(redirect, nil)
 
 	resp := httptest.NewRecorder()
 	if err := api.redirect(resp, req); err != nil {
@@ -145,6 +227,254 @@ func TestAPI_Redirect(t *testing.T) {
 	verifyStatus(t, 301, resp.Code)
 }
 
+func TestAPI_RedirectStickyVariant(t *testing.T) {
+	api, svc := MakeTestApi(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/123", nil)
+	req = mux.SetURLVars(req, map[string]string{"shortId": "123"})
+	req.AddCookie(&http.Cookie{Name: variantCookie, Value: "a"})
+
+	redirect := &shortener.Redirect{Url: testUrl, StickyVariant: "a"}
+	visit := shortener.Visit{ClientIp: "192.0.2.1", Variant: "a"}
+	svc.EXPECT().IncrementRedirect(req.Context(), "123", visit).Return(redirect, nil)
+
+	resp := httptest.NewRecorder()
+	if err := api.redirect(resp, req); err != nil {
+		t.Error(err)
+	}
+
+	verifyStatus(t, 302, resp.Code)
+	cookies := resp.Result().Cookies()
+	require.Len(t, cookies, 1)
+	require.Equal(t, "a", cookies[0].Value)
+	require.Equal(t, "/123", cookies[0].Path)
+}
+
+func TestAPI_RedirectQuery(t *testing.T) {
+	api, svc := MakeTestApi(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/123?ref=x&ref=y", nil)
+	req = mux.SetURLVars(req, map[string]string{"shortId": "123"})
+
+	redirect := &shortener.Redirect{Url: testUrl + "?ref=x&ref=y"}
+	visit := shortener.Visit{ClientIp: "192.0.2.1", Query: neturl.Values{"ref": {"x", "y"}}}
+	svc.EXPECT().IncrementRedirect(req.Context(), "123", visit).Return(redirect, nil)
+
+	resp := httptest.NewRecorder()
+	if err := api.redirect(resp, req); err != nil {
+		t.Error(err)
+	}
+
+	verifyStatus(t, 302, resp.Code)
+	require.Equal(t, redirect.Url, resp.Header().Get("Location"))
+}
+
+func TestAPI_RedirectPath(t *testing.T) {
+	api, svc := MakeTestApi(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/123/guide/a%2Fb", nil)
+	req = mux.SetURLVars(req, map[string]string{"shortId": "123", "path": "guide/a/b"})
+
+	redirect := &shortener.Redirect{Url: testUrl + "/guide/a%2Fb"}
+	visit := shortener.Visit{ClientIp: "192.0.2.1", Path: "/guide/a%2Fb"}
+	svc.EXPECT().IncrementRedirect(req.Context(), "123", visit).Return(redirect, nil)
+
+	resp := httptest.NewRecorder()
+	if err := api.redirect(resp, req); err != nil {
+		t.Error(err)
+	}
+
+	verifyStatus(t, 302, resp.Code)
+}
+
+func TestAPI_RedirectPreview(t *testing.T) {
+	api, svc := MakeTestApi(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/123+?ref=x", nil)
+	req = mux.SetURLVars(req, map[string]string{"shortId": "123+"})
+
+	preview := &shortener.Preview{
+		Id:        "123",
+		Url:       testUrl + "/<b>",
+		Host:      "www.test.com",
+		CreatedAt: time.Date(2020, 9, 13, 12, 0, 0, 0, time.UTC),
+		Count:     1,
+	}
+	visit := shortener.Visit{ClientIp: "192.0.2.1", Query: neturl.Values{"ref": {"x"}}}
+	svc.EXPECT().PreviewUrl(req.Context(), "123", visit).Return(preview, nil)
+
+	resp := httptest.NewRecorder()
+	if err := api.redirect(resp, req); err != nil {
+		t.Error(err)
+	}
+
+	verifyStatus(t, http.StatusOK, resp.Code)
+	body := resp.Body.String()
+	require.Contains(t, body, "This link goes to www.test.com")
+	require.Contains(t, body, "http://www.test.com/&lt;b&gt;")
+	require.Contains(t, body, "Created on September 13, 2020, followed 1 time.")
+	require.Contains(t, body, `<form method="post" action="/123?ref=x">`)
+}
+
+func TestAPI_RedirectPreviewLink(t *testing.T) {
+	api, svc := MakeTestApi(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/123", nil)
+	req = mux.SetURLVars(req, map[string]string{"shortId": "123"})
+
+	redirect := &shortener.Redirect{Url: testUrl, Preview: &shortener.Preview{Id: "123", Url: testUrl}}
+	svc.EXPECT().IncrementRedirect(req.Context(), "123", gomock.Any()).Return(redirect, nil)
+
+	resp := httptest.NewRecorder()
+	if err := api.redirect(resp, req); err != nil {
+		t.Error(err)
+	}
+
+	verifyStatus(t, http.StatusOK, resp.Code)
+	require.Contains(t, resp.Body.String(), `action="/123"`)
+}
+
+func TestAPI_RedirectTemporary(t *testing.T) {
+	api, svc := MakeTestApi(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/123", nil)
+	req = mux.SetURLVars(req, map[string]string{"shortId": "123"})
+
+	redirect := &shortener.Redirect{Url: testUrl}
+	svc.EXPECT().IncrementRedirect(req.Context(), "123", gomock.Any()).Return(redirect, nil)
+
+	resp := httptest.NewRecorder()
+	if err := api.redirect(resp, req); err != nil {
+		t.Error(err)
+	}
+
+	verifyStatus(t, http.StatusFound, resp.Code)
+}
+
+func TestAPI_RedirectComingSoon(t *testing.T) {
+	api, svc := MakeTestApi(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/123", nil)
+	req = mux.SetURLVars(req, map[string]string{"shortId": "123"})
+
+	notActive := errors.NewErrorLinkNotActive()
+	svc.EXPECT().IncrementRedirect(req.Context(), "123", gomock.Any()).Return(nil, &notActive)
+
+	resp := httptest.NewRecorder()
+	if err := api.redirect(resp, req); err != nil {
+		t.Error(err)
+	}
+
+	verifyStatus(t, http.StatusForbidden, resp.Code)
+	require.Contains(t, resp.Body.String(), "Coming soon")
+}
+
+func TestAPI_RedirectExhausted(t *testing.T) {
+	api, svc := MakeTestApi(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/123", nil)
+	req = mux.SetURLVars(req, map[string]string{"shortId": "123"})
+
+	exhausted := errors.NewErrorLinkExhausted()
+	svc.EXPECT().IncrementRedirect(req.Context(), "123", gomock.Any()).Return(nil, &exhausted)
+
+	resp := httptest.NewRecorder()
+	if err := api.redirect(resp, req); err != nil {
+		t.Error(err)
+	}
+
+	verifyStatus(t, http.StatusGone, resp.Code)
+}
+
+func TestAPI_CreateShortUrlWithBody(t *testing.T) {
+	api, svc := MakeTestApi(t)
+
+	body := strings.NewReader(`{"url": "www.test.com", "password": "secret"}`)
+	req := httptest.NewRequest(http.MethodPost, "/api", body)
+	req.Header.Set("Content-Type", "application/json")
+
+	opts := shortener.LinkOptions{Password: "secret"}
+	svc.EXPECT().ShortenUrl(req.Context(), testUrl, opts).Return(shortId, nil)
+
+	resp := httptest.NewRecorder()
+	if err := api.createShortUrl(resp, req); err != nil {
+		t.Error(err)
+	}
+
+	verifyStatus(t, http.StatusOK, resp.Code)
+}
+
+func TestAPI_RedirectPasswordForm(t *testing.T) {
+	api, svc := MakeTestApi(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/123", nil)
+	req = mux.SetURLVars(req, map[string]string{"shortId": "123"})
+
+	required := errors.NewErrorPasswordRequired()
+	svc.EXPECT().IncrementRedirect(req.Context(), "123", gomock.Any()).Return(nil, &required)
+
+	resp := httptest.NewRecorder()
+	if err := api.redirect(resp, req); err != nil {
+		t.Error(err)
+	}
+
+	verifyStatus(t, http.StatusUnauthorized, resp.Code)
+	require.Contains(t, resp.Header().Get("Content-Type"), "text/html")
+	require.Contains(t, resp.Body.String(), `<form method="post">`)
+}
+
+func TestAPI_RedirectUnlock(t *testing.T) {
+	api, svc := MakeTestApi(t)
+
+	req := httptest.NewRequest(http.MethodPost, "/123", strings.NewReader("password=secret"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req = mux.SetURLVars(req, map[string]string{"shortId": "123"})
+
+	visit := shortener.Visit{ClientIp: "192.0.2.1", Password: "secret", Confirmed: true}
+	svc.EXPECT().IncrementRedirect(req.Context(), "123", visit).Return(&shortener.Redirect{Url: testUrl}, nil)
+
+	resp := httptest.NewRecorder()
+	if err := api.redirect(resp, req); err != nil {
+		t.Error(err)
+	}
+
+	verifyStatus(t, http.StatusSeeOther, resp.Code)
+	require.Equal(t, testUrl, resp.Header().Get("Location"))
+}
+
+func TestAPI_Export(t *testing.T) {
+	api, svc := MakeTestApi(t)
+	api.conf.AdminApiKey = "secret"
+
+	req := httptest.NewRequest(http.MethodGet, "/api/export?format=csv", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+
+	svc.EXPECT().ExportUrls(req.Context(), gomock.Any(), shortener.FormatCSV).
+		Return(&shortener.ExportResult{Exported: 3, Skipped: 2}, nil)
+
+	resp := httptest.NewRecorder()
+	if err := api.export(resp, req); err != nil {
+		t.Error(err)
+	}
+
+	verifyStatus(t, http.StatusOK, resp.Code)
+	require.Equal(t, "text/csv; charset=utf-8", resp.Header().Get("Content-Type"))
+	require.Equal(t, "2", resp.Result().Trailer.Get("X-Export-Skipped"))
+}
+
+func TestAPI_ExportUnauthorized(t *testing.T) {
+	api, _ := MakeTestApi(t)
+	api.conf.AdminApiKey = "secret"
+
+	req := httptest.NewRequest(http.MethodGet, "/api/export", nil)
+	req.Header.Set("Authorization", "Bearer wrong")
+
+	resp := httptest.NewRecorder()
+	_ = api.export(resp, req)
+
+	verifyStatus(t, http.StatusUnauthorized, resp.Code)
+}
+
 func TestAPI_BadRequest(t *testing.T) {
 	api, _ := MakeTestApi(t)
 
@@ -154,6 +484,58 @@ func TestAPI_BadRequest(t *testing.T) {
 	_ = api.createShortUrl(resp, req)
 
 	verifyStatus(t, http.StatusBadRequest, resp.Code)
+
+	decoder := json.NewDecoder(resp.Body)
+	var payload errors.Error
+	require.NoError(t, decoder.Decode(&payload))
+
+	require.Equal(t, "error", payload.Status)
+	require.Equal(t, errors.CodeInvalidUrl, payload.Code)
+	require.Equal(t, []errors.FieldError{{Field: "url", Message: "is required"}}, payload.Details)
+}
+
+func TestAPI_NotFound(t *testing.T) {
+	api, svc := MakeTestApi(t)
+
+	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api?url=%s", shortUrl), nil)
+
+	notFound := errors.NewErrorLinkNotFound()
+	svc.EXPECT().GetUrl(req.Context(), shortUrl).Return(nil, &notFound)
+
+	resp := httptest.NewRecorder()
+	_ = api.readShortUrl(resp, req)
+
+	verifyStatus(t, http.StatusNotFound, resp.Code)
+
+	decoder := json.NewDecoder(resp.Body)
+	var payload errors.Error
+	require.NoError(t, decoder.Decode(&payload))
+
+	require.Equal(t, errors.CodeLinkNotFound, payload.Code)
+}
+
+func TestAPI_ProblemResponse(t *testing.T) {
+	api, _ := MakeTestApi(t)
+
+	req := httptest.NewRequest(http.MethodPost, "/api?url=", nil)
+	req.Header.Set("Accept", "application/problem+json, application/json")
+
+	resp := httptest.NewRecorder()
+	_ = api.createShortUrl(resp, req)
+
+	verifyStatus(t, http.StatusBadRequest, resp.Code)
+	require.Equal(t, errors.ProblemContentType, resp.Header().Get("Content-Type"))
+
+	decoder := json.NewDecoder(resp.Body)
+	var payload errors.Problem
+	require.NoError(t, decoder.Decode(&payload))
+
+	require.Equal(t, "urn:short-to-me:problem:invalid_url", payload.Type)
+	require.Equal(t, "Bad Request", payload.Title)
+	require.Equal(t, http.StatusBadRequest, payload.Status)
+	require.Equal(t, "/api", payload.Instance)
+	require.Equal(t, errors.CodeInvalidUrl, payload.Code)
+	require.Len(t, payload.Errors, 1)
 }
 
 func verifyStatus(t *testing.T, expected, actual int) {
@@ -161,3 +543,598 @@ func verifyStatus(t *testing.T, expected, actual int) {
 		t.Fatalf("Status code was %d, expected %d ", actual, expected)
 	}
 }
+
+func TestAPI_QrCode(t *testing.T) {
+	api, svc := MakeTestApi(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/qr?url="+neturl.QueryEscape(shortUrl)+"&size=128&fg=%23112233", nil)
+	svc.EXPECT().GetUrl(req.Context(), shortUrl).Return(&shortener.ModelShorten{Id: shortId, Url: testUrl}, nil)
+
+	resp := httptest.NewRecorder()
+	if err := api.qrCode(resp, req); err != nil {
+		t.Error(err)
+	}
+
+	verifyStatus(t, http.StatusOK, resp.Code)
+	require.Equal(t, "image/png", resp.Header().Get("Content-Type"))
+	require.Equal(t, "private, max-age=300", resp.Header().Get("Cache-Control"))
+	img, err := png.Decode(resp.Body)
+	require.Nil(t, err)
+	require.Equal(t, 128, img.Bounds().Dx())
+}
+
+func TestAPI_QrCodeSvg(t *testing.T) {
+	api, svc := MakeTestApi(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/qr?format=svg&url="+neturl.QueryEscape(shortUrl), nil)
+	svc.EXPECT().GetUrl(req.Context(), shortUrl).Return(&shortener.ModelShorten{Id: shortId, Url: testUrl}, nil)
+
+	resp := httptest.NewRecorder()
+	if err := api.qrCode(resp, req); err != nil {
+		t.Error(err)
+	}
+
+	verifyStatus(t, http.StatusOK, resp.Code)
+	require.Equal(t, "image/svg+xml", resp.Header().Get("Content-Type"))
+	require.Contains(t, resp.Body.String(), "<svg")
+}
+
+func TestAPI_QrCodeInvalidOptions(t *testing.T) {
+	api, _ := MakeTestApi(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/qr?url="+neturl.QueryEscape(shortUrl)+"&format=gif&size=10&level=X&bg=white&logo=true", nil)
+
+	resp := httptest.NewRecorder()
+	if err := api.qrCode(resp, req); err != nil {
+		t.Error(err)
+	}
+
+	verifyStatus(t, http.StatusBadRequest, resp.Code)
+	var payload errors.Error
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&payload))
+	require.Len(t, payload.Details, 5)
+}
+
+func TestAPI_QrCodeNotFound(t *testing.T) {
+	api, svc := MakeTestApi(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/qr?url="+neturl.QueryEscape(shortUrl), nil)
+	notFound := errors.NewErrorLinkNotFound()
+	svc.EXPECT().GetUrl(req.Context(), shortUrl).Return(nil, &notFound)
+
+	resp := httptest.NewRecorder()
+	if err := api.qrCode(resp, req); err != nil {
+		t.Error(err)
+	}
+
+	verifyStatus(t, http.StatusNotFound, resp.Code)
+}
+
+func TestAPI_QrCodeDomain(t *testing.T) {
+	api, svc := MakeTestApi(t)
+	api.conf.ShortHosts = []string{"sho.rt"}
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	domains := NewMockDomains(ctrl)
+	WithDomains(domains)(api)
+
+	domain := &tenant.Domain{Host: "go.brand-a.com", Workspace: "team-a"}
+	domains.EXPECT().FindDomain(gomock.Any(), "go.brand-a.com").Return(domain, nil)
+	link := &shortener.ModelShorten{Id: "123", Url: testUrl, Domain: "go.brand-a.com", Workspace: "team-a"}
+
+	// the short urls of a custom domain are encoded on their domain
+	req := httptest.NewRequest(http.MethodGet, "/api/qr?url="+neturl.QueryEscape("http://go.brand-a.com/123"), nil)
+	svc.EXPECT().GetUrl(req.Context(), "http://go.brand-a.com/123").Return(link, nil)
+
+	resp := httptest.NewRecorder()
+	if err := api.qrCode(resp, req); err != nil {
+		t.Error(err)
+	}
+
+	verifyStatus(t, http.StatusOK, resp.Code)
+
+	// and not found on the short hosts, as their redirects
+	req = httptest.NewRequest(http.MethodGet, "/api/qr?url="+neturl.QueryEscape("http://sho.rt/123"), nil)
+	svc.EXPECT().GetUrl(req.Context(), "http://sho.rt/123").Return(link, nil)
+
+	resp = httptest.NewRecorder()
+	_ = api.qrCode(resp, req)
+
+	verifyStatus(t, http.StatusNotFound, resp.Code)
+
+	// the short ids are never looked up on the unknown hosts
+	domains.EXPECT().FindDomain(gomock.Any(), "unknown.com").Return(nil, shortener.ErrNotFound)
+	req = httptest.NewRequest(http.MethodGet, "/api/qr?url="+neturl.QueryEscape("http://unknown.com/123"), nil)
+
+	resp = httptest.NewRecorder()
+	_ = api.qrCode(resp, req)
+
+	verifyStatus(t, http.StatusNotFound, resp.Code)
+}
+
+func TestAPI_Health(t *testing.T) {
+	api, svc := MakeTestApi(t)
+	api.conf.AdminApiKey = "secret"
+
+	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/health?url=%s", shortUrl), nil)
+	req.Header.Set("Authorization", "Bearer secret")
+
+	health := &shortener.Health{
+		Broken: true,
+		Destinations: []shortener.DestinationHealth{
+			{Destination: shortener.Destination{Field: "url", Url: testUrl}, Status: http.StatusNotFound, Broken: true},
+		},
+	}
+	svc.EXPECT().Health(req.Context(), shortUrl).Return(health, nil)
+
+	resp := httptest.NewRecorder()
+	if err := api.health(resp, req); err != nil {
+		t.Error(err)
+	}
+
+	verifyStatus(t, http.StatusOK, resp.Code)
+
+	decoder := json.NewDecoder(resp.Body)
+	var payload ResponseHealth
+	require.NoError(t, decoder.Decode(&payload))
+
+	require.Equal(t, "health", payload.Operation)
+	require.Equal(t, health, payload.Health)
+}
+
+func TestAPI_HealthUnauthorized(t *testing.T) {
+	api, _ := MakeTestApi(t)
+	api.conf.AdminApiKey = "secret"
+
+	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/health?url=%s", shortUrl), nil)
+
+	resp := httptest.NewRecorder()
+	_ = api.health(resp, req)
+
+	verifyStatus(t, http.StatusUnauthorized, resp.Code)
+}
+
+func TestAPI_Broken(t *testing.T) {
+	api, svc := MakeTestApi(t)
+	api.conf.AdminApiKey = "secret"
+
+	req := httptest.NewRequest(http.MethodGet, "/api/broken?offset=10&limit=5", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+
+	health := &shortener.Health{Broken: true}
+	svc.EXPECT().ListUrls(req.Context(), shortener.ListOptions{Offset: 10, Limit: 5, Broken: true}).
+		Return([]*shortener.ModelShorten{{Id: shortId, Url: testUrl, PasswordHash: "hash", Health: health}}, nil)
+
+	resp := httptest.NewRecorder()
+	if err := api.broken(resp, req); err != nil {
+		t.Error(err)
+	}
+
+	verifyStatus(t, http.StatusOK, resp.Code)
+	require.NotContains(t, resp.Body.String(), "hash")
+
+	decoder := json.NewDecoder(resp.Body)
+	var payload ResponseBroken
+	require.NoError(t, decoder.Decode(&payload))
+
+	require.Equal(t, []BrokenUrl{{Id: shortId, Url: testUrl, Health: health}}, payload.Urls)
+}
+
+func TestAPI_BrokenInvalidOptions(t *testing.T) {
+	api, _ := MakeTestApi(t)
+	api.conf.AdminApiKey = "secret"
+
+	req := httptest.NewRequest(http.MethodGet, "/api/broken?offset=-1&limit=5000", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+
+	resp := httptest.NewRecorder()
+	_ = api.broken(resp, req)
+
+	verifyStatus(t, http.StatusBadRequest, resp.Code)
+
+	decoder := json.NewDecoder(resp.Body)
+	var payload errors.Error
+	require.NoError(t, decoder.Decode(&payload))
+	require.Len(t, payload.Details, 2)
+}
+
+func TestAPI_RestoreShortUrl(t *testing.T) {
+	api, svc := MakeTestApi(t)
+
+	req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/api/restore?url=%s", shortUrl), nil)
+
+	svc.EXPECT().RestoreUrl(req.Context(), shortUrl)
+
+	resp := httptest.NewRecorder()
+	if err := api.restoreShortUrl(resp, req); err != nil {
+		t.Error(err)
+	}
+
+	verifyStatus(t, http.StatusOK, resp.Code)
+
+	decoder := json.NewDecoder(resp.Body)
+	var payload ResponseApi
+	require.NoError(t, decoder.Decode(&payload))
+
+	require.Equal(t, "restore", payload.Operation)
+	require.Equal(t, shortUrl, payload.Url)
+}
+
+func TestAPI_Trash(t *testing.T) {
+	api, svc := MakeTestApi(t)
+	api.conf.AdminApiKey = "secret"
+
+	req := httptest.NewRequest(http.MethodGet, "/api/trash", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+
+	deletedAt := time.Date(2020, 11, 1, 10, 0, 0, 0, time.UTC)
+	svc.EXPECT().ListUrls(req.Context(), shortener.ListOptions{Limit: 100, Deleted: true}).
+		Return([]*shortener.ModelShorten{{Id: shortId, Url: testUrl, DeletedAt: &deletedAt}}, nil)
+
+	resp := httptest.NewRecorder()
+	if err := api.trash(resp, req); err != nil {
+		t.Error(err)
+	}
+
+	verifyStatus(t, http.StatusOK, resp.Code)
+
+	decoder := json.NewDecoder(resp.Body)
+	var payload ResponseTrash
+	require.NoError(t, decoder.Decode(&payload))
+
+	require.Equal(t, []DeletedUrl{{
+		Id:        shortId,
+		Url:       testUrl,
+		DeletedAt: deletedAt,
+		PurgeAt:   deletedAt.Add(api.conf.DeleteRetention),
+	}}, payload.Urls)
+}
+
+func TestAPI_Audit(t *testing.T) {
+	api, svc := MakeTestApi(t)
+	api.conf.AdminApiKey = "secret"
+
+	req := httptest.NewRequest(http.MethodGet,
+		"/api/audit?action=delete&url=http://sho.rt/"+shortId+"&from=2020-11-01T10:00:00Z&limit=10", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	origin := &audit.Origin{Actor: audit.ActorAnonymous, RequestId: "req-1"}
+	req = req.WithContext(audit.WithOrigin(req.Context(), origin))
+
+	entry := audit.Entry{Id: "a1", Action: audit.ActionDelete, ShortId: shortId}
+	svc.EXPECT().AuditLog(req.Context(), audit.Filter{
+		Action:  audit.ActionDelete,
+		ShortId: shortId,
+		From:    time.Date(2020, 11, 1, 10, 0, 0, 0, time.UTC),
+		Limit:   10,
+	}).Return([]audit.Entry{entry}, nil)
+
+	resp := httptest.NewRecorder()
+	if err := api.auditLog(resp, req); err != nil {
+		t.Error(err)
+	}
+
+	verifyStatus(t, http.StatusOK, resp.Code)
+	require.Equal(t, audit.ActorAdmin, origin.Actor)
+
+	decoder := json.NewDecoder(resp.Body)
+	var payload ResponseAudit
+	require.NoError(t, decoder.Decode(&payload))
+	require.Equal(t, []audit.Entry{entry}, payload.Entries)
+}
+
+func TestAPI_AuditInvalidFilter(t *testing.T) {
+	api, _ := MakeTestApi(t)
+	api.conf.AdminApiKey = "secret"
+
+	req := httptest.NewRequest(http.MethodGet, "/api/audit?action=read&to=yesterday&limit=0", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+
+	resp := httptest.NewRecorder()
+	if err := api.auditLog(resp, req); err != nil {
+		t.Error(err)
+	}
+
+	verifyStatus(t, http.StatusBadRequest, resp.Code)
+
+	decoder := json.NewDecoder(resp.Body)
+	var payload errors.Error
+	require.NoError(t, decoder.Decode(&payload))
+	require.Len(t, payload.Details, 3)
+}
+
+func TestAPI_WorkspaceKey(t *testing.T) {
+	api, svc := MakeTestApi(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	keys := NewMockKeys(ctrl)
+	WithKeys(keys)(api)
+
+	key, plain, err := tenant.NewKey("team-a", "ci")
+	require.Nil(t, err)
+
+	req := httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/api?url=%s", shortUrl), nil)
+	req.Header.Set("Authorization", "Bearer "+plain)
+	origin := &audit.Origin{Actor: audit.ActorAnonymous}
+	req = req.WithContext(tenant.WithScope(audit.WithOrigin(req.Context(), origin)))
+
+	keys.EXPECT().FindKey(req.Context(), tenant.Hash(plain)).Return(&key, nil)
+	svc.EXPECT().DeleteUrl(req.Context(), shortUrl).Return(nil)
+
+	resp := httptest.NewRecorder()
+	if err := api.deleteShortUrl(resp, req); err != nil {
+		t.Error(err)
+	}
+
+	verifyStatus(t, http.StatusOK, resp.Code)
+	workspace, ok := tenant.WorkspaceFrom(req.Context())
+	require.True(t, ok)
+	require.Equal(t, "team-a", workspace)
+	require.Equal(t, audit.ActorKey(key.Id), origin.Actor)
+
+	// the keys of the workspaces are accepted by the admin endpoints, until they are revoked
+	revokedAt := time.Now()
+	key.RevokedAt = &revokedAt
+	req = httptest.NewRequest(http.MethodGet, "/api/links", nil)
+	req.Header.Set("Authorization", "Bearer "+plain)
+
+	keys.EXPECT().FindKey(req.Context(), tenant.Hash(plain)).Return(&key, nil)
+
+	resp = httptest.NewRecorder()
+	_ = api.links(resp, req)
+
+	verifyStatus(t, http.StatusUnauthorized, resp.Code)
+
+	req = httptest.NewRequest(http.MethodGet, "/api/links", nil)
+	req.Header.Set("Authorization", "Bearer stm_unknown")
+
+	keys.EXPECT().FindKey(req.Context(), tenant.Hash("stm_unknown")).Return(nil, shortener.ErrNotFound)
+
+	resp = httptest.NewRecorder()
+	_ = api.links(resp, req)
+
+	verifyStatus(t, http.StatusUnauthorized, resp.Code)
+}
+
+func TestAPI_Workspace(t *testing.T) {
+	api, svc := MakeTestApi(t)
+	api.conf.AdminApiKey = "secret"
+
+	// the requests without api key are restricted to the default workspace
+	req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/api?url=%s", testUrl), nil)
+	req = req.WithContext(tenant.WithScope(req.Context()))
+
+	svc.EXPECT().ShortenUrl(req.Context(), testUrl, shortener.LinkOptions{}).Return(shortId, nil)
+
+	resp := httptest.NewRecorder()
+	if err := api.createShortUrl(resp, req); err != nil {
+		t.Error(err)
+	}
+
+	verifyStatus(t, http.StatusOK, resp.Code)
+	workspace, _ := tenant.WorkspaceFrom(req.Context())
+	require.Equal(t, tenant.Default, workspace)
+
+	// the admin requests select a workspace
+	req = httptest.NewRequest(http.MethodGet, "/api/links?workspace=team-a", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	req = req.WithContext(tenant.WithScope(req.Context()))
+
+	svc.EXPECT().ListUrls(req.Context(), shortener.ListOptions{Limit: 100}).Return(nil, nil)
+
+	resp = httptest.NewRecorder()
+	if err := api.links(resp, req); err != nil {
+		t.Error(err)
+	}
+
+	verifyStatus(t, http.StatusOK, resp.Code)
+	workspace, _ = tenant.WorkspaceFrom(req.Context())
+	require.Equal(t, "team-a", workspace)
+
+	req = httptest.NewRequest(http.MethodGet, "/api/links?workspace=Team_A", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+
+	resp = httptest.NewRecorder()
+	_ = api.links(resp, req)
+
+	verifyStatus(t, http.StatusBadRequest, resp.Code)
+
+	// the workspace keys are refused when they are not enabled
+	req = httptest.NewRequest(http.MethodPost, fmt.Sprintf("/api?url=%s", testUrl), nil)
+	req.Header.Set("Authorization", "Bearer stm_key")
+
+	resp = httptest.NewRecorder()
+	_ = api.createShortUrl(resp, req)
+
+	verifyStatus(t, http.StatusUnauthorized, resp.Code)
+}
+
+func TestAPI_RedirectDomain(t *testing.T) {
+	api, svc := MakeTestApi(t)
+	api.conf.ShortHosts = []string{"sho.rt"}
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	domains := NewMockDomains(ctrl)
+	WithDomains(domains)(api)
+
+	// the short ids are resolved in the namespace of the custom domain, looked up once
+	domain := &tenant.Domain{Host: "go.brand-a.com", Workspace: "team-a"}
+	domains.EXPECT().FindDomain(gomock.Any(), "go.brand-a.com").Return(domain, nil)
+	redirect := &shortener.Redirect{Url: testUrl}
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodGet, "http://Go.Brand-A.com:8081/123", nil)
+		req = mux.SetURLVars(req, map[string]string{"shortId": "123"})
+
+		visit := shortener.Visit{ClientIp: "192.0.2.1", Domain: "go.brand-a.com"}
+		svc.EXPECT().IncrementRedirect(req.Context(), "123", visit).Return(redirect, nil)
+
+		resp := httptest.NewRecorder()
+		if err := api.redirect(resp, req); err != nil {
+			t.Error(err)
+		}
+
+		verifyStatus(t, http.StatusFound, resp.Code)
+	}
+
+	// the short hosts are not looked up
+	req := httptest.NewRequest(http.MethodGet, "http://sho.rt/123", nil)
+	req = mux.SetURLVars(req, map[string]string{"shortId": "123"})
+
+	svc.EXPECT().IncrementRedirect(req.Context(), "123", shortener.Visit{ClientIp: "192.0.2.1"}).Return(redirect, nil)
+
+	resp := httptest.NewRecorder()
+	if err := api.redirect(resp, req); err != nil {
+		t.Error(err)
+	}
+
+	verifyStatus(t, http.StatusFound, resp.Code)
+
+	// the unknown hosts get the fallback
+	domains.EXPECT().FindDomain(gomock.Any(), "unknown.com").Return(nil, shortener.ErrNotFound)
+	req = httptest.NewRequest(http.MethodGet, "http://unknown.com/123", nil)
+	req = mux.SetURLVars(req, map[string]string{"shortId": "123"})
+
+	resp = httptest.NewRecorder()
+	_ = api.redirect(resp, req)
+
+	verifyStatus(t, http.StatusNotFound, resp.Code)
+
+	api.conf.UnknownHostUrl = "https://www.brand.com"
+	resp = httptest.NewRecorder()
+	_ = api.redirect(resp, req)
+
+	verifyStatus(t, http.StatusFound, resp.Code)
+	require.Equal(t, "https://www.brand.com", resp.Header().Get("Location"))
+}
+
+func TestAPI_CreateShortUrlDomain(t *testing.T) {
+	api, svc := MakeTestApi(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	domains := NewMockDomains(ctrl)
+	WithDomains(domains)(api)
+
+	// the short urls created on a custom domain of the workspace are served on it
+	req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("http://go.brand-a.com/api?url=%s", testUrl), nil)
+	req = req.WithContext(tenant.WithScope(req.Context()))
+
+	domains.EXPECT().FindDomain(gomock.Any(), "go.brand-a.com").Return(&tenant.Domain{Host: "go.brand-a.com", Workspace: tenant.Default}, nil)
+	svc.EXPECT().ShortenUrl(req.Context(), testUrl, shortener.LinkOptions{Domain: "go.brand-a.com"}).Return(shortId, nil)
+
+	resp := httptest.NewRecorder()
+	if err := api.createShortUrl(resp, req); err != nil {
+		t.Error(err)
+	}
+
+	verifyStatus(t, http.StatusOK, resp.Code)
+
+	var payload ResponseApi
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&payload))
+	require.Equal(t, "http://go.brand-a.com/"+shortId, payload.Url)
+
+	// or on the chosen one
+	req = httptest.NewRequest(http.MethodPost, fmt.Sprintf("/api?url=%s&domain=s.brand-a.io", testUrl), nil)
+
+	svc.EXPECT().ShortenUrl(req.Context(), testUrl, shortener.LinkOptions{Domain: "s.brand-a.io"}).Return(shortId, nil)
+
+	resp = httptest.NewRecorder()
+	if err := api.createShortUrl(resp, req); err != nil {
+		t.Error(err)
+	}
+
+	verifyStatus(t, http.StatusOK, resp.Code)
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&payload))
+	require.Equal(t, "http://s.brand-a.io/"+shortId, payload.Url)
+}
+
+func TestAPI_Webhooks(t *testing.T) {
+	api, _ := MakeTestApi(t)
+	api.conf.AdminApiKey = "secret"
+
+	// the webhooks are not found until they are enabled
+	req := httptest.NewRequest(http.MethodGet, "/api/webhooks", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+
+	resp := httptest.NewRecorder()
+	_ = api.webhooks(resp, req)
+
+	verifyStatus(t, http.StatusNotFound, resp.Code)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	hooks := webhook.NewMockService(ctrl)
+	WithWebhooks(hooks)(api)
+
+	// the endpoints are registered in the workspace selected by the admin
+	body := `{"url":"https://hooks.test.com","events":["link.created"]}`
+	req = httptest.NewRequest(http.MethodPost, "/api/webhooks?workspace=team-a", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer secret")
+	req = req.WithContext(tenant.WithScope(req.Context()))
+
+	endpoint := &webhook.Endpoint{Id: "e1", Workspace: "team-a", Url: "https://hooks.test.com", Secret: "s"}
+	hooks.EXPECT().CreateEndpoint(req.Context(), "https://hooks.test.com", []string{shortener.EventLinkCreated}).
+		Return(endpoint, nil)
+
+	resp = httptest.NewRecorder()
+	if err := api.createWebhook(resp, req); err != nil {
+		t.Error(err)
+	}
+
+	verifyStatus(t, http.StatusOK, resp.Code)
+	var payload ResponseWebhooks
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&payload))
+	require.Equal(t, []webhook.Endpoint{*endpoint}, payload.Endpoints)
+	workspace, _ := tenant.WorkspaceFrom(req.Context())
+	require.Equal(t, "team-a", workspace)
+
+	// the deliveries are filtered by status
+	req = httptest.NewRequest(http.MethodGet, "/api/webhooks/deliveries?status=dead&endpoint=e1", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+
+	hooks.EXPECT().Deliveries(req.Context(), webhook.Filter{EndpointId: "e1", Status: webhook.StatusDead, Limit: 100}).
+		Return([]webhook.Delivery{{Id: "d1", Status: webhook.StatusDead}}, nil)
+
+	resp = httptest.NewRecorder()
+	if err := api.deliveries(resp, req); err != nil {
+		t.Error(err)
+	}
+
+	verifyStatus(t, http.StatusOK, resp.Code)
+
+	req = httptest.NewRequest(http.MethodGet, "/api/webhooks/deliveries?status=lost", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+
+	resp = httptest.NewRecorder()
+	_ = api.deliveries(resp, req)
+
+	verifyStatus(t, http.StatusBadRequest, resp.Code)
+
+	// a dead delivery is sent again
+	req = httptest.NewRequest(http.MethodPost, "/api/webhooks/redeliver?id=d1", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+
+	hooks.EXPECT().Redeliver(req.Context(), "d1").Return(&webhook.Delivery{Id: "d1", Status: webhook.StatusPending}, nil)
+
+	resp = httptest.NewRecorder()
+	if err := api.redeliver(resp, req); err != nil {
+		t.Error(err)
+	}
+
+	verifyStatus(t, http.StatusOK, resp.Code)
+
+	// the endpoints are removed by id
+	req = httptest.NewRequest(http.MethodDelete, "/api/webhooks", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+
+	resp = httptest.NewRecorder()
+	_ = api.deleteWebhook(resp, req)
+
+	verifyStatus(t, http.StatusBadRequest, resp.Code)
+
+	// the requests need an api key
+	req = httptest.NewRequest(http.MethodGet, "/api/webhooks", nil)
+
+	resp = httptest.NewRecorder()
+	_ = api.webhooks(resp, req)
+
+	verifyStatus(t, http.StatusUnauthorized, resp.Code)
+}
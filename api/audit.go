@@ -0,0 +1,158 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gsiragusa/short-to-me/audit"
+	"github.com/gsiragusa/short-to-me/errors"
+	"github.com/gsiragusa/short-to-me/server"
+)
+
+// auditLog lists the entries of the audit log of the management operations
+func (api *API) auditLog(w http.ResponseWriter, r *http.Request) error {
+	// swagger:operation GET /api/audit Admin audit
+	// List the audit log
+	//
+	// Lists the recorded management operations, from the most recent. Requires the admin api key
+	// ---
+	// produces:
+	// - application/json
+	// parameters:
+	// - name: Authorization
+	//   in: header
+	//   description: "Bearer followed by the admin api key"
+	//   required: true
+	//   type: string
+	// - name: action
+	//   in: query
+	//   description: create, update, delete, restore, purge, import, export, revoke or redeliver
+	//   required: false
+	//   type: string
+	// - name: actor
+	//   in: query
+	//   description: who requested the operations, as recorded in the entries
+	//   required: false
+	//   type: string
+	// - name: url
+	//   in: query
+	//   description: short url the operations were applied to
+	//   required: false
+	//   type: string
+	// - name: request_id
+	//   in: query
+	//   description: id of the request of the operations
+	//   required: false
+	//   type: string
+	// - name: from
+	//   in: query
+	//   description: RFC 3339 time of the oldest entries
+	//   required: false
+	//   type: string
+	// - name: to
+	//   in: query
+	//   description: RFC 3339 time the entries are older than
+	//   required: false
+	//   type: string
+	// - name: offset
+	//   in: query
+	//   description: number of entries to skip, 0 by default
+	//   required: false
+	//   type: integer
+	// - name: limit
+	//   in: query
+	//   description: maximum number of entries to list, from 1 to 1000, 100 by default
+	//   required: false
+	//   type: integer
+	//
+	// responses:
+	//   '200':
+	//     description: "Audit entries with the values of the short urls, or the resources, before and after the operations"
+	//   '400':
+	//     description: Bad Request
+	//   '401':
+	//     description: Unauthorized
+	//   '403':
+	//     description: Forbidden
+	//   '500':
+	//     description: Internal Server Error
+
+	if err := api.authorizeAdmin(r); err != nil {
+		return server.WriteError(w, r, *err)
+	}
+
+	filter, err := parseAuditFilter(r)
+	if err != nil {
+		return server.WriteError(w, r, *err)
+	}
+
+	entries, err := api.svc.AuditLog(r.Context(), filter)
+	if err != nil {
+		return server.WriteError(w, r, *err)
+	}
+
+	resp := &ResponseAudit{
+		Status:    "ok",
+		Operation: "audit",
+		Entries:   entries,
+	}
+	return server.Write(w, http.StatusOK, resp)
+}
+
+// auditActions are the actions accepted by the filter of the audit log
+var auditActions = map[string]bool{
+	audit.ActionCreate:    true,
+	audit.ActionUpdate:    true,
+	audit.ActionDelete:    true,
+	audit.ActionRestore:   true,
+	audit.ActionPurge:     true,
+	audit.ActionImport:    true,
+	audit.ActionExport:    true,
+	audit.ActionRevoke:    true,
+	audit.ActionRedeliver: true,
+}
+
+// parseAuditFilter reads the filters and the pagination of the audit log
+func parseAuditFilter(r *http.Request) (audit.Filter, *errors.Error) {
+	query := r.URL.Query()
+	opts, err := parseListOptions(r)
+	e := errors.NewErrorBadRequest()
+	if err != nil {
+		e = *err
+	}
+
+	filter := audit.Filter{
+		Action:    query.Get("action"),
+		Actor:     query.Get("actor"),
+		RequestId: query.Get("request_id"),
+		Offset:    opts.Offset,
+		Limit:     opts.Limit,
+	}
+	if filter.Action != "" && !auditActions[filter.Action] {
+		e = e.WithDetail("action", "must be create, update, delete, restore, purge, import, export, revoke or redeliver")
+	}
+	if url := strings.TrimSuffix(query.Get("url"), "/"); url != "" {
+		split := strings.Split(url, "/")
+		filter.ShortId = split[len(split)-1]
+	}
+	if v := query.Get("from"); v != "" {
+		from, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			e = e.WithDetail("from", "must be an RFC 3339 time")
+		}
+		filter.From = from
+	}
+	if v := query.Get("to"); v != "" {
+		to, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			e = e.WithDetail("to", "must be an RFC 3339 time")
+		}
+		filter.To = to
+	}
+
+	if len(e.Details) > 0 {
+		return filter, &e
+	}
+	return filter, nil
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gsiragusa/short-to-me/errors"
+	"github.com/gsiragusa/short-to-me/server"
+	"github.com/gsiragusa/short-to-me/shortener"
+	"github.com/gsiragusa/short-to-me/tenant"
+)
+
+// maxCachedDomains bounds the hosts cached, the unknown ones included
+const maxCachedDomains = 1000
+
+// domainCache caches the lookups of the custom domains by the visits, the unknown hosts included
+type domainCache struct {
+	domains Domains
+	ttl     time.Duration
+
+	mu      sync.Mutex
+	entries map[string]cachedDomain
+}
+
+type cachedDomain struct {
+	domain  *tenant.Domain
+	expires time.Time
+}
+
+func newDomainCache(domains Domains, ttl time.Duration) *domainCache {
+	return &domainCache{
+		domains: domains,
+		ttl:     ttl,
+		entries: map[string]cachedDomain{},
+	}
+}
+
+// find returns the custom domain host, shortener.ErrNotFound when it is not registered
+func (c *domainCache) find(ctx context.Context, host string) (*tenant.Domain, error) {
+	now := time.Now()
+	c.mu.Lock()
+	entry, ok := c.entries[host]
+	c.mu.Unlock()
+	if !ok || !now.Before(entry.expires) {
+		d, err := c.domains.FindDomain(ctx, host)
+		if err != nil && err != shortener.ErrNotFound {
+			return nil, err
+		}
+		entry = cachedDomain{domain: d, expires: now.Add(c.ttl)}
+
+		c.mu.Lock()
+		if len(c.entries) >= maxCachedDomains {
+			c.entries = map[string]cachedDomain{}
+		}
+		c.entries[host] = entry
+		c.mu.Unlock()
+	}
+
+	if entry.domain == nil {
+		return nil, shortener.ErrNotFound
+	}
+	return entry.domain, nil
+}
+
+// domain returns the custom domain the request was sent to, nil for the short hosts. ok is false
+// for the unknown hosts, which are told apart from the short hosts only when these are set
+func (api *API) domain(r *http.Request) (*tenant.Domain, bool, *errors.Error) {
+	return api.hostDomain(r.Context(), r.Host)
+}
+
+// hostDomain returns the custom domain of the given host, as domain does for the host of a request
+func (api *API) hostDomain(ctx context.Context, rawHost string) (*tenant.Domain, bool, *errors.Error) {
+	host := tenant.NormalizeHost(rawHost)
+	for _, h := range api.conf.ShortHosts {
+		if strings.EqualFold(rawHost, h) || strings.EqualFold(host, h) {
+			return nil, true, nil
+		}
+	}
+
+	if api.domains != nil && tenant.ValidHost(host) {
+		d, err := api.domains.find(ctx, host)
+		if err == nil {
+			return d, true, nil
+		}
+		if err != shortener.ErrNotFound {
+			api.le.WithError(err).Error("unable to read the domain")
+			e := errors.NewInternalServerError()
+			return nil, false, &e
+		}
+	}
+	return nil, len(api.conf.ShortHosts) == 0, nil
+}
+
+// unknownHost answers the visits to the unknown hosts, redirecting them to the fallback destination
+// if any. The short ids are never looked up on another domain
+func (api *API) unknownHost(w http.ResponseWriter, r *http.Request) error {
+	api.le.WithField("host", r.Host).Info("visit to an unknown host")
+	if api.conf.UnknownHostUrl == "" {
+		return server.WriteError(w, r, errors.NewErrorLinkNotFound())
+	}
+	http.Redirect(w, r, api.conf.UnknownHostUrl, http.StatusFound)
+	return nil
+}
@@ -0,0 +1,176 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gsiragusa/short-to-me/server"
+)
+
+// health returns the outcome of the last check of the destinations of a short url
+func (api *API) health(w http.ResponseWriter, r *http.Request) error {
+	// swagger:operation GET /api/health Api health
+	// Health of a short url
+	//
+	// Returns the outcome of the last check of the destinations of a short url, null when they
+	// were never checked. Requires the admin api key, or the api key of the workspace of the short url
+	// ---
+	// produces:
+	// - application/json
+	// parameters:
+	// - name: Authorization
+	//   in: header
+	//   description: "Bearer followed by the admin api key or the api key of a workspace"
+	//   required: true
+	//   type: string
+	// - name: url
+	//   in: query
+	//   description: short url to read the health of
+	//   required: true
+	//   type: string
+	//
+	// responses:
+	//   '200':
+	//     description: "Health of the destinations"
+	//     schema:
+	//       type: object
+	//       properties:
+	//         status:
+	//           type: string
+	//           example: "ok"
+	//         operation:
+	//           type: string
+	//           example: "health"
+	//         url:
+	//           type: string
+	//           example: "http://www.example.com/RMAp1Vz"
+	//         health:
+	//           type: object
+	//           properties:
+	//             checked_at:
+	//               type: string
+	//               example: "2020-11-01T10:00:00Z"
+	//             broken:
+	//               type: boolean
+	//               example: true
+	//             destinations:
+	//               type: array
+	//               items:
+	//                 type: object
+	//                 properties:
+	//                   field:
+	//                     type: string
+	//                     example: "url"
+	//                   url:
+	//                     type: string
+	//                     example: "https://www.google.com/campaign"
+	//                   status:
+	//                     type: integer
+	//                     example: 404
+	//                   latency_ms:
+	//                     type: integer
+	//                     example: 120
+	//                   error:
+	//                     type: string
+	//                   broken:
+	//                     type: boolean
+	//                     example: true
+	//   '400':
+	//     description: Bad Request
+	//   '401':
+	//     description: Unauthorized
+	//   '403':
+	//     description: Forbidden
+	//   '404':
+	//     description: Not Found
+	//   '500':
+	//     description: Internal Server Error
+
+	// the outcome of the requests to the destinations is not public
+	if err := api.authorizeAdmin(r); err != nil {
+		return server.WriteError(w, r, *err)
+	}
+
+	// parse and validate input
+	url, err := api.parseInput(r)
+	if err != nil {
+		return server.WriteError(w, r, *err)
+	}
+
+	res, err := api.svc.Health(r.Context(), url)
+	if err != nil {
+		return server.WriteError(w, r, *err)
+	}
+
+	resp := &ResponseHealth{
+		Status:    "ok",
+		Operation: "health",
+		Url:       url,
+		Health:    res,
+	}
+	return server.Write(w, http.StatusOK, resp)
+}
+
+// broken lists the short urls with a broken destination at their last check
+func (api *API) broken(w http.ResponseWriter, r *http.Request) error {
+	// swagger:operation GET /api/broken Admin broken
+	// List the broken short urls
+	//
+	// Lists the short urls with a broken destination at their last check, sorted by id.
+	// Requires the admin api key
+	// ---
+	// produces:
+	// - application/json
+	// parameters:
+	// - name: Authorization
+	//   in: header
+	//   description: "Bearer followed by the admin api key"
+	//   required: true
+	//   type: string
+	// - name: offset
+	//   in: query
+	//   description: number of short urls to skip, 0 by default
+	//   required: false
+	//   type: integer
+	// - name: limit
+	//   in: query
+	//   description: maximum number of short urls to list, from 1 to 1000, 100 by default
+	//   required: false
+	//   type: integer
+	//
+	// responses:
+	//   '200':
+	//     description: "Broken short urls with the health of their destinations"
+	//   '400':
+	//     description: Bad Request
+	//   '401':
+	//     description: Unauthorized
+	//   '403':
+	//     description: Forbidden
+	//   '500':
+	//     description: Internal Server Error
+
+	if err := api.authorizeAdmin(r); err != nil {
+		return server.WriteError(w, r, *err)
+	}
+
+	opts, err := parseListOptions(r)
+	if err != nil {
+		return server.WriteError(w, r, *err)
+	}
+	opts.Broken = true
+
+	urls, err := api.svc.ListUrls(r.Context(), opts)
+	if err != nil {
+		return server.WriteError(w, r, *err)
+	}
+
+	resp := &ResponseBroken{
+		Status:    "ok",
+		Operation: "broken",
+		Urls:      make([]BrokenUrl, 0, len(urls)),
+	}
+	for _, u := range urls {
+		resp.Urls = append(resp.Urls, BrokenUrl{Id: u.Id, Url: u.Url, Health: u.Health})
+	}
+	return server.Write(w, http.StatusOK, resp)
+}
@@ -0,0 +1,19 @@
+package api
+
+import (
+	"context"
+
+	"github.com/gsiragusa/short-to-me/tenant"
+)
+
+// Keys looks up the api keys of the workspaces
+type Keys interface {
+	// FindKey returns the api key with the given hash, shortener.ErrNotFound when it doesn't exist
+	FindKey(ctx context.Context, hash string) (*tenant.Key, error)
+}
+
+// Domains looks up the custom domains of the workspaces
+type Domains interface {
+	// FindDomain returns the custom domain host, shortener.ErrNotFound when it is not registered
+	FindDomain(ctx context.Context, host string) (*tenant.Domain, error)
+}
@@ -1,13 +1,104 @@
 package api
 
+import (
+	"time"
+
+	"github.com/gsiragusa/short-to-me/audit"
+	"github.com/gsiragusa/short-to-me/shortener"
+	"github.com/gsiragusa/short-to-me/webhook"
+)
+
+// RequestCreate is the json body of a request to shorten a url
+type RequestCreate struct {
+	Url string `json:"url"`
+	shortener.LinkOptions
+}
+
 type ResponseApi struct {
 	Status    string `json:"status"`
 	Operation string `json:"operation"`
 	Url       string `json:"url"`
+	// Qr is the url of the qr code of a new short url
+	Qr string `json:"qr,omitempty"`
+	// Labels are the title, description, tags and metadata of the short url read or updated
+	*shortener.Labels
+}
+
+// RequestUpdate is the json body of a request to change the labels of a short url
+type RequestUpdate struct {
+	Url string `json:"url"`
+	shortener.LinkUpdate
+}
+
+type ResponseLinks struct {
+	Status    string                    `json:"status"`
+	Operation string                    `json:"operation"`
+	Urls      []*shortener.ModelShorten `json:"urls"`
+}
+
+type ResponseHealth struct {
+	Status    string            `json:"status"`
+	Operation string            `json:"operation"`
+	Url       string            `json:"url"`
+	Health    *shortener.Health `json:"health"`
+}
+
+type ResponseBroken struct {
+	Status    string      `json:"status"`
+	Operation string      `json:"operation"`
+	Urls      []BrokenUrl `json:"urls"`
+}
+
+// BrokenUrl is a short url with a broken destination
+type BrokenUrl struct {
+	Id     string            `json:"id"`
+	Url    string            `json:"url"`
+	Health *shortener.Health `json:"health"`
+}
+
+type ResponseTrash struct {
+	Status    string       `json:"status"`
+	Operation string       `json:"operation"`
+	Urls      []DeletedUrl `json:"urls"`
+}
+
+// DeletedUrl is a short url in the trash, restorable until PurgeAt
+type DeletedUrl struct {
+	Id        string    `json:"id"`
+	Url       string    `json:"url"`
+	DeletedAt time.Time `json:"deleted_at"`
+	PurgeAt   time.Time `json:"purge_at"`
+}
+
+type ResponseAudit struct {
+	Status    string        `json:"status"`
+	Operation string        `json:"operation"`
+	Entries   []audit.Entry `json:"entries"`
 }
 
 type ResponseCount struct {
-	Status    string `json:"status"`
-	Operation string `json:"operation"`
-	Count     int64  `json:"count"`
+	Status       string           `json:"status"`
+	Operation    string           `json:"operation"`
+	Count        int64            `json:"count"`
+	Destinations map[string]int64 `json:"destinations,omitempty"`
+	Countries    map[string]int64 `json:"countries,omitempty"`
+	Variants     map[string]int64 `json:"variants,omitempty"`
+}
+
+// RequestWebhook is the json body of a request to register a webhook endpoint
+type RequestWebhook struct {
+	Url    string   `json:"url"`
+	Events []string `json:"events"`
+}
+
+type ResponseWebhooks struct {
+	Status    string             `json:"status"`
+	Operation string             `json:"operation"`
+	Endpoints []webhook.Endpoint `json:"endpoints"`
+}
+
+type ResponseDeliveries struct {
+	Status     string             `json:"status"`
+	Operation  string             `json:"operation"`
+	Deliveries []webhook.Delivery `json:"deliveries"`
 }
@@ -0,0 +1,204 @@
+package api
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"strconv"
+
+	"github.com/gsiragusa/short-to-me/errors"
+	"github.com/gsiragusa/short-to-me/qr"
+	"github.com/gsiragusa/short-to-me/server"
+)
+
+// Limits of the qr code parameters
+const (
+	qrMinSize   = 64
+	qrMaxSize   = 2048
+	qrMaxMargin = 16
+)
+
+// qrCode renders the QR code of a short url
+func (api *API) qrCode(w http.ResponseWriter, r *http.Request) error {
+	// swagger:operation GET /api/qr Api qrCode
+	// QR code of a short url
+	//
+	// Renders the QR code of a short url as a PNG or SVG image. The endpoint is public, as the redirects:
+	// the short url is looked up in every workspace, on the domain of its url only
+	// ---
+	// produces:
+	// - image/png
+	// - image/svg+xml
+	// parameters:
+	// - name: url
+	//   in: query
+	//   description: short url to encode
+	//   required: true
+	//   type: string
+	// - name: format
+	//   in: query
+	//   description: png (default) or svg
+	//   required: false
+	//   type: string
+	// - name: size
+	//   in: query
+	//   description: width and height of the image in pixels, from 64 to 2048, 256 by default
+	//   required: false
+	//   type: integer
+	// - name: level
+	//   in: query
+	//   description: error correction level, L, M (default), Q or H
+	//   required: false
+	//   type: string
+	// - name: margin
+	//   in: query
+	//   description: width of the quiet zone in modules, from 0 to 16, 4 by default
+	//   required: false
+	//   type: integer
+	// - name: fg
+	//   in: query
+	//   description: foreground color, RRGGBB or RRGGBBAA hex, 000000 by default
+	//   required: false
+	//   type: string
+	// - name: bg
+	//   in: query
+	//   description: background color, RRGGBB or RRGGBBAA hex, ffffff by default
+	//   required: false
+	//   type: string
+	// - name: logo
+	//   in: query
+	//   description: draws the QR_LOGO_PATH logo at the center, with the H error correction level
+	//   required: false
+	//   type: boolean
+	//
+	// responses:
+	//   '200':
+	//     description: "The QR code image"
+	//   '400':
+	//     description: Bad Request
+	//   '404':
+	//     description: "The short url doesn't exist on the host of the url"
+	//   '500':
+	//     description: Internal Server Error
+
+	url, err := api.parseInput(r)
+	if err != nil {
+		return server.WriteError(w, r, *err)
+	}
+	opts, format, err := api.parseQr(r)
+	if err != nil {
+		return server.WriteError(w, r, *err)
+	}
+
+	// the endpoint is public, the qr codes are embedded in the pages as images. The short url is
+	// resolved as the redirects resolve it, without api key nor workspace but on the domain of its
+	// url, so that the response tells no more than a visit would
+	parsed, perr := neturl.Parse(url)
+	if perr != nil {
+		return server.WriteError(w, r, errors.NewErrorInvalidUrl("must be a valid url"))
+	}
+	d, ok, err := api.hostDomain(r.Context(), parsed.Host)
+	if err != nil {
+		return server.WriteError(w, r, *err)
+	}
+	if !ok {
+		return server.WriteError(w, r, errors.NewErrorLinkNotFound())
+	}
+	existing, err := api.svc.GetUrl(r.Context(), url)
+	if err != nil {
+		return server.WriteError(w, r, *err)
+	}
+	domain := ""
+	if d != nil {
+		domain = d.Host
+	}
+	if existing.Domain != domain {
+		api.le.WithField("url", url).Error("url was not found on the domain")
+		return server.WriteError(w, r, errors.NewErrorLinkNotFound())
+	}
+
+	render, contentType := qr.PNG, "image/png"
+	if format == "svg" {
+		render, contentType = qr.SVG, "image/svg+xml"
+	}
+	var buf bytes.Buffer
+	if err := render(&buf, url, opts); err != nil {
+		api.le.WithError(err).Error("unable to render qr code")
+		return server.WriteError(w, r, errors.NewInternalServerError())
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	// the short url can be deleted or moved to another domain, the shared caches never keep it
+	w.Header().Set("Cache-Control", "private, max-age=300")
+	w.WriteHeader(http.StatusOK)
+	_, e := io.Copy(w, &buf)
+	return e
+}
+
+// parseQr reads the rendering options of a qr code, detailing every invalid parameter
+func (api *API) parseQr(r *http.Request) (qr.Options, string, *errors.Error) {
+	query := r.URL.Query()
+	opts := qr.DefaultOptions()
+	e := errors.NewErrorBadRequest()
+
+	format := query.Get("format")
+	switch format {
+	case "", "png":
+		format = "png"
+	case "svg":
+	default:
+		e = e.WithDetail("format", "must be png or svg")
+	}
+
+	if v := query.Get("size"); v != "" {
+		size, err := strconv.Atoi(v)
+		if err != nil || size < qrMinSize || size > qrMaxSize {
+			e = e.WithDetail("size", "must be an integer from 64 to 2048")
+		}
+		opts.Size = size
+	}
+	if v := query.Get("margin"); v != "" {
+		margin, err := strconv.Atoi(v)
+		if err != nil || margin < 0 || margin > qrMaxMargin {
+			e = e.WithDetail("margin", "must be an integer from 0 to 16")
+		}
+		opts.Margin = margin
+	}
+	if v := query.Get("level"); v != "" {
+		if !qr.ValidLevel(v) {
+			e = e.WithDetail("level", "must be L, M, Q or H")
+		}
+		opts.Level = v
+	}
+	if v := query.Get("fg"); v != "" {
+		c, err := qr.ParseColor(v)
+		if err != nil {
+			e = e.WithDetail("fg", "must be a RRGGBB or RRGGBBAA hex color")
+		}
+		opts.Foreground = c
+	}
+	if v := query.Get("bg"); v != "" {
+		c, err := qr.ParseColor(v)
+		if err != nil {
+			e = e.WithDetail("bg", "must be a RRGGBB or RRGGBBAA hex color")
+		}
+		opts.Background = c
+	}
+	if v := query.Get("logo"); v != "" {
+		logo, err := strconv.ParseBool(v)
+		switch {
+		case err != nil:
+			e = e.WithDetail("logo", "must be true or false")
+		case logo && api.qrLogo == nil:
+			e = e.WithDetail("logo", "no logo is configured")
+		case logo:
+			opts.Logo = api.qrLogo
+		}
+	}
+
+	if len(e.Details) > 0 {
+		return opts, format, &e
+	}
+	return opts, format, nil
+}
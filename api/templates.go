@@ -0,0 +1,72 @@
+package api
+
+import (
+	"html/template"
+
+	"github.com/gsiragusa/short-to-me/errors"
+	"github.com/gsiragusa/short-to-me/shortener"
+)
+
+// pageLayout is shared by the html pages served to the visitors of the short urls
+const pageLayout = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>{{template "title" .}}</title>
+<style>
+body { font-family: sans-serif; max-width: 28rem; margin: 4rem auto; padding: 0 1rem; color: #222; }
+input, button { font-size: 1rem; padding: .5rem; }
+.error { color: #b00020; }
+.destination { word-break: break-all; font-family: monospace; }
+.details { color: #666; }
+</style>
+</head>
+<body>
+{{template "content" .}}
+</body>
+</html>`
+
+// passwordPage is posted back to the url of the visit, keeping its query
+type passwordPage struct {
+	Error *errors.Error
+}
+
+var passwordTemplate = template.Must(template.Must(template.New("password").Parse(pageLayout)).Parse(`
+{{define "title"}}Protected link{{end}}
+{{define "content"}}
+<h1>Protected link</h1>
+<p>This link is protected by a password.</p>
+{{if and .Error (ne .Error.Code "password_required")}}<p class="error">{{.Error.Message}}</p>{{end}}
+<form method="post">
+<input type="password" name="password" placeholder="Password" autofocus required>
+<button type="submit">Continue</button>
+</form>
+{{end}}`))
+
+var comingSoonTemplate = template.Must(template.Must(template.New("coming-soon").Parse(pageLayout)).Parse(`
+{{define "title"}}Coming soon{{end}}
+{{define "content"}}
+<h1>Coming soon</h1>
+<p>This link is not active yet. Please come back later.</p>
+{{end}}`))
+
+// previewPage shows the destination of a short url, confirming the visit to Action
+type previewPage struct {
+	*shortener.Preview
+	Action string
+}
+
+var previewTemplate = template.Must(template.Must(template.New("preview").Parse(pageLayout)).Parse(`
+{{define "title"}}Link preview{{end}}
+{{define "content"}}
+<h1>This link goes to {{.Host}}</h1>
+{{if .Title}}<h2>{{.Title}}</h2>{{end}}
+{{if .Description}}<p>{{.Description}}</p>{{end}}
+<p class="destination">{{.Url}}</p>
+<p class="details">Created {{if .CreatedAt.IsZero}}a while ago{{else}}on {{.CreatedAt.Format "January 2, 2006"}}{{end}}, followed {{.Count}} {{if eq .Count 1}}time{{else}}times{{end}}.</p>
+<form method="post" action="{{.Action}}">
+<button type="submit">Continue</button>
+</form>
+{{end}}`))
@@ -0,0 +1,372 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gsiragusa/short-to-me/errors"
+	"github.com/gsiragusa/short-to-me/server"
+	"github.com/gsiragusa/short-to-me/webhook"
+)
+
+// webhooks lists the webhook endpoints
+func (api *API) webhooks(w http.ResponseWriter, r *http.Request) error {
+	// swagger:operation GET /api/webhooks Admin webhooks
+	// List the webhook endpoints
+	//
+	// Lists the endpoints receiving the events of the short urls, without their secret. Requires the
+	// admin api key, or the api key of a workspace listing its own endpoints
+	// ---
+	// produces:
+	// - application/json
+	// parameters:
+	// - name: Authorization
+	//   in: header
+	//   description: "Bearer followed by the admin api key or the api key of a workspace"
+	//   required: true
+	//   type: string
+	// - name: workspace
+	//   in: query
+	//   description: workspace of the endpoints, for the admin api key
+	//   required: false
+	//   type: string
+	//
+	// responses:
+	//   '200':
+	//     description: "The webhook endpoints"
+	//   '401':
+	//     description: Unauthorized
+	//   '403':
+	//     description: Forbidden
+	//   '404':
+	//     description: Not Found, the webhooks are not enabled
+	//   '500':
+	//     description: Internal Server Error
+
+	if err := api.authorizeWebhooks(r); err != nil {
+		return server.WriteError(w, r, *err)
+	}
+
+	endpoints, err := api.hooks.ListEndpoints(r.Context())
+	if err != nil {
+		return server.WriteError(w, r, *err)
+	}
+
+	resp := &ResponseWebhooks{
+		Status:    "ok",
+		Operation: "webhooks",
+		Endpoints: endpoints,
+	}
+	return server.Write(w, http.StatusOK, resp)
+}
+
+// createWebhook registers a webhook endpoint
+func (api *API) createWebhook(w http.ResponseWriter, r *http.Request) error {
+	// swagger:operation POST /api/webhooks Admin createWebhook
+	// Register a webhook endpoint
+	//
+	// Registers an url receiving the events of the short urls of the workspace. The response holds the
+	// secret signing the deliveries, it is not returned again
+	// ---
+	// consumes:
+	// - application/json
+	// produces:
+	// - application/json
+	// parameters:
+	// - name: Authorization
+	//   in: header
+	//   description: "Bearer followed by the admin api key or the api key of a workspace"
+	//   required: true
+	//   type: string
+	// - name: workspace
+	//   in: query
+	//   description: workspace of the endpoint, for the admin api key, default by default
+	//   required: false
+	//   type: string
+	// - name: body
+	//   in: body
+	//   description: "url of the endpoint and events sent to it: link.created, link.updated, link.deleted, link.expired or link.clicks, all of them by default"
+	//   required: true
+	//   schema:
+	//     type: object
+	//     properties:
+	//       url:
+	//         type: string
+	//       events:
+	//         type: array
+	//         items:
+	//           type: string
+	//
+	// responses:
+	//   '200':
+	//     description: "The webhook endpoint with its secret"
+	//   '400':
+	//     description: Bad Request
+	//   '401':
+	//     description: Unauthorized
+	//   '403':
+	//     description: Forbidden
+	//   '404':
+	//     description: Not Found, the webhooks are not enabled
+	//   '500':
+	//     description: Internal Server Error
+
+	if err := api.authorizeWebhooks(r); err != nil {
+		return server.WriteError(w, r, *err)
+	}
+
+	body := &RequestWebhook{}
+	if err := json.NewDecoder(r.Body).Decode(body); err != nil {
+		api.le.WithError(err).Error("unable to decode request body")
+		e := errors.NewErrorBadRequest().WithDetail("body", "must be a valid json object")
+		return server.WriteError(w, r, e)
+	}
+
+	endpoint, err := api.hooks.CreateEndpoint(r.Context(), body.Url, body.Events)
+	if err != nil {
+		return server.WriteError(w, r, *err)
+	}
+
+	resp := &ResponseWebhooks{
+		Status:    "ok",
+		Operation: "create-webhook",
+		Endpoints: []webhook.Endpoint{*endpoint},
+	}
+	return server.Write(w, http.StatusOK, resp)
+}
+
+// deleteWebhook removes a webhook endpoint
+func (api *API) deleteWebhook(w http.ResponseWriter, r *http.Request) error {
+	// swagger:operation DELETE /api/webhooks Admin deleteWebhook
+	// Remove a webhook endpoint
+	//
+	// Removes an endpoint, its pending deliveries are not sent
+	// ---
+	// produces:
+	// - application/json
+	// parameters:
+	// - name: Authorization
+	//   in: header
+	//   description: "Bearer followed by the admin api key or the api key of a workspace"
+	//   required: true
+	//   type: string
+	// - name: id
+	//   in: query
+	//   description: id of the endpoint
+	//   required: true
+	//   type: string
+	//
+	// responses:
+	//   '200':
+	//     description: "The endpoint was removed"
+	//   '400':
+	//     description: Bad Request
+	//   '401':
+	//     description: Unauthorized
+	//   '403':
+	//     description: Forbidden
+	//   '404':
+	//     description: Not Found
+	//   '500':
+	//     description: Internal Server Error
+
+	if err := api.authorizeWebhooks(r); err != nil {
+		return server.WriteError(w, r, *err)
+	}
+
+	id, err := requiredId(r)
+	if err != nil {
+		return server.WriteError(w, r, *err)
+	}
+	if err := api.hooks.DeleteEndpoint(r.Context(), id); err != nil {
+		return server.WriteError(w, r, *err)
+	}
+
+	resp := &ResponseWebhooks{
+		Status:    "ok",
+		Operation: "delete-webhook",
+		Endpoints: []webhook.Endpoint{},
+	}
+	return server.Write(w, http.StatusOK, resp)
+}
+
+// deliveries lists the log of the webhook deliveries
+func (api *API) deliveries(w http.ResponseWriter, r *http.Request) error {
+	// swagger:operation GET /api/webhooks/deliveries Admin deliveries
+	// List the webhook deliveries
+	//
+	// Lists the events sent to the endpoints, from the most recent, with the outcome of their last attempt
+	// ---
+	// produces:
+	// - application/json
+	// parameters:
+	// - name: Authorization
+	//   in: header
+	//   description: "Bearer followed by the admin api key or the api key of a workspace"
+	//   required: true
+	//   type: string
+	// - name: endpoint
+	//   in: query
+	//   description: id of the endpoint of the deliveries
+	//   required: false
+	//   type: string
+	// - name: status
+	//   in: query
+	//   description: pending, delivered or dead
+	//   required: false
+	//   type: string
+	// - name: offset
+	//   in: query
+	//   description: number of deliveries to skip, 0 by default
+	//   required: false
+	//   type: integer
+	// - name: limit
+	//   in: query
+	//   description: maximum number of deliveries to list, from 1 to 1000, 100 by default
+	//   required: false
+	//   type: integer
+	//
+	// responses:
+	//   '200':
+	//     description: "The webhook deliveries"
+	//   '400':
+	//     description: Bad Request
+	//   '401':
+	//     description: Unauthorized
+	//   '403':
+	//     description: Forbidden
+	//   '404':
+	//     description: Not Found, the webhooks are not enabled
+	//   '500':
+	//     description: Internal Server Error
+
+	if err := api.authorizeWebhooks(r); err != nil {
+		return server.WriteError(w, r, *err)
+	}
+
+	filter, err := parseDeliveryFilter(r)
+	if err != nil {
+		return server.WriteError(w, r, *err)
+	}
+
+	deliveries, err := api.hooks.Deliveries(r.Context(), filter)
+	if err != nil {
+		return server.WriteError(w, r, *err)
+	}
+
+	resp := &ResponseDeliveries{
+		Status:     "ok",
+		Operation:  "deliveries",
+		Deliveries: deliveries,
+	}
+	return server.Write(w, http.StatusOK, resp)
+}
+
+// redeliver sends a webhook delivery again
+func (api *API) redeliver(w http.ResponseWriter, r *http.Request) error {
+	// swagger:operation POST /api/webhooks/redeliver Admin redeliver
+	// Redeliver a webhook
+	//
+	// Sends a delivery again with its original payload, the dead ones included
+	// ---
+	// produces:
+	// - application/json
+	// parameters:
+	// - name: Authorization
+	//   in: header
+	//   description: "Bearer followed by the admin api key or the api key of a workspace"
+	//   required: true
+	//   type: string
+	// - name: id
+	//   in: query
+	//   description: id of the delivery
+	//   required: true
+	//   type: string
+	//
+	// responses:
+	//   '200':
+	//     description: "The delivery, pending again"
+	//   '400':
+	//     description: Bad Request
+	//   '401':
+	//     description: Unauthorized
+	//   '403':
+	//     description: Forbidden
+	//   '404':
+	//     description: Not Found
+	//   '500':
+	//     description: Internal Server Error
+
+	if err := api.authorizeWebhooks(r); err != nil {
+		return server.WriteError(w, r, *err)
+	}
+
+	id, err := requiredId(r)
+	if err != nil {
+		return server.WriteError(w, r, *err)
+	}
+	delivery, err := api.hooks.Redeliver(r.Context(), id)
+	if err != nil {
+		return server.WriteError(w, r, *err)
+	}
+
+	resp := &ResponseDeliveries{
+		Status:     "ok",
+		Operation:  "redeliver",
+		Deliveries: []webhook.Delivery{*delivery},
+	}
+	return server.Write(w, http.StatusOK, resp)
+}
+
+// authorizeWebhooks verifies the api key of the requests of the webhooks, which are not found when
+// the webhooks are not enabled
+func (api *API) authorizeWebhooks(r *http.Request) *errors.Error {
+	if api.hooks == nil {
+		api.le.Error("webhooks are not enabled")
+		e := errors.NewErrorNotFound()
+		return &e
+	}
+	return api.authorizeAdmin(r)
+}
+
+// deliveryStatuses are the statuses accepted by the filter of the deliveries
+var deliveryStatuses = map[string]bool{
+	webhook.StatusPending:   true,
+	webhook.StatusDelivered: true,
+	webhook.StatusDead:      true,
+}
+
+// parseDeliveryFilter reads the filters and the pagination of the deliveries
+func parseDeliveryFilter(r *http.Request) (webhook.Filter, *errors.Error) {
+	query := r.URL.Query()
+	opts, err := parseListOptions(r)
+	e := errors.NewErrorBadRequest()
+	if err != nil {
+		e = *err
+	}
+
+	filter := webhook.Filter{
+		EndpointId: query.Get("endpoint"),
+		Status:     query.Get("status"),
+		Offset:     opts.Offset,
+		Limit:      opts.Limit,
+	}
+	if filter.Status != "" && !deliveryStatuses[filter.Status] {
+		e = e.WithDetail("status", "must be pending, delivered or dead")
+	}
+
+	if len(e.Details) > 0 {
+		return filter, &e
+	}
+	return filter, nil
+}
+
+// requiredId reads the id query parameter
+func requiredId(r *http.Request) (string, *errors.Error) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		e := errors.NewErrorBadRequest().WithDetail("id", "is required")
+		return "", &e
+	}
+	return id, nil
+}
@@ -0,0 +1,160 @@
+// Package audit describes the entries of the audit log of the management operations, and who
+// requested them through the context of the requests
+package audit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Actions of the audit entries
+const (
+	ActionCreate    = "create"
+	ActionUpdate    = "update"
+	ActionDelete    = "delete"
+	ActionRestore   = "restore"
+	ActionPurge     = "purge"
+	ActionImport    = "import"
+	ActionExport    = "export"
+	ActionRevoke    = "revoke"
+	ActionRedeliver = "redeliver"
+)
+
+// Resources of the audit entries of the operations on something else than a short url
+const (
+	ResourceWorkspace = "workspace"
+	ResourceKey       = "key"
+	ResourceDomain    = "domain"
+	ResourceWebhook   = "webhook"
+	ResourceDelivery  = "delivery"
+)
+
+// Actors of the requests that are not authenticated by the api key of a workspace
+const (
+	ActorAnonymous = "anonymous"
+	ActorAdmin     = "admin"
+	ActorCli       = "cli"
+	ActorSystem    = "system"
+)
+
+// ActorKey is the actor of the requests authenticated with the api key id of a workspace
+func ActorKey(id string) string {
+	return "key:" + id
+}
+
+// Origin identifies who requested an operation
+type Origin struct {
+	Actor     string `json:"actor" bson:"actor"`
+	ClientIp  string `json:"client_ip,omitempty" bson:"client_ip,omitempty"`
+	RequestId string `json:"request_id,omitempty" bson:"request_id,omitempty"`
+}
+
+// Entry is an immutable record of an operation
+type Entry struct {
+	Id     string    `json:"id" bson:"_id"`
+	Time   time.Time `json:"time" bson:"time"`
+	Action string    `json:"action" bson:"action"`
+	// Workspace is the workspace of the short urls of the operation
+	Workspace string `json:"workspace,omitempty" bson:"workspace,omitempty"`
+	Origin    `bson:",inline"`
+	// ShortId is the id of the short url of the operation, if any
+	ShortId string `json:"short_id,omitempty" bson:"short_id,omitempty"`
+	// Resource and ResourceId identify the resource of the operations on something else than a
+	// short url, like a workspace or an api key
+	Resource   string `json:"resource,omitempty" bson:"resource,omitempty"`
+	ResourceId string `json:"resource_id,omitempty" bson:"resource_id,omitempty"`
+	// Before and After are the json documents of the short url, or the resource, around the operation
+	Before json.RawMessage `json:"before,omitempty" bson:"before,omitempty"`
+	After  json.RawMessage `json:"after,omitempty" bson:"after,omitempty"`
+	// Details describe the outcome of the operations on several short urls
+	Details map[string]int64 `json:"details,omitempty" bson:"details,omitempty"`
+}
+
+// Filter selects the entries of the audit log, from the most recent
+type Filter struct {
+	Action    string
+	Actor     string
+	ShortId   string
+	RequestId string
+	// From and To delimit the time of the entries, when set
+	From   time.Time
+	To     time.Time
+	Offset int64
+	Limit  int64
+}
+
+type originKey struct{}
+
+// WithOrigin returns a copy of ctx carrying origin. The actor can be refined with SetActor once
+// the request is authenticated, the other fields are immutable
+func WithOrigin(ctx context.Context, origin *Origin) context.Context {
+	return context.WithValue(ctx, originKey{}, origin)
+}
+
+// OriginFrom returns the origin carried by ctx, an anonymous one when there is none
+func OriginFrom(ctx context.Context) Origin {
+	if origin, ok := ctx.Value(originKey{}).(*Origin); ok {
+		return *origin
+	}
+	return Origin{Actor: ActorAnonymous}
+}
+
+// SetActor sets the actor of the origin carried by ctx, if any
+func SetActor(ctx context.Context, actor string) {
+	if origin, ok := ctx.Value(originKey{}).(*Origin); ok {
+		origin.Actor = actor
+	}
+}
+
+// NewId returns a random id for an entry or a request
+func NewId() string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		// the time is unique enough for the entries of a single process
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Recorder appends the entries to the audit log
+type Recorder interface {
+	InsertAudit(ctx context.Context, entry Entry) error
+}
+
+// Record appends entry to the audit log of recorder, with a new id, the current time and the origin
+// carried by ctx. The operation is already done when it is recorded, a failure is only logged
+func Record(ctx context.Context, le logrus.FieldLogger, recorder Recorder, entry Entry) {
+	if recorder == nil {
+		return
+	}
+	entry.Id = NewId()
+	entry.Time = time.Now().UTC()
+	entry.Origin = OriginFrom(ctx)
+	if err := recorder.InsertAudit(ctx, entry); err != nil {
+		le = le.WithError(err).WithField("action", entry.Action)
+		if entry.ShortId != "" {
+			le = le.WithField("id", entry.ShortId)
+		} else if entry.Resource != "" {
+			le = le.WithField(entry.Resource, entry.ResourceId)
+		}
+		le.Error("unable to record the audit entry")
+	}
+}
+
+// Snapshot returns the json document of v for Before or After, nil when v is nil
+func Snapshot(v interface{}) json.RawMessage {
+	if v == nil {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	return data
+}
@@ -0,0 +1,63 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrigin(t *testing.T) {
+	require.Equal(t, Origin{Actor: ActorAnonymous}, OriginFrom(context.Background()))
+
+	ctx := WithOrigin(context.Background(), &Origin{Actor: ActorAnonymous, ClientIp: "192.0.2.1", RequestId: "abc"})
+	SetActor(ctx, ActorAdmin)
+
+	require.Equal(t, Origin{Actor: ActorAdmin, ClientIp: "192.0.2.1", RequestId: "abc"}, OriginFrom(ctx))
+
+	// no origin to refine
+	SetActor(context.Background(), ActorAdmin)
+}
+
+func TestNewId(t *testing.T) {
+	id := NewId()
+	require.Len(t, id, 24)
+	require.NotEqual(t, id, NewId())
+}
+
+type recorder struct {
+	entries []Entry
+	err     error
+}
+
+func (r *recorder) InsertAudit(_ context.Context, entry Entry) error {
+	r.entries = append(r.entries, entry)
+	return r.err
+}
+
+func TestRecord(t *testing.T) {
+	log := logrus.New()
+	log.Out = ioutil.Discard
+	origin := Origin{Actor: ActorCli}
+	ctx := WithOrigin(context.Background(), &origin)
+
+	// the entry is completed with its id, time and origin
+	r := &recorder{}
+	Record(ctx, log, r, Entry{Action: ActionCreate, Resource: ResourceKey, ResourceId: "k1", After: Snapshot(map[string]string{"name": "ci"})})
+	require.Len(t, r.entries, 1)
+	require.Len(t, r.entries[0].Id, 24)
+	require.False(t, r.entries[0].Time.IsZero())
+	require.Equal(t, origin, r.entries[0].Origin)
+	require.JSONEq(t, `{"name":"ci"}`, string(r.entries[0].After))
+
+	// a failure doesn't fail the operation, nor does the lack of audit log
+	r.err = errors.New("unavailable")
+	Record(ctx, log, r, Entry{Action: ActionDelete, ShortId: "abc"})
+	require.Len(t, r.entries, 2)
+	Record(ctx, log, nil, Entry{Action: ActionDelete})
+
+	require.Nil(t, Snapshot(nil))
+}
@@ -0,0 +1,836 @@
+package main
+
+import (
+	"context"
+	stderrors "errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gsiragusa/short-to-me/api"
+	"github.com/gsiragusa/short-to-me/audit"
+	"github.com/gsiragusa/short-to-me/errors"
+	"github.com/gsiragusa/short-to-me/outbox"
+	"github.com/gsiragusa/short-to-me/qr"
+	"github.com/gsiragusa/short-to-me/server"
+	"github.com/gsiragusa/short-to-me/shortener"
+	"github.com/gsiragusa/short-to-me/tenant"
+	"github.com/gsiragusa/short-to-me/webhook"
+)
+
+type command struct {
+	name  string
+	args  string
+	short string
+	run   func(env *environment, args []string) error
+}
+
+var commands = []command{
+	{"serve", "", "start the HTTP server (default)", serve},
+	{"create", "[-json] [-host host] [options] <url>", "shorten a url", create},
+	{"get", "[-json] <short url|id>", "show a short url", get},
+	{"update", "[-json] [-title t] [-description d] [-tag t] [-meta k=v] <url>", "change the labels of a short url", update},
+	{"delete", "<short url|id>", "move a short url to the trash", deleteUrl},
+	{"restore", "<short url|id>", "restore a deleted short url", restore},
+	{"stats", "[-json] [short url|id]", "show the redirects of a short url, or the totals", stats},
+	{"list", "[-json] [-offset n] [-limit n] [-broken] [-deleted] [-tag t] [-search s]", "list the short urls", list},
+	{"check", "", "check the destinations of the short urls now", check},
+	{"import", "[-json] [-format jsonl|csv] [-overwrite] [file]", "import short urls from a file or stdin", importUrls},
+	{"export", "[-format jsonl|csv] [-o file]", "export all the short urls to a file or stdout", export},
+	{"purge", "", "remove the short urls deleted before the retention period", purge},
+	{"workspace", "[-json] [-name n] [-max-links n] [id]", "create a workspace, or list the workspaces", workspace},
+	{"key", "[-json] [-name n] [-revoke] [workspace|key id]", "create an api key of a workspace, revoke one, or list them", key},
+	{"domain", "[-json] [-remove] [host [workspace]]", "register a custom domain of a workspace, remove one, or list them", domain},
+	{"webhook", "[-json] [-workspace w] [-event e] [-remove] [url|webhook id]", "register a webhook endpoint, remove one, or list them", webhookCmd},
+	{"deliveries", "[-json] [-workspace w] [-endpoint id] [-status s] [-offset n] [-limit n]", "list the webhook deliveries", deliveries},
+	{"redeliver", "<delivery id>", "send a webhook delivery again", redeliver},
+	{"migrate", "", "apply the pending schema migrations", migrate},
+}
+
+func findCommand(name string) (command, bool) {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return command{}, false
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: short-to-me <command> [arguments]\n\ncommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-9s %-74s %s\n", cmd.name, cmd.args, cmd.short)
+	}
+}
+
+// newFlagSet returns the flags of a command, with the --json output flag when withJson is set
+func newFlagSet(name string, withJson bool) (*flag.FlagSet, *bool) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	asJson := new(bool)
+	if withJson {
+		fs.BoolVar(asJson, "json", false, "print the output as json")
+	}
+	return fs, asJson
+}
+
+// timeFlag is an optional RFC 3339 time flag
+type timeFlag struct {
+	t **time.Time
+}
+
+func (f timeFlag) String() string {
+	if f.t == nil || *f.t == nil {
+		return ""
+	}
+	return (*f.t).Format(time.RFC3339)
+}
+
+func (f timeFlag) Set(value string) error {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return err
+	}
+	*f.t = &t
+	return nil
+}
+
+// mapFlag sets the value of key in a map, or parses key=value pairs when key is empty
+type mapFlag struct {
+	m   *map[string]string
+	key string
+}
+
+func (f mapFlag) String() string {
+	if f.m == nil || *f.m == nil || f.key == "" {
+		return ""
+	}
+	return (*f.m)[f.key]
+}
+
+func (f mapFlag) Set(value string) error {
+	key := f.key
+	if key == "" {
+		split := strings.SplitN(value, "=", 2)
+		if len(split) != 2 {
+			return fmt.Errorf("expected key=value")
+		}
+		key, value = split[0], split[1]
+	}
+	if *f.m == nil {
+		*f.m = map[string]string{}
+	}
+	(*f.m)[key] = value
+	return nil
+}
+
+// variantsFlag appends name:weight:url variants
+type variantsFlag struct {
+	variants *[]shortener.Variant
+}
+
+func (f variantsFlag) String() string {
+	return ""
+}
+
+func (f variantsFlag) Set(value string) error {
+	split := strings.SplitN(value, ":", 3)
+	if len(split) != 3 {
+		return fmt.Errorf("expected name:weight:url")
+	}
+	weight, err := strconv.ParseInt(split[1], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid weight %q", split[1])
+	}
+	*f.variants = append(*f.variants, shortener.Variant{Name: split[0], Weight: weight, Url: split[2]})
+	return nil
+}
+
+// tagsFlag appends tags
+type tagsFlag struct {
+	tags *[]string
+}
+
+func (f tagsFlag) String() string {
+	return ""
+}
+
+func (f tagsFlag) Set(value string) error {
+	*f.tags = append(*f.tags, value)
+	return nil
+}
+
+// parseUrlArg parses the flags of a command that expects exactly one url argument
+func parseUrlArg(fs *flag.FlagSet, args []string) (string, error) {
+	if err := fs.Parse(args); err != nil {
+		return "", err
+	}
+	if fs.NArg() != 1 {
+		return "", fmt.Errorf("expected exactly one url")
+	}
+	return fs.Arg(0), nil
+}
+
+// svcError converts the error returned by the service
+func svcError(err *errors.Error) error {
+	if err == nil {
+		return nil
+	}
+	msg := fmt.Sprintf("%s (%s)", err.Message, err.Code)
+	for _, d := range err.Details {
+		msg += fmt.Sprintf("\n  %s: %s", d.Field, d.Message)
+	}
+	return stderrors.New(msg)
+}
+
+func serve(env *environment, args []string) error {
+	if env.conf.MigrateOnStartup {
+		if err := migrate(env, nil); err != nil {
+			return err
+		}
+	}
+
+	if env.policy != nil {
+		go env.policy.Watch(context.Background(), env.conf.PolicyReloadInterval)
+	}
+	if env.conf.HealthCheckInterval > 0 {
+		go env.health.Watch(context.Background(), env.conf.HealthCheckInterval)
+	}
+	if env.conf.PurgeInterval > 0 {
+		go purgeDeleted(env, env.conf.PurgeInterval)
+	}
+	if env.conf.WebhookInterval > 0 {
+		go env.dispatcher.Watch(context.Background(), env.conf.WebhookInterval)
+	}
+	if outboxEnabled(env.conf) {
+		var sinks []outbox.Sink
+		if env.conf.WebhookInterval > 0 {
+			sinks = append(sinks, env.dispatcher)
+		}
+		if env.conf.OutboxFile != "" {
+			file, err := outbox.NewFile(env.conf.OutboxFile)
+			if err != nil {
+				return fmt.Errorf("unable to open the outbox file: %v", err)
+			}
+			defer file.Close()
+			sinks = append(sinks, file)
+		}
+		relay := outbox.NewRelay(env.lgr, env.store, env.conf.OutboxBackoff, env.conf.OutboxRetention, sinks...)
+		go relay.Watch(context.Background(), env.conf.OutboxInterval)
+		go expireUrls(env, env.conf.OutboxInterval)
+	}
+
+	var apiOpts []api.Option
+	if env.conf.QrLogoPath != "" {
+		logo, err := qr.LoadLogo(env.conf.QrLogoPath)
+		if err != nil {
+			return fmt.Errorf("unable to load the qr logo: %v", err)
+		}
+		apiOpts = append(apiOpts, api.WithQrLogo(logo))
+	}
+
+	apiOpts = append(apiOpts, api.WithKeys(env.store), api.WithDomains(env.store), api.WithWebhooks(env.hooks))
+	srv := server.New(env.lgr, env.conf, api.NewAPI(env.lgr, env.conf, env.shortenSvc, apiOpts...))
+	return srv.ListenAndServe()
+}
+
+// purgeDeleted removes the short urls deleted before the retention period every interval,
+// the service logs the outcome
+func purgeDeleted(env *environment, interval time.Duration) {
+	ctx := audit.WithOrigin(context.Background(), &audit.Origin{Actor: audit.ActorSystem})
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		_, _ = env.shortenSvc.PurgeDeleted(ctx)
+	}
+}
+
+// expireUrls emits the expiry of the short urls past their not_after every interval
+func expireUrls(env *environment, interval time.Duration) {
+	ctx := audit.WithOrigin(context.Background(), &audit.Origin{Actor: audit.ActorSystem})
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		_, _ = env.shortenSvc.ExpireUrls(ctx)
+	}
+}
+
+func create(env *environment, args []string) error {
+	fs, asJson := newFlagSet("create", true)
+	host := fs.String("host", fmt.Sprintf("localhost:%d", env.conf.Port), "host of the returned short url")
+	opts := shortener.LinkOptions{}
+	fs.StringVar(&opts.Password, "password", "", "password that visitors have to provide before being redirected")
+	fs.Int64Var(&opts.MaxClicks, "max-clicks", 0, "number of redirects after which the short url stops redirecting")
+	fs.Var(timeFlag{&opts.NotBefore}, "not-before", "RFC 3339 time before which the short url doesn't redirect")
+	fs.Var(timeFlag{&opts.NotAfter}, "not-after", "RFC 3339 time at which the short url expires")
+	fs.StringVar(&opts.PendingUrl, "pending-url", "", "destination of the visits before -not-before")
+	for _, platform := range []string{shortener.PlatformIos, shortener.PlatformAndroid, shortener.PlatformDesktop} {
+		fs.Var(mapFlag{&opts.PlatformUrls, platform}, platform+"-url", "destination of the "+platform+" visitors")
+	}
+	fs.Var(mapFlag{&opts.CountryUrls, ""}, "country-url", "`country=url` destination of the visitors of a country, repeatable")
+	fs.Var(variantsFlag{&opts.Variants}, "variant", "`name:weight:url` destination rotating the visitors, repeatable")
+	fs.BoolVar(&opts.StickyVariants, "sticky-variants", false, "keep redirecting a visitor to the first variant it was served")
+	fs.BoolVar(&opts.Preview, "preview", false, "show the destination to the visitors before redirecting them")
+	fs.BoolVar(&opts.ForwardPath, "forward-path", false, "redirect /{id}/rest/of/path to the url followed by /rest/of/path")
+	fs.StringVar(&opts.QueryPolicy, "query-policy", "", "query of the visits passed to the destination: drop, merge or override")
+	fs.Var(mapFlag{&opts.Utm, ""}, "utm", "`utm_param=value` added to the destination, repeatable")
+	fs.StringVar(&opts.Title, "title", "", "title of the short url")
+	fs.StringVar(&opts.Description, "description", "", "description of the short url")
+	fs.Var(tagsFlag{&opts.Tags}, "tag", "tag of the short url, repeatable")
+	fs.Var(mapFlag{&opts.Metadata, ""}, "meta", "`key=value` metadata of the short url, repeatable")
+	fs.StringVar(&opts.Domain, "domain", "", "custom domain of the workspace serving the short url")
+	workspace := fs.String("workspace", tenant.Default, "workspace of the short url")
+	url, err := parseUrlArg(fs, args)
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(url, "http") {
+		url = fmt.Sprintf("http://%s", url)
+	}
+
+	id, e := env.shortenSvc.ShortenUrl(tenant.WithWorkspace(env.ctx, *workspace), url, opts)
+	if e != nil {
+		return svcError(e)
+	}
+	if opts.Domain != "" {
+		*host = tenant.NormalizeHost(opts.Domain)
+	}
+	shortUrl := fmt.Sprintf("http://%s/%s", *host, id)
+
+	if *asJson {
+		return printJson(map[string]string{"id": id, "url": shortUrl})
+	}
+	fmt.Println(shortUrl)
+	return nil
+}
+
+func get(env *environment, args []string) error {
+	fs, asJson := newFlagSet("get", true)
+	url, err := parseUrlArg(fs, args)
+	if err != nil {
+		return err
+	}
+
+	res, e := env.shortenSvc.GetUrl(env.ctx, url)
+	if e != nil {
+		return svcError(e)
+	}
+
+	if *asJson {
+		return printJson(res)
+	}
+	return printUrls([]*shortener.ModelShorten{res})
+}
+
+func update(env *environment, args []string) error {
+	fs, asJson := newFlagSet("update", true)
+	title := fs.String("title", "", "title of the short url, empty to remove it")
+	description := fs.String("description", "", "description of the short url, empty to remove it")
+	var tags []string
+	fs.Var(tagsFlag{&tags}, "tag", "tag replacing the tags of the short url, repeatable")
+	noTags := fs.Bool("no-tags", false, "remove the tags of the short url")
+	changes := shortener.LinkUpdate{}
+	fs.Var(mapFlag{&changes.Metadata, ""}, "meta", "`key=value` metadata of the short url, an empty value removes the key, repeatable")
+	url, err := parseUrlArg(fs, args)
+	if err != nil {
+		return err
+	}
+
+	// only the labels given are changed
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "title":
+			changes.Title = title
+		case "description":
+			changes.Description = description
+		case "tag":
+			changes.Tags = &tags
+		}
+	})
+	if *noTags {
+		changes.Tags = &[]string{}
+	}
+
+	res, e := env.shortenSvc.UpdateUrl(env.ctx, url, changes)
+	if e != nil {
+		return svcError(e)
+	}
+
+	if *asJson {
+		return printJson(res)
+	}
+	return printUrls([]*shortener.ModelShorten{res})
+}
+
+func deleteUrl(env *environment, args []string) error {
+	fs, _ := newFlagSet("delete", false)
+	url, err := parseUrlArg(fs, args)
+	if err != nil {
+		return err
+	}
+	return svcError(env.shortenSvc.DeleteUrl(env.ctx, url))
+}
+
+func restore(env *environment, args []string) error {
+	fs, _ := newFlagSet("restore", false)
+	url, err := parseUrlArg(fs, args)
+	if err != nil {
+		return err
+	}
+	return svcError(env.shortenSvc.RestoreUrl(env.ctx, url))
+}
+
+func purge(env *environment, args []string) error {
+	purged, e := env.shortenSvc.PurgeDeleted(env.ctx)
+	if e != nil {
+		return svcError(e)
+	}
+	fmt.Printf("purged %d short urls\n", purged)
+	return nil
+}
+
+func stats(env *environment, args []string) error {
+	fs, asJson := newFlagSet("stats", true)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	// without arguments the totals are shown
+	if fs.NArg() == 0 {
+		res, e := env.shortenSvc.Stats(env.ctx)
+		if e != nil {
+			return svcError(e)
+		}
+		if *asJson {
+			return printJson(res)
+		}
+		return printTable([]string{"URLS", "REDIRECTS"}, [][]string{
+			{strconv.FormatInt(res.Urls, 10), strconv.FormatInt(res.Redirects, 10)},
+		})
+	}
+
+	counts, e := env.shortenSvc.CountRedirects(env.ctx, fs.Arg(0))
+	if e != nil {
+		return svcError(e)
+	}
+	if *asJson {
+		return printJson(counts)
+	}
+	rows := [][]string{{"total", "", strconv.FormatInt(counts.Total, 10)}}
+	for _, key := range sortedKeys(counts.Destinations) {
+		rows = append(rows, []string{"destination", key, strconv.FormatInt(counts.Destinations[key], 10)})
+	}
+	for _, key := range sortedKeys(counts.Countries) {
+		rows = append(rows, []string{"country", key, strconv.FormatInt(counts.Countries[key], 10)})
+	}
+	for _, key := range sortedKeys(counts.Variants) {
+		rows = append(rows, []string{"variant", key, strconv.FormatInt(counts.Variants[key], 10)})
+	}
+	return printTable([]string{"BY", "KEY", "REDIRECTS"}, rows)
+}
+
+func list(env *environment, args []string) error {
+	fs, asJson := newFlagSet("list", true)
+	offset := fs.Int64("offset", 0, "number of short urls to skip")
+	limit := fs.Int64("limit", 100, "maximum number of short urls to list")
+	broken := fs.Bool("broken", false, "list only the short urls with a broken destination at their last check")
+	deleted := fs.Bool("deleted", false, "list the short urls in the trash")
+	opts := shortener.ListOptions{}
+	fs.Var(tagsFlag{&opts.Tags}, "tag", "list only the short urls with the tag, repeatable")
+	fs.Var(mapFlag{&opts.Metadata, ""}, "meta", "`key=value` list only the short urls with the metadata, repeatable")
+	fs.StringVar(&opts.Search, "search", "", "list only the short urls whose title contains the text")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	opts.Offset, opts.Limit, opts.Broken, opts.Deleted = *offset, *limit, *broken, *deleted
+	res, e := env.shortenSvc.ListUrls(env.ctx, opts)
+	if e != nil {
+		return svcError(e)
+	}
+
+	if *asJson {
+		return printJson(res)
+	}
+	return printUrls(res)
+}
+
+func check(env *environment, args []string) error {
+	checked, broken, err := env.health.Run(env.ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("checked %d short urls, %d broken\n", checked, broken)
+	return nil
+}
+
+func importUrls(env *environment, args []string) error {
+	fs, asJson := newFlagSet("import", true)
+	format := fs.String("format", shortener.FormatJSONL, "import format: jsonl or csv")
+	overwrite := fs.Bool("overwrite", false, "replace the stored urls with the same id instead of skipping them")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	in := os.Stdin
+	if fs.NArg() > 0 {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	res, e := env.shortenSvc.ImportUrls(env.ctx, in, *format, *overwrite)
+	if e != nil {
+		return svcError(e)
+	}
+
+	if *asJson {
+		return printJson(res)
+	}
+	for _, r := range res.Refused {
+		fmt.Fprintf(os.Stderr, "refused %s: %s\n", r.Field, r.Message)
+	}
+	return printTable([]string{"CREATED", "OVERWRITTEN", "SKIPPED"}, [][]string{
+		{strconv.Itoa(res.Created), strconv.Itoa(res.Overwritten), strconv.Itoa(res.Skipped)},
+	})
+}
+
+func export(env *environment, args []string) error {
+	fs, _ := newFlagSet("export", false)
+	format := fs.String("format", shortener.FormatJSONL, "export format: jsonl or csv")
+	output := fs.String("o", "", "output file (default stdout)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	out := os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	res, e := env.shortenSvc.ExportUrls(env.ctx, out, *format)
+	if e != nil {
+		return svcError(e)
+	}
+	if res.Skipped > 0 {
+		fmt.Fprintf(os.Stderr, "skipped %d short urls with options or on a custom domain, export them as jsonl\n", res.Skipped)
+	}
+	return nil
+}
+
+func migrate(env *environment, args []string) error {
+	_, err := env.migrator.Run(env.ctx)
+	return err
+}
+
+// record appends the entry of an operation of the commands on resource id to the audit log, with
+// the resource after the operation if any
+func record(env *environment, action, resource, id, workspace string, after interface{}) {
+	audit.Record(env.ctx, env.lgr, env.store, audit.Entry{
+		Action:     action,
+		Workspace:  workspace,
+		Resource:   resource,
+		ResourceId: id,
+		After:      audit.Snapshot(after),
+	})
+}
+
+func workspace(env *environment, args []string) error {
+	fs, asJson := newFlagSet("workspace", true)
+	name := fs.String("name", "", "name of the new workspace")
+	maxLinks := fs.Int64("max-links", 0, "quota of short urls of the new workspace, 0 is unlimited")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	// without arguments the workspaces are listed
+	if fs.NArg() == 0 {
+		res, err := env.store.ListWorkspaces(env.ctx)
+		if err != nil {
+			return err
+		}
+		if *asJson {
+			return printJson(res)
+		}
+		rows := make([][]string, 0, len(res))
+		for _, w := range res {
+			rows = append(rows, []string{w.Id, w.Name, strconv.FormatInt(w.Quota.MaxLinks, 10), w.CreatedAt.Format(time.RFC3339)})
+		}
+		return printTable([]string{"ID", "NAME", "MAX LINKS", "CREATED"}, rows)
+	}
+
+	if !tenant.ValidId(fs.Arg(0)) {
+		return fmt.Errorf("invalid workspace id %q: lowercase letters, digits and dashes", fs.Arg(0))
+	}
+	if *maxLinks < 0 {
+		return fmt.Errorf("invalid max links %d", *maxLinks)
+	}
+	w := tenant.Workspace{
+		Id:        fs.Arg(0),
+		Name:      *name,
+		CreatedAt: time.Now().UTC(),
+		Quota:     tenant.Quota{MaxLinks: *maxLinks},
+	}
+	err := env.store.InsertWorkspace(env.ctx, w)
+	if err == shortener.ErrDuplicate {
+		return fmt.Errorf("workspace %s already exists", w.Id)
+	}
+	if err != nil {
+		return err
+	}
+	record(env, audit.ActionCreate, audit.ResourceWorkspace, w.Id, w.Id, w)
+	if *asJson {
+		return printJson(w)
+	}
+	fmt.Printf("created workspace %s\n", w.Id)
+	return nil
+}
+
+func key(env *environment, args []string) error {
+	fs, asJson := newFlagSet("key", true)
+	name := fs.String("name", "", "name of the new api key")
+	revoke := fs.Bool("revoke", false, "revoke the api key with the given id")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	// without arguments the api keys are listed
+	if fs.NArg() == 0 {
+		res, err := env.store.ListKeys(env.ctx, "")
+		if err != nil {
+			return err
+		}
+		if *asJson {
+			return printJson(res)
+		}
+		rows := make([][]string, 0, len(res))
+		for _, k := range res {
+			revokedAt := "-"
+			if k.RevokedAt != nil {
+				revokedAt = k.RevokedAt.Format(time.RFC3339)
+			}
+			rows = append(rows, []string{k.Id, k.Workspace, k.Name, k.CreatedAt.Format(time.RFC3339), revokedAt})
+		}
+		return printTable([]string{"ID", "WORKSPACE", "NAME", "CREATED", "REVOKED"}, rows)
+	}
+
+	if *revoke {
+		err := env.store.RevokeKey(env.ctx, fs.Arg(0))
+		if err == shortener.ErrNotFound {
+			return fmt.Errorf("api key %s not found or already revoked", fs.Arg(0))
+		}
+		if err != nil {
+			return err
+		}
+		record(env, audit.ActionRevoke, audit.ResourceKey, fs.Arg(0), "", nil)
+		fmt.Printf("revoked api key %s\n", fs.Arg(0))
+		return nil
+	}
+
+	// the default workspace exists without being created
+	id := fs.Arg(0)
+	if id != tenant.Default {
+		if _, err := env.store.FindWorkspace(env.ctx, id); err == shortener.ErrNotFound {
+			return fmt.Errorf("workspace %s not found", id)
+		} else if err != nil {
+			return err
+		}
+	}
+	k, plain, err := tenant.NewKey(id, *name)
+	if err != nil {
+		return err
+	}
+	if err := env.store.InsertKey(env.ctx, k); err != nil {
+		return err
+	}
+	// the hash of the key is not in its json document
+	record(env, audit.ActionCreate, audit.ResourceKey, k.Id, id, k)
+	if *asJson {
+		return printJson(struct {
+			tenant.Key
+			Secret string `json:"key"`
+		}{k, plain})
+	}
+	fmt.Printf("created api key %s of workspace %s, it won't be shown again:\n%s\n", k.Id, id, plain)
+	return nil
+}
+
+func domain(env *environment, args []string) error {
+	fs, asJson := newFlagSet("domain", true)
+	remove := fs.Bool("remove", false, "unregister the custom domain")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	// without arguments the custom domains are listed
+	if fs.NArg() == 0 {
+		res, err := env.store.ListDomains(env.ctx, "")
+		if err != nil {
+			return err
+		}
+		if *asJson {
+			return printJson(res)
+		}
+		rows := make([][]string, 0, len(res))
+		for _, d := range res {
+			rows = append(rows, []string{d.Host, d.Workspace, d.CreatedAt.Format(time.RFC3339)})
+		}
+		return printTable([]string{"HOST", "WORKSPACE", "CREATED"}, rows)
+	}
+
+	host := tenant.NormalizeHost(fs.Arg(0))
+	if *remove {
+		err := env.store.DeleteDomain(env.ctx, host)
+		if err == shortener.ErrNotFound {
+			return fmt.Errorf("domain %s not found", host)
+		}
+		if err != nil {
+			return err
+		}
+		record(env, audit.ActionDelete, audit.ResourceDomain, host, "", nil)
+		fmt.Printf("removed domain %s, its short urls are not served anymore\n", host)
+		return nil
+	}
+
+	if !tenant.ValidHost(host) {
+		return fmt.Errorf("invalid domain %q", fs.Arg(0))
+	}
+	d := tenant.Domain{Host: host, Workspace: tenant.Default, CreatedAt: time.Now().UTC()}
+	if fs.NArg() > 1 {
+		d.Workspace = fs.Arg(1)
+	}
+	// the default workspace exists without being created
+	if d.Workspace != tenant.Default {
+		if _, err := env.store.FindWorkspace(env.ctx, d.Workspace); err == shortener.ErrNotFound {
+			return fmt.Errorf("workspace %s not found", d.Workspace)
+		} else if err != nil {
+			return err
+		}
+	}
+	err := env.store.InsertDomain(env.ctx, d)
+	if err == shortener.ErrDuplicate {
+		return fmt.Errorf("domain %s is already registered", host)
+	}
+	if err != nil {
+		return err
+	}
+	record(env, audit.ActionCreate, audit.ResourceDomain, host, d.Workspace, d)
+	if *asJson {
+		return printJson(d)
+	}
+	fmt.Printf("registered domain %s of workspace %s\n", host, d.Workspace)
+	return nil
+}
+
+func webhookCmd(env *environment, args []string) error {
+	fs, asJson := newFlagSet("webhook", true)
+	workspace := fs.String("workspace", "", "workspace of the endpoints, all of them when listing by default")
+	var events []string
+	fs.Var(tagsFlag{&events}, "event", "event sent to the new endpoint, repeatable, all of them by default")
+	remove := fs.Bool("remove", false, "remove the endpoint with the given id")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	ctx := env.ctx
+	if *workspace != "" {
+		ctx = tenant.WithWorkspace(ctx, *workspace)
+	}
+
+	// without arguments the endpoints are listed
+	if fs.NArg() == 0 {
+		res, e := env.hooks.ListEndpoints(ctx)
+		if e != nil {
+			return svcError(e)
+		}
+		if *asJson {
+			return printJson(res)
+		}
+		rows := make([][]string, 0, len(res))
+		for _, h := range res {
+			subscribed := "*"
+			if len(h.Events) > 0 {
+				subscribed = strings.Join(h.Events, ",")
+			}
+			rows = append(rows, []string{h.Id, h.Workspace, h.Url, subscribed, h.CreatedAt.Format(time.RFC3339)})
+		}
+		return printTable([]string{"ID", "WORKSPACE", "URL", "EVENTS", "CREATED"}, rows)
+	}
+
+	if *remove {
+		if e := env.hooks.DeleteEndpoint(ctx, fs.Arg(0)); e != nil {
+			return svcError(e)
+		}
+		fmt.Printf("removed webhook %s\n", fs.Arg(0))
+		return nil
+	}
+
+	h, e := env.hooks.CreateEndpoint(ctx, fs.Arg(0), events)
+	if e != nil {
+		return svcError(e)
+	}
+	if *asJson {
+		return printJson(h)
+	}
+	fmt.Printf("created webhook %s of workspace %s, its signing secret won't be shown again:\n%s\n", h.Id, h.Workspace, h.Secret)
+	return nil
+}
+
+func deliveries(env *environment, args []string) error {
+	fs, asJson := newFlagSet("deliveries", true)
+	filter := webhook.Filter{}
+	workspace := fs.String("workspace", "", "workspace of the deliveries, all of them by default")
+	fs.StringVar(&filter.EndpointId, "endpoint", "", "id of the endpoint of the deliveries")
+	fs.StringVar(&filter.Status, "status", "", "pending, delivered or dead")
+	fs.Int64Var(&filter.Offset, "offset", 0, "number of deliveries to skip")
+	fs.Int64Var(&filter.Limit, "limit", 100, "maximum number of deliveries to list")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	ctx := env.ctx
+	if *workspace != "" {
+		ctx = tenant.WithWorkspace(ctx, *workspace)
+	}
+
+	res, e := env.hooks.Deliveries(ctx, filter)
+	if e != nil {
+		return svcError(e)
+	}
+	if *asJson {
+		return printJson(res)
+	}
+	rows := make([][]string, 0, len(res))
+	for _, d := range res {
+		status := "-"
+		if d.LastStatus != 0 {
+			status = strconv.Itoa(d.LastStatus)
+		}
+		rows = append(rows, []string{d.Id, d.EndpointId, d.Event, d.Status, strconv.Itoa(d.Attempts), status, d.CreatedAt.Format(time.RFC3339)})
+	}
+	return printTable([]string{"ID", "ENDPOINT", "EVENT", "STATUS", "ATTEMPTS", "LAST STATUS", "CREATED"}, rows)
+}
+
+func redeliver(env *environment, args []string) error {
+	fs, _ := newFlagSet("redeliver", false)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("expected exactly one delivery id")
+	}
+	d, e := env.hooks.Redeliver(env.ctx, fs.Arg(0))
+	if e != nil {
+		return svcError(e)
+	}
+	fmt.Printf("delivery %s of %s is pending again\n", d.Id, d.Event)
+	return nil
+}
@@ -26,15 +26,56 @@
 package main
 
 import (
-	"github.com/gsiragusa/short-to-me/api"
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/gsiragusa/short-to-me/audit"
 	"github.com/gsiragusa/short-to-me/config"
 	"github.com/gsiragusa/short-to-me/database"
-	"github.com/gsiragusa/short-to-me/server"
+	"github.com/gsiragusa/short-to-me/geo"
+	"github.com/gsiragusa/short-to-me/health"
+	"github.com/gsiragusa/short-to-me/migration"
+	"github.com/gsiragusa/short-to-me/policy"
+	"github.com/gsiragusa/short-to-me/redirect"
 	"github.com/gsiragusa/short-to-me/shortener"
+	"github.com/gsiragusa/short-to-me/unfurl"
+	"github.com/gsiragusa/short-to-me/webhook"
 	"github.com/sirupsen/logrus"
 )
 
+// environment holds the dependencies shared by the commands
+type environment struct {
+	// ctx carries the origin of the operations of the command for the audit log
+	ctx        context.Context
+	lgr        *logrus.Logger
+	conf       *config.AppConfig
+	store      *database.Client
+	migrator   *migration.Migrator
+	policy     *policy.Engine
+	health     *health.Checker
+	shortenSvc shortener.Service
+	dispatcher *webhook.Dispatcher
+	hooks      webhook.Service
+}
+
 func main() {
+	// command, serve when none is given
+	name, args := "serve", []string{}
+	if len(os.Args) > 1 {
+		name, args = os.Args[1], os.Args[2:]
+	}
+	switch name {
+	case "help", "-h", "-help", "--help":
+		usage()
+		return
+	}
+	cmd, ok := findCommand(name)
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+
 	// logger
 	lgr := logrus.New()
 
@@ -50,13 +91,96 @@ func main() {
 		lgr.WithError(err).Fatal("unable to connect to Mongo")
 	}
 
-	// services
-	shortenSvc := shortener.NewService(lgr, conf, store)
+	// audit log of the management operations, quotas and custom domains of the workspaces
+	svcOpts := []shortener.Option{shortener.WithAuditor(store), shortener.WithWorkspaces(store), shortener.WithDomains(store)}
 
-	// server
-	srv := server.New(lgr, conf, api.NewAPI(lgr, conf, shortenSvc))
+	// optional geo lookups of the visitors
+	if conf.GeoIpDbPath != "" {
+		locator, err := geo.Open(conf.GeoIpDbPath)
+		if err != nil {
+			lgr.WithError(err).Fatal("unable to open the GeoIP database")
+		}
+		defer locator.Close()
+		svcOpts = append(svcOpts, shortener.WithLocator(locator))
+	}
+
+	// optional policy refusing destinations
+	var engine *policy.Engine
+	files := policy.Files{
+		Blocklist:       conf.PolicyBlocklistPath,
+		Allowlist:       conf.PolicyAllowlistPath,
+		MaliciousHashes: conf.PolicyMaliciousHashesPath,
+	}
+	if files != (policy.Files{}) {
+		engine, err = policy.NewEngine(lgr, files)
+		if err != nil {
+			lgr.WithError(err).Fatal("unable to load the policy")
+		}
+		svcOpts = append(svcOpts, shortener.WithPolicy(engine))
+	}
+
+	// optional check of the redirects of the destinations
+	if conf.RedirectCheck {
+		var redirectOpts []redirect.Option
+		if conf.RedirectCheckAllowPrivate {
+			redirectOpts = append(redirectOpts, redirect.AllowPrivate())
+		}
+		checker := redirect.NewChecker(lgr, conf.RedirectCheckMaxHops, conf.RedirectCheckTimeout, conf.ShortHosts, redirectOpts...)
+		svcOpts = append(svcOpts, shortener.WithRedirectChecker(checker))
+	}
 
-	if err := srv.ListenAndServe(); err != nil {
-		lgr.WithError(err).Fatal("error starting server")
+	// optional outbox of the events of the short urls, relayed by the server to the webhooks and the file
+	var dispatchOpts []webhook.Option
+	if conf.WebhookAllowPrivate {
+		dispatchOpts = append(dispatchOpts, webhook.AllowPrivate())
 	}
+	dispatcher := webhook.NewDispatcher(lgr, store, conf.WebhookMaxAttempts, conf.WebhookBackoff, conf.WebhookTimeout, dispatchOpts...)
+	if outboxEnabled(conf) {
+		if !store.Transactional() {
+			lgr.Warn("mongo is standalone, the events are written to the outbox without transactions")
+		}
+		svcOpts = append(svcOpts, shortener.WithOutbox(store))
+	}
+
+	var healthOpts []health.Option
+	if conf.HealthCheckAllowPrivate {
+		healthOpts = append(healthOpts, health.AllowPrivate())
+	}
+
+	origin := &audit.Origin{Actor: audit.ActorCli, RequestId: audit.NewId()}
+	// optional fetch of the destination pages of the new short urls
+	if conf.PageFetch {
+		var fetchOpts []unfurl.Option
+		if conf.PageFetchAllowPrivate {
+			fetchOpts = append(fetchOpts, unfurl.AllowPrivate())
+		}
+		fetcher := unfurl.NewFetcher(lgr, conf.PageFetchConcurrency, conf.PageFetchTimeout, conf.PageFetchMaxBytes, fetchOpts...)
+		svcOpts = append(svcOpts, shortener.WithPageFetcher(fetcher))
+	}
+
+	env := &environment{
+		ctx:        audit.WithOrigin(context.Background(), origin),
+		lgr:        lgr,
+		conf:       conf,
+		store:      store,
+		migrator:   migration.NewMigrator(lgr, store, store.Migrations()...),
+		policy:     engine,
+		health:     health.NewChecker(lgr, store, conf.HealthCheckConcurrency, conf.HealthCheckHostDelay, conf.HealthCheckTimeout, healthOpts...),
+		shortenSvc: shortener.NewService(lgr, conf, store, svcOpts...),
+		dispatcher: dispatcher,
+		hooks:      webhook.NewService(lgr, store, store),
+	}
+
+	err = cmd.run(env, args)
+	// the destination pages of the short urls created by the command are fetched in the background
+	env.shortenSvc.Wait()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", cmd.name, err)
+		os.Exit(1)
+	}
+}
+
+// outboxEnabled reports whether the events are kept in the outbox: the relay runs and has a sink
+func outboxEnabled(conf *config.AppConfig) bool {
+	return conf.OutboxInterval > 0 && (conf.WebhookInterval > 0 || conf.OutboxFile != "")
 }
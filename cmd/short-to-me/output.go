@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gsiragusa/short-to-me/shortener"
+)
+
+func printJson(v interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+func printTable(header []string, rows [][]string) error {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+func printUrls(urls []*shortener.ModelShorten) error {
+	rows := make([][]string, 0, len(urls))
+	for _, u := range urls {
+		createdAt := "-"
+		if !u.CreatedAt.IsZero() {
+			createdAt = u.CreatedAt.Format(time.RFC3339)
+		}
+		tags := "-"
+		if len(u.Tags) > 0 {
+			tags = strings.Join(u.Tags, ",")
+		}
+		title := u.Title
+		if title == "" && u.Page != nil {
+			title = u.Page.Title
+		}
+		if title == "" {
+			title = "-"
+		}
+		rows = append(rows, []string{u.Id, u.Url, title, strconv.FormatInt(u.Count, 10), createdAt, tags})
+	}
+	return printTable([]string{"ID", "URL", "TITLE", "COUNT", "CREATED", "TAGS"}, rows)
+}
+
+func sortedKeys(m map[string]int64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
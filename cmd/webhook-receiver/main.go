@@ -0,0 +1,66 @@
+// Command webhook-receiver is a local endpoint to try the webhooks: it verifies the signature of the
+// deliveries and prints them. With -fail it refuses them, to try the retries and the dead letters
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gsiragusa/short-to-me/webhook"
+)
+
+// tolerance is the age of the oldest timestamp accepted, older deliveries may be replayed
+const tolerance = 5 * time.Minute
+
+func main() {
+	addr := flag.String("addr", ":9000", "address to listen on")
+	secret := flag.String("secret", "", "signing secret of the endpoint, the signatures are not verified without it")
+	fail := flag.Bool("fail", false, "respond 503 to every delivery")
+	flag.Parse()
+
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		delivery, event := r.Header.Get(webhook.HeaderDelivery), r.Header.Get(webhook.HeaderEvent)
+
+		if *secret != "" {
+			timestamp := r.Header.Get(webhook.HeaderTimestamp)
+			if !webhook.Verify(*secret, timestamp, body, r.Header.Get(webhook.HeaderSignature)) {
+				log.Printf("%s %s: invalid signature", delivery, event)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			sent, err := strconv.ParseInt(timestamp, 10, 64)
+			if err != nil || time.Since(time.Unix(sent, 0)) > tolerance {
+				log.Printf("%s %s: expired timestamp", delivery, event)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		}
+
+		var out bytes.Buffer
+		if err := json.Indent(&out, body, "", "  "); err != nil {
+			out.Write(body)
+		}
+		log.Printf("%s %s\n%s", delivery, event, out.String())
+
+		if *fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	fmt.Printf("receiving the webhooks on %s\n", *addr)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
@@ -1,6 +1,11 @@
 package config
 
 import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
 	"github.com/kelseyhightower/envconfig"
 	"github.com/sirupsen/logrus"
 )
@@ -14,6 +19,105 @@ type AppConfig struct {
 
 	// Port is the port to run the HTTP server on
 	Port int `split_words:"true" default:"8081"`
+
+	// AdminApiKey grants access to the admin endpoints, which are disabled when empty
+	AdminApiKey string `split_words:"true"`
+
+	// TrustedProxies are the reverse proxies in front of the server, whose X-Forwarded-For and
+	// X-Real-IP headers identify the clients. The headers sent by the other clients are ignored
+	TrustedProxies Networks `split_words:"true"`
+
+	// PasswordMaxAttempts is the number of wrong passwords a visitor can try on a protected
+	// short url before being blocked for PasswordLockout
+	PasswordMaxAttempts int           `split_words:"true" default:"5"`
+	PasswordLockout     time.Duration `split_words:"true" default:"15m"`
+
+	// ComingSoonUrl is the destination of the visits to short urls that are not active yet and
+	// have no pending url. When empty, a coming soon page is served instead
+	ComingSoonUrl string `split_words:"true"`
+
+	// GeoIpDbPath is the path of a MaxMind country or city database, enabling the country urls
+	// and the country breakdown of the redirects
+	GeoIpDbPath string `split_words:"true"`
+
+	// QrLogoPath is the path of a PNG logo that can be drawn at the center of the qr codes
+	QrLogoPath string `split_words:"true"`
+
+	// PolicyBlocklistPath, PolicyAllowlistPath and PolicyMaliciousHashesPath are the paths of the
+	// policy files refusing destinations, checked for changes every PolicyReloadInterval
+	PolicyBlocklistPath       string        `split_words:"true"`
+	PolicyAllowlistPath       string        `split_words:"true"`
+	PolicyMaliciousHashesPath string        `split_words:"true"`
+	PolicyReloadInterval      time.Duration `split_words:"true" default:"30s"`
+
+	// ShortHosts are the hosts serving the short urls, the destinations on them are resolved to
+	// the destination of the short url they reference
+	ShortHosts []string `split_words:"true"`
+
+	// UnknownHostUrl is the destination of the visits to the hosts that are neither short hosts nor
+	// custom domains, when the short hosts are set. They get the link_not_found error when it is empty
+	UnknownHostUrl string `split_words:"true"`
+
+	// DomainCacheTtl is the time the custom domains are cached by the server
+	DomainCacheTtl time.Duration `split_words:"true" default:"1m"`
+
+	// RedirectCheck follows the redirects of the destinations at creation, refusing the loops and
+	// the chains longer than RedirectCheckMaxHops. Only public addresses are requested, unless
+	// RedirectCheckAllowPrivate is set
+	RedirectCheck             bool          `split_words:"true"`
+	RedirectCheckMaxHops      int           `split_words:"true" default:"5"`
+	RedirectCheckTimeout      time.Duration `split_words:"true" default:"5s"`
+	RedirectCheckAllowPrivate bool          `split_words:"true"`
+
+	// HealthCheckInterval is the period of the checks of the destinations of the short urls by
+	// the server, disabled when 0. HealthCheckConcurrency short urls are checked at a time, and
+	// the requests to the same host are spaced by HealthCheckHostDelay. Only public addresses are
+	// requested, unless HealthCheckAllowPrivate is set
+	HealthCheckInterval     time.Duration `split_words:"true"`
+	HealthCheckConcurrency  int           `split_words:"true" default:"4"`
+	HealthCheckHostDelay    time.Duration `split_words:"true" default:"1s"`
+	HealthCheckTimeout      time.Duration `split_words:"true" default:"10s"`
+	HealthCheckAllowPrivate bool          `split_words:"true"`
+
+	// PageFetch reads the destination page of the new short urls in the background, recording its
+	// title and metadata. PageFetchConcurrency pages are read at a time, each one for at most
+	// PageFetchTimeout and PageFetchMaxBytes. Only public addresses are requested, unless
+	// PageFetchAllowPrivate is set
+	PageFetch             bool          `split_words:"true" default:"true"`
+	PageFetchConcurrency  int           `split_words:"true" default:"4"`
+	PageFetchTimeout      time.Duration `split_words:"true" default:"5s"`
+	PageFetchMaxBytes     int64         `split_words:"true" default:"1048576"`
+	PageFetchAllowPrivate bool          `split_words:"true"`
+
+	// WebhookInterval is the period of the deliveries of the webhooks by the server, which are
+	// disabled when it is 0. A failed delivery is retried after
+	// WebhookBackoff, doubled at every attempt, until WebhookMaxAttempts. Each delivery is bounded
+	// by WebhookTimeout, and only public addresses are requested unless WebhookAllowPrivate is set
+	WebhookInterval     time.Duration `split_words:"true" default:"5s"`
+	WebhookMaxAttempts  int           `split_words:"true" default:"8"`
+	WebhookBackoff      time.Duration `split_words:"true" default:"30s"`
+	WebhookTimeout      time.Duration `split_words:"true" default:"10s"`
+	WebhookAllowPrivate bool          `split_words:"true"`
+
+	// ClickThresholds are the numbers of redirects of a short url emitting link.clicks
+	ClickThresholds []int64 `split_words:"true" default:"100,1000,10000"`
+
+	// OutboxInterval is the period of the relay of the events of the outbox by the server, and of
+	// the expiry of the short urls, disabled when 0. The events are kept when a sink is configured:
+	// the webhooks or OutboxFile, appending them as json lines. A failed event is retried after
+	// OutboxBackoff, doubled at every attempt, and the published ones are kept for OutboxRetention
+	OutboxInterval  time.Duration `split_words:"true" default:"1s"`
+	OutboxBackoff   time.Duration `split_words:"true" default:"10s"`
+	OutboxRetention time.Duration `split_words:"true" default:"168h"`
+	OutboxFile      string        `split_words:"true"`
+
+	// DeleteRetention is the time the deleted short urls can be restored. The server purges the
+	// ones deleted before it every PurgeInterval, disabled when 0
+	DeleteRetention time.Duration `split_words:"true" default:"720h"`
+	PurgeInterval   time.Duration `split_words:"true" default:"1h"`
+
+	// MigrateOnStartup applies the pending schema migrations before serving
+	MigrateOnStartup bool `split_words:"true" default:"true"`
 }
 
 func Configure() (*AppConfig, error) {
@@ -24,6 +128,49 @@ func Configure() (*AppConfig, error) {
 	return conf, nil
 }
 
+// Networks are ip networks, configured as a comma separated list of addresses and CIDR blocks
+type Networks []*net.IPNet
+
+// Decode parses the networks of value, for envconfig
+func (n *Networks) Decode(value string) error {
+	var res Networks
+	for _, s := range strings.Split(value, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return fmt.Errorf("invalid address %q", s)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			res = append(res, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(s)
+		if err != nil {
+			return err
+		}
+		res = append(res, network)
+	}
+	*n = res
+	return nil
+}
+
+// Contains reports whether ip belongs to one of the networks
+func (n Networks) Contains(ip net.IP) bool {
+	for _, network := range n {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
 // load accepts a struct to load the environment configuration from
 func load(config interface{}) error {
 	return envconfig.Process("", config)
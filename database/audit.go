@@ -0,0 +1,62 @@
+package database
+
+import (
+	"context"
+
+	"github.com/gsiragusa/short-to-me/audit"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const CollAudit = "audit_log"
+
+// InsertAudit appends an entry to the audit log, the entries are never updated
+func (c *Client) InsertAudit(ctx context.Context, entry audit.Entry) error {
+	collection := c.db.Collection(CollAudit)
+	_, err := collection.InsertOne(ctx, entry)
+	return err
+}
+
+// FindAudit returns the entries of the audit log matching filter, from the most recent
+func (c *Client) FindAudit(ctx context.Context, filter audit.Filter) ([]audit.Entry, error) {
+	collection := c.db.Collection(CollAudit)
+	findOpts := options.Find().
+		SetSort(bson.D{{Key: "time", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(filter.Offset).
+		SetLimit(filter.Limit)
+
+	query := scoped(ctx, bson.M{})
+	if filter.Action != "" {
+		query["action"] = filter.Action
+	}
+	if filter.Actor != "" {
+		query["actor"] = filter.Actor
+	}
+	if filter.ShortId != "" {
+		query["short_id"] = filter.ShortId
+	}
+	if filter.RequestId != "" {
+		query["request_id"] = filter.RequestId
+	}
+	period := bson.M{}
+	if !filter.From.IsZero() {
+		period["$gte"] = filter.From
+	}
+	if !filter.To.IsZero() {
+		period["$lt"] = filter.To
+	}
+	if len(period) > 0 {
+		query["time"] = period
+	}
+
+	cursor, err := collection.Find(ctx, query, findOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	res := []audit.Entry{}
+	if err := cursor.All(ctx, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
@@ -0,0 +1,244 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/gsiragusa/short-to-me/migration"
+	"github.com/gsiragusa/short-to-me/shortener"
+	"github.com/gsiragusa/short-to-me/tenant"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	CollMigrations     = "schema_migrations"
+	CollMigrationsLock = "schema_migrations_lock"
+)
+
+// migrationsLock is the id of the lock document of the migrations
+const migrationsLock = "migrations"
+
+// Migrations returns the migrations of the mongo schema, new ones are appended with the next version
+func (c *Client) Migrations() []migration.Migration {
+	return []migration.Migration{
+		{
+			Version:     1,
+			Description: "create index on short_urls.url",
+			Up:          c.createUrlIndex,
+		},
+		{
+			Version:     2,
+			Description: "backfill short_urls.created_at",
+			Up:          c.backfillCreatedAt,
+		},
+		{
+			Version:     3,
+			Description: "create index on short_urls.deleted_at",
+			Up:          c.createDeletedAtIndex,
+		},
+		{
+			Version:     4,
+			Description: "create indexes on audit_log",
+			Up:          c.createAuditIndexes,
+		},
+		{
+			Version:     5,
+			Description: "create index on short_urls.tags",
+			Up:          c.createTagsIndex,
+		},
+		{
+			Version:     6,
+			Description: "backfill short_urls.workspace and create the workspace indexes",
+			Up:          c.createWorkspaces,
+		},
+		{
+			Version:     7,
+			Description: "create the webhook indexes and backfill short_urls.expired_at",
+			Up:          c.createWebhooks,
+		},
+		{
+			Version:     8,
+			Description: "create the outbox collection and its index",
+			Up:          c.createOutbox,
+		},
+	}
+}
+
+func (c *Client) AppliedMigrations(ctx context.Context) ([]migration.Record, error) {
+	collection := c.db.Collection(CollMigrations)
+	opts := options.Find().SetSort(bson.M{"_id": 1})
+	cursor, err := collection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []migration.Record
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+func (c *Client) MarkApplied(ctx context.Context, record migration.Record) error {
+	collection := c.db.Collection(CollMigrations)
+	_, err := collection.InsertOne(ctx, record)
+	return err
+}
+
+// Lock takes the lock of the migrations for owner, or extends it. The lock document can only be
+// replaced once expired, the insert of a second one fails on its id
+func (c *Client) Lock(ctx context.Context, owner string, ttl time.Duration) error {
+	collection := c.db.Collection(CollMigrationsLock)
+	now := time.Now().UTC()
+	filter := bson.M{
+		"_id": migrationsLock,
+		"$or": bson.A{bson.M{"owner": owner}, bson.M{"expires_at": bson.M{"$lte": now}}},
+	}
+	update := bson.M{"$set": bson.M{"owner": owner, "expires_at": now.Add(ttl)}}
+	_, err := collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if isDuplicateKey(err) {
+		return migration.ErrLocked
+	}
+	return err
+}
+
+// Unlock releases the lock of the migrations, if owner still holds it
+func (c *Client) Unlock(ctx context.Context, owner string) error {
+	collection := c.db.Collection(CollMigrationsLock)
+	_, err := collection.DeleteOne(ctx, bson.M{"_id": migrationsLock, "owner": owner})
+	return err
+}
+
+func (c *Client) createUrlIndex(ctx context.Context) error {
+	collection := c.db.Collection(CollShortUrls)
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.M{"url": 1},
+	})
+	return err
+}
+
+// createDeletedAtIndex indexes the trash, only the deleted documents have the field
+func (c *Client) createDeletedAtIndex(ctx context.Context) error {
+	collection := c.db.Collection(CollShortUrls)
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.M{"deleted_at": 1},
+		Options: options.Index().SetSparse(true),
+	})
+	return err
+}
+
+// createAuditIndexes indexes the audit log by time and by short url, the filters of the lookups
+func (c *Client) createAuditIndexes(ctx context.Context) error {
+	collection := c.db.Collection(CollAudit)
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "time", Value: -1}}},
+		{Keys: bson.D{{Key: "short_id", Value: 1}, {Key: "time", Value: -1}}},
+	})
+	return err
+}
+
+// createTagsIndex indexes the tags of the short urls, the filter of the listings by campaign or product
+func (c *Client) createTagsIndex(ctx context.Context) error {
+	collection := c.db.Collection(CollShortUrls)
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.M{"tags": 1},
+		Options: options.Index().SetSparse(true),
+	})
+	return err
+}
+
+// createWorkspaces moves the existing documents to the default workspace, and indexes the short urls
+// and the audit log by workspace and the api keys by id
+func (c *Client) createWorkspaces(ctx context.Context) error {
+	collection := c.db.Collection(CollShortUrls)
+	filter := bson.M{"workspace": bson.M{"$exists": false}}
+	if _, err := collection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"workspace": tenant.Default}}); err != nil {
+		return err
+	}
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "workspace", Value: 1}, {Key: "_id", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = c.db.Collection(CollAudit).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "workspace", Value: 1}, {Key: "time", Value: -1}},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = c.db.Collection(CollApiKeys).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.M{"key_id": 1},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+// createWebhooks indexes the webhook endpoints by workspace, the deliveries by due time and for the
+// log, and the expiry of the short urls. The short urls already expired are not notified
+func (c *Client) createWebhooks(ctx context.Context) error {
+	_, err := c.db.Collection(CollWebhooks).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.M{"workspace": 1},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = c.db.Collection(CollDeliveries).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_attempt_at", Value: 1}}},
+		{Keys: bson.D{{Key: "workspace", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "endpoint_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	collection := c.db.Collection(CollShortUrls)
+	now := time.Now().UTC()
+	filter := bson.M{"not_after": bson.M{"$lte": now}, "expired_at": bson.M{"$exists": false}}
+	if _, err := collection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"expired_at": now}}); err != nil {
+		return err
+	}
+	_, err = collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.M{"not_after": 1},
+		Options: options.Index().SetSparse(true),
+	})
+	return err
+}
+
+// createOutbox indexes the outbox by publication and due time, the filters of the relay and the
+// purge. Creating the collection beforehand lets the transactions write to it, which can't create it
+func (c *Client) createOutbox(ctx context.Context) error {
+	_, err := c.db.Collection(CollOutbox).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "published_at", Value: 1}, {Key: "next_attempt_at", Value: 1}},
+	})
+	return err
+}
+
+// backfillCreatedAt recovers the creation date from the timestamp encoded in the id.
+// Documents whose id cannot be decoded are left untouched
+func (c *Client) backfillCreatedAt(ctx context.Context) error {
+	collection := c.db.Collection(CollShortUrls)
+	cursor, err := collection.Find(ctx, bson.M{"created_at": bson.M{"$exists": false}})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		u := &shortener.ModelShorten{}
+		if err := cursor.Decode(u); err != nil {
+			return err
+		}
+		createdAt, err := shortener.IdTimestamp(u.Id)
+		if err != nil {
+			continue
+		}
+		update := bson.M{"$set": bson.M{"created_at": createdAt}}
+		if _, err := collection.UpdateOne(ctx, bson.M{"_id": u.Id}, update); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
@@ -2,20 +2,48 @@ package database
 
 import (
 	"context"
+	"regexp"
+	"time"
 
 	"github.com/gsiragusa/short-to-me/config"
 	"github.com/gsiragusa/short-to-me/shortener"
+	"github.com/gsiragusa/short-to-me/tenant"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 const CollShortUrls = "short_urls"
 
+// active matches the documents that are not in the trash, merged in the filters of the lookups
+func active(filter bson.M) bson.M {
+	filter["deleted_at"] = bson.M{"$exists": false}
+	return filter
+}
+
+// scoped restricts the filter to the workspace of ctx, if any. The documents stored before the
+// workspaces have no workspace and belong to the default one
+func scoped(ctx context.Context, filter bson.M) bson.M {
+	if workspace, ok := tenant.WorkspaceFrom(ctx); ok {
+		filter["workspace"] = workspaceFilter(workspace)
+	}
+	return filter
+}
+
+func workspaceFilter(workspace string) interface{} {
+	if workspace == tenant.Default {
+		return bson.M{"$in": bson.A{workspace, nil}}
+	}
+	return workspace
+}
+
 type Client struct {
 	mc     *mongo.Client
 	db     *mongo.Database
 	config *config.AppConfig
+	// transactions is set when the server supports them, on the replica sets and the sharded clusters
+	transactions bool
 }
 
 func NewMongoClient(appConfig *config.AppConfig) (*Client, error) {
@@ -32,18 +60,25 @@ func NewMongoClient(appConfig *config.AppConfig) (*Client, error) {
 		return nil, err
 	}
 
-	return &Client{
+	c := &Client{
 		mc:     client,
 		config: appConfig,
 		db:     client.Database(appConfig.MongoDbName),
-	}, nil
+	}
+	if c.transactions, err = supportsTransactions(client); err != nil {
+		return nil, err
+	}
+	return c, nil
 }
 
-// TODO: ensure index on url
 func (c *Client) FindUrl(ctx context.Context, url string) (*shortener.ModelShorten, error) {
 	u := &shortener.ModelShorten{}
 	collection := c.db.Collection(CollShortUrls)
-	if err := collection.FindOne(ctx, bson.M{"url": url}).Decode(u); err != nil {
+	err := collection.FindOne(ctx, scoped(ctx, active(bson.M{"url": url}))).Decode(u)
+	if err == mongo.ErrNoDocuments {
+		return nil, shortener.ErrNotFound
+	}
+	if err != nil {
 		return nil, err
 	}
 	return u, nil
@@ -52,30 +87,286 @@ func (c *Client) FindUrl(ctx context.Context, url string) (*shortener.ModelShort
 func (c *Client) FindById(ctx context.Context, id string) (*shortener.ModelShorten, error) {
 	u := &shortener.ModelShorten{}
 	collection := c.db.Collection(CollShortUrls)
-	if err := collection.FindOne(ctx, bson.M{"_id": id}).Decode(u); err != nil {
+	err := collection.FindOne(ctx, scoped(ctx, active(bson.M{"_id": id}))).Decode(u)
+	if err == mongo.ErrNoDocuments {
+		return nil, shortener.ErrNotFound
+	}
+	if err != nil {
 		return nil, err
 	}
 	return u, nil
 }
 
+// StoreUrl inserts the document, ErrDuplicate when its id is taken. The short ids are unique across
+// the workspaces, so that the redirects resolve them without knowing the workspace
 func (c *Client) StoreUrl(ctx context.Context, document interface{}) error {
 	collection := c.db.Collection(CollShortUrls)
 	_, err := collection.InsertOne(ctx, document)
+	if isDuplicateKey(err) {
+		return shortener.ErrDuplicate
+	}
 	return err
 }
 
-func (c *Client) DeleteById(ctx context.Context, id string) error {
+// ReplaceUrl replaces or inserts the document, ErrDuplicate when its id is used in another workspace
+func (c *Client) ReplaceUrl(ctx context.Context, document *shortener.ModelShorten) error {
+	collection := c.db.Collection(CollShortUrls)
+	opts := options.Replace().SetUpsert(true)
+	_, err := collection.ReplaceOne(ctx, scoped(ctx, bson.M{"_id": document.Id}), document, opts)
+	if isDuplicateKey(err) {
+		return shortener.ErrDuplicate
+	}
+	return err
+}
+
+func (c *Client) ListUrls(ctx context.Context, opts shortener.ListOptions) ([]*shortener.ModelShorten, error) {
+	collection := c.db.Collection(CollShortUrls)
+	findOpts := options.Find().
+		SetSort(bson.M{"_id": 1}).
+		SetSkip(opts.Offset).
+		SetLimit(opts.Limit)
+	filter := scoped(ctx, active(bson.M{}))
+	if opts.Deleted {
+		filter["deleted_at"] = bson.M{"$exists": true}
+	}
+	if opts.Broken {
+		filter["health.broken"] = true
+	}
+	if len(opts.Tags) > 0 {
+		filter["tags"] = bson.M{"$all": opts.Tags}
+	}
+	for key, value := range opts.Metadata {
+		filter["metadata."+key] = value
+	}
+	if opts.Search != "" {
+		filter["title"] = primitive.Regex{Pattern: regexp.QuoteMeta(opts.Search), Options: "i"}
+	}
+	cursor, err := collection.Find(ctx, filter, findOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	res := []*shortener.ModelShorten{}
+	if err := cursor.All(ctx, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (c *Client) Totals(ctx context.Context) (*shortener.Stats, error) {
+	collection := c.db.Collection(CollShortUrls)
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: scoped(ctx, active(bson.M{}))}},
+		{{Key: "$group", Value: bson.M{
+			"_id":       nil,
+			"urls":      bson.M{"$sum": 1},
+			"redirects": bson.M{"$sum": "$count"},
+		}}},
+	}
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	res := &shortener.Stats{}
+	if cursor.Next(ctx) {
+		if err := cursor.Decode(res); err != nil {
+			return nil, err
+		}
+	}
+	return res, cursor.Err()
+}
+
+// CountUrls counts the documents of workspace, the ones in the trash included
+func (c *Client) CountUrls(ctx context.Context, workspace string) (int64, error) {
+	collection := c.db.Collection(CollShortUrls)
+	return collection.CountDocuments(ctx, bson.M{"workspace": workspaceFilter(workspace)})
+}
+
+// ForEachUrl streams all the stored documents to fn, stopping at the first error
+func (c *Client) ForEachUrl(ctx context.Context, fn func(*shortener.ModelShorten) error) error {
+	collection := c.db.Collection(CollShortUrls)
+	opts := options.Find().SetSort(bson.M{"_id": 1})
+	cursor, err := collection.Find(ctx, scoped(ctx, active(bson.M{})), opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		u := &shortener.ModelShorten{}
+		if err := cursor.Decode(u); err != nil {
+			return err
+		}
+		if err := fn(u); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// UpdateHealth records the outcome of the check of the destinations of a document
+func (c *Client) UpdateHealth(ctx context.Context, id string, health shortener.Health) error {
+	collection := c.db.Collection(CollShortUrls)
+	_, err := collection.UpdateOne(ctx, scoped(ctx, bson.M{"_id": id}), bson.M{"$set": bson.M{"health": health}})
+	return err
+}
+
+// UpdatePage records the destination page of a document
+func (c *Client) UpdatePage(ctx context.Context, id string, page shortener.Page) error {
 	collection := c.db.Collection(CollShortUrls)
-	_, err := collection.DeleteOne(ctx, bson.M{"_id": id})
+	_, err := collection.UpdateOne(ctx, scoped(ctx, bson.M{"_id": id}), bson.M{"$set": bson.M{"page": page}})
 	return err
 }
 
-func (c *Client) IncrementCount(ctx context.Context, id string) (*shortener.ModelShorten, error) {
+// UpdateLabels replaces the labels of the document, the empty ones are removed
+func (c *Client) UpdateLabels(ctx context.Context, id string, labels shortener.Labels) (*shortener.ModelShorten, error) {
+	u := &shortener.ModelShorten{}
+	collection := c.db.Collection(CollShortUrls)
+	set, unset := bson.M{}, bson.M{}
+	if labels.Title != "" {
+		set["title"] = labels.Title
+	} else {
+		unset["title"] = ""
+	}
+	if labels.Description != "" {
+		set["description"] = labels.Description
+	} else {
+		unset["description"] = ""
+	}
+	if len(labels.Tags) > 0 {
+		set["tags"] = labels.Tags
+	} else {
+		unset["tags"] = ""
+	}
+	if len(labels.Metadata) > 0 {
+		set["metadata"] = labels.Metadata
+	} else {
+		unset["metadata"] = ""
+	}
+
+	update := bson.M{}
+	if len(set) > 0 {
+		update["$set"] = set
+	}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := collection.FindOneAndUpdate(ctx, scoped(ctx, active(bson.M{"_id": id})), update, opts).Decode(u)
+	if err == mongo.ErrNoDocuments {
+		return nil, shortener.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// DeleteById moves the document to the trash, keeping its id in use until it is purged
+func (c *Client) DeleteById(ctx context.Context, id string) error {
+	collection := c.db.Collection(CollShortUrls)
+	update := bson.M{"$set": bson.M{"deleted_at": time.Now().UTC()}}
+	res, err := collection.UpdateOne(ctx, scoped(ctx, active(bson.M{"_id": id})), update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return shortener.ErrNotFound
+	}
+	return nil
+}
+
+// RestoreById takes the document out of the trash, if it was moved there after since
+func (c *Client) RestoreById(ctx context.Context, id string, since time.Time) error {
+	collection := c.db.Collection(CollShortUrls)
+	filter := scoped(ctx, bson.M{"_id": id, "deleted_at": bson.M{"$gte": since}})
+	res, err := collection.UpdateOne(ctx, filter, bson.M{"$unset": bson.M{"deleted_at": ""}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return shortener.ErrNotFound
+	}
+	return nil
+}
+
+// PurgeDeleted removes the documents moved to the trash before the given time
+func (c *Client) PurgeDeleted(ctx context.Context, before time.Time) (int64, error) {
+	collection := c.db.Collection(CollShortUrls)
+	res, err := collection.DeleteMany(ctx, scoped(ctx, bson.M{"deleted_at": bson.M{"$lt": before}}))
+	if err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
+
+// ExpireUrl sets expired_at on an active document whose not_after is before now, so that
+// concurrent servers expire it once, and returns it. ErrNotFound when none is left
+func (c *Client) ExpireUrl(ctx context.Context, now time.Time) (*shortener.ModelShorten, error) {
+	u := &shortener.ModelShorten{}
+	collection := c.db.Collection(CollShortUrls)
+	filter := scoped(ctx, active(bson.M{
+		"not_after":  bson.M{"$lte": now},
+		"expired_at": bson.M{"$exists": false},
+	}))
+	update := bson.M{"$set": bson.M{"expired_at": now}}
+	findOpts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := collection.FindOneAndUpdate(ctx, filter, update, findOpts).Decode(u)
+	if err == mongo.ErrNoDocuments {
+		return nil, shortener.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// IncrementCount increments the count of redirects and the breakdown of the click,
+// unless the document reached its max clicks
+func (c *Client) IncrementCount(ctx context.Context, id string, click shortener.Click) (*shortener.ModelShorten, error) {
 	u := &shortener.ModelShorten{}
 	collection := c.db.Collection(CollShortUrls)
-	increment := bson.M{"$inc": bson.M{"count": 1}}
-	if err := collection.FindOneAndUpdate(ctx, bson.M{"_id": id}, increment).Decode(u); err != nil {
+	filter := scoped(ctx, active(bson.M{
+		"_id": id,
+		"$or": bson.A{
+			bson.M{"max_clicks": bson.M{"$exists": false}},
+			bson.M{"$expr": bson.M{"$lt": bson.A{"$count", "$max_clicks"}}},
+		},
+	}))
+	counters := bson.M{"count": 1}
+	if click.Destination != "" {
+		counters["clicks.destinations."+click.Destination] = 1
+	}
+	if click.Country != "" {
+		counters["clicks.countries."+click.Country] = 1
+	}
+	if click.Variant != "" {
+		counters["clicks.variants."+click.Variant] = 1
+	}
+	increment := bson.M{"$inc": counters}
+	err := collection.FindOneAndUpdate(ctx, filter, increment).Decode(u)
+	if err == mongo.ErrNoDocuments {
+		return nil, shortener.ErrNotFound
+	}
+	if err != nil {
 		return nil, err
 	}
 	return u, nil
 }
+
+// isDuplicateKey reports whether err was caused by a unique index violation
+func isDuplicateKey(err error) bool {
+	we, ok := err.(mongo.WriteException)
+	if !ok {
+		return false
+	}
+	for _, e := range we.WriteErrors {
+		if e.Code == 11000 {
+			return true
+		}
+	}
+	return false
+}
@@ -7,9 +7,14 @@ import (
 	"log"
 	"os"
 	"testing"
+	"time"
 
+	"github.com/gsiragusa/short-to-me/audit"
 	"github.com/gsiragusa/short-to-me/config"
+	"github.com/gsiragusa/short-to-me/migration"
 	"github.com/gsiragusa/short-to-me/shortener"
+	"github.com/gsiragusa/short-to-me/tenant"
+	"github.com/gsiragusa/short-to-me/webhook"
 	"github.com/stretchr/testify/require"
 )
 
@@ -84,6 +89,71 @@ func TestClient_StoreUrl(t *testing.T) {
 	require.Equal(t, doc.Url, res.Url)
 }
 
+func TestClient_StoreUrlDuplicate(t *testing.T) {
+	clearCollection()
+	addDocument(t)
+
+	err := client.StoreUrl(ctx, doc)
+
+	require.Equal(t, shortener.ErrDuplicate, err)
+}
+
+func TestClient_ReplaceUrl(t *testing.T) {
+	clearCollection()
+	addDocument(t)
+
+	replaced := doc
+	replaced.Url = "http://www.replaced.com"
+	err := client.ReplaceUrl(ctx, &replaced)
+
+	require.Nil(t, err)
+
+	res, err := client.FindById(ctx, doc.Id)
+
+	require.Nil(t, err)
+	require.Equal(t, replaced.Url, res.Url)
+}
+
+func TestClient_ListUrls(t *testing.T) {
+	clearCollection()
+	addDocument(t)
+
+	res, err := client.ListUrls(ctx, shortener.ListOptions{Limit: 10})
+
+	require.Nil(t, err)
+	require.Len(t, res, 1)
+	require.Equal(t, doc.Id, res[0].Id)
+
+	res, err = client.ListUrls(ctx, shortener.ListOptions{Offset: 1, Limit: 10})
+
+	require.Nil(t, err)
+	require.Empty(t, res)
+}
+
+func TestClient_Totals(t *testing.T) {
+	clearCollection()
+	addDocument(t)
+
+	res, err := client.Totals(ctx)
+
+	require.Nil(t, err)
+	require.Equal(t, &shortener.Stats{Urls: 1, Redirects: doc.Count}, res)
+}
+
+func TestClient_ForEachUrl(t *testing.T) {
+	clearCollection()
+	addDocument(t)
+
+	var ids []string
+	err := client.ForEachUrl(ctx, func(u *shortener.ModelShorten) error {
+		ids = append(ids, u.Id)
+		return nil
+	})
+
+	require.Nil(t, err)
+	require.Equal(t, []string{doc.Id}, ids)
+}
+
 func TestClient_DeleteById(t *testing.T) {
 	clearCollection()
 	addDocument(t)
@@ -98,11 +168,67 @@ func TestClient_DeleteById(t *testing.T) {
 	require.NotNil(t, err)
 }
 
+func TestClient_DeleteByIdMissing(t *testing.T) {
+	clearCollection()
+
+	err := client.DeleteById(ctx, doc.Id)
+
+	require.Equal(t, shortener.ErrNotFound, err)
+}
+
+func TestClient_RestoreById(t *testing.T) {
+	clearCollection()
+	addDocument(t)
+
+	deletedAt := time.Now().UTC()
+	err := client.DeleteById(ctx, doc.Id)
+	require.Nil(t, err)
+
+	// the id stays in use
+	err = client.StoreUrl(ctx, doc)
+	require.Equal(t, shortener.ErrDuplicate, err)
+
+	trash, err := client.ListUrls(ctx, shortener.ListOptions{Limit: 10, Deleted: true})
+	require.Nil(t, err)
+	require.Len(t, trash, 1)
+	require.NotNil(t, trash[0].DeletedAt)
+
+	// deleted before the retention period
+	err = client.RestoreById(ctx, doc.Id, deletedAt.Add(time.Minute))
+	require.Equal(t, shortener.ErrNotFound, err)
+
+	err = client.RestoreById(ctx, doc.Id, deletedAt.Add(-time.Minute))
+	require.Nil(t, err)
+
+	res, err := client.FindById(ctx, doc.Id)
+	require.Nil(t, err)
+	require.Nil(t, res.DeletedAt)
+}
+
+func TestClient_PurgeDeleted(t *testing.T) {
+	clearCollection()
+	addDocument(t)
+
+	err := client.DeleteById(ctx, doc.Id)
+	require.Nil(t, err)
+
+	purged, err := client.PurgeDeleted(ctx, time.Now().UTC().Add(-time.Minute))
+	require.Nil(t, err)
+	require.Equal(t, int64(0), purged)
+
+	purged, err = client.PurgeDeleted(ctx, time.Now().UTC().Add(time.Minute))
+	require.Nil(t, err)
+	require.Equal(t, int64(1), purged)
+
+	err = client.StoreUrl(ctx, doc)
+	require.Nil(t, err)
+}
+
 func TestClient_IncrementCount(t *testing.T) {
 	clearCollection()
 	addDocument(t)
 
-	_, err := client.IncrementCount(ctx, doc.Id)
+	_, err := client.IncrementCount(ctx, doc.Id, shortener.Click{})
 
 	require.Nil(t, err)
 
@@ -111,3 +237,458 @@ func TestClient_IncrementCount(t *testing.T) {
 	require.Nil(t, err)
 	require.Equal(t, doc.Count+1, res.Count)
 }
+
+func TestClient_IncrementCountClicks(t *testing.T) {
+	clearCollection()
+	addDocument(t)
+
+	_, err := client.IncrementCount(ctx, doc.Id, shortener.Click{Destination: "ios", Country: "IT"})
+	require.Nil(t, err)
+	_, err = client.IncrementCount(ctx, doc.Id, shortener.Click{Destination: "default", Country: "IT", Variant: "a"})
+	require.Nil(t, err)
+
+	res, err := client.FindById(ctx, doc.Id)
+	require.Nil(t, err)
+	require.Equal(t, map[string]int64{"ios": 1, "default": 1}, res.Clicks.Destinations)
+	require.Equal(t, map[string]int64{"IT": 2}, res.Clicks.Countries)
+	require.Equal(t, map[string]int64{"a": 1}, res.Clicks.Variants)
+}
+
+func TestClient_UpdateHealth(t *testing.T) {
+	clearCollection()
+	addDocument(t)
+
+	health := shortener.Health{
+		CheckedAt: time.Now().UTC().Truncate(time.Millisecond),
+		Broken:    true,
+		Destinations: []shortener.DestinationHealth{
+			{Destination: shortener.Destination{Field: "url", Url: doc.Url}, Status: 404, LatencyMs: 12, Broken: true},
+		},
+	}
+	err := client.UpdateHealth(ctx, doc.Id, health)
+
+	require.Nil(t, err)
+
+	res, err := client.FindById(ctx, doc.Id)
+
+	require.Nil(t, err)
+	require.Equal(t, &health, res.Health)
+
+	broken, err := client.ListUrls(ctx, shortener.ListOptions{Limit: 10, Broken: true})
+
+	require.Nil(t, err)
+	require.Len(t, broken, 1)
+}
+
+func TestClient_MarkApplied(t *testing.T) {
+	if err := client.db.Collection(CollMigrations).Drop(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	err := client.MarkApplied(ctx, migration.Record{Version: 1, Description: "test"})
+
+	require.Nil(t, err)
+
+	res, err := client.AppliedMigrations(ctx)
+
+	require.Nil(t, err)
+	require.Len(t, res, 1)
+	require.Equal(t, 1, res[0].Version)
+}
+
+func TestClient_Lock(t *testing.T) {
+	if err := client.db.Collection(CollMigrationsLock).Drop(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	require.Nil(t, client.Lock(ctx, "a", time.Minute))
+	// held by a, extended by a
+	require.Equal(t, migration.ErrLocked, client.Lock(ctx, "b", time.Minute))
+	require.Nil(t, client.Lock(ctx, "a", time.Minute))
+
+	// only released by its owner
+	require.Nil(t, client.Unlock(ctx, "b"))
+	require.Equal(t, migration.ErrLocked, client.Lock(ctx, "b", time.Minute))
+	require.Nil(t, client.Unlock(ctx, "a"))
+	require.Nil(t, client.Lock(ctx, "b", -time.Second))
+
+	// an expired lock is taken over
+	require.Nil(t, client.Lock(ctx, "a", time.Minute))
+}
+
+func TestClient_BackfillCreatedAt(t *testing.T) {
+	clearCollection()
+	addDocument(t)
+
+	err := client.backfillCreatedAt(ctx)
+
+	require.Nil(t, err)
+
+	res, err := client.FindById(ctx, doc.Id)
+
+	require.Nil(t, err)
+	require.False(t, res.CreatedAt.IsZero())
+}
+
+func TestClient_IncrementCountMaxClicks(t *testing.T) {
+	clearCollection()
+
+	limited := doc
+	limited.MaxClicks = doc.Count + 1
+	if err := client.StoreUrl(ctx, limited); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := client.IncrementCount(ctx, doc.Id, shortener.Click{})
+
+	require.Nil(t, err)
+
+	_, err = client.IncrementCount(ctx, doc.Id, shortener.Click{})
+
+	require.Equal(t, shortener.ErrNotFound, err)
+
+	res, err := client.FindById(ctx, doc.Id)
+
+	require.Nil(t, err)
+	require.Equal(t, limited.MaxClicks, res.Count)
+}
+
+func TestClient_IncrementCountDeleted(t *testing.T) {
+	clearCollection()
+
+	// a short url with a limit of clicks deleted during a visit is not found, not exhausted
+	limited := doc
+	limited.MaxClicks = doc.Count + 1
+	if err := client.StoreUrl(ctx, limited); err != nil {
+		t.Fatal(err)
+	}
+	require.Nil(t, client.DeleteById(ctx, doc.Id))
+
+	_, err := client.IncrementCount(ctx, doc.Id, shortener.Click{})
+
+	require.Equal(t, shortener.ErrNotFound, err)
+
+	_, err = client.FindById(ctx, doc.Id)
+
+	require.Equal(t, shortener.ErrNotFound, err)
+
+	_, err = client.FindUrl(ctx, doc.Url)
+
+	require.Equal(t, shortener.ErrNotFound, err)
+}
+
+func TestClient_FindAudit(t *testing.T) {
+	if err := client.db.Collection(CollAudit).Drop(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	entries := []audit.Entry{
+		{Id: "a1", Time: now.Add(-2 * time.Hour), Action: audit.ActionCreate, Origin: audit.Origin{Actor: audit.ActorAnonymous}, ShortId: doc.Id},
+		{Id: "a2", Time: now.Add(-time.Hour), Action: audit.ActionDelete, Origin: audit.Origin{Actor: audit.ActorAdmin, RequestId: "req"}, ShortId: doc.Id},
+		{Id: "a3", Time: now, Action: audit.ActionPurge, Origin: audit.Origin{Actor: audit.ActorSystem}, Details: map[string]int64{"purged": 1}},
+	}
+	for _, e := range entries {
+		require.Nil(t, client.InsertAudit(ctx, e))
+	}
+
+	res, err := client.FindAudit(ctx, audit.Filter{Limit: 10})
+
+	require.Nil(t, err)
+	require.Len(t, res, 3)
+	require.Equal(t, "a3", res[0].Id)
+	require.Equal(t, entries[2].Details, res[0].Details)
+
+	res, err = client.FindAudit(ctx, audit.Filter{ShortId: doc.Id, Actor: audit.ActorAdmin, Limit: 10})
+
+	require.Nil(t, err)
+	require.Len(t, res, 1)
+	require.Equal(t, "req", res[0].RequestId)
+
+	res, err = client.FindAudit(ctx, audit.Filter{From: now.Add(-90 * time.Minute), To: now, Limit: 10})
+
+	require.Nil(t, err)
+	require.Len(t, res, 1)
+	require.Equal(t, "a2", res[0].Id)
+}
+
+func TestClient_UpdateLabels(t *testing.T) {
+	clearCollection()
+	addDocument(t)
+
+	labels := shortener.Labels{
+		Title:    "Spring sale",
+		Tags:     []string{"campaign:spring", "shoes"},
+		Metadata: map[string]string{"owner": "marketing"},
+	}
+	res, err := client.UpdateLabels(ctx, doc.Id, labels)
+
+	require.Nil(t, err)
+	require.Equal(t, labels, res.Labels)
+	require.Equal(t, doc.Count, res.Count)
+
+	for _, opts := range []shortener.ListOptions{
+		{Limit: 10, Tags: []string{"shoes", "campaign:spring"}},
+		{Limit: 10, Metadata: map[string]string{"owner": "marketing"}},
+		{Limit: 10, Search: "SALE"},
+	} {
+		urls, err := client.ListUrls(ctx, opts)
+
+		require.Nil(t, err)
+		require.Len(t, urls, 1)
+	}
+
+	urls, err := client.ListUrls(ctx, shortener.ListOptions{Limit: 10, Tags: []string{"shoes", "hats"}})
+
+	require.Nil(t, err)
+	require.Len(t, urls, 0)
+
+	res, err = client.UpdateLabels(ctx, doc.Id, shortener.Labels{Title: "Spring sale"})
+
+	require.Nil(t, err)
+	require.Equal(t, shortener.Labels{Title: "Spring sale"}, res.Labels)
+
+	_, err = client.UpdateLabels(ctx, "missing", labels)
+
+	require.Equal(t, shortener.ErrNotFound, err)
+}
+
+func TestClient_UpdatePage(t *testing.T) {
+	clearCollection()
+	addDocument(t)
+
+	page := shortener.Page{
+		FetchedAt: time.Now().UTC().Truncate(time.Millisecond),
+		Title:     "Test",
+		OpenGraph: map[string]string{"title": "Test page", "image": "http://www.test.com/cover.png"},
+		Favicon:   "http://www.test.com/favicon.ico",
+	}
+	err := client.UpdatePage(ctx, doc.Id, page)
+
+	require.Nil(t, err)
+
+	res, err := client.FindById(ctx, doc.Id)
+
+	require.Nil(t, err)
+	require.Equal(t, &page, res.Page)
+}
+
+func TestClient_Scoped(t *testing.T) {
+	clearCollection()
+	addDocument(t)
+	other := shortener.ModelShorten{Id: "XYZ1234", Workspace: "team-a", Url: "http://www.test.com"}
+	require.Nil(t, client.StoreUrl(ctx, &other))
+
+	res, err := client.FindById(tenant.WithWorkspace(ctx, tenant.Default), doc.Id)
+
+	require.Nil(t, err)
+	require.Equal(t, doc.Id, res.Id)
+
+	_, err = client.FindById(tenant.WithWorkspace(ctx, "team-a"), doc.Id)
+
+	require.Equal(t, shortener.ErrNotFound, err)
+
+	list, err := client.ListUrls(tenant.WithWorkspace(ctx, "team-a"), shortener.ListOptions{Limit: 10})
+
+	require.Nil(t, err)
+	require.Len(t, list, 1)
+	require.Equal(t, other.Id, list[0].Id)
+
+	err = client.DeleteById(tenant.WithWorkspace(ctx, "team-a"), doc.Id)
+
+	require.Equal(t, shortener.ErrNotFound, err)
+
+	count, err := client.CountUrls(ctx, "team-a")
+
+	require.Nil(t, err)
+	require.Equal(t, int64(1), count)
+}
+
+func TestClient_Keys(t *testing.T) {
+	if err := client.db.Collection(CollApiKeys).Drop(ctx); err != nil {
+		t.Fatal(err)
+	}
+	key, plain, err := tenant.NewKey("team-a", "ci")
+	require.Nil(t, err)
+	require.Nil(t, client.InsertKey(ctx, key))
+
+	res, err := client.FindKey(ctx, tenant.Hash(plain))
+
+	require.Nil(t, err)
+	require.Equal(t, key.Id, res.Id)
+	require.Nil(t, res.RevokedAt)
+
+	require.Nil(t, client.RevokeKey(ctx, key.Id))
+	require.Equal(t, shortener.ErrNotFound, client.RevokeKey(ctx, key.Id))
+
+	res, err = client.FindKey(ctx, tenant.Hash(plain))
+
+	require.Nil(t, err)
+	require.NotNil(t, res.RevokedAt)
+}
+
+func TestClient_Domains(t *testing.T) {
+	if err := client.db.Collection(CollDomains).Drop(ctx); err != nil {
+		t.Fatal(err)
+	}
+	domain := tenant.Domain{Host: "go.brand-a.com", Workspace: "team-a", CreatedAt: time.Now().UTC().Truncate(time.Millisecond)}
+	require.Nil(t, client.InsertDomain(ctx, domain))
+	require.Equal(t, shortener.ErrDuplicate, client.InsertDomain(ctx, domain))
+
+	res, err := client.FindDomain(ctx, domain.Host)
+
+	require.Nil(t, err)
+	require.Equal(t, &domain, res)
+
+	list, err := client.ListDomains(ctx, "team-b")
+
+	require.Nil(t, err)
+	require.Empty(t, list)
+
+	require.Nil(t, client.DeleteDomain(ctx, domain.Host))
+	require.Equal(t, shortener.ErrNotFound, client.DeleteDomain(ctx, domain.Host))
+
+	_, err = client.FindDomain(ctx, domain.Host)
+
+	require.Equal(t, shortener.ErrNotFound, err)
+}
+
+func TestClient_ExpireUrl(t *testing.T) {
+	clearCollection()
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	past, future := now.Add(-time.Hour), now.Add(time.Hour)
+	expired := shortener.ModelShorten{Id: "EXP1234", Url: "http://www.test.com", NotAfter: &past}
+	active := shortener.ModelShorten{Id: "ACT1234", Url: "http://www.test.com", NotAfter: &future}
+	require.Nil(t, client.StoreUrl(ctx, &expired))
+	require.Nil(t, client.StoreUrl(ctx, &active))
+
+	res, err := client.ExpireUrl(ctx, now)
+
+	require.Nil(t, err)
+	require.Equal(t, expired.Id, res.Id)
+	require.Equal(t, now, *res.ExpiredAt)
+
+	_, err = client.ExpireUrl(ctx, now)
+
+	require.Equal(t, shortener.ErrNotFound, err)
+}
+
+func TestClient_Webhooks(t *testing.T) {
+	for _, coll := range []string{CollWebhooks, CollDeliveries} {
+		if err := client.db.Collection(coll).Drop(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	endpoint := webhook.Endpoint{Id: "e1", Workspace: "team-a", Url: "https://hooks.test.com", Secret: "s", CreatedAt: now}
+	require.Nil(t, client.InsertEndpoint(ctx, endpoint))
+
+	list, err := client.ListEndpoints(tenant.WithWorkspace(ctx, "team-b"))
+
+	require.Nil(t, err)
+	require.Empty(t, list)
+
+	res, err := client.FindEndpoint(tenant.WithWorkspace(ctx, "team-a"), endpoint.Id)
+
+	require.Nil(t, err)
+	require.Equal(t, &endpoint, res)
+
+	delivery := webhook.Delivery{
+		Id:            "d1",
+		EndpointId:    endpoint.Id,
+		Workspace:     "team-a",
+		Event:         shortener.EventLinkCreated,
+		Payload:       []byte(`{"type":"link.created"}`),
+		Status:        webhook.StatusPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	}
+	require.Nil(t, client.InsertDelivery(ctx, delivery))
+
+	claimed, err := client.ClaimDelivery(ctx, now, time.Minute)
+
+	require.Nil(t, err)
+	require.Equal(t, delivery.Id, claimed.Id)
+	require.Equal(t, now.Add(time.Minute), claimed.NextAttemptAt)
+
+	_, err = client.ClaimDelivery(ctx, now, time.Minute)
+
+	require.Equal(t, shortener.ErrNotFound, err)
+
+	claimed.Status, claimed.Attempts = webhook.StatusDead, 8
+	require.Nil(t, client.UpdateDelivery(ctx, *claimed))
+
+	deliveries, err := client.FindDeliveries(tenant.WithWorkspace(ctx, "team-a"), webhook.Filter{Status: webhook.StatusDead, Limit: 10})
+
+	require.Nil(t, err)
+	require.Len(t, deliveries, 1)
+
+	redelivered, err := client.RedeliverDelivery(ctx, delivery.Id, now)
+
+	require.Nil(t, err)
+	require.Equal(t, webhook.StatusPending, redelivered.Status)
+	require.Equal(t, 0, redelivered.Attempts)
+
+	require.Nil(t, client.DeleteEndpoint(ctx, endpoint.Id))
+	require.Equal(t, shortener.ErrNotFound, client.DeleteEndpoint(ctx, endpoint.Id))
+}
+
+func TestClient_Outbox(t *testing.T) {
+	clearCollection()
+	if err := client.db.Collection(CollOutbox).Drop(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// the transactions can't create the collections
+	require.Nil(t, client.createOutbox(ctx))
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	event := shortener.Event{Id: "ev1", Type: shortener.EventLinkCreated, Time: now, Workspace: tenant.Default, Link: &doc}
+
+	err := client.Transaction(ctx, func(ctx context.Context) error {
+		if err := client.StoreUrl(ctx, &doc); err != nil {
+			return err
+		}
+		return client.InsertEvent(ctx, event)
+	})
+
+	require.Nil(t, err)
+
+	record, err := client.ClaimRecord(ctx, now, time.Minute)
+
+	require.Nil(t, err)
+	require.Equal(t, event, record.Event)
+	require.Equal(t, now.Add(time.Minute), record.NextAttemptAt)
+
+	_, err = client.ClaimRecord(ctx, now, time.Minute)
+
+	require.Equal(t, shortener.ErrNotFound, err)
+
+	record.PublishedAt = &now
+	record.Published = []string{"file"}
+	require.Nil(t, client.UpdateRecord(ctx, *record))
+
+	purged, err := client.PurgePublished(ctx, now.Add(time.Second))
+
+	require.Nil(t, err)
+	require.Equal(t, int64(1), purged)
+
+	// the mutation and its event are rolled back together
+	if !client.Transactional() {
+		t.Skip("mongo is standalone")
+	}
+	other := shortener.ModelShorten{Id: "XYZ1234", Url: "http://www.test.com"}
+	err = client.Transaction(ctx, func(ctx context.Context) error {
+		if err := client.StoreUrl(ctx, &other); err != nil {
+			return err
+		}
+		if err := client.InsertEvent(ctx, shortener.Event{Id: "ev2", Time: now}); err != nil {
+			return err
+		}
+		return shortener.ErrDuplicate
+	})
+
+	require.Equal(t, shortener.ErrDuplicate, err)
+	_, err = client.FindById(ctx, other.Id)
+	require.Equal(t, shortener.ErrNotFound, err)
+	_, err = client.ClaimRecord(ctx, now, time.Minute)
+	require.Equal(t, shortener.ErrNotFound, err)
+}
@@ -0,0 +1,95 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/gsiragusa/short-to-me/outbox"
+	"github.com/gsiragusa/short-to-me/shortener"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const CollOutbox = "outbox"
+
+// supportsTransactions reports whether the server is a member of a replica set or a mongos, the
+// standalone servers have no transactions
+func supportsTransactions(mc *mongo.Client) (bool, error) {
+	var res struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	err := mc.Database("admin").RunCommand(context.Background(), bson.M{"isMaster": 1}).Decode(&res)
+	if err != nil {
+		return false, err
+	}
+	return res.SetName != "" || res.Msg == "isdbgrid", nil
+}
+
+// Transaction runs fn in a transaction, the writes it makes with its ctx are applied together or
+// not at all. On a standalone server fn runs without transaction, its writes are applied one at a time
+func (c *Client) Transaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if !c.transactions {
+		return fn(ctx)
+	}
+	session, err := c.mc.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	return err
+}
+
+// Transactional reports whether Transaction applies the writes together
+func (c *Client) Transactional() bool {
+	return c.transactions
+}
+
+// InsertEvent writes an event to the outbox, in the transaction of ctx if any
+func (c *Client) InsertEvent(ctx context.Context, event shortener.Event) error {
+	collection := c.db.Collection(CollOutbox)
+	_, err := collection.InsertOne(ctx, outbox.NewRecord(event))
+	return err
+}
+
+// ClaimRecord returns the unpublished record of the outbox due the earliest at now, postponing it
+// by lease, ErrNotFound when none is due
+func (c *Client) ClaimRecord(ctx context.Context, now time.Time, lease time.Duration) (*outbox.Record, error) {
+	r := &outbox.Record{}
+	collection := c.db.Collection(CollOutbox)
+	filter := bson.M{
+		"published_at":    nil,
+		"next_attempt_at": bson.M{"$lte": now},
+	}
+	update := bson.M{"$set": bson.M{"next_attempt_at": now.Add(lease)}}
+	findOpts := options.FindOneAndUpdate().SetSort(bson.D{{Key: "next_attempt_at", Value: 1}, {Key: "_id", Value: 1}})
+	err := collection.FindOneAndUpdate(ctx, filter, update, findOpts).Decode(r)
+	if err == mongo.ErrNoDocuments {
+		return nil, shortener.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// UpdateRecord records the outcome of the publication of an event of the outbox
+func (c *Client) UpdateRecord(ctx context.Context, record outbox.Record) error {
+	collection := c.db.Collection(CollOutbox)
+	_, err := collection.ReplaceOne(ctx, bson.M{"_id": record.Id}, record)
+	return err
+}
+
+// PurgePublished removes the events of the outbox published before, returning their number
+func (c *Client) PurgePublished(ctx context.Context, before time.Time) (int64, error) {
+	collection := c.db.Collection(CollOutbox)
+	res, err := collection.DeleteMany(ctx, bson.M{"published_at": bson.M{"$lt": before}})
+	if err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
@@ -0,0 +1,168 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/gsiragusa/short-to-me/shortener"
+	"github.com/gsiragusa/short-to-me/tenant"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	CollWorkspaces = "workspaces"
+	CollApiKeys    = "api_keys"
+	CollDomains    = "domains"
+)
+
+// InsertWorkspace stores a new workspace, ErrDuplicate when its id is taken
+func (c *Client) InsertWorkspace(ctx context.Context, workspace tenant.Workspace) error {
+	collection := c.db.Collection(CollWorkspaces)
+	_, err := collection.InsertOne(ctx, workspace)
+	if isDuplicateKey(err) {
+		return shortener.ErrDuplicate
+	}
+	return err
+}
+
+// FindWorkspace returns the workspace id, ErrNotFound when it doesn't exist
+func (c *Client) FindWorkspace(ctx context.Context, id string) (*tenant.Workspace, error) {
+	w := &tenant.Workspace{}
+	collection := c.db.Collection(CollWorkspaces)
+	err := collection.FindOne(ctx, bson.M{"_id": id}).Decode(w)
+	if err == mongo.ErrNoDocuments {
+		return nil, shortener.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// ListWorkspaces returns all the workspaces, sorted by id
+func (c *Client) ListWorkspaces(ctx context.Context) ([]tenant.Workspace, error) {
+	collection := c.db.Collection(CollWorkspaces)
+	cursor, err := collection.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+
+	res := []tenant.Workspace{}
+	if err := cursor.All(ctx, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// InsertKey stores a new api key
+func (c *Client) InsertKey(ctx context.Context, key tenant.Key) error {
+	collection := c.db.Collection(CollApiKeys)
+	_, err := collection.InsertOne(ctx, key)
+	return err
+}
+
+// FindKey returns the api key with the given hash, ErrNotFound when it doesn't exist
+func (c *Client) FindKey(ctx context.Context, hash string) (*tenant.Key, error) {
+	k := &tenant.Key{}
+	collection := c.db.Collection(CollApiKeys)
+	err := collection.FindOne(ctx, bson.M{"_id": hash}).Decode(k)
+	if err == mongo.ErrNoDocuments {
+		return nil, shortener.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// ListKeys returns the api keys of workspace, of all the workspaces when it is empty
+func (c *Client) ListKeys(ctx context.Context, workspace string) ([]tenant.Key, error) {
+	collection := c.db.Collection(CollApiKeys)
+	filter := bson.M{}
+	if workspace != "" {
+		filter["workspace"] = workspace
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "workspace", Value: 1}, {Key: "created_at", Value: 1}})
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	res := []tenant.Key{}
+	if err := cursor.All(ctx, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// RevokeKey revokes the api key id, ErrNotFound when it doesn't exist or was already revoked
+func (c *Client) RevokeKey(ctx context.Context, id string) error {
+	collection := c.db.Collection(CollApiKeys)
+	filter := bson.M{"key_id": id, "revoked_at": bson.M{"$exists": false}}
+	res, err := collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"revoked_at": time.Now().UTC()}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return shortener.ErrNotFound
+	}
+	return nil
+}
+
+// InsertDomain registers a custom domain, ErrDuplicate when it is already registered
+func (c *Client) InsertDomain(ctx context.Context, domain tenant.Domain) error {
+	collection := c.db.Collection(CollDomains)
+	_, err := collection.InsertOne(ctx, domain)
+	if isDuplicateKey(err) {
+		return shortener.ErrDuplicate
+	}
+	return err
+}
+
+// FindDomain returns the custom domain host, ErrNotFound when it is not registered
+func (c *Client) FindDomain(ctx context.Context, host string) (*tenant.Domain, error) {
+	d := &tenant.Domain{}
+	collection := c.db.Collection(CollDomains)
+	err := collection.FindOne(ctx, bson.M{"_id": host}).Decode(d)
+	if err == mongo.ErrNoDocuments {
+		return nil, shortener.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// ListDomains returns the custom domains of workspace, of all the workspaces when it is empty
+func (c *Client) ListDomains(ctx context.Context, workspace string) ([]tenant.Domain, error) {
+	collection := c.db.Collection(CollDomains)
+	filter := bson.M{}
+	if workspace != "" {
+		filter["workspace"] = workspace
+	}
+	cursor, err := collection.Find(ctx, filter, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+
+	res := []tenant.Domain{}
+	if err := cursor.All(ctx, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// DeleteDomain unregisters the custom domain host, ErrNotFound when it is not registered
+func (c *Client) DeleteDomain(ctx context.Context, host string) error {
+	collection := c.db.Collection(CollDomains)
+	res, err := collection.DeleteOne(ctx, bson.M{"_id": host})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return shortener.ErrNotFound
+	}
+	return nil
+}
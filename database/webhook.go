@@ -0,0 +1,154 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/gsiragusa/short-to-me/shortener"
+	"github.com/gsiragusa/short-to-me/webhook"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	CollWebhooks   = "webhooks"
+	CollDeliveries = "webhook_deliveries"
+)
+
+// InsertEndpoint stores a new webhook endpoint
+func (c *Client) InsertEndpoint(ctx context.Context, endpoint webhook.Endpoint) error {
+	collection := c.db.Collection(CollWebhooks)
+	_, err := collection.InsertOne(ctx, endpoint)
+	return err
+}
+
+// ListEndpoints returns the webhook endpoints, sorted by creation
+func (c *Client) ListEndpoints(ctx context.Context) ([]webhook.Endpoint, error) {
+	collection := c.db.Collection(CollWebhooks)
+	findOpts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := collection.Find(ctx, scoped(ctx, bson.M{}), findOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	res := []webhook.Endpoint{}
+	if err := cursor.All(ctx, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// FindEndpoint returns the webhook endpoint id, ErrNotFound when it doesn't exist
+func (c *Client) FindEndpoint(ctx context.Context, id string) (*webhook.Endpoint, error) {
+	e := &webhook.Endpoint{}
+	collection := c.db.Collection(CollWebhooks)
+	err := collection.FindOne(ctx, scoped(ctx, bson.M{"_id": id})).Decode(e)
+	if err == mongo.ErrNoDocuments {
+		return nil, shortener.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// DeleteEndpoint removes the webhook endpoint id, ErrNotFound when it doesn't exist
+func (c *Client) DeleteEndpoint(ctx context.Context, id string) error {
+	collection := c.db.Collection(CollWebhooks)
+	res, err := collection.DeleteOne(ctx, scoped(ctx, bson.M{"_id": id}))
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return shortener.ErrNotFound
+	}
+	return nil
+}
+
+// InsertDelivery queues a webhook delivery, ErrDuplicate when its id is taken
+func (c *Client) InsertDelivery(ctx context.Context, delivery webhook.Delivery) error {
+	collection := c.db.Collection(CollDeliveries)
+	_, err := collection.InsertOne(ctx, delivery)
+	if isDuplicateKey(err) {
+		return shortener.ErrDuplicate
+	}
+	return err
+}
+
+// ClaimDelivery returns the oldest pending delivery due at now, postponing it by lease,
+// ErrNotFound when none is due
+func (c *Client) ClaimDelivery(ctx context.Context, now time.Time, lease time.Duration) (*webhook.Delivery, error) {
+	d := &webhook.Delivery{}
+	collection := c.db.Collection(CollDeliveries)
+	filter := bson.M{
+		"status":          webhook.StatusPending,
+		"next_attempt_at": bson.M{"$lte": now},
+	}
+	update := bson.M{"$set": bson.M{"next_attempt_at": now.Add(lease)}}
+	findOpts := options.FindOneAndUpdate().SetSort(bson.M{"next_attempt_at": 1})
+	err := collection.FindOneAndUpdate(ctx, filter, update, findOpts).Decode(d)
+	if err == mongo.ErrNoDocuments {
+		return nil, shortener.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// UpdateDelivery records the outcome of an attempt of a delivery
+func (c *Client) UpdateDelivery(ctx context.Context, delivery webhook.Delivery) error {
+	collection := c.db.Collection(CollDeliveries)
+	_, err := collection.ReplaceOne(ctx, bson.M{"_id": delivery.Id}, delivery)
+	return err
+}
+
+// FindDeliveries returns the deliveries matching filter, from the most recent
+func (c *Client) FindDeliveries(ctx context.Context, filter webhook.Filter) ([]webhook.Delivery, error) {
+	collection := c.db.Collection(CollDeliveries)
+	findOpts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(filter.Offset).
+		SetLimit(filter.Limit)
+
+	query := scoped(ctx, bson.M{})
+	if filter.EndpointId != "" {
+		query["endpoint_id"] = filter.EndpointId
+	}
+	if filter.Status != "" {
+		query["status"] = filter.Status
+	}
+
+	cursor, err := collection.Find(ctx, query, findOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	res := []webhook.Delivery{}
+	if err := cursor.All(ctx, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// RedeliverDelivery makes the delivery id pending and due at now with no attempts, ErrNotFound
+// when it doesn't exist
+func (c *Client) RedeliverDelivery(ctx context.Context, id string, now time.Time) (*webhook.Delivery, error) {
+	d := &webhook.Delivery{}
+	collection := c.db.Collection(CollDeliveries)
+	update := bson.M{"$set": bson.M{
+		"status":          webhook.StatusPending,
+		"attempts":        0,
+		"next_attempt_at": now,
+	}}
+	findOpts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := collection.FindOneAndUpdate(ctx, scoped(ctx, bson.M{"_id": id}), update, findOpts).Decode(d)
+	if err == mongo.ErrNoDocuments {
+		return nil, shortener.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
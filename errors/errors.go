@@ -2,37 +2,203 @@ package errors
 
 import "net/http"
 
+// Codes identify the cause of an error, they are stable and safe for clients to branch on
+const (
+	CodeBadRequest   = "bad_request"
+	CodeInvalidUrl   = "invalid_url"
+	CodeNotFound     = "not_found"
+	CodeLinkNotFound = "link_not_found"
+	CodeUnauthorized = "unauthorized"
+	CodeForbidden    = "forbidden"
+	CodeRateLimited  = "rate_limited"
+	CodeInternal     = "internal_error"
+
+	CodePasswordRequired = "password_required"
+	CodeInvalidPassword  = "invalid_password"
+	CodeLinkExhausted    = "link_exhausted"
+	CodeLinkNotActive    = "link_not_active"
+	CodeLinkExpired      = "link_expired"
+	CodeUrlBlocked       = "url_blocked"
+	CodeRedirectLoop     = "redirect_loop"
+	CodeQuotaExceeded    = "quota_exceeded"
+	CodeIdUnavailable    = "id_unavailable"
+)
+
 type Error struct {
-	Status     string `json:"status"`
-	Message    string `json:"message"`
-	HttpStatus int    `json:"http_status"`
+	Status     string       `json:"status"`
+	Code       string       `json:"code"`
+	Message    string       `json:"message"`
+	HttpStatus int          `json:"http_status"`
+	Details    []FieldError `json:"details,omitempty"`
+}
+
+// FieldError describes why the value of an input field was rejected
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
 }
 
 func NewErrorNotFound() Error {
 	return Error{
+		Code:       CodeNotFound,
 		Message:    "The resource was not found",
 		HttpStatus: http.StatusNotFound,
 	}
 }
 
+func NewErrorLinkNotFound() Error {
+	return Error{
+		Code:       CodeLinkNotFound,
+		Message:    "The short url was not found",
+		HttpStatus: http.StatusNotFound,
+	}
+}
+
+func NewErrorLinkExhausted() Error {
+	return Error{
+		Code:       CodeLinkExhausted,
+		Message:    "The short url reached its maximum number of redirects",
+		HttpStatus: http.StatusGone,
+	}
+}
+
+func NewErrorLinkNotActive() Error {
+	return Error{
+		Code:       CodeLinkNotActive,
+		Message:    "The short url is not active yet",
+		HttpStatus: http.StatusForbidden,
+	}
+}
+
+func NewErrorLinkExpired() Error {
+	return Error{
+		Code:       CodeLinkExpired,
+		Message:    "The short url expired",
+		HttpStatus: http.StatusGone,
+	}
+}
+
 func NewErrorBadRequest() Error {
 	return Error{
+		Code:       CodeBadRequest,
 		Message:    "There was something wrong with your request",
 		HttpStatus: http.StatusBadRequest,
 	}
 }
 
+func NewErrorInvalidUrl(reason string) Error {
+	return Error{
+		Code:       CodeInvalidUrl,
+		Message:    "The url is not valid",
+		HttpStatus: http.StatusBadRequest,
+	}.WithDetail("url", reason)
+}
+
+// NewErrorUrlBlocked is returned for the destinations refused by the policy of the service,
+// field being the input of the destination
+func NewErrorUrlBlocked(field, reason string) Error {
+	return Error{
+		Code:       CodeUrlBlocked,
+		Message:    "The url is blocked by the policy of the service",
+		HttpStatus: http.StatusForbidden,
+	}.WithDetail(field, reason)
+}
+
+// NewErrorRedirectLoop is returned for the destinations redirecting back to a short url or
+// through too many redirects, field being the input of the destination
+func NewErrorRedirectLoop(field, reason string) Error {
+	return Error{
+		Code:       CodeRedirectLoop,
+		Message:    "The url creates a redirect loop",
+		HttpStatus: http.StatusBadRequest,
+	}.WithDetail(field, reason)
+}
+
+// NewErrorQuotaExceeded is returned when the workspace of a request reached its quota of short urls
+func NewErrorQuotaExceeded() Error {
+	return Error{
+		Code:       CodeQuotaExceeded,
+		Message:    "The workspace reached its quota of short urls",
+		HttpStatus: http.StatusForbidden,
+	}
+}
+
+// NewErrorIdUnavailable is returned when no free id was found for a new short url
+func NewErrorIdUnavailable() Error {
+	return Error{
+		Code:       CodeIdUnavailable,
+		Message:    "No id is available for the short url. Please try again later",
+		HttpStatus: http.StatusServiceUnavailable,
+	}
+}
+
+func NewErrorUnauthorized() Error {
+	return Error{
+		Code:       CodeUnauthorized,
+		Message:    "Missing or invalid credentials",
+		HttpStatus: http.StatusUnauthorized,
+	}
+}
+
+func NewErrorForbidden() Error {
+	return Error{
+		Code:       CodeForbidden,
+		Message:    "You are not allowed to perform this operation",
+		HttpStatus: http.StatusForbidden,
+	}
+}
+
+func NewErrorRateLimited() Error {
+	return Error{
+		Code:       CodeRateLimited,
+		Message:    "Too many attempts. Please try again later",
+		HttpStatus: http.StatusTooManyRequests,
+	}
+}
+
+func NewErrorPasswordRequired() Error {
+	return Error{
+		Code:       CodePasswordRequired,
+		Message:    "The short url is protected by a password",
+		HttpStatus: http.StatusUnauthorized,
+	}
+}
+
+func NewErrorInvalidPassword() Error {
+	return Error{
+		Code:       CodeInvalidPassword,
+		Message:    "The password is not valid",
+		HttpStatus: http.StatusForbidden,
+	}
+}
+
 func NewInternalServerError() Error {
 	return Error{
+		Code:       CodeInternal,
 		Message:    "An unexpected error occurred. Please try again later",
 		HttpStatus: http.StatusInternalServerError,
 	}
 }
 
+// Error describes the error for the logs
+func (e *Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// WithDetail returns a copy of the error reporting the field that caused it
+func (e Error) WithDetail(field, message string) Error {
+	details := make([]FieldError, len(e.Details), len(e.Details)+1)
+	copy(details, e.Details)
+	e.Details = append(details, FieldError{Field: field, Message: message})
+	return e
+}
+
 func ErrResponse(err Error) *Error {
 	return &Error{
 		Status:     "error",
+		Code:       err.Code,
 		Message:    err.Message,
 		HttpStatus: err.HttpStatus,
+		Details:    err.Details,
 	}
 }
@@ -0,0 +1,33 @@
+package errors
+
+import "net/http"
+
+// ProblemContentType is the media type of the problem details defined by RFC 7807
+const ProblemContentType = "application/problem+json"
+
+// problemTypePrefix builds the type uri of a problem from its code
+const problemTypePrefix = "urn:short-to-me:problem:"
+
+// Problem is the RFC 7807 representation of an Error
+type Problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Code     string       `json:"code"`
+	Errors   []FieldError `json:"errors,omitempty"`
+}
+
+// ProblemResponse converts err to a Problem about the request path instance
+func ProblemResponse(err Error, instance string) *Problem {
+	return &Problem{
+		Type:     problemTypePrefix + err.Code,
+		Title:    http.StatusText(err.HttpStatus),
+		Status:   err.HttpStatus,
+		Detail:   err.Message,
+		Instance: instance,
+		Code:     err.Code,
+		Errors:   err.Details,
+	}
+}
@@ -0,0 +1,54 @@
+// Package geo resolves the country of IP addresses from a local MaxMind database file
+// (GeoLite2 or GeoIP2 Country or City), without any network access
+package geo
+
+import (
+	"net"
+	"strings"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// Locator looks up IP addresses in an opened MaxMind database
+type Locator struct {
+	reader *maxminddb.Reader
+}
+
+// record holds the fields of a database entry used by the lookups
+type record struct {
+	Country struct {
+		IsoCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+}
+
+// Open opens the MaxMind database at path
+func Open(path string) (*Locator, error) {
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Locator{reader: reader}, nil
+}
+
+// Country returns the ISO 3166-1 alpha-2 code of the country of ip, empty when ip is not
+// a valid address or is not in the database
+func (l *Locator) Country(ip string) (string, error) {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return "", nil
+	}
+	if addr.To4() == nil && l.reader.Metadata.IPVersion == 4 {
+		return "", nil
+	}
+
+	var res record
+	if err := l.reader.Lookup(addr, &res); err != nil {
+		return "", err
+	}
+	return strings.ToUpper(res.Country.IsoCode), nil
+}
+
+// Close releases the database
+func (l *Locator) Close() error {
+	return l.reader.Close()
+}
@@ -0,0 +1,136 @@
+package geo
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocator_Country(t *testing.T) {
+	path := writeDatabase(t, map[string]string{
+		"81.0.0.0/8":    "IT",
+		"2.16.0.0/13":   "FR",
+		"192.0.2.16/28": "us",
+	})
+
+	l, err := Open(path)
+	require.Nil(t, err)
+	defer l.Close()
+
+	for ip, expected := range map[string]string{
+		"81.2.69.142": "IT",
+		"2.17.1.1":    "FR",
+		"192.0.2.20":  "US",
+		"192.0.2.1":   "",
+		"10.0.0.1":    "",
+		"2001:db8::1": "",
+		"not an ip":   "",
+	} {
+		res, err := l.Country(ip)
+		require.Nil(t, err, ip)
+		require.Equal(t, expected, res, ip)
+	}
+}
+
+func TestOpen_Missing(t *testing.T) {
+	_, err := Open(filepath.Join(t.TempDir(), "missing.mmdb"))
+	require.NotNil(t, err)
+}
+
+// writeDatabase writes an IPv4 MaxMind database mapping the networks to their country
+func writeDatabase(t *testing.T, networks map[string]string) string {
+	type node struct {
+		children [2]int    // index of the child nodes, 0 for none
+		leaves   [2]string // country of the records pointing to the data section
+	}
+	nodes := []*node{{}}
+
+	for cidr, country := range networks {
+		_, network, err := net.ParseCIDR(cidr)
+		require.Nil(t, err)
+		ones, _ := network.Mask.Size()
+		ip := network.IP.To4()
+
+		n := nodes[0]
+		for i := 0; i < ones; i++ {
+			bit := ip[i/8] >> (7 - uint(i%8)) & 1
+			if i == ones-1 {
+				n.leaves[bit] = country
+				break
+			}
+			if n.children[bit] == 0 {
+				nodes = append(nodes, &node{})
+				n.children[bit] = len(nodes) - 1
+			}
+			n = nodes[n.children[bit]]
+		}
+	}
+
+	// data section, a {"country": {"iso_code": ...}} map by country
+	var data []byte
+	offsets := map[string]int{}
+	for _, country := range networks {
+		if _, ok := offsets[country]; ok {
+			continue
+		}
+		offsets[country] = len(data)
+		data = append(data, mapControl(1)...)
+		data = append(data, encodeString("country")...)
+		data = append(data, mapControl(1)...)
+		data = append(data, encodeString("iso_code")...)
+		data = append(data, encodeString(country)...)
+	}
+
+	// search tree, with 24 bits records
+	nodeCount := len(nodes)
+	var tree []byte
+	for _, n := range nodes {
+		for bit := 0; bit < 2; bit++ {
+			record := nodeCount
+			switch {
+			case n.leaves[bit] != "":
+				record = nodeCount + 16 + offsets[n.leaves[bit]]
+			case n.children[bit] != 0:
+				record = n.children[bit]
+			}
+			tree = append(tree, byte(record>>16), byte(record>>8), byte(record))
+		}
+	}
+
+	db := append(tree, make([]byte, 16)...)
+	db = append(db, data...)
+	db = append(db, "\xAB\xCD\xEFMaxMind.com"...)
+	db = append(db, mapControl(5)...)
+	db = append(db, encodeString("node_count")...)
+	db = append(db, encodeUint32(uint32(nodeCount))...)
+	db = append(db, encodeString("record_size")...)
+	db = append(db, encodeUint32(24)...)
+	db = append(db, encodeString("ip_version")...)
+	db = append(db, encodeUint32(4)...)
+	db = append(db, encodeString("binary_format_major_version")...)
+	db = append(db, encodeUint32(2)...)
+	db = append(db, encodeString("database_type")...)
+	db = append(db, encodeString("Test-Country")...)
+
+	path := filepath.Join(t.TempDir(), "test.mmdb")
+	require.Nil(t, ioutil.WriteFile(path, db, 0600))
+	return path
+}
+
+func mapControl(size int) []byte {
+	return []byte{7<<5 | byte(size)}
+}
+
+func encodeString(s string) []byte {
+	return append([]byte{2<<5 | byte(len(s))}, s...)
+}
+
+func encodeUint32(v uint32) []byte {
+	b := []byte{6<<5 | 4, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(b[1:], v)
+	return b
+}
@@ -0,0 +1,257 @@
+// Package health checks periodically that the destinations of the short urls respond, recording
+// the outcome on the short urls to find the broken ones
+package health
+
+import (
+	"context"
+	"net/http"
+	neturl "net/url"
+	"sync"
+	"time"
+
+	"github.com/gsiragusa/short-to-me/netguard"
+	"github.com/gsiragusa/short-to-me/shortener"
+	"github.com/sirupsen/logrus"
+)
+
+// userAgent identifies the requests of the checker to the destinations
+const userAgent = "short-to-me-health-check/1.0"
+
+// Checker requests the destinations of the short urls, a few at a time and one at a time
+// by host, waiting between the requests to the same host
+type Checker struct {
+	le          *logrus.Logger
+	store       Store
+	client      *http.Client
+	concurrency int
+	hostDelay   time.Duration
+
+	allowPrivate bool
+
+	mu    sync.Mutex
+	hosts map[string]*host
+}
+
+// host serializes the requests to a host, the holder of slot owns last
+type host struct {
+	slot chan struct{}
+	last time.Time
+}
+
+// link is a short url to check
+type link struct {
+	id           string
+	destinations []shortener.Destination
+}
+
+// Option configures a Checker
+type Option func(*Checker)
+
+// AllowPrivate lets the checker request the destinations on private and local addresses
+func AllowPrivate() Option {
+	return func(c *Checker) {
+		c.allowPrivate = true
+	}
+}
+
+// NewChecker returns a Checker checking concurrency short urls at a time, waiting hostDelay
+// between the requests to the same host. Every request is bounded by timeout, and only public
+// addresses are requested unless AllowPrivate is set
+func NewChecker(le *logrus.Logger, store Store, concurrency int, hostDelay, timeout time.Duration, opts ...Option) *Checker {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	c := &Checker{
+		le:          le,
+		store:       store,
+		concurrency: concurrency,
+		hostDelay:   hostDelay,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	c.client = netguard.NewClient(timeout, c.allowPrivate)
+	return c
+}
+
+// Run checks the destinations of every short url once, returning the number of short urls
+// checked and broken. It stops early when ctx is done
+func (c *Checker) Run(ctx context.Context) (int, int, error) {
+	// the short urls are read beforehand, the checks can outlive a cursor
+	var links []link
+	err := c.store.ForEachUrl(ctx, func(u *shortener.ModelShorten) error {
+		links = append(links, link{id: u.Id, destinations: u.Destinations()})
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	c.mu.Lock()
+	c.hosts = map[string]*host{}
+	c.mu.Unlock()
+
+	var (
+		wg              sync.WaitGroup
+		mu              sync.Mutex
+		checked, broken int
+	)
+	jobs := make(chan link)
+	for i := 0; i < c.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for l := range jobs {
+				health := c.check(ctx, l.destinations)
+				if ctx.Err() != nil {
+					continue
+				}
+				if err := c.store.UpdateHealth(ctx, l.id, health); err != nil {
+					c.le.WithError(err).WithField("id", l.id).Error("unable to record the health")
+					continue
+				}
+				mu.Lock()
+				checked++
+				if health.Broken {
+					broken++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+send:
+	for _, l := range links {
+		select {
+		case jobs <- l:
+		case <-ctx.Done():
+			break send
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return checked, broken, ctx.Err()
+}
+
+// Watch checks the short urls now and then every interval until ctx is done
+func (c *Checker) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		started := time.Now()
+		checked, broken, err := c.Run(ctx)
+		le := c.le.WithField("duration", time.Since(started).String())
+		if err != nil {
+			le.WithError(err).Error("health check interrupted")
+		} else {
+			le.Infof("health checked: %d short urls, %d broken", checked, broken)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// check requests the destinations of a short url
+func (c *Checker) check(ctx context.Context, destinations []shortener.Destination) shortener.Health {
+	res := shortener.Health{CheckedAt: time.Now().UTC()}
+	for _, d := range destinations {
+		h := c.probe(ctx, d)
+		res.Broken = res.Broken || h.Broken
+		res.Destinations = append(res.Destinations, h)
+	}
+	return res
+}
+
+// probe requests a destination with HEAD, falling back to GET for the servers refusing it
+func (c *Checker) probe(ctx context.Context, d shortener.Destination) shortener.DestinationHealth {
+	res := shortener.DestinationHealth{Destination: d}
+	u, err := neturl.Parse(d.Url)
+	if err != nil {
+		res.Error = err.Error()
+		res.Broken = true
+		return res
+	}
+
+	release, err := c.acquire(ctx, u.Host)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	defer release()
+
+	started := time.Now()
+	status, err := c.request(ctx, http.MethodHead, d.Url)
+	if err == nil && (status == http.StatusMethodNotAllowed || status == http.StatusNotImplemented) {
+		status, err = c.request(ctx, http.MethodGet, d.Url)
+	}
+	res.LatencyMs = time.Since(started).Milliseconds()
+	if err != nil {
+		res.Error = err.Error()
+		res.Broken = true
+		return res
+	}
+	res.Status = status
+	res.Broken = broken(status)
+	return res
+}
+
+// request sends a request to url, following the redirects, and returns the status code of the response
+func (c *Checker) request(ctx context.Context, method, url string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	res, err := c.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	_ = res.Body.Close()
+	return res.StatusCode, nil
+}
+
+// acquire waits for the turn of the checker to request name, returning the function releasing it
+func (c *Checker) acquire(ctx context.Context, name string) (func(), error) {
+	c.mu.Lock()
+	h, ok := c.hosts[name]
+	if !ok {
+		h = &host{slot: make(chan struct{}, 1)}
+		c.hosts[name] = h
+	}
+	c.mu.Unlock()
+
+	select {
+	case h.slot <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if wait := time.Until(h.last.Add(c.hostDelay)); wait > 0 && !h.last.IsZero() {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			<-h.slot
+			return nil, ctx.Err()
+		}
+	}
+	return func() {
+		h.last = time.Now()
+		<-h.slot
+	}, nil
+}
+
+// broken reports whether a destination answering with status is broken: the servers refusing
+// the checker without credentials or for too many requests are not
+func broken(status int) bool {
+	switch status {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusTooManyRequests:
+		return false
+	}
+	return status >= http.StatusBadRequest
+}
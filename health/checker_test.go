@@ -0,0 +1,221 @@
+package health
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/gsiragusa/short-to-me/shortener"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func MakeTestChecker(t *testing.T, concurrency int, hostDelay time.Duration, opts ...Option) (*Checker, *MockStore) {
+	log := logrus.New()
+	log.Out = ioutil.Discard // silent logger
+
+	ctrl := gomock.NewController(t)
+	t.Cleanup(ctrl.Finish)
+	store := NewMockStore(ctrl)
+
+	// the stand-ins of the destinations listen on the loopback
+	if len(opts) == 0 {
+		opts = []Option{AllowPrivate()}
+	}
+	return NewChecker(log, store, concurrency, hostDelay, time.Second, opts...), store
+}
+
+// standIn serves the destinations of the tests, recording the requests
+type standIn struct {
+	mu       sync.Mutex
+	requests []string
+	times    []time.Time
+}
+
+func (s *standIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+	s.times = append(s.times, time.Now())
+	s.mu.Unlock()
+
+	switch r.URL.Path {
+	case "/ok":
+		w.WriteHeader(http.StatusOK)
+	case "/moved":
+		http.Redirect(w, r, "/ok", http.StatusMovedPermanently)
+	case "/get-only":
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	case "/forbidden":
+		w.WriteHeader(http.StatusForbidden)
+	case "/error":
+		w.WriteHeader(http.StatusBadGateway)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func forEach(links ...*shortener.ModelShorten) func(context.Context, func(*shortener.ModelShorten) error) error {
+	return func(_ context.Context, fn func(*shortener.ModelShorten) error) error {
+		for _, l := range links {
+			if err := fn(l); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func TestChecker_Run(t *testing.T) {
+	stand := &standIn{}
+	server := httptest.NewServer(stand)
+	defer server.Close()
+
+	checker, store := MakeTestChecker(t, 2, 0)
+	ctx := context.Background()
+
+	store.EXPECT().ForEachUrl(ctx, gomock.Any()).DoAndReturn(forEach(
+		&shortener.ModelShorten{Id: "ok", Url: server.URL + "/ok", PlatformUrls: map[string]string{
+			shortener.PlatformIos: server.URL + "/get-only",
+		}},
+		&shortener.ModelShorten{Id: "moved", Url: server.URL + "/moved"},
+		&shortener.ModelShorten{Id: "forbidden", Url: server.URL + "/forbidden"},
+		&shortener.ModelShorten{Id: "missing", Url: server.URL + "/ok", Variants: []shortener.Variant{
+			{Name: "b", Url: server.URL + "/missing", Weight: 1},
+		}},
+		&shortener.ModelShorten{Id: "error", Url: server.URL + "/error"},
+		&shortener.ModelShorten{Id: "unreachable", Url: "http://127.0.0.1:1/"},
+	))
+
+	var mu sync.Mutex
+	results := map[string]shortener.Health{}
+	store.EXPECT().UpdateHealth(ctx, gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, id string, health shortener.Health) error {
+			mu.Lock()
+			defer mu.Unlock()
+			results[id] = health
+			return nil
+		}).Times(6)
+
+	checked, broken, err := checker.Run(ctx)
+	require.Nil(t, err)
+	require.Equal(t, 6, checked)
+	require.Equal(t, 3, broken)
+
+	ok := results["ok"]
+	require.False(t, ok.Broken)
+	require.False(t, ok.CheckedAt.IsZero())
+	require.Len(t, ok.Destinations, 2)
+	require.Equal(t, shortener.Destination{Field: "url", Url: server.URL + "/ok"}, ok.Destinations[0].Destination)
+	require.Equal(t, http.StatusOK, ok.Destinations[0].Status)
+	require.Equal(t, "platform_urls.ios", ok.Destinations[1].Field)
+	require.Equal(t, http.StatusOK, ok.Destinations[1].Status)
+
+	require.False(t, results["moved"].Broken)
+	require.Equal(t, http.StatusOK, results["moved"].Destinations[0].Status)
+	require.False(t, results["forbidden"].Broken)
+
+	missing := results["missing"]
+	require.True(t, missing.Broken)
+	require.False(t, missing.Destinations[0].Broken)
+	require.True(t, missing.Destinations[1].Broken)
+	require.Equal(t, http.StatusNotFound, missing.Destinations[1].Status)
+
+	require.True(t, results["error"].Broken)
+	require.True(t, results["unreachable"].Broken)
+	require.Zero(t, results["unreachable"].Destinations[0].Status)
+	require.NotEmpty(t, results["unreachable"].Destinations[0].Error)
+
+	// HEAD first, GET when it is not allowed
+	require.Contains(t, stand.requests, "HEAD /get-only")
+	require.Contains(t, stand.requests, "GET /get-only")
+}
+
+func TestChecker_RunHostDelay(t *testing.T) {
+	stand := &standIn{}
+	server := httptest.NewServer(stand)
+	defer server.Close()
+
+	delay := 50 * time.Millisecond
+	checker, store := MakeTestChecker(t, 3, delay)
+	ctx := context.Background()
+
+	store.EXPECT().ForEachUrl(ctx, gomock.Any()).DoAndReturn(forEach(
+		&shortener.ModelShorten{Id: "a", Url: server.URL + "/ok"},
+		&shortener.ModelShorten{Id: "b", Url: server.URL + "/ok"},
+		&shortener.ModelShorten{Id: "c", Url: server.URL + "/ok"},
+	))
+	store.EXPECT().UpdateHealth(ctx, gomock.Any(), gomock.Any()).Times(3)
+
+	_, _, err := checker.Run(ctx)
+	require.Nil(t, err)
+
+	// the short urls are checked concurrently, but the requests to the same host are spaced
+	require.Len(t, stand.times, 3)
+	for i := 1; i < len(stand.times); i++ {
+		require.True(t, stand.times[i].Sub(stand.times[i-1]) >= delay)
+	}
+}
+
+func TestChecker_RunPrivate(t *testing.T) {
+	stand := &standIn{}
+	server := httptest.NewServer(stand)
+	defer server.Close()
+
+	log := logrus.New()
+	log.Out = ioutil.Discard // silent logger
+	ctrl := gomock.NewController(t)
+	t.Cleanup(ctrl.Finish)
+	store := NewMockStore(ctrl)
+	checker := NewChecker(log, store, 1, 0, time.Second)
+	ctx := context.Background()
+
+	store.EXPECT().ForEachUrl(ctx, gomock.Any()).DoAndReturn(forEach(
+		&shortener.ModelShorten{Id: "private", Url: server.URL + "/ok"},
+	))
+	var health shortener.Health
+	store.EXPECT().UpdateHealth(ctx, "private", gomock.Any()).DoAndReturn(
+		func(_ context.Context, _ string, h shortener.Health) error {
+			health = h
+			return nil
+		})
+
+	_, broken, err := checker.Run(ctx)
+	require.Nil(t, err)
+	require.Equal(t, 1, broken)
+	require.NotEmpty(t, health.Destinations[0].Error)
+
+	// the destination on the loopback was never requested
+	require.Empty(t, stand.requests)
+}
+
+func TestChecker_RunCancelled(t *testing.T) {
+	checker, store := MakeTestChecker(t, 1, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	store.EXPECT().ForEachUrl(ctx, gomock.Any()).DoAndReturn(forEach(
+		&shortener.ModelShorten{Id: "a", Url: "http://www.test.com"},
+	))
+
+	checked, _, err := checker.Run(ctx)
+	require.Equal(t, context.Canceled, err)
+	require.Zero(t, checked)
+}
+
+func TestBroken(t *testing.T) {
+	require.False(t, broken(http.StatusOK))
+	require.False(t, broken(http.StatusNoContent))
+	require.False(t, broken(http.StatusTooManyRequests))
+	require.True(t, broken(http.StatusNotFound))
+	require.True(t, broken(http.StatusGone))
+	require.True(t, broken(http.StatusServiceUnavailable))
+}
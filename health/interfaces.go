@@ -0,0 +1,13 @@
+package health
+
+import (
+	"context"
+
+	"github.com/gsiragusa/short-to-me/shortener"
+)
+
+//go:generate mockgen -source=interfaces.go -destination=interfaces_mock.go -package=health
+type Store interface {
+	ForEachUrl(ctx context.Context, fn func(*shortener.ModelShorten) error) error
+	UpdateHealth(ctx context.Context, id string, health shortener.Health) error
+}
@@ -0,0 +1,17 @@
+package migration
+
+import (
+	"context"
+	"time"
+)
+
+//go:generate mockgen -source=interfaces.go -destination=interfaces_mock.go -package=migration
+type Store interface {
+	AppliedMigrations(ctx context.Context) ([]Record, error)
+	MarkApplied(ctx context.Context, record Record) error
+	// Lock takes the lock of the migrations for owner until ttl elapses, ErrLocked when another
+	// owner holds it
+	Lock(ctx context.Context, owner string, ttl time.Duration) error
+	// Unlock releases the lock of the migrations held by owner
+	Unlock(ctx context.Context, owner string) error
+}
@@ -0,0 +1,135 @@
+package migration
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// lockTtl bounds how long the lock of an instance that stopped while migrating blocks the others.
+// It must exceed the duration of the migrations
+const lockTtl = 15 * time.Minute
+
+type Migrator struct {
+	le         *logrus.Logger
+	store      Store
+	migrations []Migration
+	// retry is the wait between the attempts to take the lock held by another instance
+	retry time.Duration
+}
+
+func NewMigrator(le *logrus.Logger, store Store, migrations ...Migration) *Migrator {
+	sorted := make([]Migration, len(migrations))
+	copy(sorted, migrations)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Version < sorted[j].Version
+	})
+	return &Migrator{
+		le:         le,
+		store:      store,
+		migrations: sorted,
+		retry:      time.Second,
+	}
+}
+
+// Pending returns the migrations that were not applied yet, in version order
+func (m *Migrator) Pending(ctx context.Context) ([]Migration, error) {
+	for i := 1; i < len(m.migrations); i++ {
+		if m.migrations[i].Version == m.migrations[i-1].Version {
+			return nil, fmt.Errorf("duplicate migration version %d", m.migrations[i].Version)
+		}
+	}
+
+	applied, err := m.store.AppliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	done := make(map[int]bool, len(applied))
+	for _, r := range applied {
+		done[r.Version] = true
+	}
+
+	var pending []Migration
+	for _, mig := range m.migrations {
+		if !done[mig.Version] {
+			pending = append(pending, mig)
+		}
+	}
+	return pending, nil
+}
+
+// Run applies the pending migrations in version order, stopping at the first failure. The
+// instances starting together apply them one at a time: the others wait for the lock, then find
+// them applied
+func (m *Migrator) Run(ctx context.Context) (int, error) {
+	owner := newOwner()
+	if err := m.lock(ctx, owner); err != nil {
+		return 0, err
+	}
+	defer func() {
+		// released with a fresh context, the lock expires anyway
+		if err := m.store.Unlock(context.Background(), owner); err != nil {
+			m.le.WithError(err).Error("unable to release the lock of the migrations")
+		}
+	}()
+
+	pending, err := m.Pending(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, mig := range pending {
+		le := m.le.WithField("version", mig.Version)
+		le.Infof("applying migration: %s", mig.Description)
+		if err := mig.Up(ctx); err != nil {
+			le.WithError(err).Error("migration failed")
+			return i, fmt.Errorf("migration %d: %w", mig.Version, err)
+		}
+
+		record := Record{
+			Version:     mig.Version,
+			Description: mig.Description,
+			AppliedAt:   time.Now().UTC(),
+		}
+		if err := m.store.MarkApplied(ctx, record); err != nil {
+			le.WithError(err).Error("unable to mark migration as applied")
+			return i, fmt.Errorf("migration %d: %w", mig.Version, err)
+		}
+	}
+
+	m.le.Infof("%d migrations applied", len(pending))
+	return len(pending), nil
+}
+
+// lock waits for the lock of the migrations, until ctx is done
+func (m *Migrator) lock(ctx context.Context, owner string) error {
+	for waiting := false; ; waiting = true {
+		err := m.store.Lock(ctx, owner, lockTtl)
+		if err != ErrLocked {
+			return err
+		}
+		if !waiting {
+			m.le.Info("waiting for the migrations of another instance")
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(m.retry):
+		}
+	}
+}
+
+// newOwner returns a random id of the lock of the migrations
+func newOwner() string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b)
+}
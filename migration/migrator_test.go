@@ -0,0 +1,121 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func MakeTestMigrator(t *testing.T, migrations ...Migration) (*Migrator, *MockStore) {
+	log := logrus.New()
+	log.Out = ioutil.Discard // silent logger
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	store := NewMockStore(ctrl)
+
+	return NewMigrator(log, store, migrations...), store
+}
+
+func TestMigrator_Run(t *testing.T) {
+	ctx := context.Background()
+	var ran []int
+	up := func(version int) func(context.Context) error {
+		return func(context.Context) error {
+			ran = append(ran, version)
+			return nil
+		}
+	}
+
+	migrator, store := MakeTestMigrator(t,
+		Migration{Version: 3, Description: "third", Up: up(3)},
+		Migration{Version: 1, Description: "first", Up: up(1)},
+		Migration{Version: 2, Description: "second", Up: up(2)},
+	)
+
+	store.EXPECT().Lock(ctx, gomock.Any(), lockTtl)
+	store.EXPECT().AppliedMigrations(ctx).Return([]Record{{Version: 1}}, nil)
+	store.EXPECT().MarkApplied(ctx, gomock.Any()).Times(2)
+	store.EXPECT().Unlock(gomock.Any(), gomock.Any())
+
+	applied, err := migrator.Run(ctx)
+	require.Nil(t, err)
+	require.Equal(t, 2, applied)
+	require.Equal(t, []int{2, 3}, ran)
+}
+
+func TestMigrator_RunStopsOnFailure(t *testing.T) {
+	ctx := context.Background()
+	migrator, store := MakeTestMigrator(t,
+		Migration{Version: 1, Up: func(context.Context) error { return errors.New("boom") }},
+		Migration{Version: 2, Up: func(context.Context) error {
+			t.Fatal("migration after a failure must not run")
+			return nil
+		}},
+	)
+
+	store.EXPECT().Lock(ctx, gomock.Any(), lockTtl)
+	store.EXPECT().AppliedMigrations(ctx).Return(nil, nil)
+	store.EXPECT().Unlock(gomock.Any(), gomock.Any())
+
+	applied, err := migrator.Run(ctx)
+	require.NotNil(t, err)
+	require.Equal(t, 0, applied)
+}
+
+func TestMigrator_RunLocked(t *testing.T) {
+	ctx := context.Background()
+	migrator, store := MakeTestMigrator(t, Migration{Version: 1, Up: func(context.Context) error {
+		t.Fatal("migration applied by another instance must not run")
+		return nil
+	}})
+	migrator.retry = time.Millisecond
+
+	// the other instance applies the migration, then releases the lock
+	var owner string
+	gomock.InOrder(
+		store.EXPECT().Lock(ctx, gomock.Any(), lockTtl).Return(ErrLocked).Times(2),
+		store.EXPECT().Lock(ctx, gomock.Any(), lockTtl).Do(func(_ context.Context, o string, _ time.Duration) {
+			owner = o
+		}),
+		store.EXPECT().AppliedMigrations(ctx).Return([]Record{{Version: 1}}, nil),
+		store.EXPECT().Unlock(gomock.Any(), gomock.Any()).Do(func(_ context.Context, o string) {
+			require.Equal(t, owner, o)
+		}),
+	)
+
+	applied, err := migrator.Run(ctx)
+	require.Nil(t, err)
+	require.Equal(t, 0, applied)
+}
+
+func TestMigrator_RunLockedCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	migrator, store := MakeTestMigrator(t, Migration{Version: 1, Up: func(context.Context) error { return nil }})
+	migrator.retry = time.Hour
+
+	store.EXPECT().Lock(ctx, gomock.Any(), lockTtl).Do(func(context.Context, string, time.Duration) {
+		cancel()
+	}).Return(ErrLocked)
+
+	_, err := migrator.Run(ctx)
+	require.Equal(t, context.Canceled, err)
+}
+
+func TestMigrator_DuplicateVersion(t *testing.T) {
+	ctx := context.Background()
+	noop := func(context.Context) error { return nil }
+	migrator, _ := MakeTestMigrator(t,
+		Migration{Version: 1, Up: noop},
+		Migration{Version: 1, Up: noop},
+	)
+
+	_, err := migrator.Pending(ctx)
+	require.NotNil(t, err)
+}
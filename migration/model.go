@@ -0,0 +1,26 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrLocked is returned by the stores when another instance is applying the migrations
+var ErrLocked = errors.New("the migrations are locked by another instance")
+
+// Migration is a forward only change to the stored data, identified by its version.
+// Migrations must be idempotent: a migration interrupted before being marked as
+// applied will run again on the next attempt
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(ctx context.Context) error
+}
+
+// Record is the trace left in the store by an applied migration
+type Record struct {
+	Version     int       `bson:"_id"`
+	Description string    `bson:"description"`
+	AppliedAt   time.Time `bson:"applied_at"`
+}
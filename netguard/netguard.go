@@ -0,0 +1,99 @@
+// Package netguard builds the http clients requesting the urls chosen by the users: the
+// destinations of the short urls and the webhook endpoints. They never connect to the private,
+// local and special purpose addresses, unless they are allowed to
+package netguard
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// reservedNetworks are the private, local and special purpose ranges the clients never connect to
+var reservedNetworks = parseNetworks(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.0.2.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"198.51.100.0/24",
+	"203.0.113.0/24",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"64:ff9b::/96",
+	"2001:db8::/32",
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+)
+
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	res := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		res = append(res, network)
+	}
+	return res
+}
+
+// reserved reports whether ip belongs to a private, local or special purpose range
+func reserved(ip net.IP) bool {
+	for _, network := range reservedNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// guard refuses the connections to the reserved addresses. It runs once the host is resolved,
+// for every address tried and every redirect, so that no name can point the clients to them
+func guard(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("invalid address %s", host)
+	}
+	if reserved(ip) {
+		return fmt.Errorf("address %s is not public", ip)
+	}
+	return nil
+}
+
+// NewTransport returns a transport connecting only to the public addresses unless allowPrivate is
+// set. Dialing and the tls handshakes are bounded by timeout
+func NewTransport(timeout time.Duration, allowPrivate bool) *http.Transport {
+	dialer := &net.Dialer{Timeout: timeout}
+	if !allowPrivate {
+		dialer.Control = guard
+	}
+	return &http.Transport{
+		// a proxy would connect on behalf of the client, out of reach of the guard
+		Proxy:               nil,
+		DialContext:         dialer.DialContext,
+		TLSHandshakeTimeout: timeout,
+	}
+}
+
+// NewClient returns a client of NewTransport, every request of which, body included, is bounded by timeout
+func NewClient(timeout time.Duration, allowPrivate bool) *http.Client {
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: NewTransport(timeout, allowPrivate),
+	}
+}
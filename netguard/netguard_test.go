@@ -0,0 +1,47 @@
+package netguard
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReserved(t *testing.T) {
+	for ip, expected := range map[string]bool{
+		"127.0.0.1":        true,
+		"10.1.2.3":         true,
+		"172.20.0.1":       true,
+		"192.168.1.1":      true,
+		"169.254.169.254":  true,
+		"100.64.0.1":       true,
+		"0.0.0.0":          true,
+		"::1":              true,
+		"::ffff:127.0.0.1": true,
+		"fd00::1":          true,
+		"fe80::1":          true,
+		"8.8.8.8":          false,
+		"172.32.0.1":       false,
+		"2606:4700::1111":  false,
+	} {
+		require.Equal(t, expected, reserved(net.ParseIP(ip)), ip)
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	// the local test server is refused unless the private addresses are allowed
+	_, err := NewClient(time.Second, false).Get(srv.URL)
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "is not public")
+
+	res, err := NewClient(time.Second, true).Get(srv.URL)
+	require.Nil(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+}
@@ -0,0 +1,33 @@
+package outbox
+
+import (
+	"context"
+	"time"
+
+	"github.com/gsiragusa/short-to-me/shortener"
+)
+
+//go:generate mockgen -source=interfaces.go -destination=interfaces_mock.go -package=outbox
+type Store interface {
+	// ClaimRecord returns an unpublished record due at now, postponing it by lease so that the other
+	// relays don't publish it at the same time. shortener.ErrNotFound when none is due
+	ClaimRecord(ctx context.Context, now time.Time, lease time.Duration) (*Record, error)
+	UpdateRecord(ctx context.Context, record Record) error
+	// PurgePublished removes the records published before, returning their number
+	PurgePublished(ctx context.Context, before time.Time) (int64, error)
+}
+
+// Sink publishes the events relayed from the outbox. The events can be published more than once,
+// the consumers recognize them by id
+type Sink interface {
+	// Name identifies the sink in the records of the events it published
+	Name() string
+	Publish(ctx context.Context, event shortener.Event) error
+}
+
+// Broker is the client of a message broker
+type Broker interface {
+	// Publish sends body on topic, key orders the messages of a short url on the brokers partitioning
+	// the topics
+	Publish(ctx context.Context, topic, key string, body []byte) error
+}
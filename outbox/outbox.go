@@ -0,0 +1,44 @@
+// Package outbox relays the events of the short urls to the systems reacting to them. The events
+// are written to the outbox of the store in the transactions of the mutations of the short urls,
+// the relay then publishes them to sinks: an in-process channel, a file, a message broker or the
+// webhooks. Every event is published at least once to every sink, in order unless it is retried
+package outbox
+
+import (
+	"time"
+
+	"github.com/gsiragusa/short-to-me/shortener"
+)
+
+// Record is an event kept in the outbox until every sink published it
+type Record struct {
+	Id    string          `json:"id" bson:"_id"`
+	Event shortener.Event `json:"event" bson:"event"`
+	// Published are the names of the sinks that published the event
+	Published     []string   `json:"published,omitempty" bson:"published,omitempty"`
+	PublishedAt   *time.Time `json:"published_at,omitempty" bson:"published_at,omitempty"`
+	Attempts      int        `json:"attempts" bson:"attempts"`
+	NextAttemptAt time.Time  `json:"next_attempt_at" bson:"next_attempt_at"`
+	LastError     string     `json:"last_error,omitempty" bson:"last_error,omitempty"`
+	CreatedAt     time.Time  `json:"created_at" bson:"created_at"`
+}
+
+// NewRecord returns the record of event, due now
+func NewRecord(event shortener.Event) Record {
+	return Record{
+		Id:            event.Id,
+		Event:         event,
+		NextAttemptAt: event.Time,
+		CreatedAt:     event.Time,
+	}
+}
+
+// published reports whether the sink name published the event of the record
+func (r *Record) published(name string) bool {
+	for _, p := range r.Published {
+		if p == name {
+			return true
+		}
+	}
+	return false
+}
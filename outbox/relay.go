@@ -0,0 +1,130 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gsiragusa/short-to-me/shortener"
+	"github.com/sirupsen/logrus"
+)
+
+// maxBackoff bounds the wait between two attempts to publish an event
+const maxBackoff = time.Hour
+
+// lease is the time a relay has to publish a claimed event before the other relays can claim it
+const lease = time.Minute
+
+// Relay publishes the events of the outbox to the sinks, retrying the failed ones
+type Relay struct {
+	le        *logrus.Logger
+	store     Store
+	sinks     []Sink
+	backoff   time.Duration
+	retention time.Duration
+}
+
+// NewRelay returns a Relay publishing to sinks, waiting backoff after the first failure of an event
+// and twice as long after each of the next ones. The published events are kept for retention
+func NewRelay(le *logrus.Logger, store Store, backoff, retention time.Duration, sinks ...Sink) *Relay {
+	return &Relay{
+		le:        le,
+		store:     store,
+		sinks:     sinks,
+		backoff:   backoff,
+		retention: retention,
+	}
+}
+
+// Run publishes the events that are due, returning the number of events published and failed.
+// It stops early when ctx is done
+func (r *Relay) Run(ctx context.Context) (int, int, error) {
+	var published, failed int
+	for ctx.Err() == nil {
+		record, err := r.store.ClaimRecord(ctx, time.Now().UTC(), lease)
+		if err == shortener.ErrNotFound {
+			return published, failed, nil
+		}
+		if err != nil {
+			return published, failed, err
+		}
+
+		r.publish(ctx, record)
+		if err := r.store.UpdateRecord(ctx, *record); err != nil {
+			return published, failed, err
+		}
+		if record.PublishedAt != nil {
+			published++
+		} else {
+			failed++
+		}
+	}
+	return published, failed, ctx.Err()
+}
+
+// Watch publishes the due events now and then every interval until ctx is done, and removes the
+// events published before the retention
+func (r *Relay) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		published, failed, err := r.Run(ctx)
+		if err != nil {
+			r.le.WithError(err).Error("outbox relay interrupted")
+		} else if published+failed > 0 {
+			r.le.Infof("outbox relayed: %d events published, %d failed", published, failed)
+		}
+		if r.retention > 0 {
+			if _, err := r.store.PurgePublished(ctx, time.Now().UTC().Add(-r.retention)); err != nil {
+				r.le.WithError(err).Error("unable to purge the outbox")
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// publish sends the event of record to the sinks that didn't publish it yet, recording the outcome
+// and scheduling the next attempt
+func (r *Relay) publish(ctx context.Context, record *Record) {
+	le := r.le.WithField("event", record.Id).WithField("type", record.Event.Type)
+	var failures []string
+	for _, sink := range r.sinks {
+		if record.published(sink.Name()) {
+			continue
+		}
+		if err := sink.Publish(ctx, record.Event); err != nil {
+			le.WithError(err).WithField("sink", sink.Name()).Error("unable to publish the event")
+			failures = append(failures, fmt.Sprintf("%s: %v", sink.Name(), err))
+			continue
+		}
+		record.Published = append(record.Published, sink.Name())
+	}
+
+	now := time.Now().UTC()
+	if len(failures) == 0 {
+		record.PublishedAt = &now
+		record.LastError = ""
+		return
+	}
+	record.Attempts++
+	record.LastError = strings.Join(failures, "; ")
+	record.NextAttemptAt = now.Add(r.wait(record.Attempts))
+}
+
+// wait returns the backoff after the attempts of an event
+func (r *Relay) wait(attempts int) time.Duration {
+	wait := r.backoff
+	for i := 1; i < attempts && wait < maxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > maxBackoff {
+		wait = maxBackoff
+	}
+	return wait
+}
@@ -0,0 +1,128 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/gsiragusa/short-to-me/shortener"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func MakeTestRelay(t *testing.T, sinks ...Sink) (*Relay, *MockStore) {
+	log := logrus.New()
+	log.Out = ioutil.Discard // silent logger
+
+	ctrl := gomock.NewController(t)
+	t.Cleanup(ctrl.Finish)
+	store := NewMockStore(ctrl)
+
+	return NewRelay(log, store, time.Second, time.Hour, sinks...), store
+}
+
+// failing is a sink failing its first publications
+type failing struct {
+	name      string
+	failures  int
+	published []shortener.Event
+}
+
+func (f *failing) Name() string {
+	return f.name
+}
+
+func (f *failing) Publish(_ context.Context, event shortener.Event) error {
+	if f.failures > 0 {
+		f.failures--
+		return errors.New("unavailable")
+	}
+	f.published = append(f.published, event)
+	return nil
+}
+
+func TestRelay_Run(t *testing.T) {
+	first, second := &failing{name: "first"}, &failing{name: "second"}
+	relay, store := MakeTestRelay(t, first, second)
+	ctx := context.Background()
+	event := shortener.Event{Id: "ev1", Type: shortener.EventLinkCreated}
+	record := NewRecord(event)
+
+	gomock.InOrder(
+		store.EXPECT().ClaimRecord(ctx, gomock.Any(), lease).Return(&record, nil),
+		store.EXPECT().UpdateRecord(ctx, gomock.Any()).DoAndReturn(func(_ context.Context, r Record) error {
+			require.Equal(t, []string{"first", "second"}, r.Published)
+			require.NotNil(t, r.PublishedAt)
+			require.Equal(t, 0, r.Attempts)
+			return nil
+		}),
+		store.EXPECT().ClaimRecord(ctx, gomock.Any(), lease).Return(nil, shortener.ErrNotFound),
+	)
+
+	published, failed, err := relay.Run(ctx)
+
+	require.Nil(t, err)
+	require.Equal(t, 1, published)
+	require.Equal(t, 0, failed)
+	require.Equal(t, []shortener.Event{event}, first.published)
+	require.Equal(t, []shortener.Event{event}, second.published)
+}
+
+func TestRelay_RunRetry(t *testing.T) {
+	first, second := &failing{name: "first"}, &failing{name: "second", failures: 1}
+	relay, store := MakeTestRelay(t, first, second)
+	ctx := context.Background()
+	event := shortener.Event{Id: "ev1", Type: shortener.EventLinkDeleted}
+	record := NewRecord(event)
+
+	// the sink failing is retried later, the other one is not published twice
+	var retried Record
+	gomock.InOrder(
+		store.EXPECT().ClaimRecord(ctx, gomock.Any(), lease).Return(&record, nil),
+		store.EXPECT().UpdateRecord(ctx, gomock.Any()).DoAndReturn(func(_ context.Context, r Record) error {
+			require.Equal(t, []string{"first"}, r.Published)
+			require.Nil(t, r.PublishedAt)
+			require.Equal(t, 1, r.Attempts)
+			require.Equal(t, "second: unavailable", r.LastError)
+			require.True(t, r.NextAttemptAt.After(time.Now()))
+			retried = r
+			return nil
+		}),
+		store.EXPECT().ClaimRecord(ctx, gomock.Any(), lease).Return(nil, shortener.ErrNotFound),
+	)
+
+	published, failed, err := relay.Run(ctx)
+
+	require.Nil(t, err)
+	require.Equal(t, 0, published)
+	require.Equal(t, 1, failed)
+
+	gomock.InOrder(
+		store.EXPECT().ClaimRecord(ctx, gomock.Any(), lease).Return(&retried, nil),
+		store.EXPECT().UpdateRecord(ctx, gomock.Any()).DoAndReturn(func(_ context.Context, r Record) error {
+			require.Equal(t, []string{"first", "second"}, r.Published)
+			require.NotNil(t, r.PublishedAt)
+			require.Empty(t, r.LastError)
+			return nil
+		}),
+		store.EXPECT().ClaimRecord(ctx, gomock.Any(), lease).Return(nil, shortener.ErrNotFound),
+	)
+
+	published, _, err = relay.Run(ctx)
+
+	require.Nil(t, err)
+	require.Equal(t, 1, published)
+	require.Len(t, first.published, 1)
+	require.Len(t, second.published, 1)
+}
+
+func TestRelay_Wait(t *testing.T) {
+	relay, _ := MakeTestRelay(t)
+
+	require.Equal(t, time.Second, relay.wait(1))
+	require.Equal(t, 8*time.Second, relay.wait(4))
+	require.Equal(t, maxBackoff, relay.wait(40))
+}
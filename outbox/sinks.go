@@ -0,0 +1,150 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/gsiragusa/short-to-me/shortener"
+)
+
+// Channel publishes the events to an in-process channel, for the consumers running in the server
+type Channel struct {
+	c chan shortener.Event
+}
+
+// NewChannel returns a Channel buffering size events. The events published while it is full are
+// retried by the relay
+func NewChannel(size int) *Channel {
+	return &Channel{c: make(chan shortener.Event, size)}
+}
+
+// Events returns the channel the events are received from
+func (c *Channel) Events() <-chan shortener.Event {
+	return c.c
+}
+
+func (c *Channel) Name() string {
+	return "channel"
+}
+
+func (c *Channel) Publish(ctx context.Context, event shortener.Event) error {
+	select {
+	case c.c <- event:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+		return fmt.Errorf("channel is full")
+	}
+}
+
+// File appends the events to a file, one json object per line
+type File struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// NewFile opens path to append the events, creating it when it doesn't exist
+func NewFile(path string) (*File, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &File{f: f}, nil
+}
+
+func (f *File) Name() string {
+	return "file"
+}
+
+// Publish writes the event and syncs the file, so that a published event is not lost on a crash
+func (f *File) Publish(_ context.Context, event shortener.Event) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, err := f.f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return f.f.Sync()
+}
+
+// Close closes the file
+func (f *File) Close() error {
+	return f.f.Close()
+}
+
+// BrokerSink publishes the events to a message broker, on a topic per type of event
+type BrokerSink struct {
+	broker Broker
+	prefix string
+}
+
+// NewBrokerSink returns a BrokerSink publishing the events of type t on the topic prefix + t, keyed
+// by the id of their short url
+func NewBrokerSink(broker Broker, prefix string) *BrokerSink {
+	return &BrokerSink{broker: broker, prefix: prefix}
+}
+
+func (b *BrokerSink) Name() string {
+	return "broker"
+}
+
+func (b *BrokerSink) Publish(ctx context.Context, event shortener.Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	var key string
+	if event.Link != nil {
+		key = event.Link.Id
+	}
+	return b.broker.Publish(ctx, b.prefix+event.Type, key, body)
+}
+
+// Message is a message published to the LocalBroker
+type Message struct {
+	Topic string
+	Key   string
+	Body  []byte
+}
+
+// LocalBroker is an in-memory Broker standing in for a message broker in the tests and the local
+// deployments. The messages are sent to the subscribers of their topic
+type LocalBroker struct {
+	mu          sync.Mutex
+	subscribers map[string][]chan Message
+}
+
+// NewLocalBroker returns a LocalBroker without subscribers
+func NewLocalBroker() *LocalBroker {
+	return &LocalBroker{subscribers: map[string][]chan Message{}}
+}
+
+// Subscribe returns the channel receiving the messages of topic, buffering size messages. The
+// messages published while it is full are refused, and retried by the relay
+func (b *LocalBroker) Subscribe(topic string, size int) <-chan Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c := make(chan Message, size)
+	b.subscribers[topic] = append(b.subscribers[topic], c)
+	return c
+}
+
+func (b *LocalBroker) Publish(_ context.Context, topic, key string, body []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, c := range b.subscribers[topic] {
+		select {
+		case c <- Message{Topic: topic, Key: key, Body: body}:
+		default:
+			return fmt.Errorf("subscriber of %s is full", topic)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gsiragusa/short-to-me/shortener"
+	"github.com/stretchr/testify/require"
+)
+
+var testEvent = shortener.Event{
+	Id:        "ev1",
+	Type:      shortener.EventLinkCreated,
+	Workspace: "team-a",
+	Link:      &shortener.ModelShorten{Id: "RMAp1Vz", Url: "http://www.test.com"},
+}
+
+func TestChannel(t *testing.T) {
+	sink := NewChannel(1)
+	ctx := context.Background()
+
+	require.Nil(t, sink.Publish(ctx, testEvent))
+	require.NotNil(t, sink.Publish(ctx, testEvent))
+	require.Equal(t, testEvent, <-sink.Events())
+}
+
+func TestFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "outbox")
+	require.Nil(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	path := filepath.Join(dir, "events.jsonl")
+
+	sink, err := NewFile(path)
+	require.Nil(t, err)
+	require.Nil(t, sink.Publish(context.Background(), testEvent))
+	require.Nil(t, sink.Publish(context.Background(), testEvent))
+	require.Nil(t, sink.Close())
+
+	content, err := ioutil.ReadFile(path)
+	require.Nil(t, err)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	require.Len(t, lines, 2)
+	var event shortener.Event
+	require.Nil(t, json.Unmarshal([]byte(lines[0]), &event))
+	require.Equal(t, testEvent, event)
+}
+
+func TestBrokerSink(t *testing.T) {
+	broker := NewLocalBroker()
+	created := broker.Subscribe("links."+shortener.EventLinkCreated, 1)
+	deleted := broker.Subscribe("links."+shortener.EventLinkDeleted, 1)
+	sink := NewBrokerSink(broker, "links.")
+	ctx := context.Background()
+
+	require.Nil(t, sink.Publish(ctx, testEvent))
+	// the subscriber is full, the relay retries the event
+	require.NotNil(t, sink.Publish(ctx, testEvent))
+
+	msg := <-created
+	require.Equal(t, "links.link.created", msg.Topic)
+	require.Equal(t, "RMAp1Vz", msg.Key)
+	var event shortener.Event
+	require.Nil(t, json.Unmarshal(msg.Body, &event))
+	require.Equal(t, testEvent, event)
+	require.Empty(t, deleted)
+}
@@ -0,0 +1,192 @@
+// Package policy decides which destinations can be shortened and redirected to, from domain
+// blocklists and allowlists and a list of known malicious urls, reloaded when their files change
+package policy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	neturl "net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Files are the paths of the policy files, the empty ones are not used
+type Files struct {
+	// Blocklist lists the patterns of the refused hosts
+	Blocklist string
+	// Allowlist lists the patterns of the accepted hosts, when set the other hosts are refused
+	Allowlist string
+	// MaliciousHashes lists the hashes of the known malicious urls, see Hash
+	MaliciousHashes string
+}
+
+// Violation is returned for the refused urls
+type Violation struct {
+	Reason string
+}
+
+func (v *Violation) Error() string {
+	return v.Reason
+}
+
+// rules is a loaded version of the policy files
+type rules struct {
+	blocklist []pattern
+	allowlist []pattern
+	hashes    map[string]bool
+}
+
+// Engine checks the urls against the rules of the policy files
+type Engine struct {
+	le    *logrus.Logger
+	files Files
+
+	mu     sync.RWMutex
+	rules  *rules
+	loaded map[string]time.Time
+}
+
+// NewEngine loads the policy files
+func NewEngine(le *logrus.Logger, files Files) (*Engine, error) {
+	e := &Engine{le: le, files: files}
+	if _, err := e.Reload(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Check returns a Violation when the url is refused
+func (e *Engine) Check(url string) error {
+	u, err := neturl.Parse(url)
+	if err != nil || u.Host == "" {
+		return &Violation{Reason: "the url can't be checked"}
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+
+	e.mu.RLock()
+	r := e.rules
+	e.mu.RUnlock()
+
+	// the listed urls match with any query
+	bare := *u
+	bare.RawQuery = ""
+	bare.ForceQuery = false
+	if r.hashes[hash(canonical(u))] || r.hashes[hash(canonical(&bare))] {
+		return &Violation{Reason: "the url is known to be malicious"}
+	}
+	if matchAny(r.blocklist, host) {
+		return &Violation{Reason: fmt.Sprintf("the domain %s is blocked", host)}
+	}
+	if len(r.allowlist) > 0 && !matchAny(r.allowlist, host) {
+		return &Violation{Reason: fmt.Sprintf("the domain %s is not allowed", host)}
+	}
+	return nil
+}
+
+func matchAny(patterns []pattern, host string) bool {
+	for _, p := range patterns {
+		if p.match(host) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reload loads the policy files again when one of them was modified since the last load,
+// reporting whether the rules changed. The rules are left untouched when a file is invalid.
+// Reload is called by Watch, it must not be called concurrently
+func (e *Engine) Reload() (bool, error) {
+	modified := map[string]time.Time{}
+	changed := e.loaded == nil
+	for _, path := range []string{e.files.Blocklist, e.files.Allowlist, e.files.MaliciousHashes} {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return false, err
+		}
+		modified[path] = info.ModTime()
+		if !info.ModTime().Equal(e.loaded[path]) {
+			changed = true
+		}
+	}
+	if !changed {
+		return false, nil
+	}
+
+	r := &rules{}
+	var err error
+	if r.blocklist, err = loadPatterns(e.files.Blocklist); err != nil {
+		return false, err
+	}
+	if r.allowlist, err = loadPatterns(e.files.Allowlist); err != nil {
+		return false, err
+	}
+	if r.hashes, err = loadHashes(e.files.MaliciousHashes); err != nil {
+		return false, err
+	}
+
+	e.mu.Lock()
+	e.rules = r
+	e.mu.Unlock()
+	e.loaded = modified
+	return true, nil
+}
+
+// Watch reloads the policy files every interval until ctx is done
+func (e *Engine) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			changed, err := e.Reload()
+			if err != nil {
+				e.le.WithError(err).Error("unable to reload the policy, keeping the previous rules")
+			} else if changed {
+				e.le.Info("policy reloaded")
+			}
+		}
+	}
+}
+
+func loadPatterns(path string) ([]pattern, error) {
+	var res []pattern
+	err := load(path, func(r io.Reader) (err error) {
+		res, err = parsePatterns(r)
+		return err
+	})
+	return res, err
+}
+
+func loadHashes(path string) (map[string]bool, error) {
+	res := map[string]bool{}
+	err := load(path, func(r io.Reader) (err error) {
+		res, err = parseHashes(r)
+		return err
+	})
+	return res, err
+}
+
+func load(path string, parse func(io.Reader) error) error {
+	if path == "" {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := parse(f); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
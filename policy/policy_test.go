@@ -0,0 +1,140 @@
+package policy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func makeEngine(t *testing.T, blocklist, allowlist, hashes string) (*Engine, Files) {
+	dir := t.TempDir()
+	files := Files{}
+	for path, content := range map[*string]string{
+		&files.Blocklist:       blocklist,
+		&files.Allowlist:       allowlist,
+		&files.MaliciousHashes: hashes,
+	} {
+		if content == "" {
+			continue
+		}
+		f, err := ioutil.TempFile(dir, "policy")
+		require.Nil(t, err)
+		_ = f.Close()
+		writeFile(t, f.Name(), content)
+		*path = f.Name()
+	}
+
+	log := logrus.New()
+	log.Out = ioutil.Discard // silent logger
+	e, err := NewEngine(log, files)
+	require.Nil(t, err)
+	return e, files
+}
+
+func TestEngine_CheckBlocklist(t *testing.T) {
+	e, _ := makeEngine(t, "# blocked\nevil.com\n\n*.phishing.net\nre:^bad[0-9]+\\.org$\n", "", "")
+
+	for url, blocked := range map[string]bool{
+		"http://evil.com/path":         true,
+		"https://EVIL.com.":            true,
+		"http://www.evil.com":          false,
+		"http://login.phishing.net":    true,
+		"http://a.b.phishing.net/x":    true,
+		"http://phishing.net":          false,
+		"http://bad42.org":             true,
+		"http://bad.org":               false,
+		"http://www.test.com":          false,
+		"http://evil.com.example.com/": false,
+	} {
+		err := e.Check(url)
+		if blocked {
+			require.IsType(t, &Violation{}, err, url)
+		} else {
+			require.Nil(t, err, url)
+		}
+	}
+}
+
+func TestEngine_CheckAllowlist(t *testing.T) {
+	e, _ := makeEngine(t, "blocked.example.com\n", "*.example.com\ntest.com\n", "")
+
+	require.Nil(t, e.Check("http://test.com"))
+	require.Nil(t, e.Check("http://www.example.com"))
+	require.EqualError(t, e.Check("http://blocked.example.com"), "the domain blocked.example.com is blocked")
+	require.EqualError(t, e.Check("http://www.test.com"), "the domain www.test.com is not allowed")
+}
+
+func TestEngine_CheckAllowlistRegex(t *testing.T) {
+	e, _ := makeEngine(t, "", "re:(www\\.)?example\\.com\n", "")
+
+	require.Nil(t, e.Check("http://example.com"))
+	require.Nil(t, e.Check("http://www.example.com"))
+	// the patterns match the whole host
+	require.EqualError(t, e.Check("http://example.com.attacker.net"), "the domain example.com.attacker.net is not allowed")
+	require.EqualError(t, e.Check("http://evilexample.com"), "the domain evilexample.com is not allowed")
+	require.EqualError(t, e.Check("not a url"), "the url can't be checked")
+}
+
+func TestEngine_CheckMaliciousHashes(t *testing.T) {
+	e, _ := makeEngine(t, "", "", Hash("http://www.test.com/malware.exe")+"\n")
+
+	require.NotNil(t, e.Check("http://www.test.com/malware.exe"))
+	require.NotNil(t, e.Check("HTTP://WWW.TEST.COM:80/malware.exe#top"))
+	require.NotNil(t, e.Check("http://www.test.com/malware.exe?utm_source=mail"))
+	require.Nil(t, e.Check("http://www.test.com/"))
+	require.Nil(t, e.Check("https://www.test.com/malware.exe"))
+}
+
+func TestHash(t *testing.T) {
+	require.Equal(t, Hash("http://www.test.com"), Hash("http://WWW.test.com:80/"))
+	require.NotEqual(t, Hash("http://www.test.com/a"), Hash("http://www.test.com/A"))
+}
+
+func TestEngine_Reload(t *testing.T) {
+	e, files := makeEngine(t, "evil.com\n", "", "")
+
+	changed, err := e.Reload()
+	require.Nil(t, err)
+	require.False(t, changed)
+
+	writeFile(t, files.Blocklist, "other.com\n")
+	later := time.Now().Add(time.Minute)
+	require.Nil(t, os.Chtimes(files.Blocklist, later, later))
+
+	changed, err = e.Reload()
+	require.Nil(t, err)
+	require.True(t, changed)
+	require.Nil(t, e.Check("http://evil.com"))
+	require.NotNil(t, e.Check("http://other.com"))
+
+	// the previous rules are kept when the file is invalid
+	writeFile(t, files.Blocklist, "re:(\n")
+	later = later.Add(time.Minute)
+	require.Nil(t, os.Chtimes(files.Blocklist, later, later))
+
+	_, err = e.Reload()
+	require.NotNil(t, err)
+	require.NotNil(t, e.Check("http://other.com"))
+}
+
+func TestNewEngine_Invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hashes")
+	writeFile(t, path, "not a hash\n")
+
+	_, err := NewEngine(logrus.New(), Files{MaliciousHashes: path})
+	require.EqualError(t, err, path+": line 1: not a SHA-256 hash")
+
+	_, err = NewEngine(logrus.New(), Files{Blocklist: filepath.Join(t.TempDir(), "missing")})
+	require.NotNil(t, err)
+}
@@ -0,0 +1,113 @@
+package policy
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	neturl "net/url"
+	"regexp"
+	"strings"
+)
+
+// pattern matches the hosts of the urls
+type pattern struct {
+	// exact host, or the parent domain of the subdomains matched by a *. pattern
+	host      string
+	subdomain bool
+	regex     *regexp.Regexp
+}
+
+func (p pattern) match(host string) bool {
+	switch {
+	case p.regex != nil:
+		return p.regex.MatchString(host)
+	case p.subdomain:
+		return strings.HasSuffix(host, "."+p.host)
+	default:
+		return host == p.host
+	}
+}
+
+// parsePatterns reads one pattern by line: a host, *. followed by a domain for its subdomains,
+// or re: followed by a regular expression matching the whole hosts. Empty lines and # comments are skipped
+func parsePatterns(r io.Reader) ([]pattern, error) {
+	var res []pattern
+	err := readLines(r, func(n int, line string) error {
+		switch {
+		case strings.HasPrefix(line, "re:"):
+			// anchored, so that a pattern of a host doesn't match the hosts containing it
+			regex, err := regexp.Compile("^(?:" + strings.TrimPrefix(line, "re:") + ")$")
+			if err != nil {
+				return fmt.Errorf("line %d: %v", n, err)
+			}
+			res = append(res, pattern{regex: regex})
+		case strings.HasPrefix(line, "*."):
+			res = append(res, pattern{host: strings.ToLower(strings.TrimPrefix(line, "*.")), subdomain: true})
+		default:
+			res = append(res, pattern{host: strings.ToLower(line)})
+		}
+		return nil
+	})
+	return res, err
+}
+
+// parseHashes reads one hex SHA-256 hash by line. Empty lines and # comments are skipped
+func parseHashes(r io.Reader) (map[string]bool, error) {
+	res := map[string]bool{}
+	err := readLines(r, func(n int, line string) error {
+		hash, err := hex.DecodeString(line)
+		if err != nil || len(hash) != sha256.Size {
+			return fmt.Errorf("line %d: not a SHA-256 hash", n)
+		}
+		res[hex.EncodeToString(hash)] = true
+		return nil
+	})
+	return res, err
+}
+
+func readLines(r io.Reader, fn func(n int, line string) error) error {
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := fn(n, line); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// Hash returns the hash listed in the malicious urls file for url: the hex SHA-256 of the url
+// with its scheme and host in lower case, without default port and fragment
+func Hash(url string) string {
+	u, err := neturl.Parse(url)
+	if err != nil {
+		return ""
+	}
+	return hash(canonical(u))
+}
+
+func hash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// canonical returns the normalized form of u that is hashed
+func canonical(u *neturl.URL) string {
+	c := *u
+	c.Scheme = strings.ToLower(c.Scheme)
+	c.Host = strings.ToLower(c.Host)
+	if port := c.Port(); (c.Scheme == "http" && port == "80") || (c.Scheme == "https" && port == "443") {
+		c.Host = strings.TrimSuffix(c.Host, ":"+port)
+	}
+	if c.Path == "" {
+		c.Path = "/"
+	}
+	c.Fragment = ""
+	c.RawFragment = ""
+	return c.String()
+}
@@ -0,0 +1,250 @@
+// Package qr renders QR codes as PNG or SVG images
+package qr
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// Error correction levels, recovering about 7%, 15%, 25% and 30% of the code
+const (
+	LevelLow      = "L"
+	LevelMedium   = "M"
+	LevelQuartile = "Q"
+	LevelHigh     = "H"
+)
+
+var levels = map[string]qrcode.RecoveryLevel{
+	LevelLow:      qrcode.Low,
+	LevelMedium:   qrcode.Medium,
+	LevelQuartile: qrcode.High,
+	LevelHigh:     qrcode.Highest,
+}
+
+// logoShare is the share of the width of the code covered by the logo, small enough for the
+// high error correction level to recover the modules it hides
+const logoShare = 0.2
+
+// Options customizes the rendering of a QR code
+type Options struct {
+	// Size is the width and height of the image in pixels
+	Size int
+	// Level is the error correction level: L, M, Q or H
+	Level string
+	// Margin is the width of the quiet zone around the code, in modules
+	Margin     int
+	Foreground color.RGBA
+	Background color.RGBA
+	// Logo is drawn at the center of the code, raising the error correction level to H
+	Logo image.Image
+}
+
+// DefaultOptions returns black on white 256 pixels codes, with the standard quiet zone
+func DefaultOptions() Options {
+	return Options{
+		Size:       256,
+		Level:      LevelMedium,
+		Margin:     4,
+		Foreground: color.RGBA{A: 0xff},
+		Background: color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff},
+	}
+}
+
+// ValidLevel reports whether level is a known error correction level
+func ValidLevel(level string) bool {
+	_, ok := levels[level]
+	return ok
+}
+
+// ParseColor parses a RRGGBB or RRGGBBAA hex color, optionally prefixed by #
+func ParseColor(s string) (color.RGBA, error) {
+	s = strings.TrimPrefix(s, "#")
+	if len(s) != 6 && len(s) != 8 {
+		return color.RGBA{}, fmt.Errorf("invalid color %q", s)
+	}
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return color.RGBA{}, fmt.Errorf("invalid color %q", s)
+	}
+	if len(s) == 6 {
+		v = v<<8 | 0xff
+	}
+	return color.RGBA{R: uint8(v >> 24), G: uint8(v >> 16), B: uint8(v >> 8), A: uint8(v)}, nil
+}
+
+// LoadLogo reads a PNG logo
+func LoadLogo(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return png.Decode(f)
+}
+
+// modules returns the dark modules of the code of content, without quiet zone
+func modules(content string, opts Options) ([][]bool, error) {
+	level := opts.Level
+	if opts.Logo != nil {
+		level = LevelHigh
+	}
+	recovery, ok := levels[level]
+	if !ok {
+		return nil, fmt.Errorf("invalid error correction level %q", opts.Level)
+	}
+	code, err := qrcode.New(content, recovery)
+	if err != nil {
+		return nil, err
+	}
+	code.DisableBorder = true
+	return code.Bitmap(), nil
+}
+
+// layout places the code in the image: the size of the image, grown when it is too small for the
+// modules, the size of a module and the offset of the first one, centering the code when the size
+// isn't a multiple of the modules
+func layout(count int, opts Options) (int, int, int) {
+	total := count + 2*opts.Margin
+	scale := opts.Size / total
+	if scale < 1 {
+		scale = 1
+	}
+	size := opts.Size
+	if size < total*scale {
+		size = total * scale
+	}
+	return size, scale, (size - count*scale) / 2
+}
+
+// logoBox returns the square covered by the logo, centered in the image
+func logoBox(count, scale, offset int) image.Rectangle {
+	side := int(float64(count*scale) * logoShare)
+	min := offset + (count*scale-side)/2
+	return image.Rect(min, min, min+side, min+side)
+}
+
+// PNG writes the QR code of content as a PNG image
+func PNG(w io.Writer, content string, opts Options) error {
+	bitmap, err := modules(content, opts)
+	if err != nil {
+		return err
+	}
+	count := len(bitmap)
+	size, scale, offset := layout(count, opts)
+
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.Draw(img, img.Bounds(), &image.Uniform{C: opts.Background}, image.Point{}, draw.Src)
+	fg := &image.Uniform{C: opts.Foreground}
+	for y, row := range bitmap {
+		for x, dark := range row {
+			if dark {
+				module := image.Rect(offset+x*scale, offset+y*scale, offset+(x+1)*scale, offset+(y+1)*scale)
+				draw.Draw(img, module, fg, image.Point{}, draw.Over)
+			}
+		}
+	}
+
+	if opts.Logo != nil {
+		box := logoBox(count, scale, offset)
+		draw.Draw(img, box, &image.Uniform{C: opts.Background}, image.Point{}, draw.Src)
+		pad := box.Dx() / 10
+		draw.Draw(img, box.Inset(pad), fit(opts.Logo, box.Dx()-2*pad), image.Point{}, draw.Over)
+	}
+
+	return png.Encode(w, img)
+}
+
+// SVG writes the QR code of content as an SVG image
+func SVG(w io.Writer, content string, opts Options) error {
+	bitmap, err := modules(content, opts)
+	if err != nil {
+		return err
+	}
+	count := len(bitmap)
+	total := count + 2*opts.Margin
+
+	// one path for the dark modules, with a subpath by horizontal run
+	var path strings.Builder
+	for y, row := range bitmap {
+		for x := 0; x < count; x++ {
+			if !row[x] {
+				continue
+			}
+			start := x
+			for x < count && row[x] {
+				x++
+			}
+			fmt.Fprintf(&path, "M%d %dh%dv1h-%dz", start+opts.Margin, y+opts.Margin, x-start, x-start)
+		}
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`,
+		opts.Size, opts.Size, total, total)
+	fmt.Fprintf(&buf, `<rect width="%d" height="%d"%s/>`, total, total, fill(opts.Background))
+	fmt.Fprintf(&buf, `<path d="%s"%s/>`, path.String(), fill(opts.Foreground))
+
+	if opts.Logo != nil {
+		// in modules, the unit of the view box
+		side := float64(count) * logoShare
+		min := float64(opts.Margin) + (float64(count)-side)/2
+		pad := side / 10
+		fmt.Fprintf(&buf, `<rect x="%g" y="%g" width="%g" height="%g"%s/>`, min, min, side, side, fill(opts.Background))
+
+		var logo bytes.Buffer
+		if err := png.Encode(&logo, opts.Logo); err != nil {
+			return err
+		}
+		// the aspect ratio of the logo is preserved by default
+		fmt.Fprintf(&buf, `<image x="%g" y="%g" width="%g" height="%g" href="data:image/png;base64,%s"/>`,
+			min+pad, min+pad, side-2*pad, side-2*pad, base64.StdEncoding.EncodeToString(logo.Bytes()))
+	}
+
+	buf.WriteString(`</svg>`)
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// fill returns the fill attributes of c
+func fill(c color.RGBA) string {
+	attr := fmt.Sprintf(` fill="#%02x%02x%02x"`, c.R, c.G, c.B)
+	if c.A != 0xff {
+		attr += fmt.Sprintf(` fill-opacity="%.3g"`, float64(c.A)/0xff)
+	}
+	return attr
+}
+
+// fit resizes img to fit a transparent square of side pixels, centered and keeping its aspect
+// ratio, with the nearest neighbour
+func fit(img image.Image, side int) image.Image {
+	res := image.NewRGBA(image.Rect(0, 0, side, side))
+	bounds := img.Bounds()
+	if side <= 0 || bounds.Empty() {
+		return res
+	}
+
+	w, h := side, side
+	if bounds.Dx() > bounds.Dy() {
+		h = side * bounds.Dy() / bounds.Dx()
+	} else {
+		w = side * bounds.Dx() / bounds.Dy()
+	}
+	left, top := (side-w)/2, (side-h)/2
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			res.Set(left+x, top+y, img.At(bounds.Min.X+x*bounds.Dx()/w, bounds.Min.Y+y*bounds.Dy()/h))
+		}
+	}
+	return res
+}
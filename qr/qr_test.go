@@ -0,0 +1,118 @@
+package qr
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const content = "http://localhost:8081/pRA4OEy"
+
+func TestPNG(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Foreground = color.RGBA{R: 0x11, G: 0x22, B: 0x33, A: 0xff}
+
+	var buf bytes.Buffer
+	require.Nil(t, PNG(&buf, content, opts))
+
+	img, err := png.Decode(&buf)
+	require.Nil(t, err)
+	require.Equal(t, image.Rect(0, 0, 256, 256), img.Bounds())
+
+	// the quiet zone is background, the top left finder pattern starts right after it
+	bitmap, err := modules(content, opts)
+	require.Nil(t, err)
+	_, scale, offset := layout(len(bitmap), opts)
+	require.True(t, offset >= opts.Margin*scale)
+	requireColor(t, opts.Background, img.At(offset-1, offset-1))
+	requireColor(t, opts.Foreground, img.At(offset, offset))
+}
+
+func TestPNG_TooSmall(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Size = 10
+
+	var buf bytes.Buffer
+	require.Nil(t, PNG(&buf, content, opts))
+
+	img, err := png.Decode(&buf)
+	require.Nil(t, err)
+	bitmap, _ := modules(content, opts)
+	require.Equal(t, len(bitmap)+2*opts.Margin, img.Bounds().Dx())
+}
+
+func TestPNG_Logo(t *testing.T) {
+	logo := image.NewRGBA(image.Rect(0, 0, 40, 20))
+	red := color.RGBA{R: 0xff, A: 0xff}
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 40; x++ {
+			logo.Set(x, y, red)
+		}
+	}
+	opts := DefaultOptions()
+	opts.Logo = logo
+
+	var buf bytes.Buffer
+	require.Nil(t, PNG(&buf, content, opts))
+
+	img, err := png.Decode(&buf)
+	require.Nil(t, err)
+	requireColor(t, red, img.At(128, 128))
+}
+
+func TestSVG(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Margin = 2
+	opts.Background = color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0x80}
+
+	var buf bytes.Buffer
+	require.Nil(t, SVG(&buf, content, opts))
+
+	bitmap, err := modules(content, opts)
+	require.Nil(t, err)
+	total := len(bitmap) + 4
+
+	svg := buf.String()
+	require.True(t, strings.HasPrefix(svg, `<svg xmlns="http://www.w3.org/2000/svg" width="256" height="256"`))
+	require.Contains(t, svg, fmt.Sprintf(`viewBox="0 0 %d %d"`, total, total))
+	require.Contains(t, svg, `fill="#ffffff" fill-opacity="0.502"`)
+	require.Contains(t, svg, `<path d="M2 2h7v1h-7z`)
+	require.True(t, strings.HasSuffix(svg, `</svg>`))
+}
+
+func TestModules_Level(t *testing.T) {
+	low, err := modules(content, Options{Level: LevelLow})
+	require.Nil(t, err)
+	high, err := modules(content, Options{Level: LevelHigh})
+	require.Nil(t, err)
+	require.True(t, len(high) > len(low))
+
+	_, err = modules(content, Options{Level: "X"})
+	require.NotNil(t, err)
+}
+
+func TestParseColor(t *testing.T) {
+	c, err := ParseColor("#1a2B3c")
+	require.Nil(t, err)
+	require.Equal(t, color.RGBA{R: 0x1a, G: 0x2b, B: 0x3c, A: 0xff}, c)
+
+	c, err = ParseColor("1a2b3c80")
+	require.Nil(t, err)
+	require.Equal(t, color.RGBA{R: 0x1a, G: 0x2b, B: 0x3c, A: 0x80}, c)
+
+	_, err = ParseColor("red")
+	require.NotNil(t, err)
+	_, err = ParseColor("#12345g")
+	require.NotNil(t, err)
+}
+
+func requireColor(t *testing.T, expected color.RGBA, actual color.Color) {
+	r, g, b, a := actual.RGBA()
+	require.Equal(t, expected, color.RGBA{R: uint8(r >> 8), G: uint8(g >> 8), B: uint8(b >> 8), A: uint8(a >> 8)})
+}
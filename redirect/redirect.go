@@ -0,0 +1,151 @@
+// Package redirect follows the redirects of the destinations of the short urls, to refuse the
+// ones looping back to the service or to themselves
+package redirect
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"time"
+
+	"github.com/gsiragusa/short-to-me/netguard"
+	"github.com/sirupsen/logrus"
+)
+
+// Loop is returned for the refused destinations
+type Loop struct {
+	Reason string
+}
+
+func (l *Loop) Error() string {
+	return l.Reason
+}
+
+// Checker follows the redirects of urls, without their bodies
+type Checker struct {
+	le      *logrus.Logger
+	client  *http.Client
+	maxHops int
+	hosts   []string
+
+	allowPrivate bool
+}
+
+// Option configures a Checker
+type Option func(*Checker)
+
+// AllowPrivate lets the checker follow the redirects to private and local addresses
+func AllowPrivate() Option {
+	return func(c *Checker) {
+		c.allowPrivate = true
+	}
+}
+
+// NewChecker returns a Checker refusing the chains of more than maxHops redirects and the
+// redirects to hosts, the hosts serving the short urls. Every request is bounded by timeout, and
+// only public addresses are requested unless AllowPrivate is set
+func NewChecker(le *logrus.Logger, maxHops int, timeout time.Duration, hosts []string, opts ...Option) *Checker {
+	c := &Checker{
+		le:      le,
+		maxHops: maxHops,
+		hosts:   hosts,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	c.client = netguard.NewClient(timeout, c.allowPrivate)
+	// the redirects are followed one by one by Check
+	c.client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return c
+}
+
+// Check returns a Loop when the redirects from url come back to an url of the chain, reach the
+// hosts of the service or exceed the maximum number of hops. The destinations that can't be
+// reached are accepted, since they don't loop
+func (c *Checker) Check(ctx context.Context, url string) error {
+	seen := map[string]bool{}
+	for hops := 0; ; hops++ {
+		if seen[url] {
+			return &Loop{Reason: "redirects back to " + url}
+		}
+		seen[url] = true
+
+		u, err := neturl.Parse(url)
+		if err != nil {
+			return nil
+		}
+		if hops > 0 && c.shortHost(u) {
+			return &Loop{Reason: "redirects to a short url of this service"}
+		}
+
+		next, err := c.next(ctx, u)
+		if err != nil {
+			c.le.WithError(err).WithField("url", url).Warn("unable to follow the redirects")
+			return nil
+		}
+		if next == "" {
+			return nil
+		}
+		if hops == c.maxHops {
+			return &Loop{Reason: fmt.Sprintf("follows more than %d redirects", c.maxHops)}
+		}
+		url = next
+	}
+}
+
+// next returns the location u redirects to, empty when it doesn't redirect
+func (c *Checker) next(ctx context.Context, u *neturl.URL) (string, error) {
+	res, err := c.request(ctx, http.MethodHead, u)
+	if err != nil {
+		return "", err
+	}
+	// some servers only answer to GET
+	if res.StatusCode == http.StatusMethodNotAllowed || res.StatusCode == http.StatusNotImplemented {
+		if res, err = c.request(ctx, http.MethodGet, u); err != nil {
+			return "", err
+		}
+	}
+
+	switch res.StatusCode {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		return "", nil
+	}
+	location, err := res.Location()
+	if err == http.ErrNoLocation {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return location.String(), nil
+}
+
+// request sends a request to u, closing the body of the response
+func (c *Checker) request(ctx context.Context, method string, u *neturl.URL) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	_ = res.Body.Close()
+	return res, nil
+}
+
+func (c *Checker) shortHost(u *neturl.URL) bool {
+	for _, host := range c.hosts {
+		if strings.EqualFold(u.Host, host) || strings.EqualFold(u.Hostname(), host) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package redirect
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func makeChecker(t *testing.T, hosts ...string) (*Checker, string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/a", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/b", http.StatusFound)
+	})
+	mux.HandleFunc("/b", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/a", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/self", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://sho.rt/RMAp1Vz", http.StatusFound)
+	})
+	mux.HandleFunc("/get-only", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		http.Redirect(w, r, "/ok", http.StatusTemporaryRedirect)
+	})
+	// /chain/n redirects n times before reaching /ok
+	mux.HandleFunc("/chain/", func(w http.ResponseWriter, r *http.Request) {
+		n, _ := strconv.Atoi(r.URL.Path[len("/chain/"):])
+		if n == 0 {
+			http.Redirect(w, r, "/ok", http.StatusFound)
+			return
+		}
+		http.Redirect(w, r, "/chain/"+strconv.Itoa(n-1), http.StatusFound)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	log := logrus.New()
+	log.Out = ioutil.Discard // silent logger
+	return NewChecker(log, 3, time.Second, hosts, AllowPrivate()), server.URL
+}
+
+func TestChecker_Check(t *testing.T) {
+	c, url := makeChecker(t, "sho.rt")
+	ctx := context.Background()
+
+	require.Nil(t, c.Check(ctx, url+"/ok"))
+	require.Nil(t, c.Check(ctx, url+"/get-only"))
+	require.Nil(t, c.Check(ctx, url+"/chain/2"))
+	// not found and unreachable destinations don't loop
+	require.Nil(t, c.Check(ctx, url+"/missing"))
+	require.Nil(t, c.Check(ctx, "http://127.0.0.1:1/"))
+
+	require.EqualError(t, c.Check(ctx, url+"/a"), "redirects back to "+url+"/a")
+	require.EqualError(t, c.Check(ctx, url+"/chain/3"), "follows more than 3 redirects")
+	require.EqualError(t, c.Check(ctx, url+"/self"), "redirects to a short url of this service")
+}
+
+func TestChecker_CheckPrivate(t *testing.T) {
+	requested := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = true
+		http.Redirect(w, r, r.URL.Path, http.StatusFound)
+	}))
+	defer server.Close()
+
+	log := logrus.New()
+	log.Out = ioutil.Discard // silent logger
+	c := NewChecker(log, 3, time.Second, nil)
+
+	// the loopback is never requested, the destination is accepted as unreachable
+	require.Nil(t, c.Check(context.Background(), server.URL+"/loop"))
+	require.False(t, requested)
+}
+
+func TestChecker_CheckContext(t *testing.T) {
+	c, url := makeChecker(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	require.Nil(t, c.Check(ctx, url+"/a"))
+}
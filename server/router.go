@@ -1,11 +1,19 @@
 package server
 
 import (
+	"bytes"
 	"encoding/json"
+	"html/template"
+	"net"
 	"net/http"
+	"regexp"
+	"strings"
 
 	"github.com/gorilla/mux"
+	"github.com/gsiragusa/short-to-me/audit"
+	"github.com/gsiragusa/short-to-me/config"
 	"github.com/gsiragusa/short-to-me/errors"
+	"github.com/gsiragusa/short-to-me/tenant"
 	"github.com/sirupsen/logrus"
 )
 
@@ -27,14 +35,22 @@ type RouteConfig struct {
 
 type Router struct {
 	MuxRouter *mux.Router
+	// proxies are the trusted reverse proxies, whose forwarding headers identify the clients
+	proxies config.Networks
 }
 
-// NewRouter returns a mux.Router for the api server
-func NewRouter(routeConfigArr ...[]RouteConfig) *Router {
+// NewRouter returns a mux.Router for the api server, behind the trusted proxies
+func NewRouter(proxies config.Networks, routeConfigArr ...[]RouteConfig) *Router {
 	r := &Router{
 		MuxRouter: mux.NewRouter().StrictSlash(true),
+		proxies:   proxies,
 	}
 
+	// public documentation, before the handlers so that it isn't taken for a short url path
+	r.MuxRouter.PathPrefix("/docs/").Handler(
+		http.StripPrefix("/docs/",
+			http.FileServer(http.Dir("public_docs/"))))
+
 	// all handlers
 	for _, routeConfigs := range routeConfigArr {
 		for _, routeConfig := range routeConfigs {
@@ -45,11 +61,6 @@ func NewRouter(routeConfigArr ...[]RouteConfig) *Router {
 	// not found handler
 	r.MuxRouter.NotFoundHandler = r.notFoundHandler()
 
-	// public documentation
-	r.MuxRouter.PathPrefix("/docs/").Handler(
-		http.StripPrefix("/docs/",
-			http.FileServer(http.Dir("public_docs/"))))
-
 	return r
 }
 
@@ -60,40 +71,116 @@ func (r *Router) addHandler(routes ...RouteConfig) {
 			Path(routeConfig.Path).
 			Name(routeConfig.Name)
 
-		route.Handler(httpHandler(routeConfig.Handler))
+		route.Handler(r.httpHandler(routeConfig.Handler))
 	}
 }
 
 func (r *Router) notFoundHandler() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		_ = WriteError(w, errors.NewErrorNotFound())
+		_ = WriteError(w, r, errors.NewErrorNotFound())
 	})
 }
 
+// RequestIdHeader carries the id of a request, generated when the client doesn't send one
+const RequestIdHeader = "X-Request-Id"
+
+// requestIdPattern accepts the request ids sent by the clients
+var requestIdPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
 // httpHandler wraps all handlers
-func httpHandler(h RouteHandler) http.HandlerFunc {
+func (r *Router) httpHandler(h RouteHandler) http.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request) {
+		req = withOrigin(w, req, clientIp(req, r.proxies))
 		err := h.ServeHTTP(w, req)
-		errHandler(w, err)
+		errHandler(w, req, err)
 	}
 }
 
+// withOrigin attaches the origin of the request to its context for the audit log, echoing the
+// id of the request in the response, and the scope restricting it to a workspace. The request is
+// anonymous and unrestricted until it is authenticated
+func withOrigin(w http.ResponseWriter, r *http.Request, clientIp string) *http.Request {
+	id := r.Header.Get(RequestIdHeader)
+	if !requestIdPattern.MatchString(id) {
+		id = audit.NewId()
+	}
+	w.Header().Set(RequestIdHeader, id)
+
+	origin := &audit.Origin{
+		Actor:     audit.ActorAnonymous,
+		ClientIp:  clientIp,
+		RequestId: id,
+	}
+	ctx := tenant.WithScope(audit.WithOrigin(r.Context(), origin))
+	return r.WithContext(ctx)
+}
+
+// ClientIp returns the address of the client that sent the request, as resolved by the router
+func ClientIp(r *http.Request) string {
+	if ip := audit.OriginFrom(r.Context()).ClientIp; ip != "" {
+		return ip
+	}
+	return remoteIp(r)
+}
+
+// clientIp returns the address of the client that sent the request. The forwarding headers are
+// only honored from the trusted proxies: X-Forwarded-For is read from its last address, the one
+// appended by the proxy, skipping the other trusted proxies
+func clientIp(r *http.Request, proxies config.Networks) string {
+	ip := remoteIp(r)
+	if !proxies.Contains(net.ParseIP(ip)) {
+		return ip
+	}
+
+	if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		hops := strings.Split(strings.Join(forwarded, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := net.ParseIP(strings.TrimSpace(hops[i]))
+			if hop == nil {
+				break
+			}
+			ip = hop.String()
+			if !proxies.Contains(hop) {
+				break
+			}
+		}
+		return ip
+	}
+	if real := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); real != nil {
+		return real.String()
+	}
+	return ip
+}
+
+// remoteIp returns the address of the peer of the connection
+func remoteIp(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
 // errHandler ensure that errors are handled properly
-func errHandler(w http.ResponseWriter, err error) {
+func errHandler(w http.ResponseWriter, r *http.Request, err error) {
 	if err == nil {
 		return
 	}
-	_ = WriteError(w, errors.NewInternalServerError())
+	_ = WriteError(w, r, errors.NewInternalServerError())
 }
 
 func Write(w http.ResponseWriter, code int, payload interface{}) error {
+	return write(w, code, "application/json; charset=utf-8", payload)
+}
+
+func write(w http.ResponseWriter, code int, contentType string, payload interface{}) error {
 	// short circuit if the payload is empty
 	if payload == nil {
 		w.WriteHeader(code)
 		return nil
 	}
 
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Content-Type", contentType)
 	data, err := json.Marshal(payload)
 	if err != nil {
 		return err
@@ -113,6 +200,37 @@ func Write(w http.ResponseWriter, code int, payload interface{}) error {
 	return nil
 }
 
-func WriteError(w http.ResponseWriter, err errors.Error) error {
+// WriteHtml renders tmpl with data as the response
+func WriteHtml(w http.ResponseWriter, code int, tmpl *template.Template, data interface{}) error {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		logrus.WithError(err).Error("failed to write response")
+	}
+	return nil
+}
+
+// WriteError writes err as the response, using the RFC 7807 problem format when the client accepts it
+func WriteError(w http.ResponseWriter, r *http.Request, err errors.Error) error {
+	if acceptsProblem(r) {
+		return write(w, err.HttpStatus, errors.ProblemContentType, errors.ProblemResponse(err, r.URL.Path))
+	}
 	return Write(w, err.HttpStatus, errors.ErrResponse(err))
 }
+
+// acceptsProblem reports whether the client opted in the problem format through the Accept header
+func acceptsProblem(r *http.Request) bool {
+	for _, accept := range r.Header.Values("Accept") {
+		for _, mediaType := range strings.Split(accept, ",") {
+			if strings.HasPrefix(strings.TrimSpace(mediaType), errors.ProblemContentType) {
+				return true
+			}
+		}
+	}
+	return false
+}
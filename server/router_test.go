@@ -0,0 +1,70 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gsiragusa/short-to-me/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientIp(t *testing.T) {
+	var proxies config.Networks
+	require.Nil(t, proxies.Decode("10.0.0.0/8, 192.0.2.10"))
+	require.NotNil(t, (&config.Networks{}).Decode("10.0.0.0/8,proxy"))
+
+	for name, tc := range map[string]struct {
+		remote    string
+		forwarded []string
+		realIp    string
+		expected  string
+	}{
+		"direct": {
+			remote:   "203.0.113.7:1234",
+			expected: "203.0.113.7",
+		},
+		"spoofed by a client": {
+			remote:    "203.0.113.7:1234",
+			forwarded: []string{"198.51.100.1"},
+			realIp:    "198.51.100.2",
+			expected:  "203.0.113.7",
+		},
+		"behind a proxy": {
+			remote:    "10.1.2.3:1234",
+			forwarded: []string{"198.51.100.1"},
+			expected:  "198.51.100.1",
+		},
+		"spoofed behind a proxy": {
+			remote:    "10.1.2.3:1234",
+			forwarded: []string{"198.51.100.9, 198.51.100.1"},
+			expected:  "198.51.100.1",
+		},
+		"behind a chain of proxies": {
+			remote:    "192.0.2.10:1234",
+			forwarded: []string{"198.51.100.1, 10.4.5.6", "10.7.8.9"},
+			expected:  "198.51.100.1",
+		},
+		"malformed behind a proxy": {
+			remote:    "10.1.2.3:1234",
+			forwarded: []string{"unknown"},
+			expected:  "10.1.2.3",
+		},
+		"real ip behind a proxy": {
+			remote:   "10.1.2.3:1234",
+			realIp:   "198.51.100.2",
+			expected: "198.51.100.2",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tc.remote
+			for _, f := range tc.forwarded {
+				req.Header.Add("X-Forwarded-For", f)
+			}
+			if tc.realIp != "" {
+				req.Header.Set("X-Real-IP", tc.realIp)
+			}
+			require.Equal(t, tc.expected, clientIp(req, proxies))
+		})
+	}
+}
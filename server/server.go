@@ -24,7 +24,7 @@ func New(le *logrus.Logger, appConfig *config.AppConfig, routes ...Handler) *Ser
 	return &Server{
 		le:     le,
 		config: appConfig,
-		router: NewRouter(parsedRoutes...),
+		router: NewRouter(appConfig.TrustedProxies, parsedRoutes...),
 	}
 }
 
@@ -0,0 +1,72 @@
+package shortener
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/gsiragusa/short-to-me/audit"
+	"github.com/gsiragusa/short-to-me/errors"
+	"github.com/gsiragusa/short-to-me/tenant"
+)
+
+// maxAuditLimit caps the number of audit entries returned by a single request
+const maxAuditLimit = 1000
+
+// redacted replaces the secrets in the snapshots of the audit log
+const redacted = "redacted"
+
+// record appends an entry to the audit log, for the origin carried by ctx
+func (s *service) record(ctx context.Context, action, id string, before, after *ModelShorten, details map[string]int64) {
+	entry := audit.Entry{
+		Action:  action,
+		ShortId: id,
+		Before:  snapshot(before),
+		After:   snapshot(after),
+		Details: details,
+	}
+	// the operations that are not restricted to a workspace are recorded in the one of their short url
+	if workspace, ok := tenant.WorkspaceFrom(ctx); ok {
+		entry.Workspace = workspace
+	} else if after != nil {
+		entry.Workspace = after.workspace()
+	} else if before != nil {
+		entry.Workspace = before.workspace()
+	}
+	audit.Record(ctx, s.le, s.auditor, entry)
+}
+
+// snapshot returns the json document of u for the audit log, with its password hash redacted
+func snapshot(u *ModelShorten) json.RawMessage {
+	if u == nil {
+		return nil
+	}
+	record := exportRecord{ModelShorten: u}
+	if u.PasswordHash != "" {
+		record.PasswordHash = redacted
+	}
+	return audit.Snapshot(&record)
+}
+
+// service method that returns the entries of the audit log matching the filter, from the most recent
+func (s *service) AuditLog(ctx context.Context, filter audit.Filter) ([]audit.Entry, *errors.Error) {
+	le := s.le.WithField("offset", filter.Offset).WithField("limit", filter.Limit)
+	le.Info("requested audit log")
+
+	if filter.Offset < 0 || filter.Limit < 0 {
+		le.Error("invalid pagination")
+		return nil, &badRequestError
+	}
+	if filter.Limit == 0 || filter.Limit > maxAuditLimit {
+		filter.Limit = maxAuditLimit
+	}
+	if s.auditor == nil {
+		return []audit.Entry{}, nil
+	}
+
+	res, err := s.auditor.FindAudit(ctx, filter)
+	if err != nil {
+		le.WithError(err).Error("unable to read the audit log")
+		return nil, &internalServerError
+	}
+	return res, nil
+}
@@ -0,0 +1,27 @@
+package shortener
+
+// countryCode reports whether code looks like an ISO 3166-1 alpha-2 country code, in any case
+func countryCode(code string) bool {
+	if len(code) != 2 {
+		return false
+	}
+	for _, c := range code {
+		if (c < 'A' || c > 'Z') && (c < 'a' || c > 'z') {
+			return false
+		}
+	}
+	return true
+}
+
+// country returns the country of the visitor, empty when there is no locator or it is unknown
+func (s *service) country(visit Visit) string {
+	if s.locator == nil || visit.ClientIp == "" {
+		return ""
+	}
+	country, err := s.locator.Country(visit.ClientIp)
+	if err != nil {
+		s.le.WithError(err).WithField("ip", visit.ClientIp).Warn("unable to locate visitor")
+		return ""
+	}
+	return country
+}
@@ -0,0 +1,75 @@
+package shortener
+
+import "sort"
+
+// Destination is a destination of a short url, with the input field it is set by
+type Destination struct {
+	Field string `json:"field" bson:"field"`
+	Url   string `json:"url" bson:"url"`
+}
+
+// destinations returns the alternate destinations set by the options
+func (o LinkOptions) destinations() []Destination {
+	var res []Destination
+	if o.PendingUrl != "" {
+		res = append(res, Destination{"pending_url", o.PendingUrl})
+	}
+	res = appendUrls(res, "platform_urls.", o.PlatformUrls)
+	res = appendUrls(res, "country_urls.", o.CountryUrls)
+	for _, v := range o.Variants {
+		res = append(res, Destination{"variants." + v.Name, v.Url})
+	}
+	return res
+}
+
+// Destinations returns every destination of the short url
+func (m *ModelShorten) Destinations() []Destination {
+	return append([]Destination{{"url", m.Url}}, m.options().destinations()...)
+}
+
+func appendUrls(res []Destination, prefix string, urls map[string]string) []Destination {
+	keys := make([]string, 0, len(urls))
+	for k := range urls {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		res = append(res, Destination{prefix + k, urls[k]})
+	}
+	return res
+}
+
+// mapDestinations returns a copy of the options with their destinations replaced by fn,
+// in the order of destinations
+func (o LinkOptions) mapDestinations(fn func(field, url string) string) LinkOptions {
+	if o.PendingUrl != "" {
+		o.PendingUrl = fn("pending_url", o.PendingUrl)
+	}
+	o.PlatformUrls = mapUrls("platform_urls.", o.PlatformUrls, fn)
+	o.CountryUrls = mapUrls("country_urls.", o.CountryUrls, fn)
+	if o.Variants != nil {
+		variants := make([]Variant, len(o.Variants))
+		for i, v := range o.Variants {
+			v.Url = fn("variants."+v.Name, v.Url)
+			variants[i] = v
+		}
+		o.Variants = variants
+	}
+	return o
+}
+
+func mapUrls(prefix string, urls map[string]string, fn func(field, url string) string) map[string]string {
+	if urls == nil {
+		return nil
+	}
+	keys := make([]string, 0, len(urls))
+	for k := range urls {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	res := make(map[string]string, len(urls))
+	for _, k := range keys {
+		res[k] = fn(prefix+k, urls[k])
+	}
+	return res
+}
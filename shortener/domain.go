@@ -0,0 +1,57 @@
+package shortener
+
+import (
+	"context"
+	neturl "net/url"
+	"strings"
+
+	"github.com/gsiragusa/short-to-me/errors"
+	"github.com/gsiragusa/short-to-me/tenant"
+)
+
+// checkDomain refuses a custom domain that is not registered for workspace. The domains of the
+// other workspaces are reported as not registered
+func (s *service) checkDomain(ctx context.Context, host, workspace string) *errors.Error {
+	if host == "" {
+		return nil
+	}
+	le := s.le.WithField("domain", host)
+
+	host = tenant.NormalizeHost(host)
+	var d *tenant.Domain
+	err := ErrNotFound
+	if s.domains != nil && tenant.ValidHost(host) {
+		d, err = s.domains.FindDomain(ctx, host)
+	}
+	if err == nil && d.Workspace != workspace {
+		err = ErrNotFound
+	}
+	if err == ErrNotFound {
+		le.Error("unknown domain")
+		e := errors.NewErrorBadRequest().WithDetail("domain", "is not a domain of the workspace")
+		return &e
+	}
+	if err != nil {
+		le.WithError(err).Error("unable to read the domain")
+		return &internalServerError
+	}
+	return nil
+}
+
+// shortDomain reports whether u is on one of the hosts serving the short urls, the short hosts or
+// the custom domains, with the custom domain whose namespace holds its short ids
+func (s *service) shortDomain(ctx context.Context, u *neturl.URL) (string, bool) {
+	for _, host := range s.config.ShortHosts {
+		if strings.EqualFold(u.Host, host) || strings.EqualFold(u.Hostname(), host) {
+			return "", true
+		}
+	}
+	if s.domains == nil {
+		return "", false
+	}
+	d, err := s.domains.FindDomain(ctx, tenant.NormalizeHost(u.Host))
+	if err != nil {
+		return "", false
+	}
+	return d.Host, true
+}
@@ -0,0 +1,127 @@
+package shortener
+
+import (
+	"context"
+	"time"
+
+	"github.com/gsiragusa/short-to-me/audit"
+	"github.com/gsiragusa/short-to-me/errors"
+)
+
+// Events of the short urls
+const (
+	EventLinkCreated = "link.created"
+	EventLinkUpdated = "link.updated"
+	EventLinkDeleted = "link.deleted"
+	EventLinkExpired = "link.expired"
+	// EventLinkClicked is sent for every redirect counted
+	EventLinkClicked = "link.clicked"
+	// EventLinkClicks is sent when the redirects of a short url reach one of the click thresholds
+	EventLinkClicks = "link.clicks"
+)
+
+// Reasons of the expiry of the short urls
+const (
+	ExpiryMaxClicks = "max_clicks"
+	ExpiryNotAfter  = "not_after"
+)
+
+// Event is a change of a short url, published to the systems reacting to it
+type Event struct {
+	Id        string    `json:"id" bson:"id"`
+	Type      string    `json:"type" bson:"type"`
+	Time      time.Time `json:"time" bson:"time"`
+	Workspace string    `json:"workspace" bson:"workspace"`
+	// Link is the short url after the change, without its password hash
+	Link *ModelShorten `json:"link" bson:"link"`
+	// Clicks is the threshold of redirects reached, for link.clicks
+	Clicks int64 `json:"clicks,omitempty" bson:"clicks,omitempty"`
+	// Reason is why the short url expired, for link.expired: max_clicks or not_after
+	Reason string `json:"reason,omitempty" bson:"reason,omitempty"`
+	// Variant and Country describe the redirect, for link.clicked
+	Variant string `json:"variant,omitempty" bson:"variant,omitempty"`
+	Country string `json:"country,omitempty" bson:"country,omitempty"`
+}
+
+// linkEvent returns the event typ of the short url u
+func linkEvent(typ string, u *ModelShorten) Event {
+	c := *u
+	c.PasswordHash = ""
+	return Event{Type: typ, Workspace: c.workspace(), Link: &c}
+}
+
+// transaction runs fn, the mutation of the store and the events it emits, in a transaction of the
+// outbox so that the events are kept if and only if the mutation is applied
+func (s *service) transaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if s.outbox == nil {
+		return fn(ctx)
+	}
+	return s.outbox.Transaction(ctx, fn)
+}
+
+// emit writes event to the outbox, in the transaction of ctx
+func (s *service) emit(ctx context.Context, event Event) error {
+	if s.outbox == nil {
+		return nil
+	}
+	event.Id = audit.NewId()
+	event.Time = time.Now().UTC()
+	return s.outbox.InsertEvent(ctx, event)
+}
+
+// clickEvents returns the events of the redirect of u, the count-th one: the click itself, the
+// click threshold and the max clicks reached
+func (s *service) clickEvents(u *ModelShorten, count int64, click Click) []Event {
+	if u == nil {
+		return nil
+	}
+	clicked := linkEvent(EventLinkClicked, u)
+	clicked.Link.Count = count
+	clicked.Variant = click.Variant
+	clicked.Country = click.Country
+	res := []Event{clicked}
+	for _, threshold := range s.config.ClickThresholds {
+		if count == threshold {
+			event := linkEvent(EventLinkClicks, u)
+			event.Link.Count = count
+			event.Clicks = count
+			res = append(res, event)
+		}
+	}
+	if u.MaxClicks > 0 && count == u.MaxClicks {
+		event := linkEvent(EventLinkExpired, u)
+		event.Link.Count = count
+		event.Reason = ExpiryMaxClicks
+		res = append(res, event)
+	}
+	return res
+}
+
+// service method that emits once the expiry of the short urls whose not_after passed
+func (s *service) ExpireUrls(ctx context.Context) (int64, *errors.Error) {
+	now := time.Now().UTC()
+	var expired int64
+	for {
+		err := s.transaction(ctx, func(ctx context.Context) error {
+			u, err := s.store.ExpireUrl(ctx, now)
+			if err != nil {
+				return err
+			}
+			event := linkEvent(EventLinkExpired, u)
+			event.Reason = ExpiryNotAfter
+			return s.emit(ctx, event)
+		})
+		if err == ErrNotFound {
+			break
+		}
+		if err != nil {
+			s.le.WithError(err).Error("unable to expire urls")
+			return expired, &internalServerError
+		}
+		expired++
+	}
+	if expired > 0 {
+		s.le.Infof("expired %d urls", expired)
+	}
+	return expired, nil
+}
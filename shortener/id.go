@@ -0,0 +1,65 @@
+package shortener
+
+import (
+	"sync"
+	"time"
+
+	"github.com/speps/go-hashids"
+)
+
+// maxIdSuffix bounds the random suffixes of the ids retried after a collision
+const maxIdSuffix = 1 << 20
+
+// newId hashes the given timestamp to generate a short url id. The ids of the same second
+// collide, suffix is added to the hash of the others to make them unique
+func newId(t time.Time, suffix int) (string, error) {
+	h, err := hashids.NewWithData(hashids.NewData())
+	if err != nil {
+		return "", err
+	}
+	if suffix > 0 {
+		return h.Encode([]int{int(t.Unix()), suffix})
+	}
+	return h.Encode([]int{int(t.Unix())})
+}
+
+// idSequence numbers the ids generated in the same second, so that the short urls created by an
+// instance never collide with each other
+type idSequence struct {
+	mu     sync.Mutex
+	second int64
+	next   int
+}
+
+// take returns the suffix of the next id generated at t
+func (q *idSequence) take(t time.Time) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if t.Unix() != q.second {
+		q.second = t.Unix()
+		q.next = 0
+	}
+	suffix := q.next
+	q.next++
+	return suffix
+}
+
+// randomSuffix returns the suffix of an id retried after a collision with another instance
+func randomSuffix() int {
+	random.Lock()
+	defer random.Unlock()
+	return 1 + random.Intn(maxIdSuffix-1)
+}
+
+// IdTimestamp returns the timestamp a short url id was generated from
+func IdTimestamp(id string) (time.Time, error) {
+	h, err := hashids.NewWithData(hashids.NewData())
+	if err != nil {
+		return time.Time{}, err
+	}
+	numbers, err := h.DecodeWithError(id)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(int64(numbers[0]), 0).UTC(), nil
+}
@@ -2,23 +2,121 @@ package shortener
 
 import (
 	"context"
+	stderrors "errors"
+	"io"
+	"time"
 
+	"github.com/gsiragusa/short-to-me/audit"
 	"github.com/gsiragusa/short-to-me/errors"
+	"github.com/gsiragusa/short-to-me/tenant"
+)
+
+var (
+	// ErrDuplicate is returned by a Store when a document with the same id is already stored
+	ErrDuplicate = stderrors.New("duplicate id")
+	// ErrNotFound is returned by a Store when no document matches the update
+	ErrNotFound = stderrors.New("not found")
 )
 
 //go:generate mockgen -source=interfaces.go -destination=interfaces_mock.go -package=shortener
 type Service interface {
-	ShortenUrl(ctx context.Context, url string) (string, *errors.Error)
+	ShortenUrl(ctx context.Context, url string, opts LinkOptions) (string, *errors.Error)
 	RetrieveUrl(ctx context.Context, url string) (string, *errors.Error)
 	DeleteUrl(ctx context.Context, url string) *errors.Error
-	CountRedirects(ctx context.Context, url string) (int64, *errors.Error)
-	IncrementRedirect(ctx context.Context, id string) (string, *errors.Error)
+	RestoreUrl(ctx context.Context, url string) *errors.Error
+	PurgeDeleted(ctx context.Context) (int64, *errors.Error)
+	ExpireUrls(ctx context.Context) (int64, *errors.Error)
+	CountRedirects(ctx context.Context, url string) (*Counts, *errors.Error)
+	Health(ctx context.Context, url string) (*Health, *errors.Error)
+	IncrementRedirect(ctx context.Context, id string, visit Visit) (*Redirect, *errors.Error)
+	PreviewUrl(ctx context.Context, id string, visit Visit) (*Preview, *errors.Error)
+	GetUrl(ctx context.Context, url string) (*ModelShorten, *errors.Error)
+	UpdateUrl(ctx context.Context, url string, update LinkUpdate) (*ModelShorten, *errors.Error)
+	ListUrls(ctx context.Context, opts ListOptions) ([]*ModelShorten, *errors.Error)
+	Stats(ctx context.Context) (*Stats, *errors.Error)
+	// ExportUrls streams the short urls to w, skipping the ones the format can't carry
+	ExportUrls(ctx context.Context, w io.Writer, format string) (*ExportResult, *errors.Error)
+	ImportUrls(ctx context.Context, r io.Reader, format string, overwrite bool) (*ImportResult, *errors.Error)
+	AuditLog(ctx context.Context, filter audit.Filter) ([]audit.Entry, *errors.Error)
+	// Wait returns once the work the service runs in the background, the fetches of the destination
+	// pages, is done
+	Wait()
 }
 
 type Store interface {
 	StoreUrl(ctx context.Context, document interface{}) error
 	FindUrl(ctx context.Context, url string) (*ModelShorten, error)
 	FindById(ctx context.Context, id string) (*ModelShorten, error)
+	// DeleteById moves a document to the trash, hiding it from the other methods
 	DeleteById(ctx context.Context, id string) error
-	IncrementCount(ctx context.Context, id string) (*ModelShorten, error)
+	// RestoreById restores a document moved to the trash after since
+	RestoreById(ctx context.Context, id string, since time.Time) error
+	// PurgeDeleted removes the documents moved to the trash before, returning their number
+	PurgeDeleted(ctx context.Context, before time.Time) (int64, error)
+	// ExpireUrl marks a document whose not_after is before now as expired, once, returning it.
+	// ErrNotFound when none is left
+	ExpireUrl(ctx context.Context, now time.Time) (*ModelShorten, error)
+	// IncrementCount counts a redirect, returning the document before the increment
+	IncrementCount(ctx context.Context, id string, click Click) (*ModelShorten, error)
+	// UpdatePage records the destination page of a document
+	UpdatePage(ctx context.Context, id string, page Page) error
+	// UpdateLabels replaces the labels of a document, returning the updated document
+	UpdateLabels(ctx context.Context, id string, labels Labels) (*ModelShorten, error)
+	ListUrls(ctx context.Context, opts ListOptions) ([]*ModelShorten, error)
+	Totals(ctx context.Context) (*Stats, error)
+	// CountUrls returns the number of documents of workspace, the deleted ones included
+	CountUrls(ctx context.Context, workspace string) (int64, error)
+	ForEachUrl(ctx context.Context, fn func(*ModelShorten) error) error
+	ReplaceUrl(ctx context.Context, document *ModelShorten) error
+}
+
+// Policy decides which destinations can be shortened and redirected to
+type Policy interface {
+	// Check returns the reason why url is refused, nil when it is accepted
+	Check(url string) error
+}
+
+// Locator resolves the country of the visitors from their IP address
+type Locator interface {
+	// Country returns the ISO 3166-1 alpha-2 code of the country of ip, empty when unknown
+	Country(ip string) (string, error)
+}
+
+// RedirectChecker follows the redirects of the destinations
+type RedirectChecker interface {
+	// Check returns the reason why the redirects from url are refused, a loop or a chain too long,
+	// nil when they are accepted
+	Check(ctx context.Context, url string) error
+}
+
+// Workspaces holds the quotas of the workspaces
+type Workspaces interface {
+	// FindWorkspace returns the workspace id, ErrNotFound when it doesn't exist
+	FindWorkspace(ctx context.Context, id string) (*tenant.Workspace, error)
+}
+
+// Domains holds the custom domains of the workspaces
+type Domains interface {
+	// FindDomain returns the custom domain host, ErrNotFound when it is not registered
+	FindDomain(ctx context.Context, host string) (*tenant.Domain, error)
+}
+
+// Outbox keeps the events of the short urls until they are published
+type Outbox interface {
+	// Transaction runs fn so that the writes it makes with its ctx are applied together or not at all
+	Transaction(ctx context.Context, fn func(ctx context.Context) error) error
+	InsertEvent(ctx context.Context, event Event) error
+}
+
+// PageFetcher reads the destination pages of the short urls
+type PageFetcher interface {
+	// Fetch returns the title and the metadata of the page at url
+	Fetch(ctx context.Context, url string) (*Page, error)
+}
+
+// Auditor keeps the append-only audit log of the management operations
+type Auditor interface {
+	InsertAudit(ctx context.Context, entry audit.Entry) error
+	// FindAudit returns the entries matching filter, from the most recent
+	FindAudit(ctx context.Context, filter audit.Filter) ([]audit.Entry, error)
 }
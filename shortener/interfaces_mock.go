@@ -5,10 +5,14 @@ package shortener
 
 import (
 	context "context"
+	io "io"
 	reflect "reflect"
+	time "time"
 
 	gomock "github.com/golang/mock/gomock"
+	audit "github.com/gsiragusa/short-to-me/audit"
 	errors "github.com/gsiragusa/short-to-me/errors"
+	tenant "github.com/gsiragusa/short-to-me/tenant"
 )
 
 // MockService is a mock of Service interface
@@ -35,16 +39,16 @@ func (_m *MockService) EXPECT() *MockServiceMockRecorder {
 }
 
 // ShortenUrl mocks base method
-func (_m *MockService) ShortenUrl(ctx context.Context, url string) (string, *errors.Error) {
-	ret := _m.ctrl.Call(_m, "ShortenUrl", ctx, url)
+func (_m *MockService) ShortenUrl(ctx context.Context, url string, opts LinkOptions) (string, *errors.Error) {
+	ret := _m.ctrl.Call(_m, "ShortenUrl", ctx, url, opts)
 	ret0, _ := ret[0].(string)
 	ret1, _ := ret[1].(*errors.Error)
 	return ret0, ret1
 }
 
 // ShortenUrl indicates an expected call of ShortenUrl
-func (_mr *MockServiceMockRecorder) ShortenUrl(arg0, arg1 interface{}) *gomock.Call {
-	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "ShortenUrl", reflect.TypeOf((*MockService)(nil).ShortenUrl), arg0, arg1)
+func (_mr *MockServiceMockRecorder) ShortenUrl(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "ShortenUrl", reflect.TypeOf((*MockService)(nil).ShortenUrl), arg0, arg1, arg2)
 }
 
 // RetrieveUrl mocks base method
@@ -72,10 +76,48 @@ func (_mr *MockServiceMockRecorder) DeleteUrl(arg0, arg1 interface{}) *gomock.Ca
 	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "DeleteUrl", reflect.TypeOf((*MockService)(nil).DeleteUrl), arg0, arg1)
 }
 
+// RestoreUrl mocks base method
+func (_m *MockService) RestoreUrl(ctx context.Context, url string) *errors.Error {
+	ret := _m.ctrl.Call(_m, "RestoreUrl", ctx, url)
+	ret0, _ := ret[0].(*errors.Error)
+	return ret0
+}
+
+// RestoreUrl indicates an expected call of RestoreUrl
+func (_mr *MockServiceMockRecorder) RestoreUrl(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "RestoreUrl", reflect.TypeOf((*MockService)(nil).RestoreUrl), arg0, arg1)
+}
+
+// PurgeDeleted mocks base method
+func (_m *MockService) PurgeDeleted(ctx context.Context) (int64, *errors.Error) {
+	ret := _m.ctrl.Call(_m, "PurgeDeleted", ctx)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(*errors.Error)
+	return ret0, ret1
+}
+
+// PurgeDeleted indicates an expected call of PurgeDeleted
+func (_mr *MockServiceMockRecorder) PurgeDeleted(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "PurgeDeleted", reflect.TypeOf((*MockService)(nil).PurgeDeleted), arg0)
+}
+
+// ExpireUrls mocks base method
+func (_m *MockService) ExpireUrls(ctx context.Context) (int64, *errors.Error) {
+	ret := _m.ctrl.Call(_m, "ExpireUrls", ctx)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(*errors.Error)
+	return ret0, ret1
+}
+
+// ExpireUrls indicates an expected call of ExpireUrls
+func (_mr *MockServiceMockRecorder) ExpireUrls(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "ExpireUrls", reflect.TypeOf((*MockService)(nil).ExpireUrls), arg0)
+}
+
 // CountRedirects mocks base method
-func (_m *MockService) CountRedirects(ctx context.Context, url string) (int64, *errors.Error) {
+func (_m *MockService) CountRedirects(ctx context.Context, url string) (*Counts, *errors.Error) {
 	ret := _m.ctrl.Call(_m, "CountRedirects", ctx, url)
-	ret0, _ := ret[0].(int64)
+	ret0, _ := ret[0].(*Counts)
 	ret1, _ := ret[1].(*errors.Error)
 	return ret0, ret1
 }
@@ -85,17 +127,144 @@ func (_mr *MockServiceMockRecorder) CountRedirects(arg0, arg1 interface{}) *gomo
 	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "CountRedirects", reflect.TypeOf((*MockService)(nil).CountRedirects), arg0, arg1)
 }
 
+// Health mocks base method
+func (_m *MockService) Health(ctx context.Context, url string) (*Health, *errors.Error) {
+	ret := _m.ctrl.Call(_m, "Health", ctx, url)
+	ret0, _ := ret[0].(*Health)
+	ret1, _ := ret[1].(*errors.Error)
+	return ret0, ret1
+}
+
+// Health indicates an expected call of Health
+func (_mr *MockServiceMockRecorder) Health(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "Health", reflect.TypeOf((*MockService)(nil).Health), arg0, arg1)
+}
+
 // IncrementRedirect mocks base method
-func (_m *MockService) IncrementRedirect(ctx context.Context, id string) (string, *errors.Error) {
-	ret := _m.ctrl.Call(_m, "IncrementRedirect", ctx, id)
-	ret0, _ := ret[0].(string)
+func (_m *MockService) IncrementRedirect(ctx context.Context, id string, visit Visit) (*Redirect, *errors.Error) {
+	ret := _m.ctrl.Call(_m, "IncrementRedirect", ctx, id, visit)
+	ret0, _ := ret[0].(*Redirect)
 	ret1, _ := ret[1].(*errors.Error)
 	return ret0, ret1
 }
 
 // IncrementRedirect indicates an expected call of IncrementRedirect
-func (_mr *MockServiceMockRecorder) IncrementRedirect(arg0, arg1 interface{}) *gomock.Call {
-	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "IncrementRedirect", reflect.TypeOf((*MockService)(nil).IncrementRedirect), arg0, arg1)
+func (_mr *MockServiceMockRecorder) IncrementRedirect(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "IncrementRedirect", reflect.TypeOf((*MockService)(nil).IncrementRedirect), arg0, arg1, arg2)
+}
+
+// PreviewUrl mocks base method
+func (_m *MockService) PreviewUrl(ctx context.Context, id string, visit Visit) (*Preview, *errors.Error) {
+	ret := _m.ctrl.Call(_m, "PreviewUrl", ctx, id, visit)
+	ret0, _ := ret[0].(*Preview)
+	ret1, _ := ret[1].(*errors.Error)
+	return ret0, ret1
+}
+
+// PreviewUrl indicates an expected call of PreviewUrl
+func (_mr *MockServiceMockRecorder) PreviewUrl(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "PreviewUrl", reflect.TypeOf((*MockService)(nil).PreviewUrl), arg0, arg1, arg2)
+}
+
+// GetUrl mocks base method
+func (_m *MockService) GetUrl(ctx context.Context, url string) (*ModelShorten, *errors.Error) {
+	ret := _m.ctrl.Call(_m, "GetUrl", ctx, url)
+	ret0, _ := ret[0].(*ModelShorten)
+	ret1, _ := ret[1].(*errors.Error)
+	return ret0, ret1
+}
+
+// GetUrl indicates an expected call of GetUrl
+func (_mr *MockServiceMockRecorder) GetUrl(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "GetUrl", reflect.TypeOf((*MockService)(nil).GetUrl), arg0, arg1)
+}
+
+// UpdateUrl mocks base method
+func (_m *MockService) UpdateUrl(ctx context.Context, url string, update LinkUpdate) (*ModelShorten, *errors.Error) {
+	ret := _m.ctrl.Call(_m, "UpdateUrl", ctx, url, update)
+	ret0, _ := ret[0].(*ModelShorten)
+	ret1, _ := ret[1].(*errors.Error)
+	return ret0, ret1
+}
+
+// UpdateUrl indicates an expected call of UpdateUrl
+func (_mr *MockServiceMockRecorder) UpdateUrl(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "UpdateUrl", reflect.TypeOf((*MockService)(nil).UpdateUrl), arg0, arg1, arg2)
+}
+
+// ListUrls mocks base method
+func (_m *MockService) ListUrls(ctx context.Context, opts ListOptions) ([]*ModelShorten, *errors.Error) {
+	ret := _m.ctrl.Call(_m, "ListUrls", ctx, opts)
+	ret0, _ := ret[0].([]*ModelShorten)
+	ret1, _ := ret[1].(*errors.Error)
+	return ret0, ret1
+}
+
+// ListUrls indicates an expected call of ListUrls
+func (_mr *MockServiceMockRecorder) ListUrls(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "ListUrls", reflect.TypeOf((*MockService)(nil).ListUrls), arg0, arg1)
+}
+
+// Stats mocks base method
+func (_m *MockService) Stats(ctx context.Context) (*Stats, *errors.Error) {
+	ret := _m.ctrl.Call(_m, "Stats", ctx)
+	ret0, _ := ret[0].(*Stats)
+	ret1, _ := ret[1].(*errors.Error)
+	return ret0, ret1
+}
+
+// Stats indicates an expected call of Stats
+func (_mr *MockServiceMockRecorder) Stats(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "Stats", reflect.TypeOf((*MockService)(nil).Stats), arg0)
+}
+
+// ExportUrls mocks base method
+func (_m *MockService) ExportUrls(ctx context.Context, w io.Writer, format string) (*ExportResult, *errors.Error) {
+	ret := _m.ctrl.Call(_m, "ExportUrls", ctx, w, format)
+	ret0, _ := ret[0].(*ExportResult)
+	ret1, _ := ret[1].(*errors.Error)
+	return ret0, ret1
+}
+
+// ExportUrls indicates an expected call of ExportUrls
+func (_mr *MockServiceMockRecorder) ExportUrls(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "ExportUrls", reflect.TypeOf((*MockService)(nil).ExportUrls), arg0, arg1, arg2)
+}
+
+// ImportUrls mocks base method
+func (_m *MockService) ImportUrls(ctx context.Context, r io.Reader, format string, overwrite bool) (*ImportResult, *errors.Error) {
+	ret := _m.ctrl.Call(_m, "ImportUrls", ctx, r, format, overwrite)
+	ret0, _ := ret[0].(*ImportResult)
+	ret1, _ := ret[1].(*errors.Error)
+	return ret0, ret1
+}
+
+// ImportUrls indicates an expected call of ImportUrls
+func (_mr *MockServiceMockRecorder) ImportUrls(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "ImportUrls", reflect.TypeOf((*MockService)(nil).ImportUrls), arg0, arg1, arg2, arg3)
+}
+
+// AuditLog mocks base method
+func (_m *MockService) AuditLog(ctx context.Context, filter audit.Filter) ([]audit.Entry, *errors.Error) {
+	ret := _m.ctrl.Call(_m, "AuditLog", ctx, filter)
+	ret0, _ := ret[0].([]audit.Entry)
+	ret1, _ := ret[1].(*errors.Error)
+	return ret0, ret1
+}
+
+// AuditLog indicates an expected call of AuditLog
+func (_mr *MockServiceMockRecorder) AuditLog(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "AuditLog", reflect.TypeOf((*MockService)(nil).AuditLog), arg0, arg1)
+}
+
+// Wait mocks base method
+func (_m *MockService) Wait() {
+	_m.ctrl.Call(_m, "Wait")
+}
+
+// Wait indicates an expected call of Wait
+func (_mr *MockServiceMockRecorder) Wait() *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "Wait", reflect.TypeOf((*MockService)(nil).Wait))
 }
 
 // MockStore is a mock of Store interface
@@ -171,15 +340,450 @@ func (_mr *MockStoreMockRecorder) DeleteById(arg0, arg1 interface{}) *gomock.Cal
 	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "DeleteById", reflect.TypeOf((*MockStore)(nil).DeleteById), arg0, arg1)
 }
 
+// RestoreById mocks base method
+func (_m *MockStore) RestoreById(ctx context.Context, id string, since time.Time) error {
+	ret := _m.ctrl.Call(_m, "RestoreById", ctx, id, since)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RestoreById indicates an expected call of RestoreById
+func (_mr *MockStoreMockRecorder) RestoreById(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "RestoreById", reflect.TypeOf((*MockStore)(nil).RestoreById), arg0, arg1, arg2)
+}
+
+// PurgeDeleted mocks base method
+func (_m *MockStore) PurgeDeleted(ctx context.Context, before time.Time) (int64, error) {
+	ret := _m.ctrl.Call(_m, "PurgeDeleted", ctx, before)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PurgeDeleted indicates an expected call of PurgeDeleted
+func (_mr *MockStoreMockRecorder) PurgeDeleted(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "PurgeDeleted", reflect.TypeOf((*MockStore)(nil).PurgeDeleted), arg0, arg1)
+}
+
+// ExpireUrl mocks base method
+func (_m *MockStore) ExpireUrl(ctx context.Context, now time.Time) (*ModelShorten, error) {
+	ret := _m.ctrl.Call(_m, "ExpireUrl", ctx, now)
+	ret0, _ := ret[0].(*ModelShorten)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ExpireUrl indicates an expected call of ExpireUrl
+func (_mr *MockStoreMockRecorder) ExpireUrl(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "ExpireUrl", reflect.TypeOf((*MockStore)(nil).ExpireUrl), arg0, arg1)
+}
+
 // IncrementCount mocks base method
-func (_m *MockStore) IncrementCount(ctx context.Context, id string) (*ModelShorten, error) {
-	ret := _m.ctrl.Call(_m, "IncrementCount", ctx, id)
+func (_m *MockStore) IncrementCount(ctx context.Context, id string, click Click) (*ModelShorten, error) {
+	ret := _m.ctrl.Call(_m, "IncrementCount", ctx, id, click)
 	ret0, _ := ret[0].(*ModelShorten)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // IncrementCount indicates an expected call of IncrementCount
-func (_mr *MockStoreMockRecorder) IncrementCount(arg0, arg1 interface{}) *gomock.Call {
-	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "IncrementCount", reflect.TypeOf((*MockStore)(nil).IncrementCount), arg0, arg1)
+func (_mr *MockStoreMockRecorder) IncrementCount(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "IncrementCount", reflect.TypeOf((*MockStore)(nil).IncrementCount), arg0, arg1, arg2)
+}
+
+// UpdatePage mocks base method
+func (_m *MockStore) UpdatePage(ctx context.Context, id string, page Page) error {
+	ret := _m.ctrl.Call(_m, "UpdatePage", ctx, id, page)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdatePage indicates an expected call of UpdatePage
+func (_mr *MockStoreMockRecorder) UpdatePage(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "UpdatePage", reflect.TypeOf((*MockStore)(nil).UpdatePage), arg0, arg1, arg2)
+}
+
+// UpdateLabels mocks base method
+func (_m *MockStore) UpdateLabels(ctx context.Context, id string, labels Labels) (*ModelShorten, error) {
+	ret := _m.ctrl.Call(_m, "UpdateLabels", ctx, id, labels)
+	ret0, _ := ret[0].(*ModelShorten)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpdateLabels indicates an expected call of UpdateLabels
+func (_mr *MockStoreMockRecorder) UpdateLabels(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "UpdateLabels", reflect.TypeOf((*MockStore)(nil).UpdateLabels), arg0, arg1, arg2)
+}
+
+// ListUrls mocks base method
+func (_m *MockStore) ListUrls(ctx context.Context, opts ListOptions) ([]*ModelShorten, error) {
+	ret := _m.ctrl.Call(_m, "ListUrls", ctx, opts)
+	ret0, _ := ret[0].([]*ModelShorten)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListUrls indicates an expected call of ListUrls
+func (_mr *MockStoreMockRecorder) ListUrls(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "ListUrls", reflect.TypeOf((*MockStore)(nil).ListUrls), arg0, arg1)
+}
+
+// Totals mocks base method
+func (_m *MockStore) Totals(ctx context.Context) (*Stats, error) {
+	ret := _m.ctrl.Call(_m, "Totals", ctx)
+	ret0, _ := ret[0].(*Stats)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Totals indicates an expected call of Totals
+func (_mr *MockStoreMockRecorder) Totals(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "Totals", reflect.TypeOf((*MockStore)(nil).Totals), arg0)
+}
+
+// CountUrls mocks base method
+func (_m *MockStore) CountUrls(ctx context.Context, workspace string) (int64, error) {
+	ret := _m.ctrl.Call(_m, "CountUrls", ctx, workspace)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CountUrls indicates an expected call of CountUrls
+func (_mr *MockStoreMockRecorder) CountUrls(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "CountUrls", reflect.TypeOf((*MockStore)(nil).CountUrls), arg0, arg1)
+}
+
+// ForEachUrl mocks base method
+func (_m *MockStore) ForEachUrl(ctx context.Context, fn func(*ModelShorten) error) error {
+	ret := _m.ctrl.Call(_m, "ForEachUrl", ctx, fn)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ForEachUrl indicates an expected call of ForEachUrl
+func (_mr *MockStoreMockRecorder) ForEachUrl(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "ForEachUrl", reflect.TypeOf((*MockStore)(nil).ForEachUrl), arg0, arg1)
+}
+
+// ReplaceUrl mocks base method
+func (_m *MockStore) ReplaceUrl(ctx context.Context, document *ModelShorten) error {
+	ret := _m.ctrl.Call(_m, "ReplaceUrl", ctx, document)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ReplaceUrl indicates an expected call of ReplaceUrl
+func (_mr *MockStoreMockRecorder) ReplaceUrl(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "ReplaceUrl", reflect.TypeOf((*MockStore)(nil).ReplaceUrl), arg0, arg1)
+}
+
+// MockPolicy is a mock of Policy interface
+type MockPolicy struct {
+	ctrl     *gomock.Controller
+	recorder *MockPolicyMockRecorder
+}
+
+// MockPolicyMockRecorder is the mock recorder for MockPolicy
+type MockPolicyMockRecorder struct {
+	mock *MockPolicy
+}
+
+// NewMockPolicy creates a new mock instance
+func NewMockPolicy(ctrl *gomock.Controller) *MockPolicy {
+	mock := &MockPolicy{ctrl: ctrl}
+	mock.recorder = &MockPolicyMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (_m *MockPolicy) EXPECT() *MockPolicyMockRecorder {
+	return _m.recorder
+}
+
+// Check mocks base method
+func (_m *MockPolicy) Check(url string) error {
+	ret := _m.ctrl.Call(_m, "Check", url)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Check indicates an expected call of Check
+func (_mr *MockPolicyMockRecorder) Check(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "Check", reflect.TypeOf((*MockPolicy)(nil).Check), arg0)
+}
+
+// MockLocator is a mock of Locator interface
+type MockLocator struct {
+	ctrl     *gomock.Controller
+	recorder *MockLocatorMockRecorder
+}
+
+// MockLocatorMockRecorder is the mock recorder for MockLocator
+type MockLocatorMockRecorder struct {
+	mock *MockLocator
+}
+
+// NewMockLocator creates a new mock instance
+func NewMockLocator(ctrl *gomock.Controller) *MockLocator {
+	mock := &MockLocator{ctrl: ctrl}
+	mock.recorder = &MockLocatorMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (_m *MockLocator) EXPECT() *MockLocatorMockRecorder {
+	return _m.recorder
+}
+
+// Country mocks base method
+func (_m *MockLocator) Country(ip string) (string, error) {
+	ret := _m.ctrl.Call(_m, "Country", ip)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Country indicates an expected call of Country
+func (_mr *MockLocatorMockRecorder) Country(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "Country", reflect.TypeOf((*MockLocator)(nil).Country), arg0)
+}
+
+// MockRedirectChecker is a mock of RedirectChecker interface
+type MockRedirectChecker struct {
+	ctrl     *gomock.Controller
+	recorder *MockRedirectCheckerMockRecorder
+}
+
+// MockRedirectCheckerMockRecorder is the mock recorder for MockRedirectChecker
+type MockRedirectCheckerMockRecorder struct {
+	mock *MockRedirectChecker
+}
+
+// NewMockRedirectChecker creates a new mock instance
+func NewMockRedirectChecker(ctrl *gomock.Controller) *MockRedirectChecker {
+	mock := &MockRedirectChecker{ctrl: ctrl}
+	mock.recorder = &MockRedirectCheckerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (_m *MockRedirectChecker) EXPECT() *MockRedirectCheckerMockRecorder {
+	return _m.recorder
+}
+
+// Check mocks base method
+func (_m *MockRedirectChecker) Check(ctx context.Context, url string) error {
+	ret := _m.ctrl.Call(_m, "Check", ctx, url)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Check indicates an expected call of Check
+func (_mr *MockRedirectCheckerMockRecorder) Check(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "Check", reflect.TypeOf((*MockRedirectChecker)(nil).Check), arg0, arg1)
+}
+
+// MockWorkspaces is a mock of Workspaces interface
+type MockWorkspaces struct {
+	ctrl     *gomock.Controller
+	recorder *MockWorkspacesMockRecorder
+}
+
+// MockWorkspacesMockRecorder is the mock recorder for MockWorkspaces
+type MockWorkspacesMockRecorder struct {
+	mock *MockWorkspaces
+}
+
+// NewMockWorkspaces creates a new mock instance
+func NewMockWorkspaces(ctrl *gomock.Controller) *MockWorkspaces {
+	mock := &MockWorkspaces{ctrl: ctrl}
+	mock.recorder = &MockWorkspacesMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (_m *MockWorkspaces) EXPECT() *MockWorkspacesMockRecorder {
+	return _m.recorder
+}
+
+// FindWorkspace mocks base method
+func (_m *MockWorkspaces) FindWorkspace(ctx context.Context, id string) (*tenant.Workspace, error) {
+	ret := _m.ctrl.Call(_m, "FindWorkspace", ctx, id)
+	ret0, _ := ret[0].(*tenant.Workspace)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FindWorkspace indicates an expected call of FindWorkspace
+func (_mr *MockWorkspacesMockRecorder) FindWorkspace(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "FindWorkspace", reflect.TypeOf((*MockWorkspaces)(nil).FindWorkspace), arg0, arg1)
+}
+
+// MockDomains is a mock of Domains interface
+type MockDomains struct {
+	ctrl     *gomock.Controller
+	recorder *MockDomainsMockRecorder
+}
+
+// MockDomainsMockRecorder is the mock recorder for MockDomains
+type MockDomainsMockRecorder struct {
+	mock *MockDomains
+}
+
+// NewMockDomains creates a new mock instance
+func NewMockDomains(ctrl *gomock.Controller) *MockDomains {
+	mock := &MockDomains{ctrl: ctrl}
+	mock.recorder = &MockDomainsMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (_m *MockDomains) EXPECT() *MockDomainsMockRecorder {
+	return _m.recorder
+}
+
+// FindDomain mocks base method
+func (_m *MockDomains) FindDomain(ctx context.Context, host string) (*tenant.Domain, error) {
+	ret := _m.ctrl.Call(_m, "FindDomain", ctx, host)
+	ret0, _ := ret[0].(*tenant.Domain)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FindDomain indicates an expected call of FindDomain
+func (_mr *MockDomainsMockRecorder) FindDomain(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "FindDomain", reflect.TypeOf((*MockDomains)(nil).FindDomain), arg0, arg1)
+}
+
+// MockOutbox is a mock of Outbox interface
+type MockOutbox struct {
+	ctrl     *gomock.Controller
+	recorder *MockOutboxMockRecorder
+}
+
+// MockOutboxMockRecorder is the mock recorder for MockOutbox
+type MockOutboxMockRecorder struct {
+	mock *MockOutbox
+}
+
+// NewMockOutbox creates a new mock instance
+func NewMockOutbox(ctrl *gomock.Controller) *MockOutbox {
+	mock := &MockOutbox{ctrl: ctrl}
+	mock.recorder = &MockOutboxMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (_m *MockOutbox) EXPECT() *MockOutboxMockRecorder {
+	return _m.recorder
+}
+
+// Transaction mocks base method
+func (_m *MockOutbox) Transaction(ctx context.Context, fn func(context.Context) error) error {
+	ret := _m.ctrl.Call(_m, "Transaction", ctx, fn)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Transaction indicates an expected call of Transaction
+func (_mr *MockOutboxMockRecorder) Transaction(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "Transaction", reflect.TypeOf((*MockOutbox)(nil).Transaction), arg0, arg1)
+}
+
+// InsertEvent mocks base method
+func (_m *MockOutbox) InsertEvent(ctx context.Context, event Event) error {
+	ret := _m.ctrl.Call(_m, "InsertEvent", ctx, event)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// InsertEvent indicates an expected call of InsertEvent
+func (_mr *MockOutboxMockRecorder) InsertEvent(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "InsertEvent", reflect.TypeOf((*MockOutbox)(nil).InsertEvent), arg0, arg1)
+}
+
+// MockPageFetcher is a mock of PageFetcher interface
+type MockPageFetcher struct {
+	ctrl     *gomock.Controller
+	recorder *MockPageFetcherMockRecorder
+}
+
+// MockPageFetcherMockRecorder is the mock recorder for MockPageFetcher
+type MockPageFetcherMockRecorder struct {
+	mock *MockPageFetcher
+}
+
+// NewMockPageFetcher creates a new mock instance
+func NewMockPageFetcher(ctrl *gomock.Controller) *MockPageFetcher {
+	mock := &MockPageFetcher{ctrl: ctrl}
+	mock.recorder = &MockPageFetcherMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (_m *MockPageFetcher) EXPECT() *MockPageFetcherMockRecorder {
+	return _m.recorder
+}
+
+// Fetch mocks base method
+func (_m *MockPageFetcher) Fetch(ctx context.Context, url string) (*Page, error) {
+	ret := _m.ctrl.Call(_m, "Fetch", ctx, url)
+	ret0, _ := ret[0].(*Page)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Fetch indicates an expected call of Fetch
+func (_mr *MockPageFetcherMockRecorder) Fetch(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "Fetch", reflect.TypeOf((*MockPageFetcher)(nil).Fetch), arg0, arg1)
+}
+
+// MockAuditor is a mock of Auditor interface
+type MockAuditor struct {
+	ctrl     *gomock.Controller
+	recorder *MockAuditorMockRecorder
+}
+
+// MockAuditorMockRecorder is the mock recorder for MockAuditor
+type MockAuditorMockRecorder struct {
+	mock *MockAuditor
+}
+
+// NewMockAuditor creates a new mock instance
+func NewMockAuditor(ctrl *gomock.Controller) *MockAuditor {
+	mock := &MockAuditor{ctrl: ctrl}
+	mock.recorder = &MockAuditorMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (_m *MockAuditor) EXPECT() *MockAuditorMockRecorder {
+	return _m.recorder
+}
+
+// InsertAudit mocks base method
+func (_m *MockAuditor) InsertAudit(ctx context.Context, entry audit.Entry) error {
+	ret := _m.ctrl.Call(_m, "InsertAudit", ctx, entry)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// InsertAudit indicates an expected call of InsertAudit
+func (_mr *MockAuditorMockRecorder) InsertAudit(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "InsertAudit", reflect.TypeOf((*MockAuditor)(nil).InsertAudit), arg0, arg1)
+}
+
+// FindAudit mocks base method
+func (_m *MockAuditor) FindAudit(ctx context.Context, filter audit.Filter) ([]audit.Entry, error) {
+	ret := _m.ctrl.Call(_m, "FindAudit", ctx, filter)
+	ret0, _ := ret[0].([]audit.Entry)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FindAudit indicates an expected call of FindAudit
+func (_mr *MockAuditorMockRecorder) FindAudit(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "FindAudit", reflect.TypeOf((*MockAuditor)(nil).FindAudit), arg0, arg1)
 }
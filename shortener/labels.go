@@ -0,0 +1,195 @@
+package shortener
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gsiragusa/short-to-me/audit"
+	"github.com/gsiragusa/short-to-me/errors"
+)
+
+// Limits of the labels of a short url
+const (
+	maxTitleLength       = 200
+	maxDescriptionLength = 2000
+	maxTags              = 20
+	maxMetadata          = 20
+	maxMetadataValue     = 500
+)
+
+// labelPattern matches the tags
+var labelPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.:-]{0,49}$`)
+
+// metadataKeyPattern matches the metadata keys. They have no :, which separates them from their
+// value in the filters of the listings
+var metadataKeyPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{0,49}$`)
+
+// metadataKeyRule describes the metadata keys matched by metadataKeyPattern
+const metadataKeyRule = "key must be up to 50 lower case letters, digits, _, . or -, starting with a letter or a digit"
+
+// Labels organize the short urls, they don't change the redirects
+type Labels struct {
+	Title       string `json:"title,omitempty" bson:"title,omitempty"`
+	Description string `json:"description,omitempty" bson:"description,omitempty"`
+	// Tags group the short urls, for example by campaign or product
+	Tags []string `json:"tags,omitempty" bson:"tags,omitempty"`
+	// Metadata are free key/value pairs
+	Metadata map[string]string `json:"metadata,omitempty" bson:"metadata,omitempty"`
+}
+
+func (l Labels) empty() bool {
+	return l.Title == "" && l.Description == "" && len(l.Tags) == 0 && len(l.Metadata) == 0
+}
+
+// normalized returns the labels with trimmed texts and sorted, lower case and unique tags
+func (l Labels) normalized() Labels {
+	res := Labels{
+		Title:       strings.TrimSpace(l.Title),
+		Description: strings.TrimSpace(l.Description),
+		Metadata:    l.Metadata,
+	}
+	seen := map[string]bool{}
+	for _, tag := range l.Tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if !seen[tag] {
+			seen[tag] = true
+			res.Tags = append(res.Tags, tag)
+		}
+	}
+	sort.Strings(res.Tags)
+	if len(res.Metadata) == 0 {
+		res.Metadata = nil
+	}
+	return res
+}
+
+// validateLabels adds the details of the invalid labels to e, they are expected normalized
+func validateLabels(e errors.Error, l Labels) errors.Error {
+	if utf8.RuneCountInString(l.Title) > maxTitleLength {
+		e = e.WithDetail("title", fmt.Sprintf("must be at most %d characters", maxTitleLength))
+	}
+	if utf8.RuneCountInString(l.Description) > maxDescriptionLength {
+		e = e.WithDetail("description", fmt.Sprintf("must be at most %d characters", maxDescriptionLength))
+	}
+
+	if len(l.Tags) > maxTags {
+		e = e.WithDetail("tags", fmt.Sprintf("must be at most %d", maxTags))
+	}
+	for _, tag := range l.Tags {
+		if !labelPattern.MatchString(tag) {
+			e = e.WithDetail("tags", "must be up to 50 letters, digits, _, ., : or -, starting with a letter or a digit")
+			break
+		}
+	}
+
+	if len(l.Metadata) > maxMetadata {
+		e = e.WithDetail("metadata", fmt.Sprintf("must have at most %d keys", maxMetadata))
+	}
+	keys := make([]string, 0, len(l.Metadata))
+	for key := range l.Metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		field := "metadata." + key
+		if !metadataKeyPattern.MatchString(key) {
+			e = e.WithDetail(field, metadataKeyRule)
+		} else if utf8.RuneCountInString(l.Metadata[key]) > maxMetadataValue {
+			e = e.WithDetail(field, fmt.Sprintf("must be at most %d characters", maxMetadataValue))
+		}
+	}
+	return e
+}
+
+// LinkUpdate changes the labels of a short url, the nil fields are left unchanged
+type LinkUpdate struct {
+	Title       *string   `json:"title,omitempty"`
+	Description *string   `json:"description,omitempty"`
+	Tags        *[]string `json:"tags,omitempty"`
+	// Metadata replaces the keys it has, an empty value removes the key
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+func (u LinkUpdate) empty() bool {
+	return u.Title == nil && u.Description == nil && u.Tags == nil && len(u.Metadata) == 0
+}
+
+// apply returns the labels l changed by the update
+func (u LinkUpdate) apply(l Labels) Labels {
+	if u.Title != nil {
+		l.Title = *u.Title
+	}
+	if u.Description != nil {
+		l.Description = *u.Description
+	}
+	if u.Tags != nil {
+		l.Tags = *u.Tags
+	}
+	if len(u.Metadata) > 0 {
+		metadata := make(map[string]string, len(l.Metadata)+len(u.Metadata))
+		for key, value := range l.Metadata {
+			metadata[key] = value
+		}
+		for key, value := range u.Metadata {
+			if value == "" {
+				delete(metadata, key)
+			} else {
+				metadata[key] = value
+			}
+		}
+		l.Metadata = metadata
+	}
+	return l.normalized()
+}
+
+// service method that changes the labels of a short url, returning the updated short url
+func (s *service) UpdateUrl(ctx context.Context, url string, update LinkUpdate) (*ModelShorten, *errors.Error) {
+	le := s.le.WithField("url", url)
+	le.Info("requested update url")
+
+	// get the id from the last part of the url
+	split := strings.Split(url, "/")
+	id := split[len(split)-1]
+
+	if update.empty() {
+		le.Error("empty update")
+		e := errors.NewErrorBadRequest().WithDetail("body", "must change title, description, tags or metadata")
+		return nil, &e
+	}
+
+	existing, err := s.store.FindById(ctx, id)
+	if err != nil {
+		le.WithError(err).Error("url was not found")
+		return nil, &errorNotFound
+	}
+
+	labels := update.apply(existing.Labels)
+	if e := validateLabels(errors.NewErrorBadRequest(), labels); len(e.Details) > 0 {
+		le.Errorf("invalid labels: %v", e.Details)
+		return nil, &e
+	}
+
+	var res *ModelShorten
+	err = s.transaction(ctx, func(ctx context.Context) error {
+		var err error
+		if res, err = s.store.UpdateLabels(ctx, id, labels); err != nil {
+			return err
+		}
+		return s.emit(ctx, linkEvent(EventLinkUpdated, res))
+	})
+	if err != nil {
+		le.WithError(err).Error("unable to update url")
+		if err == ErrNotFound {
+			return nil, &errorNotFound
+		}
+		return nil, &internalServerError
+	}
+
+	le.Info("url updated")
+	s.record(ctx, audit.ActionUpdate, id, existing, res, nil)
+	return res, nil
+}
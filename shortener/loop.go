@@ -0,0 +1,92 @@
+package shortener
+
+import (
+	"context"
+	"fmt"
+	neturl "net/url"
+	"strings"
+
+	"github.com/gsiragusa/short-to-me/errors"
+)
+
+// maxSelfHops bounds the short urls of the service followed to resolve a destination
+const maxSelfHops = 5
+
+// unshorten resolves a destination on the hosts of the service to the destination of the short
+// url it references, which has to redirect unconditionally. It returns the reason why it can't
+// be resolved otherwise
+func (s *service) unshorten(ctx context.Context, destination string) (string, string) {
+	seen := map[string]bool{}
+	for hops := 0; ; hops++ {
+		u, err := neturl.Parse(destination)
+		if err != nil {
+			return destination, ""
+		}
+		domain, ok := s.shortDomain(ctx, u)
+		if !ok {
+			return destination, ""
+		}
+		if hops == maxSelfHops {
+			return destination, fmt.Sprintf("follows more than %d short urls", maxSelfHops)
+		}
+
+		// the preview of a short url has the same destination
+		id := strings.TrimSuffix(strings.TrimPrefix(u.Path, "/"), "+")
+		if id == "" || strings.Contains(id, "/") {
+			return destination, "links to this service"
+		}
+		if seen[id] {
+			return destination, "redirects back to " + id
+		}
+		seen[id] = true
+
+		existing, err := s.store.FindById(ctx, id)
+		if err != nil || existing.Domain != domain {
+			return destination, "links to an unknown short url"
+		}
+		if !existing.plain() {
+			return destination, "links to a short url with options, use its destination instead"
+		}
+		destination = existing.Url
+	}
+}
+
+// unshortenAll resolves the destinations of a new short url on the hosts of the service,
+// returning an error detailing the ones that can't be resolved
+func (s *service) unshortenAll(ctx context.Context, url string, opts LinkOptions) (string, LinkOptions, *errors.Error) {
+	var res *errors.Error
+	resolve := func(field, destination string) string {
+		target, reason := s.unshorten(ctx, destination)
+		if reason != "" {
+			res = withLoop(res, field, reason)
+		}
+		return target
+	}
+	url = resolve("url", url)
+	opts = opts.mapDestinations(resolve)
+	return url, opts, res
+}
+
+// checkRedirects returns an error detailing the destinations whose redirects are refused
+func (s *service) checkRedirects(ctx context.Context, destinations []Destination) *errors.Error {
+	if s.redirects == nil {
+		return nil
+	}
+	var res *errors.Error
+	for _, d := range destinations {
+		if err := s.redirects.Check(ctx, d.Url); err != nil {
+			res = withLoop(res, d.Field, err.Error())
+		}
+	}
+	return res
+}
+
+func withLoop(res *errors.Error, field, reason string) *errors.Error {
+	var e errors.Error
+	if res == nil {
+		e = errors.NewErrorRedirectLoop(field, reason)
+	} else {
+		e = res.WithDetail(field, reason)
+	}
+	return &e
+}
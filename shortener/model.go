@@ -1,7 +1,250 @@
 package shortener
 
+import (
+	"net/url"
+	"time"
+
+	"github.com/gsiragusa/short-to-me/errors"
+)
+
 type ModelShorten struct {
-	Id    string `json:"-" bson:"_id"`
-	Url   string `json:"url" bson:"url"`
-	Count int64  `json:"-" bson:"count"`
+	Id        string `json:"id" bson:"_id"`
+	Workspace string `json:"workspace,omitempty" bson:"workspace,omitempty"`
+	// Domain is the custom domain serving the short url, empty for the short hosts
+	Domain         string            `json:"domain,omitempty" bson:"domain,omitempty"`
+	Url            string            `json:"url" bson:"url"`
+	Count          int64             `json:"count" bson:"count"`
+	CreatedAt      time.Time         `json:"created_at" bson:"created_at,omitempty"`
+	PasswordHash   string            `json:"-" bson:"password_hash,omitempty"`
+	MaxClicks      int64             `json:"max_clicks,omitempty" bson:"max_clicks,omitempty"`
+	NotBefore      *time.Time        `json:"not_before,omitempty" bson:"not_before,omitempty"`
+	NotAfter       *time.Time        `json:"not_after,omitempty" bson:"not_after,omitempty"`
+	PendingUrl     string            `json:"pending_url,omitempty" bson:"pending_url,omitempty"`
+	PlatformUrls   map[string]string `json:"platform_urls,omitempty" bson:"platform_urls,omitempty"`
+	CountryUrls    map[string]string `json:"country_urls,omitempty" bson:"country_urls,omitempty"`
+	Variants       []Variant         `json:"variants,omitempty" bson:"variants,omitempty"`
+	StickyVariants bool              `json:"sticky_variants,omitempty" bson:"sticky_variants,omitempty"`
+	ForwardPath    bool              `json:"forward_path,omitempty" bson:"forward_path,omitempty"`
+	Preview        bool              `json:"preview,omitempty" bson:"preview,omitempty"`
+	QueryPolicy    string            `json:"query_policy,omitempty" bson:"query_policy,omitempty"`
+	Utm            map[string]string `json:"utm,omitempty" bson:"utm,omitempty"`
+	Clicks         ClickStats        `json:"clicks" bson:"clicks,omitempty"`
+	Health         *Health           `json:"health,omitempty" bson:"health,omitempty"`
+	Page           *Page             `json:"page,omitempty" bson:"page,omitempty"`
+	Labels         `bson:",inline"`
+	// DeletedAt is set on the short urls in the trash, hidden until they are restored or purged
+	DeletedAt *time.Time `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
+	// ExpiredAt is set once the expiry of a short url with not_after was emitted
+	ExpiredAt *time.Time `json:"expired_at,omitempty" bson:"expired_at,omitempty"`
+}
+
+// Health is the outcome of the last check of the destinations of a short url
+type Health struct {
+	CheckedAt time.Time `json:"checked_at" bson:"checked_at"`
+	// Broken is set when one of the destinations is broken
+	Broken       bool                `json:"broken" bson:"broken"`
+	Destinations []DestinationHealth `json:"destinations" bson:"destinations"`
+}
+
+// DestinationHealth is the response of a destination to a check
+type DestinationHealth struct {
+	Destination `bson:",inline"`
+	// Status is the status code of the response, after the redirects, 0 when there was no response
+	Status    int    `json:"status,omitempty" bson:"status,omitempty"`
+	LatencyMs int64  `json:"latency_ms" bson:"latency_ms"`
+	Error     string `json:"error,omitempty" bson:"error,omitempty"`
+	Broken    bool   `json:"broken" bson:"broken"`
+}
+
+// Page describes the destination of a short url, as fetched after its creation
+type Page struct {
+	FetchedAt   time.Time `json:"fetched_at" bson:"fetched_at"`
+	Title       string    `json:"title,omitempty" bson:"title,omitempty"`
+	Description string    `json:"description,omitempty" bson:"description,omitempty"`
+	// OpenGraph and Twitter are the og: and twitter: properties of the page, without their prefix
+	OpenGraph map[string]string `json:"open_graph,omitempty" bson:"open_graph,omitempty"`
+	Twitter   map[string]string `json:"twitter,omitempty" bson:"twitter,omitempty"`
+	Favicon   string            `json:"favicon,omitempty" bson:"favicon,omitempty"`
+	// Error is the reason why the page could not be read
+	Error string `json:"error,omitempty" bson:"error,omitempty"`
+}
+
+// ClickStats breaks down the redirects of a short url
+type ClickStats struct {
+	// Destinations counts the redirects by the key of the destination served:
+	// a platform, a country, or DestinationDefault for Url
+	Destinations map[string]int64 `json:"destinations,omitempty" bson:"destinations,omitempty"`
+	// Countries counts the redirects by country of the visitors, when it is known
+	Countries map[string]int64 `json:"countries,omitempty" bson:"countries,omitempty"`
+	// Variants counts the redirects by variant served
+	Variants map[string]int64 `json:"variants,omitempty" bson:"variants,omitempty"`
+}
+
+// IsZero reports whether no breakdown was recorded, omitting the stats from the stored document
+func (c ClickStats) IsZero() bool {
+	return len(c.Destinations) == 0 && len(c.Countries) == 0 && len(c.Variants) == 0
+}
+
+// Click describes a redirect, to update the click stats
+type Click struct {
+	Destination string
+	Country     string
+	Variant     string
+}
+
+// Counts is the number of redirects of a short url with their breakdown
+type Counts struct {
+	Total int64 `json:"count"`
+	ClickStats
+}
+
+// plain reports whether the short url redirects unconditionally, so that it can be shared
+// by every request to shorten the same url without options
+func (m *ModelShorten) plain() bool {
+	return m.PasswordHash == "" && m.MaxClicks == 0 && m.NotBefore == nil && m.NotAfter == nil &&
+		len(m.PlatformUrls) == 0 && len(m.CountryUrls) == 0 && len(m.Variants) == 0 &&
+		!m.ForwardPath && !m.Preview && (m.QueryPolicy == "" || m.QueryPolicy == QueryDrop) && len(m.Utm) == 0
+}
+
+// LinkOptions customizes the behavior of a new short url
+type LinkOptions struct {
+	// Password protects the redirect, visitors have to provide it before being redirected
+	Password string `json:"password,omitempty"`
+	// MaxClicks is the number of redirects after which the short url stops redirecting, 1 makes
+	// a burn after reading short url
+	MaxClicks int64 `json:"max_clicks,omitempty"`
+	// NotBefore and NotAfter delimit the period in which the short url redirects
+	NotBefore *time.Time `json:"not_before,omitempty"`
+	NotAfter  *time.Time `json:"not_after,omitempty"`
+	// PendingUrl is the destination of the visits before NotBefore, replacing the coming soon response
+	PendingUrl string `json:"pending_url,omitempty"`
+	// PlatformUrls are alternate destinations by platform: ios, android or desktop
+	PlatformUrls map[string]string `json:"platform_urls,omitempty"`
+	// CountryUrls are alternate destinations by ISO 3166-1 alpha-2 country code of the visitors
+	CountryUrls map[string]string `json:"country_urls,omitempty"`
+	// Variants rotate the visitors that are redirected to the url across several destinations
+	Variants []Variant `json:"variants,omitempty"`
+	// StickyVariants keeps redirecting a visitor to the first variant it was served
+	StickyVariants bool `json:"sticky_variants,omitempty"`
+	// ForwardPath makes a prefix short url, redirecting /{id}/rest/of/path to the destination
+	// followed by /rest/of/path
+	ForwardPath bool `json:"forward_path,omitempty"`
+	// Preview shows the destination to the visitors, who confirm before being redirected
+	Preview bool `json:"preview,omitempty"`
+	// QueryPolicy passes the query parameters of the visits to the destination: drop, merge or override
+	QueryPolicy string `json:"query_policy,omitempty"`
+	// Utm are utm parameters added to the destination, their values can use the {id}, {platform},
+	// {country} and {variant} placeholders
+	Utm map[string]string `json:"utm,omitempty"`
+	// Domain is the custom domain serving the short url, registered for its workspace
+	Domain string `json:"domain,omitempty"`
+	Labels
+}
+
+func (o LinkOptions) empty() bool {
+	return o.Password == "" && o.MaxClicks == 0 && o.NotBefore == nil && o.NotAfter == nil &&
+		len(o.PlatformUrls) == 0 && len(o.CountryUrls) == 0 && len(o.Variants) == 0 && !o.StickyVariants &&
+		!o.ForwardPath && !o.Preview && o.QueryPolicy == "" && len(o.Utm) == 0 && o.Domain == "" && o.Labels.empty()
+}
+
+// Redirect is the destination of a visit
+type Redirect struct {
+	Url string
+	// Permanent is set when every visit gets the same destination, so that it can be cached
+	Permanent bool
+	// StickyVariant is the variant served, to remember for the visitor of a short url with sticky variants
+	StickyVariant string
+	// Preview is set instead of redirecting, for the short urls with preview
+	Preview *Preview
+}
+
+// Preview describes the destination of a short url to its visitors, before they follow it
+type Preview struct {
+	Id        string
+	Url       string
+	Host      string
+	CreatedAt time.Time
+	Count     int64
+	// Title and Description are the ones of the short url, or of its destination page
+	Title       string
+	Description string
+}
+
+func newPreview(u *ModelShorten, destination string) *Preview {
+	host := ""
+	if parsed, err := url.Parse(destination); err == nil {
+		host = parsed.Hostname()
+	}
+	res := &Preview{
+		Id:          u.Id,
+		Url:         destination,
+		Host:        host,
+		CreatedAt:   u.CreatedAt,
+		Count:       u.Count,
+		Title:       u.Title,
+		Description: u.Description,
+	}
+	if u.Page != nil {
+		res.Title = firstNonEmpty(res.Title, u.Page.OpenGraph["title"], u.Page.Title)
+		res.Description = firstNonEmpty(res.Description, u.Page.OpenGraph["description"], u.Page.Description)
+	}
+	return res
+}
+
+// Visit describes the request of a visitor following a short url
+type Visit struct {
+	// Password is the password provided by the visitor of a protected short url
+	Password string
+	// ClientIp identifies the visitor, to throttle repeated failures
+	ClientIp string
+	// UserAgent selects the destination by platform
+	UserAgent string
+	// Variant is the variant previously served to the visitor
+	Variant string
+	// Confirmed is set when the visitor confirmed the redirect from the preview
+	Confirmed bool
+	// Path is the escaped path following the id of the short url in the visit, if any
+	Path string
+	// Query is the query of the visit, passed to the destination according to the query policy
+	Query url.Values
+	// Domain is the custom domain the visit was sent to, empty for the short hosts
+	Domain string
+}
+
+// ListOptions paginates a listing of short urls, sorted by id
+type ListOptions struct {
+	Offset int64
+	Limit  int64
+	// Broken lists only the short urls with a broken destination at their last check
+	Broken bool
+	// Deleted lists the short urls in the trash instead of the active ones
+	Deleted bool
+	// Tags lists only the short urls having all of them
+	Tags []string
+	// Metadata lists only the short urls having all of these key/value pairs
+	Metadata map[string]string
+	// Search lists only the short urls whose title contains it, ignoring the case
+	Search string
+}
+
+// Stats aggregates the short urls stored
+type Stats struct {
+	Urls      int64 `json:"urls" bson:"urls"`
+	Redirects int64 `json:"redirects" bson:"redirects"`
+}
+
+// ExportResult summarizes the outcome of an export
+type ExportResult struct {
+	Exported int `json:"exported"`
+	// Skipped counts the short urls the format can't carry
+	Skipped int `json:"skipped"`
+}
+
+// ImportResult summarizes the outcome of an import
+type ImportResult struct {
+	Created     int `json:"created"`
+	Overwritten int `json:"overwritten"`
+	Skipped     int `json:"skipped"`
+	// Refused details the records skipped because they are not valid short urls
+	Refused []errors.FieldError `json:"refused,omitempty"`
 }
@@ -0,0 +1,117 @@
+package shortener
+
+import (
+	neturl "net/url"
+	"strings"
+
+	"github.com/gsiragusa/short-to-me/errors"
+	"github.com/gsiragusa/short-to-me/tenant"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// validate returns an error detailing every invalid option
+func (o LinkOptions) validate() *errors.Error {
+	e := errors.NewErrorBadRequest()
+	if o.MaxClicks < 0 {
+		e = e.WithDetail("max_clicks", "must not be negative")
+	}
+	if o.NotBefore != nil && o.NotAfter != nil && !o.NotAfter.After(*o.NotBefore) {
+		e = e.WithDetail("not_after", "must be after not_before")
+	}
+	if o.PendingUrl != "" {
+		if o.NotBefore == nil {
+			e = e.WithDetail("pending_url", "requires not_before")
+		}
+		if !absoluteUrl(o.PendingUrl) {
+			e = e.WithDetail("pending_url", "must be an absolute http url")
+		}
+	}
+
+	for platform, url := range o.PlatformUrls {
+		field := "platform_urls." + platform
+		if !knownPlatform(platform) {
+			e = e.WithDetail(field, "platform must be one of ios, android or desktop")
+		} else if !absoluteUrl(url) {
+			e = e.WithDetail(field, "must be an absolute http url")
+		}
+	}
+
+	for country, url := range o.CountryUrls {
+		field := "country_urls." + country
+		if !countryCode(country) {
+			e = e.WithDetail(field, "country must be an ISO 3166-1 alpha-2 code")
+		} else if !absoluteUrl(url) {
+			e = e.WithDetail(field, "must be an absolute http url")
+		}
+	}
+
+	e = validateVariants(e, o.Variants)
+	e = validateQuery(e, o.QueryPolicy, o.Utm)
+	e = validateLabels(e, o.Labels.normalized())
+	if o.StickyVariants && len(o.Variants) == 0 {
+		e = e.WithDetail("sticky_variants", "requires variants")
+	}
+
+	if len(e.Details) > 0 {
+		return &e
+	}
+	return nil
+}
+
+// apply sets the options on the model of a new short url
+func (o LinkOptions) apply(u *ModelShorten) error {
+	u.MaxClicks = o.MaxClicks
+	u.NotBefore = o.NotBefore
+	u.NotAfter = o.NotAfter
+	u.PendingUrl = o.PendingUrl
+	u.PlatformUrls = o.PlatformUrls
+	u.Variants = o.Variants
+	u.StickyVariants = o.StickyVariants
+	u.ForwardPath = o.ForwardPath
+	u.Preview = o.Preview
+	u.QueryPolicy = o.QueryPolicy
+	u.Utm = o.Utm
+	u.Labels = o.Labels.normalized()
+	u.Domain = tenant.NormalizeHost(o.Domain)
+	if len(o.CountryUrls) > 0 {
+		u.CountryUrls = make(map[string]string, len(o.CountryUrls))
+		for country, url := range o.CountryUrls {
+			u.CountryUrls[strings.ToUpper(country)] = url
+		}
+	}
+
+	if o.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(o.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return err
+		}
+		u.PasswordHash = string(hash)
+	}
+	return nil
+}
+
+// options returns the options of a stored short url, the password aside
+func (m *ModelShorten) options() LinkOptions {
+	return LinkOptions{
+		MaxClicks:      m.MaxClicks,
+		NotBefore:      m.NotBefore,
+		NotAfter:       m.NotAfter,
+		PendingUrl:     m.PendingUrl,
+		PlatformUrls:   m.PlatformUrls,
+		CountryUrls:    m.CountryUrls,
+		Variants:       m.Variants,
+		StickyVariants: m.StickyVariants,
+		ForwardPath:    m.ForwardPath,
+		Preview:        m.Preview,
+		QueryPolicy:    m.QueryPolicy,
+		Utm:            m.Utm,
+		Domain:         m.Domain,
+		Labels:         m.Labels,
+	}
+}
+
+// absoluteUrl reports whether raw is an absolute http or https url
+func absoluteUrl(raw string) bool {
+	u, err := neturl.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
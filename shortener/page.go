@@ -0,0 +1,41 @@
+package shortener
+
+import (
+	"context"
+	"time"
+)
+
+// fetchPage reads the destination page of a new short url and records it, with the reason of the
+// failure when it can't be read. It runs after the response to the creation, without its context
+func (s *service) fetchPage(id, url string) {
+	defer s.fetches.Done()
+	le := s.le.WithField("id", id).WithField("url", url)
+	ctx := context.Background()
+
+	page, err := s.pages.Fetch(ctx, url)
+	if err != nil {
+		le.WithError(err).Warn("unable to fetch the destination page")
+		page = &Page{FetchedAt: time.Now().UTC(), Error: err.Error()}
+	}
+	if err := s.store.UpdatePage(ctx, id, *page); err != nil {
+		le.WithError(err).Error("unable to record the destination page")
+		return
+	}
+	le.Info("destination page fetched")
+}
+
+// firstNonEmpty returns the first of values that is not empty
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// service method that returns once the destination pages being fetched are recorded, so that the
+// commands don't exit before them
+func (s *service) Wait() {
+	s.fetches.Wait()
+}
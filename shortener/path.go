@@ -0,0 +1,41 @@
+package shortener
+
+import (
+	neturl "net/url"
+	"strings"
+)
+
+// validPath reports whether the path of a visit can be appended to a destination: it must be
+// absolute and not climb out of the path of the destination
+func validPath(path string) bool {
+	if !strings.HasPrefix(path, "/") {
+		return false
+	}
+	for _, segment := range strings.Split(path, "/") {
+		if unescaped, err := neturl.PathUnescape(segment); err != nil || unescaped == "." || unescaped == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// withPath returns the destination with the escaped path appended to its path, keeping its query
+// and fragment
+func withPath(destination string, path string) string {
+	if path == "" {
+		return destination
+	}
+	parsed, err := neturl.Parse(destination)
+	if err != nil {
+		return destination
+	}
+
+	escaped := strings.TrimSuffix(parsed.EscapedPath(), "/") + path
+	unescaped, err := neturl.PathUnescape(escaped)
+	if err != nil {
+		return destination
+	}
+	parsed.Path = unescaped
+	parsed.RawPath = escaped
+	return parsed.String()
+}
@@ -0,0 +1,55 @@
+package shortener
+
+import "strings"
+
+// Platforms that can have their own destination
+const (
+	PlatformIos     = "ios"
+	PlatformAndroid = "android"
+	PlatformDesktop = "desktop"
+
+	// DestinationDefault is the destination key of the main url of a short url
+	DestinationDefault = "default"
+)
+
+var platforms = []string{PlatformIos, PlatformAndroid, PlatformDesktop}
+
+// detectPlatform returns the platform of the visitor from its user agent.
+// Unknown agents, crawlers included, are treated as desktop
+func detectPlatform(userAgent string) string {
+	switch {
+	case strings.Contains(userAgent, "iPhone"),
+		strings.Contains(userAgent, "iPad"),
+		strings.Contains(userAgent, "iPod"):
+		return PlatformIos
+	case strings.Contains(userAgent, "Android"):
+		return PlatformAndroid
+	default:
+		return PlatformDesktop
+	}
+}
+
+// destination returns the url the visitor from country is redirected to, with the key it is
+// counted by. The url of the platform of the visitor comes first, then the url of its country
+func destination(u *ModelShorten, visit Visit, country string) (string, string) {
+	if len(u.PlatformUrls) == 0 && len(u.CountryUrls) == 0 {
+		return u.Url, ""
+	}
+	platform := detectPlatform(visit.UserAgent)
+	if url, ok := u.PlatformUrls[platform]; ok {
+		return url, platform
+	}
+	if url, ok := u.CountryUrls[country]; ok && country != "" {
+		return url, country
+	}
+	return u.Url, DestinationDefault
+}
+
+func knownPlatform(platform string) bool {
+	for _, p := range platforms {
+		if p == platform {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,25 @@
+package shortener
+
+import "github.com/gsiragusa/short-to-me/errors"
+
+// checkPolicy returns an error detailing the destinations refused by the policy
+func (s *service) checkPolicy(destinations []Destination) *errors.Error {
+	if s.policy == nil {
+		return nil
+	}
+	var res *errors.Error
+	for _, d := range destinations {
+		err := s.policy.Check(d.Url)
+		if err == nil {
+			continue
+		}
+		if res == nil {
+			e := errors.NewErrorUrlBlocked(d.Field, err.Error())
+			res = &e
+		} else {
+			e := res.WithDetail(d.Field, err.Error())
+			res = &e
+		}
+	}
+	return res
+}
@@ -0,0 +1,156 @@
+package shortener
+
+import (
+	neturl "net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gsiragusa/short-to-me/errors"
+)
+
+// Policies for the query parameters of the visits
+const (
+	// QueryDrop ignores the query of the visits, the default
+	QueryDrop = "drop"
+	// QueryMerge adds the parameters of the visit missing from the destination
+	QueryMerge = "merge"
+	// QueryOverride adds the parameters of the visit, replacing the ones of the destination
+	QueryOverride = "override"
+)
+
+// utmParameters are the parameters that can be set by the utm option
+var utmParameters = map[string]bool{
+	"utm_source":   true,
+	"utm_medium":   true,
+	"utm_campaign": true,
+	"utm_term":     true,
+	"utm_content":  true,
+	"utm_id":       true,
+}
+
+// utmPlaceholder matches the placeholders of the utm values, replaced on every visit
+var utmPlaceholder = regexp.MustCompile(`\{[^{}]*\}`)
+
+var utmPlaceholders = map[string]bool{
+	"{id}":       true,
+	"{platform}": true,
+	"{country}":  true,
+	"{variant}":  true,
+}
+
+// validateQuery adds the details of the invalid query options to e
+func validateQuery(e errors.Error, policy string, utm map[string]string) errors.Error {
+	switch policy {
+	case "", QueryDrop, QueryMerge, QueryOverride:
+	default:
+		e = e.WithDetail("query_policy", "must be drop, merge or override")
+	}
+	for param, value := range utm {
+		field := "utm." + param
+		if !utmParameters[param] {
+			e = e.WithDetail(field, "must be one of utm_source, utm_medium, utm_campaign, utm_term, utm_content or utm_id")
+			continue
+		}
+		if value == "" {
+			e = e.WithDetail(field, "must not be empty")
+		}
+		for _, placeholder := range utmPlaceholder.FindAllString(value, -1) {
+			if !utmPlaceholders[placeholder] {
+				e = e.WithDetail(field, "unknown placeholder "+placeholder+", expected {id}, {platform}, {country} or {variant}")
+			}
+		}
+	}
+	return e
+}
+
+// queryParam is a parameter of a query, kept as it was encoded
+type queryParam struct {
+	key string
+	raw string
+}
+
+// withQuery returns the destination with the parameters of the visit, according to the policy of
+// the short url, and its utm parameters. The parameters of the destination that are kept are left
+// as they were encoded. The utm parameters are only added when neither the destination nor the
+// visit have them
+func withQuery(destination string, u *ModelShorten, query neturl.Values, values map[string]string) string {
+	if len(u.Utm) == 0 && (len(query) == 0 || u.QueryPolicy == "" || u.QueryPolicy == QueryDrop) {
+		return destination
+	}
+
+	parsed, err := neturl.Parse(destination)
+	if err != nil {
+		return destination
+	}
+
+	var params []queryParam
+	present := map[string]bool{}
+	for _, raw := range strings.Split(parsed.RawQuery, "&") {
+		if raw == "" {
+			continue
+		}
+		key := raw
+		if i := strings.Index(raw, "="); i >= 0 {
+			key = raw[:i]
+		}
+		if k, err := neturl.QueryUnescape(key); err == nil {
+			key = k
+		}
+		if u.QueryPolicy == QueryOverride && len(query[key]) > 0 {
+			continue
+		}
+		params = append(params, queryParam{key: key, raw: raw})
+		present[key] = true
+	}
+
+	if u.QueryPolicy == QueryMerge || u.QueryPolicy == QueryOverride {
+		keys := make([]string, 0, len(query))
+		for key := range query {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if present[key] {
+				continue
+			}
+			for _, value := range query[key] {
+				params = append(params, encodeParam(key, value))
+			}
+			present[key] = true
+		}
+	}
+
+	replacer := utmReplacer(values)
+	keys := make([]string, 0, len(u.Utm))
+	for key := range u.Utm {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !present[key] {
+			params = append(params, encodeParam(key, replacer.Replace(u.Utm[key])))
+		}
+	}
+
+	raw := make([]string, len(params))
+	for i, p := range params {
+		raw[i] = p.raw
+	}
+	parsed.RawQuery = strings.Join(raw, "&")
+	parsed.ForceQuery = false
+	return parsed.String()
+}
+
+func encodeParam(key, value string) queryParam {
+	return queryParam{key: key, raw: neturl.QueryEscape(key) + "=" + neturl.QueryEscape(value)}
+}
+
+// utmReplacer replaces the placeholders of the utm values, the unknown values are left empty
+func utmReplacer(values map[string]string) *strings.Replacer {
+	var pairs []string
+	for placeholder := range utmPlaceholders {
+		pairs = append(pairs, placeholder, values[placeholder])
+	}
+	return strings.NewReplacer(pairs...)
+}
@@ -3,74 +3,216 @@ package shortener
 import (
 	"context"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/gsiragusa/short-to-me/audit"
 	"github.com/gsiragusa/short-to-me/config"
 	"github.com/gsiragusa/short-to-me/errors"
 	"github.com/sirupsen/logrus"
-	"github.com/speps/go-hashids"
 )
 
 type service struct {
-	le     *logrus.Logger
-	config *config.AppConfig
-	store  Store
+	le         *logrus.Logger
+	config     *config.AppConfig
+	store      Store
+	passwords  *throttle
+	locator    Locator
+	policy     Policy
+	redirects  RedirectChecker
+	auditor    Auditor
+	pages      PageFetcher
+	workspaces Workspaces
+	domains    Domains
+	outbox     Outbox
+
+	ids idSequence
+	// fetches tracks the destination pages fetched in the background
+	fetches sync.WaitGroup
+}
+
+// Option configures the optional dependencies of the service
+type Option func(*service)
+
+// WithLocator enables the country urls and the country breakdown of the redirects
+func WithLocator(locator Locator) Option {
+	return func(s *service) {
+		s.locator = locator
+	}
 }
 
-func NewService(le *logrus.Logger, appConfig *config.AppConfig, store Store) Service {
-	return &service{
-		le:     le,
-		config: appConfig,
-		store:  store,
+// WithPolicy refuses the destinations rejected by policy, at creation and on every visit
+func WithPolicy(policy Policy) Option {
+	return func(s *service) {
+		s.policy = policy
 	}
 }
 
+// WithRedirectChecker refuses the new short urls whose destinations redirect in a loop
+func WithRedirectChecker(checker RedirectChecker) Option {
+	return func(s *service) {
+		s.redirects = checker
+	}
+}
+
+// WithAuditor records the management operations in the audit log
+func WithAuditor(auditor Auditor) Option {
+	return func(s *service) {
+		s.auditor = auditor
+	}
+}
+
+// WithPageFetcher reads the destination page of the new short urls in the background
+func WithPageFetcher(pages PageFetcher) Option {
+	return func(s *service) {
+		s.pages = pages
+	}
+}
+
+// WithWorkspaces enforces the quotas of the workspaces
+func WithWorkspaces(workspaces Workspaces) Option {
+	return func(s *service) {
+		s.workspaces = workspaces
+	}
+}
+
+// WithDomains serves short urls on the custom domains of the workspaces
+func WithDomains(domains Domains) Option {
+	return func(s *service) {
+		s.domains = domains
+	}
+}
+
+// WithOutbox emits the events of the short urls to outbox, in the transactions of their mutations
+func WithOutbox(outbox Outbox) Option {
+	return func(s *service) {
+		s.outbox = outbox
+	}
+}
+
+func NewService(le *logrus.Logger, appConfig *config.AppConfig, store Store, opts ...Option) Service {
+	s := &service{
+		le:        le,
+		config:    appConfig,
+		store:     store,
+		passwords: newThrottle(appConfig.PasswordMaxAttempts, appConfig.PasswordLockout),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// maxIdAttempts bounds the ids tried to store a new short url, the retries only happen when
+// another instance took the id
+const maxIdAttempts = 5
+
+// maxListLimit caps the number of short urls returned by a single listing
+const maxListLimit = 1000
+
 var (
-	errorNotFound       = errors.NewErrorNotFound()
+	errorNotFound       = errors.NewErrorLinkNotFound()
+	badRequestError     = errors.NewErrorBadRequest()
 	internalServerError = errors.NewInternalServerError()
+	idUnavailableError  = errors.NewErrorIdUnavailable()
 )
 
 // service method that returns the url encoded
-func (s *service) ShortenUrl(ctx context.Context, url string) (string, *errors.Error) {
+func (s *service) ShortenUrl(ctx context.Context, url string, opts LinkOptions) (string, *errors.Error) {
 	le := s.le.WithField("url", url)
 	le.Info("requested short url")
 
-	// check url was already stored
-	existing, err := s.store.FindUrl(ctx, url)
-	if err == nil {
-		le.Infof("already existing: %s", existing.Id)
-		return existing.Id, nil
+	if e := opts.validate(); e != nil {
+		le.Errorf("invalid options: %v", e.Details)
+		return "", e
 	}
 
-	// hash current timestamp for url encoding
-	hd := hashids.NewData()
-	h, err := hashids.NewWithData(hd)
-	if err != nil {
-		le.WithError(err).Error("error creating hash")
-		return "", &internalServerError
+	// short urls of the service are replaced by their destination, to avoid chains of redirects
+	url, opts, e := s.unshortenAll(ctx, url, opts)
+	if e != nil {
+		le.Errorf("self referencing: %v", e.Details)
+		return "", e
 	}
-	encoded, err := h.Encode([]int{int(time.Now().Unix())})
-	if err != nil {
-		le.WithError(err).Error("error encoding")
-		return "", &internalServerError
+
+	destinations := append([]Destination{{"url", url}}, opts.destinations()...)
+	if e := s.checkPolicy(destinations); e != nil {
+		le.Errorf("refused by policy: %v", e.Details)
+		return "", e
+	}
+	if e := s.checkRedirects(ctx, destinations); e != nil {
+		le.Errorf("redirect loop: %v", e.Details)
+		return "", e
 	}
 
-	// create the model using the encoded timestamp as id
-	// it identifies the short url
-	res := &ModelShorten{
-		Id:  encoded,
-		Url: url,
+	// check url was already stored, short urls with options are never shared, nor across workspaces
+	workspace := workspaceOf(ctx)
+	if opts.empty() {
+		existing, err := s.store.FindUrl(ctx, url)
+		if err == nil && existing.plain() && existing.Labels.empty() && existing.workspace() == workspace {
+			le.Infof("already existing: %s", existing.Id)
+			return existing.Id, nil
+		}
+	}
+	if e := s.checkQuota(ctx, workspace); e != nil {
+		return "", e
+	}
+	if e := s.checkDomain(ctx, opts.Domain, workspace); e != nil {
+		return "", e
 	}
 
-	// store the object
-	le.Info("store short url")
-	if err := s.store.StoreUrl(ctx, res); err != nil {
-		le.WithError(err).Error("unable to store short url")
+	// create the model, the id is set before storing it
+	now := time.Now().UTC()
+	res := &ModelShorten{
+		Url:       url,
+		Workspace: workspace,
+		CreatedAt: now,
+	}
+	if err := opts.apply(res); err != nil {
+		le.WithError(err).Error("error applying options")
 		return "", &internalServerError
 	}
 
-	le.Infof("created id: %s", res.Id)
-	return res.Id, nil
+	// store the object, with the hash of the current timestamp and of its sequence number as id.
+	// The ids in use, deleted ones included until they are purged, are never reissued
+	suffix := s.ids.take(now)
+	for attempt := 0; attempt < maxIdAttempts; attempt++ {
+		if attempt > 0 {
+			suffix = randomSuffix()
+		}
+		encoded, err := newId(now, suffix)
+		if err != nil {
+			le.WithError(err).Error("error encoding")
+			return "", &internalServerError
+		}
+		res.Id = encoded
+
+		le.Info("store short url")
+		err = s.transaction(ctx, func(ctx context.Context) error {
+			if err := s.store.StoreUrl(ctx, res); err != nil {
+				return err
+			}
+			return s.emit(ctx, linkEvent(EventLinkCreated, res))
+		})
+		if err == ErrDuplicate {
+			le.Warnf("id already in use: %s", encoded)
+			continue
+		}
+		if err != nil {
+			le.WithError(err).Error("unable to store short url")
+			return "", &internalServerError
+		}
+
+		le.Infof("created id: %s", res.Id)
+		s.record(ctx, audit.ActionCreate, res.Id, nil, res, nil)
+		if s.pages != nil {
+			s.fetches.Add(1)
+			go s.fetchPage(res.Id, res.Url)
+		}
+		return res.Id, nil
+	}
+
+	le.Error("no free id")
+	return "", &idUnavailableError
 }
 
 // service method that retrieves an existing extended url given a short url
@@ -102,18 +244,148 @@ func (s *service) DeleteUrl(ctx context.Context, url string) *errors.Error {
 	split := strings.Split(url, "/")
 	id := split[len(split)-1]
 
-	// delete url
-	if err := s.store.DeleteById(ctx, id); err != nil {
+	// the audit log and the event keep the deleted url
+	var before *ModelShorten
+	if s.auditor != nil || s.outbox != nil {
+		existing, err := s.store.FindById(ctx, id)
+		if err != nil {
+			le.WithError(err).Error("url was not found")
+			return &errorNotFound
+		}
+		before = existing
+	}
+
+	// move url to the trash, it can be restored until it is purged
+	err := s.transaction(ctx, func(ctx context.Context) error {
+		if err := s.store.DeleteById(ctx, id); err != nil {
+			return err
+		}
+		if before == nil {
+			return nil
+		}
+		return s.emit(ctx, linkEvent(EventLinkDeleted, before))
+	})
+	if err == ErrNotFound {
 		le.WithError(err).Error("url was not found")
 		return &errorNotFound
 	}
+	if err != nil {
+		le.WithError(err).Error("unable to delete url")
+		return &internalServerError
+	}
 
 	le.Info("url deleted")
+	s.record(ctx, audit.ActionDelete, id, before, nil, nil)
 	return nil
 }
 
+// service method that restores a short url deleted during the retention period
+func (s *service) RestoreUrl(ctx context.Context, url string) *errors.Error {
+	le := s.le.WithField("url", url)
+	le.Info("requested restore url")
+
+	// get the id from the last part of the url
+	split := strings.Split(url, "/")
+	id := split[len(split)-1]
+
+	since := time.Now().UTC().Add(-s.config.DeleteRetention)
+	if err := s.store.RestoreById(ctx, id, since); err != nil {
+		le.WithError(err).Error("url was not found in the trash")
+		return &errorNotFound
+	}
+
+	le.Info("url restored")
+	if s.auditor != nil {
+		after, err := s.store.FindById(ctx, id)
+		if err != nil {
+			le.WithError(err).Error("restored url was not found")
+		}
+		s.record(ctx, audit.ActionRestore, id, nil, after, nil)
+	}
+	return nil
+}
+
+// service method that removes the short urls deleted before the retention period
+func (s *service) PurgeDeleted(ctx context.Context) (int64, *errors.Error) {
+	before := time.Now().UTC().Add(-s.config.DeleteRetention)
+	le := s.le.WithField("before", before)
+
+	purged, err := s.store.PurgeDeleted(ctx, before)
+	if err != nil {
+		le.WithError(err).Error("unable to purge the deleted urls")
+		return 0, &internalServerError
+	}
+
+	le.Infof("purged %d urls", purged)
+	s.record(ctx, audit.ActionPurge, "", nil, nil, map[string]int64{"purged": purged})
+	return purged, nil
+}
+
+// service method that returns the stored document of a short url
+func (s *service) GetUrl(ctx context.Context, url string) (*ModelShorten, *errors.Error) {
+	le := s.le.WithField("url", url)
+	le.Info("requested get url")
+
+	// get the id from the last part of the url
+	split := strings.Split(url, "/")
+	id := split[len(split)-1]
+
+	existing, err := s.store.FindById(ctx, id)
+	if err != nil {
+		le.WithError(err).Error("url was not found")
+		return nil, &errorNotFound
+	}
+	return existing, nil
+}
+
+// service method that lists the stored short urls
+func (s *service) ListUrls(ctx context.Context, opts ListOptions) ([]*ModelShorten, *errors.Error) {
+	le := s.le.WithField("offset", opts.Offset).WithField("limit", opts.Limit)
+	le.Info("requested list urls")
+
+	if opts.Offset < 0 || opts.Limit < 0 {
+		le.Error("invalid pagination")
+		return nil, &badRequestError
+	}
+	if opts.Limit == 0 || opts.Limit > maxListLimit {
+		opts.Limit = maxListLimit
+	}
+
+	// the filters match the labels as they are stored
+	opts.Tags = Labels{Tags: opts.Tags}.normalized().Tags
+	e := errors.NewErrorBadRequest()
+	for key := range opts.Metadata {
+		if !metadataKeyPattern.MatchString(key) {
+			e = e.WithDetail("metadata."+key, metadataKeyRule)
+		}
+	}
+	if len(e.Details) > 0 {
+		le.Errorf("invalid filters: %v", e.Details)
+		return nil, &e
+	}
+
+	res, err := s.store.ListUrls(ctx, opts)
+	if err != nil {
+		le.WithError(err).Error("unable to list urls")
+		return nil, &internalServerError
+	}
+	return res, nil
+}
+
+// service method that returns the totals of short urls and redirects
+func (s *service) Stats(ctx context.Context) (*Stats, *errors.Error) {
+	s.le.Info("requested stats")
+
+	res, err := s.store.Totals(ctx)
+	if err != nil {
+		s.le.WithError(err).Error("unable to compute stats")
+		return nil, &internalServerError
+	}
+	return res, nil
+}
+
 // service method that returns the count of redirects for a given url
-func (s *service) CountRedirects(ctx context.Context, url string) (int64, *errors.Error) {
+func (s *service) CountRedirects(ctx context.Context, url string) (*Counts, *errors.Error) {
 	le := s.le.WithField("url", url)
 	le.Info("requested count redirects")
 
@@ -125,25 +397,184 @@ func (s *service) CountRedirects(ctx context.Context, url string) (int64, *error
 	existing, err := s.store.FindById(ctx, id)
 	if err != nil {
 		le.WithError(err).Error("url was not found")
-		return 0, &errorNotFound
+		return nil, &errorNotFound
 	}
 
 	le.Infof("returning count: %d", existing.Count)
-	return existing.Count, nil
+	return &Counts{
+		Total:      existing.Count,
+		ClickStats: existing.Clicks,
+	}, nil
+}
+
+// Health returns the outcome of the last check of the destinations of the short url,
+// nil when they were never checked
+func (s *service) Health(ctx context.Context, url string) (*Health, *errors.Error) {
+	le := s.le.WithField("url", url)
+	le.Info("requested health")
+
+	// get the id from the last part of the url
+	split := strings.Split(url, "/")
+	id := split[len(split)-1]
+
+	existing, err := s.store.FindById(ctx, id)
+	if err != nil {
+		le.WithError(err).Error("url was not found")
+		return nil, &errorNotFound
+	}
+	return existing.Health, nil
 }
 
 // service method that, given a short url id, increments the count of redirects
 // and returns the redirect url
-func (s *service) IncrementRedirect(ctx context.Context, id string) (string, *errors.Error) {
+func (s *service) IncrementRedirect(ctx context.Context, id string, visit Visit) (*Redirect, *errors.Error) {
 	le := s.le.WithField("id", id)
 	le.Info("increment redirect count")
 
-	existing, err := s.store.IncrementCount(ctx, id)
+	existing, e := s.findVisited(ctx, id, visit)
+	if e != nil {
+		return nil, e
+	}
+
+	if e := s.checkVisit(existing, visit); e != nil {
+		if pending := s.pendingUrl(existing); e.Code == errors.CodeLinkNotActive && pending != "" {
+			le.Info("not active yet, redirect to pending url")
+			return &Redirect{Url: pending}, nil
+		}
+		le.Infof("redirect refused: %s", e.Code)
+		return nil, e
+	}
+
+	url, click := s.resolve(existing, visit)
+
+	// the visits of the short urls with preview are counted once confirmed
+	if existing.Preview && !visit.Confirmed {
+		le.Info("preview before redirect")
+		return &Redirect{Url: url, Preview: newPreview(existing, url)}, nil
+	}
+
+	// the store refuses the increment once the limit of clicks is reached,
+	// so that concurrent visits can't overshoot it
+	err := s.transaction(ctx, func(ctx context.Context) error {
+		updated, err := s.store.IncrementCount(ctx, id, click)
+		if err != nil {
+			return err
+		}
+		for _, event := range s.clickEvents(updated, updated.Count+1, click) {
+			if err := s.emit(ctx, event); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	switch {
+	case err == ErrNotFound && existing.MaxClicks > 0:
+		return nil, s.refusedIncrement(ctx, id)
+	case err == ErrNotFound:
+		le.Error("url was not found")
+		return nil, &errorNotFound
+	case err != nil:
+		le.WithError(err).Error("unable to increment count")
+		return nil, &internalServerError
+	}
+
+	le.Info("count incremented")
+	res := &Redirect{
+		Url:       url,
+		Permanent: existing.plain(),
+	}
+	if existing.StickyVariants {
+		res.StickyVariant = click.Variant
+	}
+	return res, nil
+}
+
+// refusedIncrement returns why the store refused to count a visit of a short url with a limit of
+// clicks: it may as well have been deleted or have expired since it was read
+func (s *service) refusedIncrement(ctx context.Context, id string) *errors.Error {
+	le := s.le.WithField("id", id)
+	current, err := s.store.FindById(ctx, id)
+	switch {
+	case err == ErrNotFound:
+		le.Error("url was not found")
+		return &errorNotFound
+	case err != nil:
+		le.WithError(err).Error("unable to read the url")
+		return &internalServerError
+	case current.NotAfter != nil && !time.Now().Before(*current.NotAfter):
+		le.Info("redirect refused: expired")
+		e := errors.NewErrorLinkExpired()
+		return &e
+	}
+	le.Info("redirect refused: max clicks reached")
+	e := errors.NewErrorLinkExhausted()
+	return &e
+}
+
+// service method that describes the destination of a short url without redirecting
+func (s *service) PreviewUrl(ctx context.Context, id string, visit Visit) (*Preview, *errors.Error) {
+	le := s.le.WithField("id", id)
+	le.Info("requested preview")
+
+	existing, e := s.findVisited(ctx, id, visit)
+	if e != nil {
+		return nil, e
+	}
+	if e := s.checkVisit(existing, visit); e != nil {
+		le.Infof("preview refused: %s", e.Code)
+		return nil, e
+	}
+
+	url, _ := s.resolve(existing, visit)
+	return newPreview(existing, url), nil
+}
+
+// findVisited returns the short url followed by the visitor
+func (s *service) findVisited(ctx context.Context, id string, visit Visit) (*ModelShorten, *errors.Error) {
+	le := s.le.WithField("id", id)
+
+	existing, err := s.store.FindById(ctx, id)
 	if err != nil {
 		le.WithError(err).Error("url was not found")
-		return "", &errorNotFound
+		return nil, &errorNotFound
 	}
 
-	le.Info("count incremented")
-	return existing.Url, nil
+	// the short ids are resolved in the namespace of the domain of the visit
+	if existing.Domain != visit.Domain {
+		le.WithField("domain", visit.Domain).Error("url was not found on the domain")
+		return nil, &errorNotFound
+	}
+
+	// the short urls stored before their destinations were refused are disabled
+	if e := s.checkPolicy(existing.Destinations()); e != nil {
+		le.Errorf("refused by policy: %v", e.Details)
+		return nil, e
+	}
+
+	// only the prefix short urls have paths
+	if visit.Path != "" && (!existing.ForwardPath || !validPath(visit.Path)) {
+		le.WithField("path", visit.Path).Error("path not forwarded")
+		return nil, &errorNotFound
+	}
+	return existing, nil
+}
+
+// resolve returns the destination of the visitor, with the click that counts the visit
+func (s *service) resolve(u *ModelShorten, visit Visit) (string, Click) {
+	country := s.country(visit)
+	url, key := destination(u, visit, country)
+
+	// the variants rotate the visitors of the url
+	variant := ""
+	if (key == "" || key == DestinationDefault) && len(u.Variants) > 0 {
+		v := pickVariant(u, visit)
+		url, variant = v.Url, v.Name
+	}
+	url = withQuery(withPath(url, visit.Path), u, visit.Query, map[string]string{
+		"{id}":       u.Id,
+		"{platform}": detectPlatform(visit.UserAgent),
+		"{country}":  strings.ToLower(country),
+		"{variant}":  variant,
+	})
+	return url, Click{Destination: key, Country: country, Variant: variant}
 }
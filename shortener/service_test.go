@@ -1,15 +1,26 @@
 package shortener
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
+	"github.com/gsiragusa/short-to-me/audit"
 	"github.com/gsiragusa/short-to-me/config"
+	errs "github.com/gsiragusa/short-to-me/errors"
+	"github.com/gsiragusa/short-to-me/tenant"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
 )
 
 const (
@@ -38,11 +49,39 @@ func TestService_ShortenUrl(t *testing.T) {
 	store.EXPECT().FindUrl(ctx, testUrl).Return(nil, errors.New(""))
 	store.EXPECT().StoreUrl(ctx, gomock.Any())
 
-	res, err := svc.ShortenUrl(ctx, testUrl)
+	res, err := svc.ShortenUrl(ctx, testUrl, LinkOptions{})
 	require.Nil(t, err)
 	require.NotEmpty(t, res)
 }
 
+func TestService_ShortenUrlExisting(t *testing.T) {
+	svc, store := MakeTestService(t)
+	ctx := context.Background()
+
+	existing := &ModelShorten{Id: shortId, Url: testUrl}
+	store.EXPECT().FindUrl(ctx, testUrl).Return(existing, nil)
+
+	res, err := svc.ShortenUrl(ctx, testUrl, LinkOptions{})
+	require.Nil(t, err)
+	require.Equal(t, shortId, res)
+}
+
+func TestService_ShortenUrlWithPassword(t *testing.T) {
+	svc, store := MakeTestService(t)
+	ctx := context.Background()
+
+	var stored *ModelShorten
+	store.EXPECT().StoreUrl(ctx, gomock.Any()).Do(func(_ context.Context, doc interface{}) {
+		stored = doc.(*ModelShorten)
+	})
+
+	res, err := svc.ShortenUrl(ctx, testUrl, LinkOptions{Password: "secret"})
+	require.Nil(t, err)
+	require.Equal(t, stored.Id, res)
+	require.NotEmpty(t, stored.PasswordHash)
+	require.NotEqual(t, "secret", stored.PasswordHash)
+}
+
 func TestService_RetrieveUrl(t *testing.T) {
 	svc, store := MakeTestService(t)
 	ctx := context.Background()
@@ -83,7 +122,7 @@ func TestService_CountRedirects(t *testing.T) {
 
 	res, err := svc.CountRedirects(ctx, shortId)
 	require.Nil(t, err)
-	require.Equal(t, int64(10), res)
+	require.Equal(t, &Counts{Total: 10}, res)
 }
 
 func TestService_IncrementRedirect(t *testing.T) {
@@ -96,9 +135,1475 @@ func TestService_IncrementRedirect(t *testing.T) {
 		Count: 10,
 	}
 
-	store.EXPECT().IncrementCount(ctx, shortId).Return(expected, nil)
+	store.EXPECT().FindById(ctx, shortId).Return(expected, nil)
+	store.EXPECT().IncrementCount(ctx, shortId, Click{}).Return(expected, nil)
 
-	res, err := svc.IncrementRedirect(ctx, shortId)
+	res, err := svc.IncrementRedirect(ctx, shortId, Visit{})
 	require.Nil(t, err)
-	require.Equal(t, testUrl, res)
+	require.Equal(t, &Redirect{Url: testUrl, Permanent: true}, res)
+}
+
+func TestService_IncrementRedirectMaxClicks(t *testing.T) {
+	svc, store := MakeTestService(t)
+	ctx := context.Background()
+
+	expected := &ModelShorten{
+		Id:        shortId,
+		Url:       testUrl,
+		Count:     0,
+		MaxClicks: 1,
+	}
+
+	store.EXPECT().FindById(ctx, shortId).Return(expected, nil).Times(2)
+	store.EXPECT().IncrementCount(ctx, shortId, Click{}).Return(expected, nil)
+
+	res, err := svc.IncrementRedirect(ctx, shortId, Visit{})
+	require.Nil(t, err)
+	require.Equal(t, &Redirect{Url: testUrl}, res)
+
+	// a concurrent visit got the last click first
+	store.EXPECT().IncrementCount(ctx, shortId, Click{}).Return(nil, ErrNotFound)
+	store.EXPECT().FindById(ctx, shortId).Return(&ModelShorten{Id: shortId, Url: testUrl, Count: 1, MaxClicks: 1}, nil)
+
+	_, err = svc.IncrementRedirect(ctx, shortId, Visit{})
+	require.Equal(t, errs.CodeLinkExhausted, err.Code)
+	require.Equal(t, http.StatusGone, err.HttpStatus)
+
+	// deleted since it was read
+	store.EXPECT().FindById(ctx, shortId).Return(expected, nil)
+	store.EXPECT().IncrementCount(ctx, shortId, Click{}).Return(nil, ErrNotFound)
+	store.EXPECT().FindById(ctx, shortId).Return(nil, ErrNotFound)
+
+	_, err = svc.IncrementRedirect(ctx, shortId, Visit{})
+	require.Equal(t, errs.CodeLinkNotFound, err.Code)
+
+	// expired since it was read
+	expired := time.Now().Add(-time.Second)
+	store.EXPECT().FindById(ctx, shortId).Return(expected, nil)
+	store.EXPECT().IncrementCount(ctx, shortId, Click{}).Return(nil, ErrNotFound)
+	store.EXPECT().FindById(ctx, shortId).Return(&ModelShorten{Id: shortId, Url: testUrl, MaxClicks: 1, NotAfter: &expired}, nil)
+
+	_, err = svc.IncrementRedirect(ctx, shortId, Visit{})
+	require.Equal(t, errs.CodeLinkExpired, err.Code)
+}
+
+func TestService_IncrementRedirectExhausted(t *testing.T) {
+	svc, store := MakeTestService(t)
+	ctx := context.Background()
+
+	expected := &ModelShorten{
+		Id:        shortId,
+		Url:       testUrl,
+		Count:     1,
+		MaxClicks: 1,
+	}
+
+	store.EXPECT().FindById(ctx, shortId).Return(expected, nil)
+
+	_, err := svc.IncrementRedirect(ctx, shortId, Visit{})
+	require.Equal(t, errs.CodeLinkExhausted, err.Code)
+}
+
+func TestService_ShortenUrlInvalidOptions(t *testing.T) {
+	svc, _ := MakeTestService(t)
+	ctx := context.Background()
+
+	now := time.Now()
+	opts := LinkOptions{
+		MaxClicks:  -1,
+		NotBefore:  &now,
+		NotAfter:   &now,
+		PendingUrl: "/relative",
+	}
+
+	_, err := svc.ShortenUrl(ctx, testUrl, opts)
+	require.Equal(t, errs.CodeBadRequest, err.Code)
+	require.Len(t, err.Details, 3)
+}
+
+func TestService_IncrementRedirectNotActive(t *testing.T) {
+	svc, store := MakeTestService(t)
+	ctx := context.Background()
+
+	tomorrow := time.Now().Add(24 * time.Hour)
+	expected := &ModelShorten{
+		Id:        shortId,
+		Url:       testUrl,
+		NotBefore: &tomorrow,
+	}
+	store.EXPECT().FindById(ctx, shortId).Return(expected, nil).Times(2)
+
+	_, err := svc.IncrementRedirect(ctx, shortId, Visit{})
+	require.Equal(t, errs.CodeLinkNotActive, err.Code)
+
+	// visits before activation are not counted
+	expected.PendingUrl = "http://www.test.com/soon"
+	res, err := svc.IncrementRedirect(ctx, shortId, Visit{})
+	require.Nil(t, err)
+	require.Equal(t, &Redirect{Url: expected.PendingUrl}, res)
+}
+
+func TestService_IncrementRedirectExpired(t *testing.T) {
+	svc, store := MakeTestService(t)
+	ctx := context.Background()
+
+	yesterday := time.Now().Add(-24 * time.Hour)
+	expected := &ModelShorten{
+		Id:       shortId,
+		Url:      testUrl,
+		NotAfter: &yesterday,
+	}
+	store.EXPECT().FindById(ctx, shortId).Return(expected, nil)
+
+	_, err := svc.IncrementRedirect(ctx, shortId, Visit{})
+	require.Equal(t, errs.CodeLinkExpired, err.Code)
+	require.Equal(t, http.StatusGone, err.HttpStatus)
+}
+
+func TestService_IncrementRedirectWithPassword(t *testing.T) {
+	svc, store := MakeTestService(t)
+	ctx := context.Background()
+
+	hash, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	expected := &ModelShorten{
+		Id:           shortId,
+		Url:          testUrl,
+		PasswordHash: string(hash),
+	}
+	store.EXPECT().FindById(ctx, shortId).Return(expected, nil).AnyTimes()
+
+	_, err := svc.IncrementRedirect(ctx, shortId, Visit{ClientIp: "10.0.0.1"})
+	require.Equal(t, errs.CodePasswordRequired, err.Code)
+
+	_, err = svc.IncrementRedirect(ctx, shortId, Visit{ClientIp: "10.0.0.1", Password: "wrong"})
+	require.Equal(t, errs.CodeInvalidPassword, err.Code)
+
+	store.EXPECT().IncrementCount(ctx, shortId, Click{}).Return(expected, nil)
+
+	res, err := svc.IncrementRedirect(ctx, shortId, Visit{ClientIp: "10.0.0.1", Password: "secret"})
+	require.Nil(t, err)
+	require.Equal(t, testUrl, res.Url)
+}
+
+func TestService_IncrementRedirectThrottled(t *testing.T) {
+	svc, store := MakeTestService(t)
+	ctx := context.Background()
+
+	hash, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	expected := &ModelShorten{
+		Id:           shortId,
+		Url:          testUrl,
+		PasswordHash: string(hash),
+	}
+	store.EXPECT().FindById(ctx, shortId).Return(expected, nil).AnyTimes()
+
+	visit := Visit{ClientIp: "10.0.0.1", Password: "wrong"}
+	for i := 0; i < 5; i++ {
+		_, err := svc.IncrementRedirect(ctx, shortId, visit)
+		require.Equal(t, errs.CodeInvalidPassword, err.Code)
+	}
+
+	// even the right password is refused once blocked
+	visit.Password = "secret"
+	_, err := svc.IncrementRedirect(ctx, shortId, visit)
+	require.Equal(t, errs.CodeRateLimited, err.Code)
+
+	// other visitors are not affected
+	store.EXPECT().IncrementCount(ctx, shortId, Click{}).Return(expected, nil)
+	_, err = svc.IncrementRedirect(ctx, shortId, Visit{ClientIp: "10.0.0.2", Password: "secret"})
+	require.Nil(t, err)
+}
+
+func TestService_GetUrl(t *testing.T) {
+	svc, store := MakeTestService(t)
+	ctx := context.Background()
+
+	expected := &ModelShorten{
+		Id:    shortId,
+		Url:   testUrl,
+		Count: 10,
+	}
+
+	store.EXPECT().FindById(ctx, shortId).Return(expected, nil)
+
+	res, err := svc.GetUrl(ctx, "http://www.short.me/"+shortId)
+	require.Nil(t, err)
+	require.Equal(t, expected, res)
+}
+
+func TestService_ListUrls(t *testing.T) {
+	svc, store := MakeTestService(t)
+	ctx := context.Background()
+
+	expected := []*ModelShorten{{Id: shortId, Url: testUrl}}
+
+	store.EXPECT().ListUrls(ctx, ListOptions{Offset: 10, Limit: maxListLimit}).Return(expected, nil)
+
+	res, err := svc.ListUrls(ctx, ListOptions{Offset: 10})
+	require.Nil(t, err)
+	require.Equal(t, expected, res)
+
+	_, err = svc.ListUrls(ctx, ListOptions{Offset: -1})
+	require.NotNil(t, err)
+}
+
+func TestService_Stats(t *testing.T) {
+	svc, store := MakeTestService(t)
+	ctx := context.Background()
+
+	expected := &Stats{Urls: 2, Redirects: 12}
+
+	store.EXPECT().Totals(ctx).Return(expected, nil)
+
+	res, err := svc.Stats(ctx)
+	require.Nil(t, err)
+	require.Equal(t, expected, res)
+}
+
+func TestService_ExportUrls(t *testing.T) {
+	svc, store := MakeTestService(t)
+	ctx := context.Background()
+
+	stored := &ModelShorten{
+		Id:        shortId,
+		Url:       testUrl,
+		Count:     10,
+		CreatedAt: time.Unix(1600000000, 0).UTC(),
+	}
+	forEach := func(_ context.Context, fn func(*ModelShorten) error) error {
+		return fn(stored)
+	}
+	store.EXPECT().ForEachUrl(ctx, gomock.Any()).DoAndReturn(forEach).Times(3)
+
+	var jsonl bytes.Buffer
+	res, err := svc.ExportUrls(ctx, &jsonl, FormatJSONL)
+	require.Nil(t, err)
+	require.Equal(t, &ExportResult{Exported: 1}, res)
+	require.JSONEq(t, `{"id":"RMAp1Vz","url":"http://www.test.com","count":10,"clicks":{},"created_at":"2020-09-13T12:26:40Z"}`, jsonl.String())
+
+	// the password hash is only carried by the export
+	stored.PasswordHash = "hash"
+	jsonl.Reset()
+	_, err = svc.ExportUrls(ctx, &jsonl, FormatJSONL)
+	require.Nil(t, err)
+	require.JSONEq(t, `{"id":"RMAp1Vz","url":"http://www.test.com","count":10,"clicks":{},"created_at":"2020-09-13T12:26:40Z","password_hash":"hash"}`, jsonl.String())
+	stored.PasswordHash = ""
+
+	var csv bytes.Buffer
+	_, err = svc.ExportUrls(ctx, &csv, FormatCSV)
+	require.Nil(t, err)
+	require.Equal(t, "id,url,count,created_at,title,description,tags,metadata\n"+
+		"RMAp1Vz,http://www.test.com,10,2020-09-13T12:26:40Z,,,,\n", csv.String())
+}
+
+func TestService_ExportUrlsCsv(t *testing.T) {
+	svc, store := MakeTestService(t)
+	ctx := context.Background()
+
+	labeled := &ModelShorten{Id: shortId, Url: testUrl, Labels: Labels{
+		Title:    "Spring sale",
+		Tags:     []string{"campaign:spring", "shoes"},
+		Metadata: map[string]string{"owner": "marketing"},
+	}}
+	protected := &ModelShorten{Id: "pRA4OEy", Url: testUrl, PasswordHash: "hash"}
+	store.EXPECT().ForEachUrl(ctx, gomock.Any()).DoAndReturn(func(_ context.Context, fn func(*ModelShorten) error) error {
+		if err := fn(labeled); err != nil {
+			return err
+		}
+		return fn(protected)
+	})
+
+	// the short urls with options are skipped, their options would be lost
+	var csv bytes.Buffer
+	res, err := svc.ExportUrls(ctx, &csv, FormatCSV)
+	require.Nil(t, err)
+	require.Equal(t, &ExportResult{Exported: 1, Skipped: 1}, res)
+	require.Equal(t, "id,url,count,created_at,title,description,tags,metadata\n"+
+		`RMAp1Vz,http://www.test.com,0,,Spring sale,,campaign:spring shoes,"{""owner"":""marketing""}"`+"\n", csv.String())
+
+	// the labels are imported back
+	store.EXPECT().StoreUrl(ctx, &ModelShorten{Id: shortId, Workspace: tenant.Default, Url: testUrl, Labels: labeled.Labels})
+	imported, err := svc.ImportUrls(ctx, &csv, FormatCSV, false)
+	require.Nil(t, err)
+	require.Equal(t, &ImportResult{Created: 1}, imported)
+}
+
+func TestService_ImportUrls(t *testing.T) {
+	svc, store := MakeTestService(t)
+	ctx := context.Background()
+
+	input := "id,url,count,created_at\n" +
+		"RMAp1Vz,http://www.test.com,10,2020-09-13T12:26:40Z\n" +
+		"pRA4OEy,http://www.other.com,4,\n"
+
+	created := &ModelShorten{Id: "pRA4OEy", Workspace: tenant.Default, Url: "http://www.other.com", Count: 4}
+	store.EXPECT().StoreUrl(ctx, gomock.Any()).Return(ErrDuplicate)
+	store.EXPECT().StoreUrl(ctx, created)
+
+	res, err := svc.ImportUrls(ctx, strings.NewReader(input), FormatCSV, false)
+	require.Nil(t, err)
+	require.Equal(t, &ImportResult{Created: 1, Skipped: 1}, res)
+}
+
+func TestService_ImportUrlsOverwrite(t *testing.T) {
+	svc, store := MakeTestService(t)
+	ctx := context.Background()
+
+	input := `{"id":"RMAp1Vz","url":"http://www.test.com","count":10,"password_hash":"hash"}`
+	expected := &ModelShorten{Id: shortId, Workspace: tenant.Default, Url: testUrl, Count: 10, PasswordHash: "hash"}
+
+	store.EXPECT().StoreUrl(ctx, expected).Return(ErrDuplicate)
+	store.EXPECT().ReplaceUrl(ctx, expected)
+
+	res, err := svc.ImportUrls(ctx, strings.NewReader(input), FormatJSONL, true)
+	require.Nil(t, err)
+	require.Equal(t, &ImportResult{Overwritten: 1}, res)
+}
+
+func TestService_ImportUrlsInvalid(t *testing.T) {
+	svc, _ := MakeTestService(t)
+	ctx := context.Background()
+
+	_, err := svc.ImportUrls(ctx, strings.NewReader(`{"url":"http://www.test.com"}`), FormatJSONL, false)
+	require.NotNil(t, err)
+}
+
+func TestService_ImportUrlsRefused(t *testing.T) {
+	log := logrus.New()
+	log.Out = ioutil.Discard
+
+	conf, err := config.Configure()
+	require.Nil(t, err)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	store := NewMockStore(ctrl)
+	policy := NewMockPolicy(ctrl)
+	svc := NewService(log, conf, store, WithPolicy(policy))
+	ctx := context.Background()
+
+	blocked := errors.New("the domain evil.com is blocked")
+	policy.EXPECT().Check(testUrl).Return(nil).AnyTimes()
+	policy.EXPECT().Check("http://evil.com").Return(blocked).AnyTimes()
+
+	// the records are checked as the new short urls, the refused ones are skipped
+	input := `{"id":"RMAp1Vz","url":"http://www.test.com","tags":[" Spring","sale","spring"]}
+{"id":"pRA4OEy","url":"http://www.test.com","variants":[{"name":"b","url":"http://evil.com","weight":1}]}
+{"id":"XYZ1234","url":"http://www.test.com","metadata":{"$where":"1"}}
+{"id":"ABC1234","url":"/relative","domain":"go.brand-a.com"}
+`
+	expected := &ModelShorten{Id: shortId, Workspace: tenant.Default, Url: testUrl, Labels: Labels{Tags: []string{"sale", "spring"}}}
+	store.EXPECT().StoreUrl(ctx, expected)
+
+	res, e := svc.ImportUrls(ctx, strings.NewReader(input), FormatJSONL, false)
+	require.Nil(t, e)
+	require.Equal(t, 1, res.Created)
+	require.Equal(t, 3, res.Skipped)
+	require.Equal(t, []errs.FieldError{
+		{Field: "record 2", Message: "variants.b: " + blocked.Error()},
+		{Field: "record 3", Message: "metadata.$where: key must be up to 50 lower case letters, digits, _, . or -, starting with a letter or a digit"},
+		{Field: "record 4", Message: "url: must be an absolute http url"},
+	}, res.Refused)
+}
+
+func TestIdTimestamp(t *testing.T) {
+	now := time.Unix(1600000000, 0).UTC()
+
+	id, err := newId(now, 0)
+	require.Nil(t, err)
+
+	res, err := IdTimestamp(id)
+	require.Nil(t, err)
+	require.Equal(t, now, res)
+
+	retry, err := newId(now, 1)
+	require.Nil(t, err)
+	require.NotEqual(t, id, retry)
+
+	res, err = IdTimestamp(retry)
+	require.Nil(t, err)
+	require.Equal(t, now, res)
+}
+
+func TestService_IncrementRedirectPlatform(t *testing.T) {
+	svc, store := MakeTestService(t)
+	ctx := context.Background()
+
+	expected := &ModelShorten{
+		Id:  shortId,
+		Url: testUrl,
+		PlatformUrls: map[string]string{
+			PlatformIos: "https://apps.apple.com/app/id0",
+		},
+	}
+	store.EXPECT().FindById(ctx, shortId).Return(expected, nil).Times(2)
+
+	iphone := "Mozilla/5.0 (iPhone; CPU iPhone OS 14_0 like Mac OS X) AppleWebKit/605.1.15"
+	store.EXPECT().IncrementCount(ctx, shortId, Click{Destination: PlatformIos}).Return(expected, nil)
+	res, err := svc.IncrementRedirect(ctx, shortId, Visit{UserAgent: iphone})
+	require.Nil(t, err)
+	require.Equal(t, &Redirect{Url: "https://apps.apple.com/app/id0"}, res)
+
+	// android has no url of its own
+	android := "Mozilla/5.0 (Linux; Android 10; Pixel 3) AppleWebKit/537.36"
+	store.EXPECT().IncrementCount(ctx, shortId, Click{Destination: DestinationDefault}).Return(expected, nil)
+	res, err = svc.IncrementRedirect(ctx, shortId, Visit{UserAgent: android})
+	require.Nil(t, err)
+	require.Equal(t, &Redirect{Url: testUrl}, res)
+}
+
+func TestService_ShortenUrlUnknownPlatform(t *testing.T) {
+	svc, _ := MakeTestService(t)
+	ctx := context.Background()
+
+	opts := LinkOptions{PlatformUrls: map[string]string{"windows": testUrl}}
+
+	_, err := svc.ShortenUrl(ctx, testUrl, opts)
+	require.Equal(t, errs.CodeBadRequest, err.Code)
+	require.Equal(t, "platform_urls.windows", err.Details[0].Field)
+}
+
+func TestDetectPlatform(t *testing.T) {
+	require.Equal(t, PlatformIos, detectPlatform("Mozilla/5.0 (iPad; CPU OS 13_2 like Mac OS X)"))
+	require.Equal(t, PlatformAndroid, detectPlatform("Mozilla/5.0 (Linux; Android 10; SM-G973F)"))
+	require.Equal(t, PlatformDesktop, detectPlatform("Mozilla/5.0 (Windows NT 10.0; Win64; x64)"))
+	require.Equal(t, PlatformDesktop, detectPlatform(""))
+}
+
+func TestService_IncrementRedirectCountry(t *testing.T) {
+	log := logrus.New()
+	log.Out = ioutil.Discard
+
+	conf, err := config.Configure()
+	require.Nil(t, err)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	store := NewMockStore(ctrl)
+	locator := NewMockLocator(ctrl)
+	svc := NewService(log, conf, store, WithLocator(locator))
+	ctx := context.Background()
+
+	expected := &ModelShorten{
+		Id:           shortId,
+		Url:          testUrl,
+		PlatformUrls: map[string]string{PlatformIos: "https://apps.apple.com/app/id0"},
+		CountryUrls:  map[string]string{"IT": "http://www.test.it"},
+	}
+	store.EXPECT().FindById(ctx, shortId).Return(expected, nil).Times(3)
+	locator.EXPECT().Country("192.0.2.1").Return("IT", nil).Times(2)
+	locator.EXPECT().Country("192.0.2.2").Return("", nil)
+
+	store.EXPECT().IncrementCount(ctx, shortId, Click{Destination: "IT", Country: "IT"}).Return(expected, nil)
+	res, e := svc.IncrementRedirect(ctx, shortId, Visit{ClientIp: "192.0.2.1"})
+	require.Nil(t, e)
+	require.Equal(t, "http://www.test.it", res.Url)
+
+	// the platform url comes first
+	iphone := "Mozilla/5.0 (iPhone; CPU iPhone OS 14_0 like Mac OS X)"
+	store.EXPECT().IncrementCount(ctx, shortId, Click{Destination: PlatformIos, Country: "IT"}).Return(expected, nil)
+	res, e = svc.IncrementRedirect(ctx, shortId, Visit{ClientIp: "192.0.2.1", UserAgent: iphone})
+	require.Nil(t, e)
+	require.Equal(t, "https://apps.apple.com/app/id0", res.Url)
+
+	// unknown country
+	store.EXPECT().IncrementCount(ctx, shortId, Click{Destination: DestinationDefault}).Return(expected, nil)
+	res, e = svc.IncrementRedirect(ctx, shortId, Visit{ClientIp: "192.0.2.2"})
+	require.Nil(t, e)
+	require.Equal(t, testUrl, res.Url)
+}
+
+func TestService_ShortenUrlCountryUrls(t *testing.T) {
+	svc, store := MakeTestService(t)
+	ctx := context.Background()
+
+	store.EXPECT().StoreUrl(ctx, gomock.Any()).DoAndReturn(func(_ context.Context, document interface{}) error {
+		require.Equal(t, map[string]string{"IT": "http://www.test.it"}, document.(*ModelShorten).CountryUrls)
+		return nil
+	})
+
+	_, err := svc.ShortenUrl(ctx, testUrl, LinkOptions{CountryUrls: map[string]string{"it": "http://www.test.it"}})
+	require.Nil(t, err)
+
+	_, err = svc.ShortenUrl(ctx, testUrl, LinkOptions{CountryUrls: map[string]string{"ITA": "http://www.test.it"}})
+	require.Equal(t, errs.CodeBadRequest, err.Code)
+	require.Equal(t, "country_urls.ITA", err.Details[0].Field)
+}
+
+func TestService_IncrementRedirectVariants(t *testing.T) {
+	svc, store := MakeTestService(t)
+	ctx := context.Background()
+
+	expected := &ModelShorten{
+		Id:  shortId,
+		Url: testUrl,
+		Variants: []Variant{
+			{Name: "a", Url: "http://www.test.com/a", Weight: 1},
+			{Name: "b", Url: "http://www.test.com/b", Weight: 1},
+		},
+		StickyVariants: true,
+	}
+	store.EXPECT().FindById(ctx, shortId).Return(expected, nil).Times(2)
+
+	// the visitor keeps the variant it was served
+	store.EXPECT().IncrementCount(ctx, shortId, Click{Variant: "b"}).Return(expected, nil)
+	res, err := svc.IncrementRedirect(ctx, shortId, Visit{Variant: "b"})
+	require.Nil(t, err)
+	require.Equal(t, &Redirect{Url: "http://www.test.com/b", StickyVariant: "b"}, res)
+
+	store.EXPECT().IncrementCount(ctx, shortId, gomock.Any()).Return(expected, nil)
+	res, err = svc.IncrementRedirect(ctx, shortId, Visit{Variant: "removed"})
+	require.Nil(t, err)
+	require.Contains(t, []string{"a", "b"}, res.StickyVariant)
+	require.Equal(t, "http://www.test.com/"+res.StickyVariant, res.Url)
+}
+
+func TestWeightedVariant(t *testing.T) {
+	variants := []Variant{
+		{Name: "a", Weight: 70},
+		{Name: "b", Weight: 30},
+	}
+	require.Equal(t, "a", weightedVariant(variants, 0).Name)
+	require.Equal(t, "a", weightedVariant(variants, 69).Name)
+	require.Equal(t, "b", weightedVariant(variants, 70).Name)
+	require.Equal(t, "b", weightedVariant(variants, 99).Name)
+}
+
+func TestPickVariantOverflow(t *testing.T) {
+	// weights summing past the int64 range, as an import could store them
+	u := &ModelShorten{Variants: []Variant{
+		{Name: "a", Weight: math.MaxInt64},
+		{Name: "b", Weight: math.MaxInt64},
+	}}
+	require.NotPanics(t, func() { pickVariant(u, Visit{}) })
+}
+
+func TestService_ShortenUrlInvalidVariants(t *testing.T) {
+	svc, _ := MakeTestService(t)
+	ctx := context.Background()
+
+	opts := LinkOptions{
+		Variants: []Variant{
+			{Name: "a", Url: "http://www.test.com/a", Weight: 0},
+			{Name: "a", Url: "http://www.test.com/b", Weight: 1},
+			{Name: "c.d", Url: "http://www.test.com/c", Weight: 1},
+			{Name: "e", Url: "http://www.test.com/e", Weight: math.MaxInt64},
+		},
+	}
+
+	_, err := svc.ShortenUrl(ctx, testUrl, opts)
+	require.Equal(t, errs.CodeBadRequest, err.Code)
+	require.Equal(t, []errs.FieldError{
+		{Field: "variants.a", Message: "weight must be positive"},
+		{Field: "variants.a", Message: "duplicate name"},
+		{Field: "variants", Message: "names must be 1 to 32 letters, digits, _ or -"},
+		{Field: "variants.e", Message: "weight must be at most 10000"},
+	}, err.Details)
+
+	_, err = svc.ShortenUrl(ctx, testUrl, LinkOptions{StickyVariants: true})
+	require.Equal(t, "sticky_variants", err.Details[0].Field)
+}
+
+func TestWithQuery(t *testing.T) {
+	values := map[string]string{"{id}": shortId, "{country}": "it"}
+	for name, tc := range map[string]struct {
+		destination string
+		link        ModelShorten
+		query       string
+		expected    string
+	}{
+		"drop": {
+			destination: "http://www.test.com/p?x=1",
+			query:       "ref=a",
+			expected:    "http://www.test.com/p?x=1",
+		},
+		"merge": {
+			destination: "http://www.test.com/p?x=1&ref=orig#top",
+			link:        ModelShorten{QueryPolicy: QueryMerge},
+			query:       "ref=new&a=1&a=2",
+			expected:    "http://www.test.com/p?x=1&ref=orig&a=1&a=2#top",
+		},
+		"override": {
+			destination: "http://www.test.com/p?ref=orig&x=1&ref=other",
+			link:        ModelShorten{QueryPolicy: QueryOverride},
+			query:       "ref=new",
+			expected:    "http://www.test.com/p?x=1&ref=new",
+		},
+		"encoding": {
+			destination: "http://www.test.com/p?q=a%20b",
+			link:        ModelShorten{QueryPolicy: QueryMerge},
+			query:       "r=c+d%26e&%C3%A8=%2F",
+			expected:    "http://www.test.com/p?q=a%20b&r=c+d%26e&%C3%A8=%2F",
+		},
+		"utm": {
+			destination: "http://www.test.com/p",
+			link: ModelShorten{Utm: map[string]string{
+				"utm_source":   "short",
+				"utm_campaign": "{id}-{country}",
+			}},
+			expected: "http://www.test.com/p?utm_campaign=RMAp1Vz-it&utm_source=short",
+		},
+		"utm already set": {
+			destination: "http://www.test.com/p?utm_source=mail",
+			link: ModelShorten{QueryPolicy: QueryMerge, Utm: map[string]string{
+				"utm_source": "short",
+				"utm_medium": "link",
+			}},
+			query:    "utm_medium=qr",
+			expected: "http://www.test.com/p?utm_source=mail&utm_medium=qr",
+		},
+	} {
+		query, err := url.ParseQuery(tc.query)
+		require.Nil(t, err)
+		if tc.query == "" {
+			query = nil
+		}
+		require.Equal(t, tc.expected, withQuery(tc.destination, &tc.link, query, values), name)
+	}
+}
+
+func TestService_ShortenUrlInvalidQueryOptions(t *testing.T) {
+	svc, _ := MakeTestService(t)
+	ctx := context.Background()
+
+	opts := LinkOptions{
+		QueryPolicy: "append",
+		Utm: map[string]string{
+			"utm_source": "{source}",
+			"utm_x":      "x",
+		},
+	}
+
+	_, err := svc.ShortenUrl(ctx, testUrl, opts)
+	require.Equal(t, errs.CodeBadRequest, err.Code)
+	require.Len(t, err.Details, 3)
+}
+
+func TestWithPath(t *testing.T) {
+	for _, tc := range []struct {
+		destination string
+		path        string
+		expected    string
+	}{
+		{"http://www.test.com", "/guide/intro", "http://www.test.com/guide/intro"},
+		{"http://www.test.com/v2/", "/guide", "http://www.test.com/v2/guide"},
+		{"http://www.test.com/v2?lang=en#top", "/a%20b/c%2Fd", "http://www.test.com/v2/a%20b/c%2Fd?lang=en#top"},
+		{"http://www.test.com/v2", "", "http://www.test.com/v2"},
+	} {
+		require.Equal(t, tc.expected, withPath(tc.destination, tc.path), tc.path)
+	}
+
+	require.True(t, validPath("/guide/intro"))
+	require.False(t, validPath("/guide/../admin"))
+	require.False(t, validPath("/%2e%2e/admin"))
+	require.False(t, validPath("guide"))
+}
+
+func TestService_IncrementRedirectPath(t *testing.T) {
+	svc, store := MakeTestService(t)
+	ctx := context.Background()
+
+	expected := &ModelShorten{
+		Id:  shortId,
+		Url: "http://www.test.com/docs",
+	}
+	store.EXPECT().FindById(ctx, shortId).Return(expected, nil).Times(2)
+
+	// plain short urls don't forward paths
+	_, err := svc.IncrementRedirect(ctx, shortId, Visit{Path: "/guide"})
+	require.Equal(t, errs.CodeLinkNotFound, err.Code)
+
+	expected.ForwardPath = true
+	store.EXPECT().IncrementCount(ctx, shortId, Click{}).Return(expected, nil)
+	res, err := svc.IncrementRedirect(ctx, shortId, Visit{Path: "/guide"})
+	require.Nil(t, err)
+	require.Equal(t, &Redirect{Url: "http://www.test.com/docs/guide"}, res)
+}
+
+func TestService_IncrementRedirectPreview(t *testing.T) {
+	svc, store := MakeTestService(t)
+	ctx := context.Background()
+
+	created := time.Unix(1600000000, 0).UTC()
+	expected := &ModelShorten{
+		Id:        shortId,
+		Url:       testUrl + "/page",
+		Count:     3,
+		CreatedAt: created,
+		Preview:   true,
+	}
+	store.EXPECT().FindById(ctx, shortId).Return(expected, nil).Times(2)
+
+	// previews are not counted
+	res, err := svc.IncrementRedirect(ctx, shortId, Visit{})
+	require.Nil(t, err)
+	require.Equal(t, &Preview{
+		Id:        shortId,
+		Url:       testUrl + "/page",
+		Host:      "www.test.com",
+		CreatedAt: created,
+		Count:     3,
+	}, res.Preview)
+
+	store.EXPECT().IncrementCount(ctx, shortId, Click{}).Return(expected, nil)
+	res, err = svc.IncrementRedirect(ctx, shortId, Visit{Confirmed: true})
+	require.Nil(t, err)
+	require.Equal(t, &Redirect{Url: testUrl + "/page"}, res)
+}
+
+func TestService_PreviewUrl(t *testing.T) {
+	svc, store := MakeTestService(t)
+	ctx := context.Background()
+
+	expected := &ModelShorten{Id: shortId, Url: testUrl}
+	store.EXPECT().FindById(ctx, shortId).Return(expected, nil).Times(2)
+
+	res, err := svc.PreviewUrl(ctx, shortId, Visit{})
+	require.Nil(t, err)
+	require.Equal(t, testUrl, res.Url)
+
+	// the destination of a protected short url isn't disclosed
+	expected.PasswordHash = "hash"
+	_, err = svc.PreviewUrl(ctx, shortId, Visit{})
+	require.Equal(t, errs.CodePasswordRequired, err.Code)
+}
+
+func TestService_Policy(t *testing.T) {
+	log := logrus.New()
+	log.Out = ioutil.Discard
+
+	conf, err := config.Configure()
+	require.Nil(t, err)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	store := NewMockStore(ctrl)
+	policy := NewMockPolicy(ctrl)
+	svc := NewService(log, conf, store, WithPolicy(policy))
+	ctx := context.Background()
+
+	blocked := errors.New("the domain evil.com is blocked")
+	policy.EXPECT().Check(testUrl).Return(nil).AnyTimes()
+	policy.EXPECT().Check("http://evil.com").Return(blocked).AnyTimes()
+
+	// refused at create time, with every destination refused
+	_, e := svc.ShortenUrl(ctx, "http://evil.com", LinkOptions{
+		PlatformUrls: map[string]string{PlatformIos: testUrl, PlatformAndroid: "http://evil.com"},
+	})
+	require.NotNil(t, e)
+	require.Equal(t, errs.CodeUrlBlocked, e.Code)
+	require.Equal(t, http.StatusForbidden, e.HttpStatus)
+	require.Equal(t, []errs.FieldError{
+		{Field: "url", Message: blocked.Error()},
+		{Field: "platform_urls.android", Message: blocked.Error()},
+	}, e.Details)
+
+	// the short urls stored before are disabled
+	stored := &ModelShorten{Id: shortId, Url: testUrl, Variants: []Variant{{Name: "b", Url: "http://evil.com", Weight: 1}}}
+	store.EXPECT().FindById(ctx, shortId).Return(stored, nil).Times(2)
+
+	_, e = svc.IncrementRedirect(ctx, shortId, Visit{})
+	require.NotNil(t, e)
+	require.Equal(t, errs.CodeUrlBlocked, e.Code)
+	require.Equal(t, []errs.FieldError{{Field: "variants.b", Message: blocked.Error()}}, e.Details)
+
+	_, e = svc.PreviewUrl(ctx, shortId, Visit{})
+	require.NotNil(t, e)
+	require.Equal(t, errs.CodeUrlBlocked, e.Code)
+}
+
+func TestService_ShortenUrlSelfReference(t *testing.T) {
+	log := logrus.New()
+	log.Out = ioutil.Discard
+
+	conf, err := config.Configure()
+	require.Nil(t, err)
+	conf.ShortHosts = []string{"sho.rt"}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	store := NewMockStore(ctrl)
+	svc := NewService(log, conf, store)
+	ctx := context.Background()
+
+	// a plain short url is resolved to its destination, which is already shortened
+	store.EXPECT().FindById(ctx, shortId).Return(&ModelShorten{Id: shortId, Url: testUrl}, nil).Times(2)
+	store.EXPECT().FindUrl(ctx, testUrl).Return(&ModelShorten{Id: shortId, Url: testUrl}, nil)
+
+	res, e := svc.ShortenUrl(ctx, "https://SHO.RT/"+shortId, LinkOptions{})
+	require.Nil(t, e)
+	require.Equal(t, shortId, res)
+
+	// the alternate destinations are resolved as well
+	store.EXPECT().StoreUrl(ctx, gomock.Any()).Do(func(_ context.Context, u *ModelShorten) {
+		require.Equal(t, testUrl, u.PlatformUrls[PlatformIos])
+	})
+	_, e = svc.ShortenUrl(ctx, "http://www.test.it", LinkOptions{
+		PlatformUrls: map[string]string{PlatformIos: "http://sho.rt:443/" + shortId + "+"},
+	})
+	require.Nil(t, e)
+
+	// loops and short urls with options are refused
+	store.EXPECT().FindById(ctx, "a").Return(&ModelShorten{Id: "a", Url: "http://sho.rt/b"}, nil)
+	store.EXPECT().FindById(ctx, "b").Return(&ModelShorten{Id: "b", Url: "http://sho.rt/a"}, nil)
+	store.EXPECT().FindById(ctx, "c").Return(&ModelShorten{Id: "c", Url: testUrl, MaxClicks: 1}, nil)
+	store.EXPECT().FindById(ctx, "d").Return(nil, ErrNotFound)
+
+	_, e = svc.ShortenUrl(ctx, "http://sho.rt/a", LinkOptions{
+		CountryUrls:  map[string]string{"IT": "http://sho.rt/c", "FR": "http://sho.rt/d"},
+		PlatformUrls: map[string]string{PlatformDesktop: "http://sho.rt/docs/"},
+	})
+	require.NotNil(t, e)
+	require.Equal(t, errs.CodeRedirectLoop, e.Code)
+	require.Equal(t, []errs.FieldError{
+		{Field: "url", Message: "redirects back to a"},
+		{Field: "platform_urls.desktop", Message: "links to this service"},
+		{Field: "country_urls.FR", Message: "links to an unknown short url"},
+		{Field: "country_urls.IT", Message: "links to a short url with options, use its destination instead"},
+	}, e.Details)
+}
+
+func TestService_ShortenUrlRedirectLoop(t *testing.T) {
+	log := logrus.New()
+	log.Out = ioutil.Discard
+
+	conf, err := config.Configure()
+	require.Nil(t, err)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	store := NewMockStore(ctrl)
+	checker := NewMockRedirectChecker(ctrl)
+	svc := NewService(log, conf, store, WithRedirectChecker(checker))
+	ctx := context.Background()
+
+	checker.EXPECT().Check(ctx, "http://loop.test").Return(errors.New("redirects back to http://loop.test"))
+	checker.EXPECT().Check(ctx, testUrl).Return(nil)
+
+	_, e := svc.ShortenUrl(ctx, "http://loop.test", LinkOptions{
+		PlatformUrls: map[string]string{PlatformIos: testUrl},
+	})
+	require.NotNil(t, e)
+	require.Equal(t, errs.CodeRedirectLoop, e.Code)
+	require.Equal(t, []errs.FieldError{{Field: "url", Message: "redirects back to http://loop.test"}}, e.Details)
+}
+
+func TestService_Health(t *testing.T) {
+	svc, store := MakeTestService(t)
+	ctx := context.Background()
+
+	health := &Health{Broken: true}
+	store.EXPECT().FindById(ctx, shortId).Return(&ModelShorten{Id: shortId, Url: testUrl, Health: health}, nil)
+	store.EXPECT().FindById(ctx, "unknown").Return(nil, ErrNotFound)
+
+	res, err := svc.Health(ctx, "http://www.example.com/"+shortId)
+	require.Nil(t, err)
+	require.Equal(t, health, res)
+
+	_, err = svc.Health(ctx, "http://www.example.com/unknown")
+	require.NotNil(t, err)
+	require.Equal(t, errs.CodeLinkNotFound, err.Code)
+}
+
+func TestService_ShortenUrlIdInUse(t *testing.T) {
+	svc, store := MakeTestService(t)
+	ctx := context.Background()
+
+	var ids []string
+	store.EXPECT().FindUrl(ctx, testUrl).Return(nil, ErrNotFound)
+	store.EXPECT().StoreUrl(ctx, gomock.Any()).Do(func(_ context.Context, u *ModelShorten) {
+		ids = append(ids, u.Id)
+	}).Return(ErrDuplicate)
+	store.EXPECT().StoreUrl(ctx, gomock.Any()).Do(func(_ context.Context, u *ModelShorten) {
+		ids = append(ids, u.Id)
+	})
+
+	res, err := svc.ShortenUrl(ctx, testUrl, LinkOptions{})
+	require.Nil(t, err)
+	require.Len(t, ids, 2)
+	require.NotEqual(t, ids[0], ids[1])
+	require.Equal(t, ids[1], res)
+
+	// the ids are taken by another instance
+	store.EXPECT().FindUrl(ctx, testUrl).Return(nil, ErrNotFound)
+	store.EXPECT().StoreUrl(ctx, gomock.Any()).Return(ErrDuplicate).Times(maxIdAttempts)
+	_, err = svc.ShortenUrl(ctx, testUrl, LinkOptions{})
+	require.NotNil(t, err)
+	require.Equal(t, errs.CodeIdUnavailable, err.Code)
+	require.Equal(t, http.StatusServiceUnavailable, err.HttpStatus)
+}
+
+func TestIdSequence(t *testing.T) {
+	now := time.Unix(1600000000, 0).UTC()
+	seq := idSequence{}
+
+	// the ids of the same second are numbered, far past the attempts of a single creation
+	seen := map[string]bool{}
+	for i := 0; i < 2*maxIdAttempts; i++ {
+		id, err := newId(now, seq.take(now))
+		require.Nil(t, err)
+		require.False(t, seen[id])
+		seen[id] = true
+	}
+
+	// the numbers restart every second
+	require.Equal(t, 0, seq.take(now.Add(time.Second)))
+}
+
+func TestService_RestoreUrl(t *testing.T) {
+	svc, store := MakeTestService(t)
+	ctx := context.Background()
+
+	// restorable when deleted during the retention period
+	store.EXPECT().RestoreById(ctx, shortId, gomock.Any()).Do(func(_ context.Context, _ string, since time.Time) {
+		require.WithinDuration(t, time.Now().Add(-720*time.Hour), since, time.Minute)
+	})
+	err := svc.RestoreUrl(ctx, "http://www.example.com/"+shortId)
+	require.Nil(t, err)
+
+	store.EXPECT().RestoreById(ctx, shortId, gomock.Any()).Return(ErrNotFound)
+	err = svc.RestoreUrl(ctx, shortId)
+	require.NotNil(t, err)
+	require.Equal(t, errs.CodeLinkNotFound, err.Code)
+}
+
+func TestService_DeleteUrlNotFound(t *testing.T) {
+	svc, store := MakeTestService(t)
+	ctx := context.Background()
+
+	store.EXPECT().DeleteById(ctx, shortId).Return(ErrNotFound)
+
+	err := svc.DeleteUrl(ctx, shortId)
+	require.NotNil(t, err)
+	require.Equal(t, errs.CodeLinkNotFound, err.Code)
+}
+
+func TestService_PurgeDeleted(t *testing.T) {
+	svc, store := MakeTestService(t)
+	ctx := context.Background()
+
+	store.EXPECT().PurgeDeleted(ctx, gomock.Any()).Return(int64(2), nil)
+
+	res, err := svc.PurgeDeleted(ctx)
+	require.Nil(t, err)
+	require.Equal(t, int64(2), res)
+}
+
+func TestService_Audit(t *testing.T) {
+	log := logrus.New()
+	log.Out = ioutil.Discard
+
+	conf, err := config.Configure()
+	require.Nil(t, err)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	store := NewMockStore(ctrl)
+	auditor := NewMockAuditor(ctrl)
+	svc := NewService(log, conf, store, WithAuditor(auditor))
+	origin := audit.Origin{Actor: audit.ActorAdmin, ClientIp: "10.0.0.1", RequestId: "req-1"}
+	ctx := audit.WithOrigin(context.Background(), &origin)
+
+	// create records the new short url, without its password hash
+	var entry audit.Entry
+	store.EXPECT().StoreUrl(ctx, gomock.Any())
+	auditor.EXPECT().InsertAudit(ctx, gomock.Any()).Do(func(_ context.Context, e audit.Entry) { entry = e })
+	id, e := svc.ShortenUrl(ctx, testUrl, LinkOptions{Password: "secret"})
+	require.Nil(t, e)
+	require.Equal(t, audit.ActionCreate, entry.Action)
+	require.Equal(t, origin, entry.Origin)
+	require.Equal(t, id, entry.ShortId)
+	require.Nil(t, entry.Before)
+	after := exportRecord{ModelShorten: &ModelShorten{}}
+	require.Nil(t, json.Unmarshal(entry.After, &after))
+	require.Equal(t, testUrl, after.Url)
+	require.Equal(t, redacted, after.PasswordHash)
+
+	// delete records the deleted short url, a failure to record doesn't fail the operation
+	existing := &ModelShorten{Id: shortId, Url: testUrl}
+	store.EXPECT().FindById(ctx, shortId).Return(existing, nil)
+	store.EXPECT().DeleteById(ctx, shortId)
+	auditor.EXPECT().InsertAudit(ctx, gomock.Any()).Do(func(_ context.Context, e audit.Entry) { entry = e }).
+		Return(errors.New("unavailable"))
+	e = svc.DeleteUrl(ctx, shortId)
+	require.Nil(t, e)
+	require.Equal(t, audit.ActionDelete, entry.Action)
+	var before ModelShorten
+	require.Nil(t, json.Unmarshal(entry.Before, &before))
+	require.Equal(t, *existing, before)
+	require.Nil(t, entry.After)
+
+	// purge records the number of short urls removed
+	store.EXPECT().PurgeDeleted(ctx, gomock.Any()).Return(int64(3), nil)
+	auditor.EXPECT().InsertAudit(ctx, gomock.Any()).Do(func(_ context.Context, e audit.Entry) { entry = e })
+	_, e = svc.PurgeDeleted(ctx)
+	require.Nil(t, e)
+	require.Equal(t, audit.ActionPurge, entry.Action)
+	require.Equal(t, map[string]int64{"purged": 3}, entry.Details)
+
+	// the log is read with a bounded limit
+	auditor.EXPECT().FindAudit(ctx, audit.Filter{Action: audit.ActionDelete, Limit: maxAuditLimit}).
+		Return([]audit.Entry{entry}, nil)
+	res, e := svc.AuditLog(ctx, audit.Filter{Action: audit.ActionDelete})
+	require.Nil(t, e)
+	require.Len(t, res, 1)
+
+	_, e = svc.AuditLog(ctx, audit.Filter{Offset: -1})
+	require.NotNil(t, e)
+	require.Equal(t, http.StatusBadRequest, e.HttpStatus)
+}
+
+func TestService_ShortenUrlWithLabels(t *testing.T) {
+	svc, store := MakeTestService(t)
+	ctx := context.Background()
+
+	// short urls with labels are never shared
+	var stored *ModelShorten
+	store.EXPECT().StoreUrl(ctx, gomock.Any()).Do(func(_ context.Context, doc interface{}) {
+		stored = doc.(*ModelShorten)
+	})
+	_, e := svc.ShortenUrl(ctx, testUrl, LinkOptions{Labels: Labels{
+		Title:    " Spring sale ",
+		Tags:     []string{"Shoes", "campaign:spring", "shoes"},
+		Metadata: map[string]string{"owner": "marketing"},
+	}})
+	require.Nil(t, e)
+	require.Equal(t, Labels{
+		Title:    "Spring sale",
+		Tags:     []string{"campaign:spring", "shoes"},
+		Metadata: map[string]string{"owner": "marketing"},
+	}, stored.Labels)
+
+	_, e = svc.ShortenUrl(ctx, testUrl, LinkOptions{Labels: Labels{
+		Title:    strings.Repeat("a", 201),
+		Tags:     []string{"summer sale"},
+		Metadata: map[string]string{"Owner": "marketing", "team:a": "x"},
+	}})
+	require.NotNil(t, e)
+	require.Equal(t, []errs.FieldError{
+		{Field: "title", Message: "must be at most 200 characters"},
+		{Field: "tags", Message: "must be up to 50 letters, digits, _, ., : or -, starting with a letter or a digit"},
+		{Field: "metadata.Owner", Message: "key must be up to 50 lower case letters, digits, _, . or -, starting with a letter or a digit"},
+		{Field: "metadata.team:a", Message: "key must be up to 50 lower case letters, digits, _, . or -, starting with a letter or a digit"},
+	}, e.Details)
+}
+
+func TestService_UpdateUrl(t *testing.T) {
+	svc, store := MakeTestService(t)
+	ctx := context.Background()
+
+	existing := &ModelShorten{Id: shortId, Url: testUrl, Labels: Labels{
+		Title:    "Spring sale",
+		Tags:     []string{"shoes"},
+		Metadata: map[string]string{"owner": "marketing", "product": "sneakers"},
+	}}
+	expected := Labels{
+		Title:    "Spring sale",
+		Tags:     []string{"campaign:spring", "hats"},
+		Metadata: map[string]string{"owner": "sales"},
+	}
+	store.EXPECT().FindById(ctx, shortId).Return(existing, nil)
+	store.EXPECT().UpdateLabels(ctx, shortId, expected).Return(&ModelShorten{Id: shortId, Url: testUrl, Labels: expected}, nil)
+
+	tags := []string{"Hats", "campaign:spring"}
+	res, e := svc.UpdateUrl(ctx, "http://www.example.com/"+shortId, LinkUpdate{
+		Tags:     &tags,
+		Metadata: map[string]string{"owner": "sales", "product": ""},
+	})
+	require.Nil(t, e)
+	require.Equal(t, expected, res.Labels)
+
+	// nothing to change
+	_, e = svc.UpdateUrl(ctx, shortId, LinkUpdate{})
+	require.NotNil(t, e)
+	require.Equal(t, http.StatusBadRequest, e.HttpStatus)
+
+	// invalid labels are not stored
+	store.EXPECT().FindById(ctx, shortId).Return(existing, nil)
+	description := strings.Repeat("a", 2001)
+	_, e = svc.UpdateUrl(ctx, shortId, LinkUpdate{Description: &description})
+	require.NotNil(t, e)
+	require.Equal(t, []errs.FieldError{{Field: "description", Message: "must be at most 2000 characters"}}, e.Details)
+
+	store.EXPECT().FindById(ctx, shortId).Return(nil, ErrNotFound)
+	_, e = svc.UpdateUrl(ctx, shortId, LinkUpdate{Description: &description})
+	require.NotNil(t, e)
+	require.Equal(t, errs.CodeLinkNotFound, e.Code)
+}
+
+func TestService_ListUrlsFilters(t *testing.T) {
+	svc, store := MakeTestService(t)
+	ctx := context.Background()
+
+	store.EXPECT().ListUrls(ctx, ListOptions{Limit: 10, Tags: []string{"campaign:spring", "shoes"}}).
+		Return([]*ModelShorten{}, nil)
+	_, e := svc.ListUrls(ctx, ListOptions{Limit: 10, Tags: []string{"Shoes", "campaign:spring"}})
+	require.Nil(t, e)
+
+	_, e = svc.ListUrls(ctx, ListOptions{Limit: 10, Metadata: map[string]string{"$where": "1"}})
+	require.NotNil(t, e)
+	require.Equal(t, http.StatusBadRequest, e.HttpStatus)
+}
+
+func TestService_FetchPage(t *testing.T) {
+	log := logrus.New()
+	log.Out = ioutil.Discard
+
+	conf, err := config.Configure()
+	require.Nil(t, err)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	store := NewMockStore(ctrl)
+	pages := NewMockPageFetcher(ctrl)
+	svc := NewService(log, conf, store, WithPageFetcher(pages))
+	ctx := context.Background()
+
+	// the page of a new short url is fetched in the background
+	page := &Page{Title: "Test", OpenGraph: map[string]string{"title": "Test page"}}
+	recorded := make(chan Page, 1)
+	store.EXPECT().StoreUrl(ctx, gomock.Any())
+	pages.EXPECT().Fetch(gomock.Any(), testUrl).Return(page, nil)
+	store.EXPECT().UpdatePage(gomock.Any(), gomock.Any(), gomock.Any()).Do(func(_ context.Context, _ string, p Page) {
+		recorded <- p
+	})
+	_, e := svc.ShortenUrl(ctx, testUrl, LinkOptions{Preview: true})
+	require.Nil(t, e)
+	require.Equal(t, *page, <-recorded)
+
+	// the failures are recorded, not to fetch the page again
+	store.EXPECT().StoreUrl(ctx, gomock.Any())
+	pages.EXPECT().Fetch(gomock.Any(), testUrl).Return(nil, errors.New("address 10.0.0.1 is not public"))
+	store.EXPECT().UpdatePage(gomock.Any(), gomock.Any(), gomock.Any()).Do(func(_ context.Context, _ string, p Page) {
+		recorded <- p
+	})
+	_, e = svc.ShortenUrl(ctx, testUrl, LinkOptions{Preview: true})
+	require.Nil(t, e)
+	failed := <-recorded
+	require.Equal(t, "address 10.0.0.1 is not public", failed.Error)
+	require.False(t, failed.FetchedAt.IsZero())
+
+	// Wait returns once the page is recorded, for the commands exiting right after the creation
+	var waited Page
+	store.EXPECT().StoreUrl(ctx, gomock.Any())
+	pages.EXPECT().Fetch(gomock.Any(), testUrl).Return(page, nil)
+	store.EXPECT().UpdatePage(gomock.Any(), gomock.Any(), gomock.Any()).Do(func(_ context.Context, _ string, p Page) {
+		waited = p
+	})
+	_, e = svc.ShortenUrl(ctx, testUrl, LinkOptions{Preview: true})
+	require.Nil(t, e)
+	svc.Wait()
+	require.Equal(t, *page, waited)
+
+	// the preview shows the title of the page when the short url has none
+	store.EXPECT().FindById(ctx, shortId).Return(&ModelShorten{Id: shortId, Url: testUrl, Preview: true, Page: page}, nil)
+	preview, e := svc.PreviewUrl(ctx, shortId, Visit{})
+	require.Nil(t, e)
+	require.Equal(t, "Test page", preview.Title)
+}
+
+func TestService_ShortenUrlWorkspace(t *testing.T) {
+	svc, store := MakeTestService(t)
+	ctx := tenant.WithWorkspace(context.Background(), "team-a")
+
+	// the short urls of the other workspaces are not shared
+	existing := &ModelShorten{Id: shortId, Url: testUrl}
+	store.EXPECT().FindUrl(ctx, testUrl).Return(existing, nil)
+	store.EXPECT().StoreUrl(ctx, gomock.Any()).DoAndReturn(func(_ context.Context, doc interface{}) error {
+		require.Equal(t, "team-a", doc.(*ModelShorten).Workspace)
+		return nil
+	})
+
+	res, err := svc.ShortenUrl(ctx, testUrl, LinkOptions{})
+	require.Nil(t, err)
+	require.NotEqual(t, shortId, res)
+}
+
+func TestService_ShortenUrlQuota(t *testing.T) {
+	log := logrus.New()
+	log.Out = ioutil.Discard
+
+	conf, err := config.Configure()
+	require.Nil(t, err)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	store := NewMockStore(ctrl)
+	workspaces := NewMockWorkspaces(ctrl)
+	svc := NewService(log, conf, store, WithWorkspaces(workspaces))
+	ctx := tenant.WithWorkspace(context.Background(), "team-a")
+
+	workspace := &tenant.Workspace{Id: "team-a", Quota: tenant.Quota{MaxLinks: 2}}
+	store.EXPECT().FindUrl(ctx, testUrl).Return(nil, ErrNotFound).Times(2)
+	workspaces.EXPECT().FindWorkspace(ctx, "team-a").Return(workspace, nil).Times(2)
+	store.EXPECT().CountUrls(ctx, "team-a").Return(int64(1), nil)
+	store.EXPECT().StoreUrl(ctx, gomock.Any())
+
+	_, e := svc.ShortenUrl(ctx, testUrl, LinkOptions{})
+	require.Nil(t, e)
+
+	store.EXPECT().CountUrls(ctx, "team-a").Return(int64(2), nil)
+
+	_, e = svc.ShortenUrl(ctx, testUrl, LinkOptions{})
+	require.NotNil(t, e)
+	require.Equal(t, errs.CodeQuotaExceeded, e.Code)
+
+	// the default workspace has no quota until it is created
+	ctx = context.Background()
+	store.EXPECT().FindUrl(ctx, testUrl).Return(nil, ErrNotFound)
+	workspaces.EXPECT().FindWorkspace(ctx, tenant.Default).Return(nil, ErrNotFound)
+	store.EXPECT().StoreUrl(ctx, gomock.Any())
+
+	_, e = svc.ShortenUrl(ctx, testUrl, LinkOptions{})
+	require.Nil(t, e)
+}
+
+func TestService_ImportUrlsWorkspace(t *testing.T) {
+	svc, store := MakeTestService(t)
+	ctx := tenant.WithWorkspace(context.Background(), "team-a")
+
+	// the workspace of the records is replaced, and the short urls of the other workspaces are kept
+	input := `{"id":"RMAp1Vz","workspace":"team-b","url":"http://www.test.com","count":10}`
+	expected := &ModelShorten{Id: shortId, Workspace: "team-a", Url: testUrl, Count: 10}
+
+	store.EXPECT().StoreUrl(ctx, expected).Return(ErrDuplicate)
+	store.EXPECT().ReplaceUrl(ctx, expected).Return(ErrDuplicate)
+
+	res, err := svc.ImportUrls(ctx, strings.NewReader(input), FormatJSONL, true)
+	require.Nil(t, err)
+	require.Equal(t, &ImportResult{Skipped: 1}, res)
+}
+
+func TestService_ShortenUrlDomain(t *testing.T) {
+	log := logrus.New()
+	log.Out = ioutil.Discard
+
+	conf, err := config.Configure()
+	require.Nil(t, err)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	store := NewMockStore(ctrl)
+	domains := NewMockDomains(ctrl)
+	svc := NewService(log, conf, store, WithDomains(domains))
+	ctx := tenant.WithWorkspace(context.Background(), "team-a")
+
+	domain := &tenant.Domain{Host: "go.brand-a.com", Workspace: "team-a"}
+	domains.EXPECT().FindDomain(ctx, "go.brand-a.com").Return(domain, nil).AnyTimes()
+	domains.EXPECT().FindDomain(ctx, "s.brand-b.io").Return(&tenant.Domain{Host: "s.brand-b.io", Workspace: "team-b"}, nil).AnyTimes()
+	domains.EXPECT().FindDomain(ctx, "www.test.com").Return(nil, ErrNotFound).AnyTimes()
+	store.EXPECT().StoreUrl(ctx, gomock.Any()).Do(func(_ context.Context, u *ModelShorten) {
+		require.Equal(t, "go.brand-a.com", u.Domain)
+	})
+
+	_, e := svc.ShortenUrl(ctx, testUrl, LinkOptions{Domain: "Go.Brand-A.com"})
+	require.Nil(t, e)
+
+	// the domains of the other workspaces are refused
+	_, e = svc.ShortenUrl(ctx, testUrl, LinkOptions{Domain: "s.brand-b.io"})
+	require.NotNil(t, e)
+	require.Equal(t, errs.CodeBadRequest, e.Code)
+
+	// the destinations on the custom domains are resolved in their namespace
+	store.EXPECT().FindById(ctx, shortId).Return(&ModelShorten{Id: shortId, Url: testUrl}, nil)
+
+	_, e = svc.ShortenUrl(ctx, "http://go.brand-a.com/"+shortId, LinkOptions{})
+	require.NotNil(t, e)
+	require.Equal(t, errs.CodeRedirectLoop, e.Code)
+	require.Equal(t, []errs.FieldError{{Field: "url", Message: "links to an unknown short url"}}, e.Details)
+}
+
+func TestService_IncrementRedirectDomain(t *testing.T) {
+	svc, store := MakeTestService(t)
+	ctx := context.Background()
+
+	existing := &ModelShorten{Id: shortId, Url: testUrl, Domain: "go.brand-a.com"}
+	store.EXPECT().FindById(ctx, shortId).Return(existing, nil).Times(2)
+	store.EXPECT().IncrementCount(ctx, shortId, Click{}).Return(existing, nil)
+
+	// the short ids are not resolved on another domain
+	_, e := svc.IncrementRedirect(ctx, shortId, Visit{})
+	require.NotNil(t, e)
+	require.Equal(t, errs.CodeLinkNotFound, e.Code)
+
+	res, e := svc.IncrementRedirect(ctx, shortId, Visit{Domain: "go.brand-a.com"})
+	require.Nil(t, e)
+	require.Equal(t, testUrl, res.Url)
+}
+
+func TestService_Events(t *testing.T) {
+	log := logrus.New()
+	log.Out = ioutil.Discard
+
+	conf, err := config.Configure()
+	require.Nil(t, err)
+	conf.ClickThresholds = []int64{100}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	store := NewMockStore(ctrl)
+	outbox := NewMockOutbox(ctrl)
+	svc := NewService(log, conf, store, WithOutbox(outbox))
+	ctx := tenant.WithWorkspace(context.Background(), "team-a")
+
+	// the transactions of the tests run their function, the rollbacks are up to the store
+	outbox.EXPECT().Transaction(ctx, gomock.Any()).DoAndReturn(func(ctx context.Context, fn func(context.Context) error) error {
+		return fn(ctx)
+	}).AnyTimes()
+	var events []Event
+	outbox.EXPECT().InsertEvent(ctx, gomock.Any()).DoAndReturn(func(_ context.Context, e Event) error {
+		events = append(events, e)
+		return nil
+	}).Times(7)
+
+	// create emits the new short url, without its password hash
+	store.EXPECT().StoreUrl(ctx, gomock.Any())
+	_, e := svc.ShortenUrl(ctx, testUrl, LinkOptions{Password: "secret"})
+	require.Nil(t, e)
+	require.Len(t, events, 1)
+	require.Equal(t, EventLinkCreated, events[0].Type)
+	require.Equal(t, "team-a", events[0].Workspace)
+	require.Equal(t, testUrl, events[0].Link.Url)
+	require.Empty(t, events[0].Link.PasswordHash)
+	require.NotEmpty(t, events[0].Id)
+
+	// delete emits the deleted short url
+	existing := &ModelShorten{Id: shortId, Url: testUrl, Workspace: "team-a"}
+	store.EXPECT().FindById(ctx, shortId).Return(existing, nil)
+	store.EXPECT().DeleteById(ctx, shortId)
+	e = svc.DeleteUrl(ctx, shortId)
+	require.Nil(t, e)
+	require.Len(t, events, 2)
+	require.Equal(t, EventLinkDeleted, events[1].Type)
+	require.Equal(t, existing, events[1].Link)
+
+	// the redirect reaching a threshold emits the clicks
+	clicked := &ModelShorten{Id: shortId, Url: testUrl, Workspace: "team-a", Count: 99}
+	store.EXPECT().FindById(ctx, shortId).Return(clicked, nil)
+	store.EXPECT().IncrementCount(ctx, shortId, Click{}).Return(clicked, nil)
+	_, e = svc.IncrementRedirect(ctx, shortId, Visit{})
+	require.Nil(t, e)
+	require.Len(t, events, 4)
+	require.Equal(t, EventLinkClicked, events[2].Type)
+	require.Equal(t, "team-a", events[2].Workspace)
+	require.Equal(t, shortId, events[2].Link.Id)
+	require.Equal(t, EventLinkClicks, events[3].Type)
+	require.Equal(t, int64(100), events[3].Clicks)
+	require.Equal(t, int64(100), events[3].Link.Count)
+	require.Equal(t, int64(99), clicked.Count)
+
+	// the last click expires the short url
+	exhausted := &ModelShorten{Id: shortId, Url: testUrl, Workspace: "team-a", Count: 4, MaxClicks: 5}
+	store.EXPECT().FindById(ctx, shortId).Return(exhausted, nil)
+	store.EXPECT().IncrementCount(ctx, shortId, Click{}).Return(exhausted, nil)
+	_, e = svc.IncrementRedirect(ctx, shortId, Visit{})
+	require.Nil(t, e)
+	require.Len(t, events, 6)
+	require.Equal(t, EventLinkClicked, events[4].Type)
+	require.Equal(t, EventLinkExpired, events[5].Type)
+	require.Equal(t, ExpiryMaxClicks, events[5].Reason)
+
+	// the short urls past their not_after expire once, one transaction each
+	gomock.InOrder(
+		store.EXPECT().ExpireUrl(ctx, gomock.Any()).Return(existing, nil),
+		store.EXPECT().ExpireUrl(ctx, gomock.Any()).Return(nil, ErrNotFound),
+	)
+	n, e := svc.ExpireUrls(ctx)
+	require.Nil(t, e)
+	require.Equal(t, int64(1), n)
+	require.Len(t, events, 7)
+	require.Equal(t, EventLinkExpired, events[6].Type)
+	require.Equal(t, ExpiryNotAfter, events[6].Reason)
+
+	// every click carries its variant and country
+	click := svc.(*service).clickEvents(clicked, 7, Click{Variant: "b", Country: "IT"})
+	require.Len(t, click, 1)
+	require.Equal(t, EventLinkClicked, click[0].Type)
+	require.Equal(t, "b", click[0].Variant)
+	require.Equal(t, "IT", click[0].Country)
+	require.Equal(t, int64(7), click[0].Link.Count)
+}
+
+func TestService_ImportUrlsEvents(t *testing.T) {
+	log := logrus.New()
+	log.Out = ioutil.Discard
+
+	conf, err := config.Configure()
+	require.Nil(t, err)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	store := NewMockStore(ctrl)
+	outbox := NewMockOutbox(ctrl)
+	auditor := NewMockAuditor(ctrl)
+	svc := NewService(log, conf, store, WithOutbox(outbox), WithAuditor(auditor))
+	ctx := tenant.WithWorkspace(context.Background(), "team-a")
+
+	outbox.EXPECT().Transaction(ctx, gomock.Any()).DoAndReturn(func(ctx context.Context, fn func(context.Context) error) error {
+		return fn(ctx)
+	}).AnyTimes()
+	var events []Event
+	outbox.EXPECT().InsertEvent(ctx, gomock.Any()).DoAndReturn(func(_ context.Context, e Event) error {
+		events = append(events, e)
+		return nil
+	}).Times(2)
+	var entries []audit.Entry
+	auditor.EXPECT().InsertAudit(ctx, gomock.Any()).Do(func(_ context.Context, e audit.Entry) {
+		entries = append(entries, e)
+	}).Times(3)
+
+	// the created and the overwritten short urls are emitted and recorded one by one, as by the api
+	input := `{"id":"RMAp1Vz","url":"http://www.test.com","count":10}
+{"id":"pRA4OEy","url":"http://www.test.com","count":4}
+`
+	replaced := &ModelShorten{Id: "pRA4OEy", Workspace: "team-a", Url: "http://www.other.com"}
+	store.EXPECT().StoreUrl(ctx, gomock.Any())
+	store.EXPECT().StoreUrl(ctx, gomock.Any()).Return(ErrDuplicate)
+	store.EXPECT().FindById(ctx, "pRA4OEy").Return(replaced, nil)
+	store.EXPECT().ReplaceUrl(ctx, gomock.Any())
+
+	res, e := svc.ImportUrls(ctx, strings.NewReader(input), FormatJSONL, true)
+	require.Nil(t, e)
+	require.Equal(t, &ImportResult{Created: 1, Overwritten: 1}, res)
+
+	require.Equal(t, EventLinkCreated, events[0].Type)
+	require.Equal(t, shortId, events[0].Link.Id)
+	require.Equal(t, "team-a", events[0].Workspace)
+	require.Equal(t, EventLinkUpdated, events[1].Type)
+	require.Equal(t, "pRA4OEy", events[1].Link.Id)
+
+	require.Equal(t, audit.ActionCreate, entries[0].Action)
+	require.Equal(t, shortId, entries[0].ShortId)
+	require.Equal(t, audit.ActionUpdate, entries[1].Action)
+	require.Equal(t, "pRA4OEy", entries[1].ShortId)
+	require.NotNil(t, entries[1].Before)
+	require.Equal(t, audit.ActionImport, entries[2].Action)
+}
+
+func TestService_EventsRollback(t *testing.T) {
+	log := logrus.New()
+	log.Out = ioutil.Discard
+
+	conf, err := config.Configure()
+	require.Nil(t, err)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	store := NewMockStore(ctrl)
+	outbox := NewMockOutbox(ctrl)
+	svc := NewService(log, conf, store, WithOutbox(outbox))
+	ctx := context.Background()
+
+	// the mutation fails with its event, the store rolls both back
+	outbox.EXPECT().Transaction(ctx, gomock.Any()).DoAndReturn(func(ctx context.Context, fn func(context.Context) error) error {
+		return fn(ctx)
+	})
+	store.EXPECT().UpdateLabels(ctx, shortId, gomock.Any()).Return(&ModelShorten{Id: shortId, Url: testUrl}, nil)
+	store.EXPECT().FindById(ctx, shortId).Return(&ModelShorten{Id: shortId, Url: testUrl}, nil)
+	outbox.EXPECT().InsertEvent(ctx, gomock.Any()).Return(errors.New("unavailable"))
+
+	title := "Spring sale"
+	_, e := svc.UpdateUrl(ctx, shortId, LinkUpdate{Title: &title})
+	require.NotNil(t, e)
+	require.Equal(t, http.StatusInternalServerError, e.HttpStatus)
 }
@@ -0,0 +1,78 @@
+package shortener
+
+import (
+	"sync"
+	"time"
+)
+
+// throttle counts the failed attempts of a key and blocks it once they reach the limit
+// within the window. It is kept in memory, each instance of the service throttles on its own
+type throttle struct {
+	mu       sync.Mutex
+	limit    int
+	window   time.Duration
+	attempts map[string]*attempts
+	now      func() time.Time
+}
+
+type attempts struct {
+	failures int
+	since    time.Time
+}
+
+// sweepSize is the number of tracked keys above which the expired ones are removed
+const sweepSize = 10000
+
+func newThrottle(limit int, window time.Duration) *throttle {
+	return &throttle{
+		limit:    limit,
+		window:   window,
+		attempts: map[string]*attempts{},
+		now:      time.Now,
+	}
+}
+
+// blocked reports whether key reached the limit of failures in the current window
+func (t *throttle) blocked(key string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	a, ok := t.attempts[key]
+	if !ok {
+		return false
+	}
+	if t.now().Sub(a.since) > t.window {
+		delete(t.attempts, key)
+		return false
+	}
+	return a.failures >= t.limit
+}
+
+// fail records a failed attempt of key
+func (t *throttle) fail(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	if len(t.attempts) >= sweepSize {
+		for k, a := range t.attempts {
+			if now.Sub(a.since) > t.window {
+				delete(t.attempts, k)
+			}
+		}
+	}
+
+	a, ok := t.attempts[key]
+	if !ok || now.Sub(a.since) > t.window {
+		a = &attempts{since: now}
+		t.attempts[key] = a
+	}
+	a.failures++
+}
+
+// reset forgets the failures of key
+func (t *throttle) reset(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.attempts, key)
+}
@@ -0,0 +1,354 @@
+package shortener
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gsiragusa/short-to-me/audit"
+	"github.com/gsiragusa/short-to-me/errors"
+	"github.com/gsiragusa/short-to-me/tenant"
+)
+
+const (
+	FormatJSONL = "jsonl"
+	FormatCSV   = "csv"
+)
+
+// exportRecord is the jsonl record of a short url. It carries the password hash, hidden from
+// every other response, so that the protected short urls survive an export and import. The
+// audit log uses it too, with the hash redacted
+type exportRecord struct {
+	*ModelShorten
+	PasswordHash string `json:"password_hash,omitempty"`
+}
+
+// csvHeader lists the columns of the csv format, which carries the core fields and the labels of
+// a link. The files of the previous versions only have the first legacyCsvColumns columns
+var csvHeader = []string{"id", "url", "count", "created_at", "title", "description", "tags", "metadata"}
+
+const legacyCsvColumns = 4
+
+// service method that streams every stored url to w in the given format. The csv format skips the
+// short urls with options or on a custom domain, only the jsonl format carries them
+func (s *service) ExportUrls(ctx context.Context, w io.Writer, format string) (*ExportResult, *errors.Error) {
+	le := s.le.WithField("format", format)
+	le.Info("requested export")
+
+	var write func(*ModelShorten) error
+	var flush func() error
+	supported := func(*ModelShorten) bool { return true }
+	switch format {
+	case FormatJSONL:
+		encoder := json.NewEncoder(w)
+		write = func(u *ModelShorten) error {
+			return encoder.Encode(exportRecord{ModelShorten: u, PasswordHash: u.PasswordHash})
+		}
+		flush = func() error { return nil }
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(csvHeader); err != nil {
+			le.WithError(err).Error("unable to write export")
+			return nil, &internalServerError
+		}
+		write = func(u *ModelShorten) error {
+			record, err := toCsvRecord(u)
+			if err != nil {
+				return err
+			}
+			return cw.Write(record)
+		}
+		flush = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+		supported = func(u *ModelShorten) bool { return u.plain() && u.Domain == "" }
+	default:
+		le.Error("unknown export format")
+		e := errors.NewErrorBadRequest().WithDetail("format", "must be jsonl or csv")
+		return nil, &e
+	}
+
+	res := &ExportResult{}
+	err := s.store.ForEachUrl(ctx, func(u *ModelShorten) error {
+		if !supported(u) {
+			le.Warnf("skipped %s, its options are only exported in jsonl", u.Id)
+			res.Skipped++
+			return nil
+		}
+		res.Exported++
+		return write(u)
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		le.WithError(err).Error("unable to export urls")
+		return res, &internalServerError
+	}
+
+	le.Infof("exported %d urls, %d skipped", res.Exported, res.Skipped)
+	s.record(ctx, audit.ActionExport, "", nil, nil, map[string]int64{
+		"exported": int64(res.Exported),
+		"skipped":  int64(res.Skipped),
+	})
+	return res, nil
+}
+
+// service method that recreates the urls read from r with their original ids and counts.
+// Urls whose id is already stored are skipped, or replaced when overwrite is set
+func (s *service) ImportUrls(ctx context.Context, r io.Reader, format string, overwrite bool) (*ImportResult, *errors.Error) {
+	le := s.le.WithField("format", format)
+	le.Info("requested import")
+
+	var next func() (*ModelShorten, error)
+	switch format {
+	case FormatJSONL:
+		next = jsonlReader(r)
+	case FormatCSV:
+		next = csvReader(r)
+	default:
+		le.Error("unknown import format")
+		e := errors.NewErrorBadRequest().WithDetail("format", "must be jsonl or csv")
+		return nil, &e
+	}
+
+	res := &ImportResult{}
+	for line := 1; ; line++ {
+		u, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err == nil && (u.Id == "" || u.Url == "") {
+			err = fmt.Errorf("id and url are required")
+		}
+		if err != nil {
+			le.WithError(err).Errorf("invalid record %d", line)
+			e := errors.NewErrorBadRequest().WithDetail(fmt.Sprintf("record %d", line), err.Error())
+			return res, &e
+		}
+
+		// the records are checked as the new short urls, in the workspace ctx is restricted to
+		if _, ok := tenant.WorkspaceFrom(ctx); ok || u.Workspace == "" {
+			u.Workspace = workspaceOf(ctx)
+		}
+		if e := s.checkImported(ctx, u); e != nil {
+			if e.HttpStatus == http.StatusInternalServerError {
+				return res, e
+			}
+			le.Warnf("refused record %d: %v", line, e.Details)
+			res.Skipped++
+			res.Refused = append(res.Refused, errors.FieldError{
+				Field:   fmt.Sprintf("record %d", line),
+				Message: describeRefusal(e),
+			})
+			continue
+		}
+
+		if err := s.importUrl(ctx, u, overwrite, res); err != nil {
+			le.WithError(err).Errorf("unable to import %s", u.Id)
+			return res, &internalServerError
+		}
+	}
+
+	le.Infof("imported: %d created, %d overwritten, %d skipped", res.Created, res.Overwritten, res.Skipped)
+	s.record(ctx, audit.ActionImport, "", nil, nil, map[string]int64{
+		"created":     int64(res.Created),
+		"overwritten": int64(res.Overwritten),
+		"skipped":     int64(res.Skipped),
+	})
+	return res, nil
+}
+
+// checkImported refuses the records ShortenUrl would refuse: invalid options or labels, destinations
+// refused by the policy, domains of other workspaces. It normalizes the others as ShortenUrl does.
+// The short urls they link to are not resolved, they may be imported after them
+func (s *service) checkImported(ctx context.Context, u *ModelShorten) *errors.Error {
+	if !absoluteUrl(u.Url) {
+		e := errors.NewErrorInvalidUrl("must be an absolute http url")
+		return &e
+	}
+	opts := u.options()
+	if e := opts.validate(); e != nil {
+		return e
+	}
+	if e := s.checkPolicy(u.Destinations()); e != nil {
+		return e
+	}
+	if e := s.checkDomain(ctx, opts.Domain, u.Workspace); e != nil {
+		return e
+	}
+	// without a password, apply keeps the hash of the record
+	if err := opts.apply(u); err != nil {
+		s.le.WithError(err).Error("error applying options")
+		return &internalServerError
+	}
+	return nil
+}
+
+// describeRefusal returns the details of e on a line, or its message when it has none
+func describeRefusal(e *errors.Error) string {
+	if len(e.Details) == 0 {
+		return e.Message
+	}
+	details := make([]string, len(e.Details))
+	for i, d := range e.Details {
+		details[i] = d.Field + ": " + d.Message
+	}
+	return strings.Join(details, "; ")
+}
+
+// importUrl stores u, with its event and audit entry as a new short url. The short urls of another
+// workspace with the same id are never overwritten
+func (s *service) importUrl(ctx context.Context, u *ModelShorten, overwrite bool, res *ImportResult) error {
+	err := s.transaction(ctx, func(ctx context.Context) error {
+		if err := s.store.StoreUrl(ctx, u); err != nil {
+			return err
+		}
+		return s.emit(ctx, linkEvent(EventLinkCreated, u))
+	})
+	switch {
+	case err == nil:
+		res.Created++
+		s.record(ctx, audit.ActionCreate, u.Id, nil, u, nil)
+	case err != ErrDuplicate:
+		return err
+	case !overwrite:
+		res.Skipped++
+	default:
+		return s.overwriteUrl(ctx, u, res)
+	}
+	return nil
+}
+
+// overwriteUrl replaces the stored short url with the id of u, as an update of the short url
+func (s *service) overwriteUrl(ctx context.Context, u *ModelShorten, res *ImportResult) error {
+	// the audit log keeps the replaced url, unless it was in the trash
+	var before *ModelShorten
+	if s.auditor != nil {
+		if existing, err := s.store.FindById(ctx, u.Id); err == nil {
+			before = existing
+		}
+	}
+
+	err := s.transaction(ctx, func(ctx context.Context) error {
+		if err := s.store.ReplaceUrl(ctx, u); err != nil {
+			return err
+		}
+		return s.emit(ctx, linkEvent(EventLinkUpdated, u))
+	})
+	if err == ErrDuplicate {
+		res.Skipped++
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	res.Overwritten++
+	s.record(ctx, audit.ActionUpdate, u.Id, before, u, nil)
+	return nil
+}
+
+func jsonlReader(r io.Reader) func() (*ModelShorten, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	return func() (*ModelShorten, error) {
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" {
+				continue
+			}
+			record := exportRecord{ModelShorten: &ModelShorten{}}
+			if err := json.Unmarshal([]byte(line), &record); err != nil {
+				return nil, err
+			}
+			record.ModelShorten.PasswordHash = record.PasswordHash
+			return record.ModelShorten, nil
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+}
+
+func csvReader(r io.Reader) func() (*ModelShorten, error) {
+	cr := csv.NewReader(r)
+	// the number of columns is checked by fromCsvRecord, the legacy files have less of them
+	cr.FieldsPerRecord = -1
+	header := true
+	return func() (*ModelShorten, error) {
+		record, err := cr.Read()
+		if err == nil && header {
+			header = false
+			if record[0] == csvHeader[0] {
+				record, err = cr.Read()
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		return fromCsvRecord(record)
+	}
+}
+
+// toCsvRecord returns the columns of u, the tags separated by spaces and the metadata as a json object
+func toCsvRecord(u *ModelShorten) ([]string, error) {
+	createdAt := ""
+	if !u.CreatedAt.IsZero() {
+		createdAt = u.CreatedAt.Format(time.RFC3339)
+	}
+	metadata := ""
+	if len(u.Metadata) > 0 {
+		data, err := json.Marshal(u.Metadata)
+		if err != nil {
+			return nil, err
+		}
+		metadata = string(data)
+	}
+	return []string{
+		u.Id, u.Url, strconv.FormatInt(u.Count, 10), createdAt,
+		u.Title, u.Description, strings.Join(u.Tags, " "), metadata,
+	}, nil
+}
+
+func fromCsvRecord(record []string) (*ModelShorten, error) {
+	if len(record) != len(csvHeader) && len(record) != legacyCsvColumns {
+		return nil, fmt.Errorf("expected %d columns, got %d", len(csvHeader), len(record))
+	}
+	u := &ModelShorten{
+		Id:  record[0],
+		Url: record[1],
+	}
+
+	var err error
+	if record[2] != "" {
+		if u.Count, err = strconv.ParseInt(record[2], 10, 64); err != nil {
+			return nil, err
+		}
+	}
+	if record[3] != "" {
+		if u.CreatedAt, err = time.Parse(time.RFC3339, record[3]); err != nil {
+			return nil, err
+		}
+	}
+	if len(record) == legacyCsvColumns {
+		return u, nil
+	}
+
+	u.Title, u.Description = record[4], record[5]
+	u.Tags = strings.Fields(record[6])
+	if record[7] != "" {
+		if err := json.Unmarshal([]byte(record[7]), &u.Metadata); err != nil {
+			return nil, fmt.Errorf("metadata must be a json object: %v", err)
+		}
+	}
+	return u, nil
+}
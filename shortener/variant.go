@@ -0,0 +1,97 @@
+package shortener
+
+import (
+	"math/rand"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/gsiragusa/short-to-me/errors"
+)
+
+// Variant is one of the destinations rotating the visitors of a short url
+type Variant struct {
+	// Name identifies the variant in the click stats
+	Name string `json:"name" bson:"name"`
+	Url  string `json:"url" bson:"url"`
+	// Weight is the share of the visitors redirected to the variant, relative to the other variants
+	Weight int64 `json:"weight" bson:"weight"`
+}
+
+// maxVariants caps the number of variants of a short url
+const maxVariants = 20
+
+// maxWeight caps the weight of a variant, so that the sum of the weights can't overflow
+const maxWeight = 10000
+
+// variantName restricts the names to the characters allowed in the keys of the click stats
+var variantName = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
+// random is shared by the visits, math/rand sources aren't safe for concurrent use
+var random = struct {
+	sync.Mutex
+	*rand.Rand
+}{Rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
+// validateVariants adds the details of the invalid variants to e
+func validateVariants(e errors.Error, variants []Variant) errors.Error {
+	if len(variants) > maxVariants {
+		return e.WithDetail("variants", "must not be more than 20")
+	}
+	names := map[string]bool{}
+	for _, v := range variants {
+		field := "variants." + v.Name
+		switch {
+		case !variantName.MatchString(v.Name):
+			e = e.WithDetail("variants", "names must be 1 to 32 letters, digits, _ or -")
+		case names[v.Name]:
+			e = e.WithDetail(field, "duplicate name")
+		case v.Weight <= 0:
+			e = e.WithDetail(field, "weight must be positive")
+		case v.Weight > maxWeight:
+			e = e.WithDetail(field, "weight must be at most 10000")
+		case !absoluteUrl(v.Url):
+			e = e.WithDetail(field, "must be an absolute http url")
+		}
+		names[v.Name] = true
+	}
+	return e
+}
+
+// pickVariant returns the variant served to the visitor: the one it was previously served when
+// the variants are sticky, otherwise a random one according to the weights
+func pickVariant(u *ModelShorten, visit Visit) Variant {
+	if u.StickyVariants && visit.Variant != "" {
+		for _, v := range u.Variants {
+			if v.Name == visit.Variant {
+				return v
+			}
+		}
+	}
+
+	total := int64(0)
+	for _, v := range u.Variants {
+		if v.Weight > 0 && v.Weight <= maxWeight {
+			total += v.Weight
+		}
+	}
+	// the imported short urls skip the validation of the weights
+	if total <= 0 {
+		return u.Variants[0]
+	}
+	random.Lock()
+	roll := random.Int63n(total)
+	random.Unlock()
+	return weightedVariant(u.Variants, roll)
+}
+
+// weightedVariant returns the variant the roll falls in, roll being in [0, sum of the weights)
+func weightedVariant(variants []Variant, roll int64) Variant {
+	for _, v := range variants {
+		if roll < v.Weight {
+			return v
+		}
+		roll -= v.Weight
+	}
+	return variants[len(variants)-1]
+}
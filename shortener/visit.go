@@ -0,0 +1,62 @@
+package shortener
+
+import (
+	"time"
+
+	"github.com/gsiragusa/short-to-me/errors"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// checkVisit verifies that the short url can redirect the visitor
+func (s *service) checkVisit(u *ModelShorten, visit Visit) *errors.Error {
+	now := time.Now()
+	if u.NotBefore != nil && now.Before(*u.NotBefore) {
+		e := errors.NewErrorLinkNotActive()
+		return &e
+	}
+	if u.NotAfter != nil && !now.Before(*u.NotAfter) {
+		e := errors.NewErrorLinkExpired()
+		return &e
+	}
+	if u.MaxClicks > 0 && u.Count >= u.MaxClicks {
+		e := errors.NewErrorLinkExhausted()
+		return &e
+	}
+	if u.PasswordHash != "" {
+		return s.checkPassword(u, visit)
+	}
+	return nil
+}
+
+// pendingUrl returns the destination of the visits to a short url that is not active yet,
+// empty when the visitors get the coming soon response
+func (s *service) pendingUrl(u *ModelShorten) string {
+	if u.PendingUrl != "" {
+		return u.PendingUrl
+	}
+	return s.config.ComingSoonUrl
+}
+
+// checkPassword verifies the password of the visitor of a protected short url,
+// blocking the visitors that fail too many times
+func (s *service) checkPassword(u *ModelShorten, visit Visit) *errors.Error {
+	if visit.Password == "" {
+		e := errors.NewErrorPasswordRequired()
+		return &e
+	}
+
+	key := u.Id + "|" + visit.ClientIp
+	if s.passwords.blocked(key) {
+		e := errors.NewErrorRateLimited()
+		return &e
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(visit.Password)); err != nil {
+		s.passwords.fail(key)
+		e := errors.NewErrorInvalidPassword()
+		return &e
+	}
+
+	s.passwords.reset(key)
+	return nil
+}
@@ -0,0 +1,63 @@
+package shortener
+
+import (
+	"context"
+
+	"github.com/gsiragusa/short-to-me/errors"
+	"github.com/gsiragusa/short-to-me/tenant"
+)
+
+// workspaceOf returns the workspace ctx is restricted to, the default one when it is not
+func workspaceOf(ctx context.Context) string {
+	if workspace, ok := tenant.WorkspaceFrom(ctx); ok {
+		return workspace
+	}
+	return tenant.Default
+}
+
+// workspace returns the workspace of the short url, the documents stored before the workspaces
+// belong to the default one
+func (m *ModelShorten) workspace() string {
+	if m.Workspace == "" {
+		return tenant.Default
+	}
+	return m.Workspace
+}
+
+// checkQuota refuses a new short url in workspace when it reached its quota. The default workspace
+// has no quota unless it is created explicitly
+func (s *service) checkQuota(ctx context.Context, workspace string) *errors.Error {
+	if s.workspaces == nil {
+		return nil
+	}
+	le := s.le.WithField("workspace", workspace)
+
+	w, err := s.workspaces.FindWorkspace(ctx, workspace)
+	if err == ErrNotFound && workspace == tenant.Default {
+		return nil
+	}
+	if err == ErrNotFound {
+		le.Error("unknown workspace")
+		e := errors.NewErrorBadRequest().WithDetail("workspace", "does not exist")
+		return &e
+	}
+	if err != nil {
+		le.WithError(err).Error("unable to read the workspace")
+		return &internalServerError
+	}
+	if w.Quota.MaxLinks == 0 {
+		return nil
+	}
+
+	count, err := s.store.CountUrls(ctx, workspace)
+	if err != nil {
+		le.WithError(err).Error("unable to count the short urls")
+		return &internalServerError
+	}
+	if count >= w.Quota.MaxLinks {
+		le.Errorf("quota of %d short urls reached", w.Quota.MaxLinks)
+		e := errors.NewErrorQuotaExceeded()
+		return &e
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+// Package tenant describes the workspaces isolating the short urls of the teams sharing the
+// service, their api keys, and the workspace the operations are scoped to through their context
+package tenant
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Default is the workspace of the short urls created without a workspace api key
+const Default = "default"
+
+// keyPrefix starts the api keys of the workspaces, to tell them apart from the admin api key
+const keyPrefix = "stm_"
+
+// idPattern matches the ids of the workspaces
+var idPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+
+// hostPattern matches the names of the custom domains, made of at least two labels
+var hostPattern = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// Workspace isolates the short urls, api keys, audit log and analytics of a team
+type Workspace struct {
+	Id        string    `json:"id" bson:"_id"`
+	Name      string    `json:"name,omitempty" bson:"name,omitempty"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+	Quota     Quota     `json:"quota" bson:"quota"`
+}
+
+// Quota limits the resources of a workspace, the zero values are unlimited
+type Quota struct {
+	// MaxLinks is the number of short urls of the workspace, the deleted ones included until they are purged
+	MaxLinks int64 `json:"max_links,omitempty" bson:"max_links,omitempty"`
+}
+
+// Key is an api key of a workspace. Only the hash of the key is stored, the key is shown once
+// when it is created
+type Key struct {
+	Hash      string     `json:"-" bson:"_id"`
+	Id        string     `json:"id" bson:"key_id"`
+	Workspace string     `json:"workspace" bson:"workspace"`
+	Name      string     `json:"name,omitempty" bson:"name,omitempty"`
+	CreatedAt time.Time  `json:"created_at" bson:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty" bson:"revoked_at,omitempty"`
+}
+
+// Domain is a custom domain serving the short urls of a workspace. The short ids are resolved in
+// the namespace of the domain the visits are sent to
+type Domain struct {
+	Host      string    `json:"host" bson:"_id"`
+	Workspace string    `json:"workspace" bson:"workspace"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+// NormalizeHost returns host in lowercase without its port, as the custom domains are stored
+func NormalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+}
+
+// ValidHost reports whether the normalized host can be a custom domain
+func ValidHost(host string) bool {
+	return len(host) <= 253 && hostPattern.MatchString(host)
+}
+
+// ValidId reports whether id can identify a workspace
+func ValidId(id string) bool {
+	return idPattern.MatchString(id)
+}
+
+// NewKey returns a new api key of workspace with its secret, to hand over to the team
+func NewKey(workspace, name string) (Key, string, error) {
+	id := make([]byte, 6)
+	secret := make([]byte, 24)
+	if _, err := rand.Read(id); err != nil {
+		return Key{}, "", err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return Key{}, "", err
+	}
+	key := Key{
+		Id:        hex.EncodeToString(id),
+		Workspace: workspace,
+		Name:      name,
+		CreatedAt: time.Now().UTC(),
+	}
+	plain := keyPrefix + key.Id + "_" + hex.EncodeToString(secret)
+	key.Hash = Hash(plain)
+	return key, plain, nil
+}
+
+// IsKey reports whether key has the format of the api keys of the workspaces
+func IsKey(key string) bool {
+	return len(key) > len(keyPrefix) && key[:len(keyPrefix)] == keyPrefix
+}
+
+// Hash returns the hash an api key is stored and looked up by. The keys are random, a fast hash
+// is enough to protect them
+func Hash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Scope is the workspace the operations of a request are restricted to
+type Scope struct {
+	workspace string
+}
+
+type scopeKey struct{}
+
+// WithScope returns a copy of ctx carrying an empty scope, set by SetWorkspace once the request is
+// authenticated. The operations of a context without workspace are not restricted
+func WithScope(ctx context.Context) context.Context {
+	return context.WithValue(ctx, scopeKey{}, &Scope{})
+}
+
+// WithWorkspace returns a copy of ctx restricted to workspace
+func WithWorkspace(ctx context.Context, workspace string) context.Context {
+	return context.WithValue(ctx, scopeKey{}, &Scope{workspace: workspace})
+}
+
+// SetWorkspace restricts the scope carried by ctx to workspace, if any
+func SetWorkspace(ctx context.Context, workspace string) {
+	if scope, ok := ctx.Value(scopeKey{}).(*Scope); ok {
+		scope.workspace = workspace
+	}
+}
+
+// WorkspaceFrom returns the workspace ctx is restricted to, false when it is not restricted
+func WorkspaceFrom(ctx context.Context) (string, bool) {
+	if scope, ok := ctx.Value(scopeKey{}).(*Scope); ok && scope.workspace != "" {
+		return scope.workspace, true
+	}
+	return "", false
+}
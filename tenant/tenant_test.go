@@ -0,0 +1,55 @@
+package tenant
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestScope(t *testing.T) {
+	_, ok := WorkspaceFrom(context.Background())
+	require.False(t, ok)
+
+	ctx := WithScope(context.Background())
+	_, ok = WorkspaceFrom(ctx)
+	require.False(t, ok)
+
+	SetWorkspace(ctx, "team-a")
+	workspace, ok := WorkspaceFrom(ctx)
+	require.True(t, ok)
+	require.Equal(t, "team-a", workspace)
+
+	// no scope to restrict
+	SetWorkspace(context.Background(), "team-a")
+}
+
+func TestNewKey(t *testing.T) {
+	key, plain, err := NewKey("team-a", "ci")
+	require.Nil(t, err)
+	require.True(t, IsKey(plain))
+	require.Equal(t, Hash(plain), key.Hash)
+	require.Equal(t, "team-a", key.Workspace)
+
+	other, _, err := NewKey("team-a", "ci")
+	require.Nil(t, err)
+	require.NotEqual(t, key.Id, other.Id)
+
+	require.False(t, IsKey("secret"))
+}
+
+func TestValidId(t *testing.T) {
+	require.True(t, ValidId("team-a"))
+	require.False(t, ValidId("Team_A"))
+	require.False(t, ValidId("-team"))
+	require.False(t, ValidId(""))
+}
+
+func TestNormalizeHost(t *testing.T) {
+	require.Equal(t, "go.brand-a.com", NormalizeHost("Go.Brand-A.com:8081"))
+	require.Equal(t, "go.brand-a.com", NormalizeHost("go.brand-a.com."))
+	require.True(t, ValidHost(NormalizeHost("S.Brand-B.io")))
+	require.False(t, ValidHost("localhost"))
+	require.False(t, ValidHost("-brand.com"))
+	require.False(t, ValidHost("brand..com"))
+}
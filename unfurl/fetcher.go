@@ -0,0 +1,140 @@
+// Package unfurl reads the destination pages of the short urls, extracting their title, Open Graph
+// and Twitter card metadata and favicon. The pages are only requested on public addresses
+package unfurl
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"time"
+
+	"github.com/gsiragusa/short-to-me/netguard"
+	"github.com/gsiragusa/short-to-me/shortener"
+	"github.com/sirupsen/logrus"
+)
+
+// userAgent identifies the requests of the fetcher to the destinations
+const userAgent = "short-to-me-unfurl/1.0"
+
+// maxRedirects is the number of redirects followed to reach a page
+const maxRedirects = 5
+
+// Fetcher requests the destination pages, a few at a time
+type Fetcher struct {
+	le           *logrus.Logger
+	client       *http.Client
+	maxBytes     int64
+	slots        chan struct{}
+	allowPrivate bool
+}
+
+// Option configures a Fetcher
+type Option func(*Fetcher)
+
+// AllowPrivate lets the fetcher request private and local addresses, for the tests and the
+// deployments shortening internal urls
+func AllowPrivate() Option {
+	return func(f *Fetcher) {
+		f.allowPrivate = true
+	}
+}
+
+// NewFetcher returns a Fetcher requesting concurrency pages at a time. Every request, body
+// included, is bounded by timeout, and at most maxBytes of the pages are read
+func NewFetcher(le *logrus.Logger, concurrency int, timeout time.Duration, maxBytes int64, opts ...Option) *Fetcher {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	f := &Fetcher{
+		le:       le,
+		maxBytes: maxBytes,
+		slots:    make(chan struct{}, concurrency),
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+
+	transport := netguard.NewTransport(timeout, f.allowPrivate)
+	transport.MaxIdleConnsPerHost = 1
+	f.client = &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return fmt.Errorf("follows more than %d redirects", maxRedirects)
+			}
+			if !webUrl(req.URL) {
+				return fmt.Errorf("redirects to a %s url", req.URL.Scheme)
+			}
+			return nil
+		},
+	}
+	return f
+}
+
+// Fetch requests the page at url, returning its title and metadata. The pages that are not html
+// have no metadata
+func (f *Fetcher) Fetch(ctx context.Context, url string) (*shortener.Page, error) {
+	u, err := neturl.Parse(url)
+	if err != nil {
+		return nil, err
+	}
+	if !webUrl(u) {
+		return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+
+	select {
+	case f.slots <- struct{}{}:
+		defer func() { <-f.slots }()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml;q=0.9,*/*;q=0.1")
+	res, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < http.StatusOK || res.StatusCode >= http.StatusMultipleChoices {
+		return nil, fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
+
+	// the relative urls of the page are resolved against the url reached after the redirects
+	page := &shortener.Page{FetchedAt: time.Now().UTC()}
+	base := res.Request.URL
+	mediaType, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		page.Favicon = defaultFavicon(base)
+		page.Error = "not an html page: " + mediaType
+		return page, nil
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, f.maxBytes))
+	if err != nil {
+		return nil, err
+	}
+	parse(page, strings.ToValidUTF8(string(body), ""), base)
+	return page, nil
+}
+
+// webUrl reports whether u is an absolute http or https url
+func webUrl(u *neturl.URL) bool {
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// defaultFavicon is the favicon browsers request when a page has none
+func defaultFavicon(base *neturl.URL) string {
+	return (&neturl.URL{Scheme: base.Scheme, Host: base.Host, Path: "/favicon.ico"}).String()
+}
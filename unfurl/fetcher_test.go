@@ -0,0 +1,160 @@
+package unfurl
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	neturl "net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gsiragusa/short-to-me/shortener"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+const article = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>
+  Spring sale &amp; more
+</title>
+<!-- <meta property="og:title" content="commented out"> -->
+<meta name="description" content="Shoes &quot;on sale&quot;">
+<meta property="og:title" content="Spring sale">
+<meta property="og:image" content="/cover.png">
+<meta property="og:image" content="/second.png">
+<meta property='og:type' content='website'>
+<meta name="twitter:card" content="summary_large_image">
+<meta name="twitter:title" content="Sale > 50%">
+<script>var html = '<meta property="og:description" content="in a script">';</script>
+<link rel="shortcut icon" href="/static/icon.png">
+</head>
+<body>
+<meta property="og:description" content="in the body">
+</body>
+</html>`
+
+func MakeTestFetcher(t *testing.T, maxBytes int64, opts ...Option) *Fetcher {
+	log := logrus.New()
+	log.Out = ioutil.Discard // silent logger
+
+	return NewFetcher(log, 2, time.Second, maxBytes, opts...)
+}
+
+// standIn serves the pages of the tests
+func standIn(t *testing.T) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/article":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			_, _ = w.Write([]byte(article))
+		case "/moved":
+			http.Redirect(w, r, "/blog/article", http.StatusMovedPermanently)
+		case "/blog/article":
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = w.Write([]byte(`<title>Blog</title><meta property="og:image" content="cover.png">`))
+		case "/large":
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = w.Write([]byte("<head>" + strings.Repeat(" ", 4096) + "<title>Too far</title>"))
+		case "/report.pdf":
+			w.Header().Set("Content-Type", "application/pdf")
+			_, _ = w.Write([]byte("%PDF-1.4"))
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestFetcher_Fetch(t *testing.T) {
+	srv := standIn(t)
+	fetcher := MakeTestFetcher(t, 1<<20, AllowPrivate())
+
+	page, err := fetcher.Fetch(context.Background(), srv.URL+"/article")
+	require.Nil(t, err)
+	require.WithinDuration(t, time.Now(), page.FetchedAt, time.Minute)
+	page.FetchedAt = time.Time{}
+	require.Equal(t, &shortener.Page{
+		Title:       "Spring sale & more",
+		Description: `Shoes "on sale"`,
+		OpenGraph: map[string]string{
+			"title": "Spring sale",
+			"image": srv.URL + "/cover.png",
+			"type":  "website",
+		},
+		Twitter: map[string]string{
+			"card":  "summary_large_image",
+			"title": "Sale > 50%",
+		},
+		Favicon: srv.URL + "/static/icon.png",
+	}, page)
+}
+
+func TestFetcher_FetchRedirect(t *testing.T) {
+	srv := standIn(t)
+	fetcher := MakeTestFetcher(t, 1<<20, AllowPrivate())
+
+	// the relative urls are resolved against the page reached
+	page, err := fetcher.Fetch(context.Background(), srv.URL+"/moved")
+	require.Nil(t, err)
+	require.Equal(t, "Blog", page.Title)
+	require.Equal(t, srv.URL+"/blog/cover.png", page.OpenGraph["image"])
+	require.Equal(t, srv.URL+"/favicon.ico", page.Favicon)
+
+	_, err = fetcher.Fetch(context.Background(), srv.URL+"/loop")
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "follows more than 5 redirects")
+}
+
+func TestFetcher_FetchLimits(t *testing.T) {
+	srv := standIn(t)
+	fetcher := MakeTestFetcher(t, 1024, AllowPrivate())
+
+	// the pages are read up to the size limit
+	page, err := fetcher.Fetch(context.Background(), srv.URL+"/large")
+	require.Nil(t, err)
+	require.Empty(t, page.Title)
+
+	page, err = fetcher.Fetch(context.Background(), srv.URL+"/report.pdf")
+	require.Nil(t, err)
+	require.Equal(t, "not an html page: application/pdf", page.Error)
+
+	_, err = fetcher.Fetch(context.Background(), srv.URL+"/missing")
+	require.NotNil(t, err)
+	require.Equal(t, "unexpected status 404", err.Error())
+
+	_, err = fetcher.Fetch(context.Background(), "ftp://example.com/file")
+	require.NotNil(t, err)
+}
+
+func TestFetcher_FetchPrivate(t *testing.T) {
+	srv := standIn(t)
+	fetcher := MakeTestFetcher(t, 1<<20)
+
+	// the local test server is refused without AllowPrivate
+	_, err := fetcher.Fetch(context.Background(), srv.URL+"/article")
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "is not public")
+}
+
+func TestParse(t *testing.T) {
+	base, _ := neturl.Parse("https://www.example.com/a/page.html")
+
+	page := &shortener.Page{}
+	parse(page, `<HTML><HEAD><BASE HREF="https://cdn.example.com/assets/">
+<META PROPERTY="OG:IMAGE" CONTENT="img.png"><meta name=twitter:image content=javascript:alert(1)>
+<meta property="og:bad key" content="ignored"><link rel=icon href=//static.example.com/favicon.svg>
+<Title>Caf&eacute;</TITLE></HEAD>`, base)
+	require.Equal(t, &shortener.Page{
+		Title:     "Café",
+		OpenGraph: map[string]string{"image": "https://cdn.example.com/assets/img.png"},
+		Favicon:   "https://static.example.com/favicon.svg",
+	}, page)
+}
@@ -0,0 +1,232 @@
+package unfurl
+
+import (
+	"html"
+	neturl "net/url"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gsiragusa/short-to-me/shortener"
+)
+
+// Limits of the metadata recorded from a page
+const (
+	maxTitle      = 300
+	maxValue      = 1000
+	maxProperties = 32
+)
+
+// attrPattern matches the attributes of a tag, with a double quoted, single quoted or bare value
+var attrPattern = regexp.MustCompile(`([^\s"'<>/=]+)(?:\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'=<>` + "`" + `]+)))?`)
+
+// propertyPattern matches the names of the Open Graph and Twitter card properties kept
+var propertyPattern = regexp.MustCompile(`^[a-z0-9_:-]{1,64}$`)
+
+// urlProperties are the properties holding urls, resolved against the url of the page
+var urlProperties = map[string]bool{
+	"url":              true,
+	"image":            true,
+	"image:url":        true,
+	"image:secure_url": true,
+	"image:src":        true,
+}
+
+// parse records the title and the metadata of the head of doc in page. The document is scanned
+// tag by tag until the body, skipping the comments, scripts and styles, which are the only parts
+// of a head where a tag can be found out of place
+func parse(page *shortener.Page, doc string, base *neturl.URL) {
+	// the closing tags are searched in a copy of the same length with the ascii letters in lower case
+	lower := strings.Map(asciiLower, doc)
+	var favicon string
+	for i := 0; i < len(doc); {
+		start := strings.IndexByte(doc[i:], '<')
+		if start < 0 {
+			break
+		}
+		i += start
+
+		if strings.HasPrefix(doc[i:], "<!--") {
+			end := strings.Index(doc[i:], "-->")
+			if end < 0 {
+				break
+			}
+			i += end + len("-->")
+			continue
+		}
+
+		end := tagEnd(doc, i)
+		if end < 0 {
+			break
+		}
+		name, attrs := tag(doc[i+1 : end])
+		i = end + 1
+
+		switch name {
+		case "body", "/head":
+			i = len(doc)
+		case "script", "style", "title", "noscript", "template":
+			closing := strings.Index(lower[i:], "</"+name)
+			if closing < 0 {
+				closing = len(doc) - i
+			}
+			if name == "title" && page.Title == "" {
+				page.Title = truncate(text(doc[i:i+closing]), maxTitle)
+			}
+			i += closing
+		case "base":
+			if href, err := base.Parse(attrs["href"]); err == nil && attrs["href"] != "" {
+				base = href
+			}
+		case "meta":
+			meta(page, attrs)
+		case "link":
+			if favicon == "" && icon(attrs["rel"]) && attrs["href"] != "" {
+				favicon = attrs["href"]
+			}
+		}
+	}
+
+	page.Favicon = resolve(base, favicon)
+	if page.Favicon == "" {
+		page.Favicon = defaultFavicon(base)
+	}
+	page.OpenGraph = resolveAll(base, page.OpenGraph)
+	page.Twitter = resolveAll(base, page.Twitter)
+}
+
+// resolveAll resolves the urls of properties, removing the ones that are not http urls
+func resolveAll(base *neturl.URL, properties map[string]string) map[string]string {
+	for key, value := range properties {
+		if !urlProperties[key] {
+			continue
+		}
+		if u := resolve(base, value); u != "" {
+			properties[key] = u
+		} else {
+			delete(properties, key)
+		}
+	}
+	if len(properties) == 0 {
+		return nil
+	}
+	return properties
+}
+
+// meta records the description and the Open Graph and Twitter card properties
+func meta(page *shortener.Page, attrs map[string]string) {
+	key := strings.ToLower(attrs["property"])
+	if key == "" {
+		key = strings.ToLower(attrs["name"])
+	}
+	value := truncate(text(attrs["content"]), maxValue)
+	if value == "" {
+		return
+	}
+
+	switch {
+	case key == "description":
+		if page.Description == "" {
+			page.Description = value
+		}
+	case strings.HasPrefix(key, "og:"):
+		page.OpenGraph = property(page.OpenGraph, strings.TrimPrefix(key, "og:"), value)
+	case strings.HasPrefix(key, "twitter:"):
+		page.Twitter = property(page.Twitter, strings.TrimPrefix(key, "twitter:"), value)
+	}
+}
+
+// property sets key in properties, unless it is already set, the first value of the repeated
+// properties being the main one
+func property(properties map[string]string, key, value string) map[string]string {
+	if !propertyPattern.MatchString(key) {
+		return properties
+	}
+	if properties == nil {
+		properties = map[string]string{}
+	}
+	if _, ok := properties[key]; !ok && len(properties) < maxProperties {
+		properties[key] = value
+	}
+	return properties
+}
+
+// tagEnd returns the index of the > closing the tag starting at start, ignoring the ones quoted
+// in the attributes, -1 when the tag is not closed
+func tagEnd(doc string, start int) int {
+	var quote byte
+	for i := start + 1; i < len(doc); i++ {
+		switch c := doc[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '>':
+			return i
+		}
+	}
+	return -1
+}
+
+// tag returns the lower case name and the attributes of the content of a tag
+func tag(content string) (string, map[string]string) {
+	content = strings.TrimSuffix(content, "/")
+	nameEnd := strings.IndexAny(content, " \t\r\n/")
+	if nameEnd < 0 {
+		nameEnd = len(content)
+	}
+	name := strings.ToLower(content[:nameEnd])
+
+	attrs := map[string]string{}
+	for _, m := range attrPattern.FindAllStringSubmatch(content[nameEnd:], -1) {
+		key := strings.ToLower(m[1])
+		if _, ok := attrs[key]; !ok {
+			attrs[key] = html.UnescapeString(m[2] + m[3] + m[4])
+		}
+	}
+	return name, attrs
+}
+
+// icon reports whether the rel attribute of a link designates a favicon
+func icon(rel string) bool {
+	for _, token := range strings.Fields(strings.ToLower(rel)) {
+		if token == "icon" {
+			return true
+		}
+	}
+	return false
+}
+
+// resolve returns the absolute http url of ref, empty when it is not one
+func resolve(base *neturl.URL, ref string) string {
+	if ref == "" {
+		return ""
+	}
+	u, err := base.Parse(ref)
+	if err != nil || !webUrl(u) {
+		return ""
+	}
+	return u.String()
+}
+
+// text unescapes s, collapsing its white spaces
+func text(s string) string {
+	return strings.Join(strings.Fields(html.UnescapeString(s)), " ")
+}
+
+// truncate cuts s to max characters
+func truncate(s string, max int) string {
+	if utf8.RuneCountInString(s) <= max {
+		return s
+	}
+	return string([]rune(s)[:max])
+}
+
+func asciiLower(r rune) rune {
+	if 'A' <= r && r <= 'Z' {
+		return r + 'a' - 'A'
+	}
+	return r
+}
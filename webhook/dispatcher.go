@@ -0,0 +1,244 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gsiragusa/short-to-me/netguard"
+	"github.com/gsiragusa/short-to-me/shortener"
+	"github.com/gsiragusa/short-to-me/tenant"
+	"github.com/sirupsen/logrus"
+)
+
+// userAgent identifies the deliveries to the endpoints
+const userAgent = "short-to-me-webhook/1.0"
+
+// maxBackoff bounds the wait between two attempts of a delivery
+const maxBackoff = 6 * time.Hour
+
+// maxResponseBytes is the part of the responses of the endpoints read before closing them
+const maxResponseBytes = 4096
+
+// Dispatcher queues the events of the short urls for the endpoints subscribed to them, as a sink
+// of the outbox, and sends the deliveries that are due
+type Dispatcher struct {
+	le           *logrus.Logger
+	store        Store
+	client       *http.Client
+	maxAttempts  int
+	backoff      time.Duration
+	timeout      time.Duration
+	allowPrivate bool
+}
+
+// Option configures a Dispatcher
+type Option func(*Dispatcher)
+
+// AllowPrivate lets the dispatcher deliver to private and local addresses, for the tests and the
+// endpoints on the internal network
+func AllowPrivate() Option {
+	return func(d *Dispatcher) {
+		d.allowPrivate = true
+	}
+}
+
+// NewDispatcher returns a Dispatcher giving up a delivery after maxAttempts, waiting backoff after
+// the first failure and twice as long after each of the next ones. Every request is bounded by timeout
+func NewDispatcher(le *logrus.Logger, store Store, maxAttempts int, backoff, timeout time.Duration, opts ...Option) *Dispatcher {
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	d := &Dispatcher{
+		le:          le,
+		store:       store,
+		maxAttempts: maxAttempts,
+		backoff:     backoff,
+		timeout:     timeout,
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+
+	d.client = netguard.NewClient(timeout, d.allowPrivate)
+	// a redirect is a failed delivery, the endpoints are registered with their final url
+	d.client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return d
+}
+
+// Name identifies the dispatcher among the sinks of the outbox
+func (d *Dispatcher) Name() string {
+	return "webhooks"
+}
+
+// Publish queues event for the endpoints of its workspace subscribed to it. The event is queued
+// once per endpoint, even when it is published again
+func (d *Dispatcher) Publish(ctx context.Context, event shortener.Event) error {
+	endpoints, err := d.store.ListEndpoints(tenant.WithWorkspace(ctx, event.Workspace))
+	if err != nil {
+		return err
+	}
+
+	var payload []byte
+	now := time.Now().UTC()
+	for _, e := range endpoints {
+		if !e.Subscribed(event.Type) {
+			continue
+		}
+		if payload == nil {
+			if payload, err = json.Marshal(event); err != nil {
+				return err
+			}
+		}
+		delivery := Delivery{
+			Id:            event.Id + "-" + e.Id,
+			EndpointId:    e.Id,
+			Workspace:     e.Workspace,
+			Event:         event.Type,
+			Payload:       payload,
+			Status:        StatusPending,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+		}
+		// the event was queued for the endpoint by a previous publication
+		if err := d.store.InsertDelivery(ctx, delivery); err != nil && err != shortener.ErrDuplicate {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run sends the deliveries that are due, returning the number of deliveries sent and failed.
+// It stops early when ctx is done
+func (d *Dispatcher) Run(ctx context.Context) (int, int, error) {
+	var delivered, failed int
+	for ctx.Err() == nil {
+		// the lease outlasts the request, the delivery is updated before it ends
+		delivery, err := d.store.ClaimDelivery(ctx, time.Now().UTC(), 2*d.timeout+time.Minute)
+		if err == shortener.ErrNotFound {
+			return delivered, failed, nil
+		}
+		if err != nil {
+			return delivered, failed, err
+		}
+
+		d.deliver(ctx, delivery)
+		if err := d.store.UpdateDelivery(ctx, *delivery); err != nil {
+			return delivered, failed, err
+		}
+		if delivery.Status == StatusDelivered {
+			delivered++
+		} else {
+			failed++
+		}
+	}
+	return delivered, failed, ctx.Err()
+}
+
+// Watch sends the due deliveries now and then every interval until ctx is done
+func (d *Dispatcher) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		delivered, failed, err := d.Run(ctx)
+		if err != nil {
+			d.le.WithError(err).Error("webhook deliveries interrupted")
+		} else if delivered+failed > 0 {
+			d.le.Infof("webhooks delivered: %d, failed: %d", delivered, failed)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// deliver sends delivery to its endpoint, recording the outcome and scheduling the next attempt
+func (d *Dispatcher) deliver(ctx context.Context, delivery *Delivery) {
+	now := time.Now().UTC()
+	delivery.Attempts++
+	delivery.LastAttemptAt = &now
+	delivery.LastStatus = 0
+	delivery.LastError = ""
+	le := d.le.WithField("delivery", delivery.Id).WithField("endpoint", delivery.EndpointId)
+
+	endpoint, err := d.store.FindEndpoint(ctx, delivery.EndpointId)
+	switch {
+	case err == shortener.ErrNotFound:
+		// the deliveries of a removed endpoint are not retried
+		le.Info("webhook endpoint removed")
+		delivery.LastError = "endpoint removed"
+		delivery.Status = StatusDead
+		return
+	case err != nil:
+		le.WithError(err).Error("unable to read the webhook endpoint")
+		delivery.LastError = err.Error()
+	default:
+		delivery.LastStatus, err = d.post(ctx, endpoint, delivery)
+		if err != nil {
+			delivery.LastError = err.Error()
+		}
+	}
+
+	if err == nil {
+		delivery.Status = StatusDelivered
+		delivery.DeliveredAt = &now
+		le.Info("webhook delivered")
+		return
+	}
+	if delivery.Attempts >= d.maxAttempts {
+		delivery.Status = StatusDead
+		le.WithError(err).Errorf("webhook dead after %d attempts", delivery.Attempts)
+		return
+	}
+	delivery.NextAttemptAt = now.Add(d.wait(delivery.Attempts))
+	le.WithError(err).Info("webhook failed, retrying")
+}
+
+// post sends the payload of delivery to endpoint, returning the status code of the response
+func (d *Dispatcher) post(ctx context.Context, endpoint *Endpoint, delivery *Delivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.Url, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set(HeaderEvent, delivery.Event)
+	req.Header.Set(HeaderDelivery, delivery.Id)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, Sign(endpoint.Secret, timestamp, delivery.Payload))
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(res.Body, maxResponseBytes))
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("endpoint responded %d", res.StatusCode)
+	}
+	return res.StatusCode, nil
+}
+
+// wait returns the backoff after the attempts of a delivery
+func (d *Dispatcher) wait(attempts int) time.Duration {
+	wait := d.backoff
+	for i := 1; i < attempts && wait < maxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > maxBackoff {
+		wait = maxBackoff
+	}
+	return wait
+}
@@ -0,0 +1,234 @@
+package webhook
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/gsiragusa/short-to-me/shortener"
+	"github.com/gsiragusa/short-to-me/tenant"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func MakeTestDispatcher(t *testing.T, opts ...Option) (*Dispatcher, *MockStore) {
+	log := logrus.New()
+	log.Out = ioutil.Discard // silent logger
+
+	ctrl := gomock.NewController(t)
+	t.Cleanup(ctrl.Finish)
+	store := NewMockStore(ctrl)
+
+	return NewDispatcher(log, store, 3, time.Minute, time.Second, opts...), store
+}
+
+// receiver stands in for the endpoints of the tests, recording the deliveries with a valid signature
+type receiver struct {
+	mu       sync.Mutex
+	secret   string
+	status   int
+	received []*http.Request
+	bodies   []string
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	if !Verify(rc.secret, r.Header.Get(HeaderTimestamp), body, r.Header.Get(HeaderSignature)) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	rc.mu.Lock()
+	rc.received = append(rc.received, r)
+	rc.bodies = append(rc.bodies, string(body))
+	rc.mu.Unlock()
+	w.WriteHeader(rc.status)
+}
+
+func TestSign(t *testing.T) {
+	body := []byte(`{"type":"link.created"}`)
+	signature := Sign("secret", "1600000000", body)
+
+	require.True(t, Verify("secret", "1600000000", body, signature))
+	require.False(t, Verify("other", "1600000000", body, signature))
+	require.False(t, Verify("secret", "1600000001", body, signature))
+	require.False(t, Verify("secret", "1600000000", []byte(`{}`), signature))
+}
+
+func TestDispatcher_Publish(t *testing.T) {
+	dispatcher, store := MakeTestDispatcher(t)
+	ctx := context.Background()
+	event := shortener.Event{
+		Id:        "ev1",
+		Type:      shortener.EventLinkDeleted,
+		Workspace: "team-a",
+		Link:      &shortener.ModelShorten{Id: "RMAp1Vz", Url: "http://www.test.com"},
+	}
+	endpoints := []Endpoint{
+		{Id: "all", Workspace: "team-a"},
+		{Id: "created", Workspace: "team-a", Events: []string{shortener.EventLinkCreated}},
+		{Id: "deleted", Workspace: "team-a", Events: []string{shortener.EventLinkCreated, shortener.EventLinkDeleted}},
+	}
+
+	store.EXPECT().ListEndpoints(gomock.Any()).DoAndReturn(func(ctx context.Context) ([]Endpoint, error) {
+		workspace, _ := tenant.WorkspaceFrom(ctx)
+		require.Equal(t, "team-a", workspace)
+		return endpoints, nil
+	})
+	var deliveries []Delivery
+	store.EXPECT().InsertDelivery(ctx, gomock.Any()).DoAndReturn(func(_ context.Context, d Delivery) error {
+		deliveries = append(deliveries, d)
+		return nil
+	}).Times(2)
+
+	require.Nil(t, dispatcher.Publish(ctx, event))
+	require.Equal(t, "ev1-all", deliveries[0].Id)
+	require.Equal(t, "all", deliveries[0].EndpointId)
+	require.Equal(t, "deleted", deliveries[1].EndpointId)
+	for _, d := range deliveries {
+		require.Equal(t, StatusPending, d.Status)
+		require.Equal(t, "team-a", d.Workspace)
+		require.Equal(t, shortener.EventLinkDeleted, d.Event)
+		require.Contains(t, string(d.Payload), `"id":"ev1"`)
+	}
+
+	// the event published again is not queued twice
+	store.EXPECT().ListEndpoints(gomock.Any()).Return(endpoints, nil)
+	store.EXPECT().InsertDelivery(ctx, gomock.Any()).Return(shortener.ErrDuplicate).Times(2)
+
+	require.Nil(t, dispatcher.Publish(ctx, event))
+}
+
+func TestDispatcher_Run(t *testing.T) {
+	rc := &receiver{secret: "secret", status: http.StatusNoContent}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	dispatcher, store := MakeTestDispatcher(t, AllowPrivate())
+	ctx := context.Background()
+	delivery := &Delivery{Id: "d1", EndpointId: "e1", Event: shortener.EventLinkCreated, Payload: []byte(`{"type":"link.created"}`), Status: StatusPending}
+
+	gomock.InOrder(
+		store.EXPECT().ClaimDelivery(ctx, gomock.Any(), gomock.Any()).Return(delivery, nil),
+		store.EXPECT().FindEndpoint(ctx, "e1").Return(&Endpoint{Id: "e1", Url: server.URL + "/hook", Secret: "secret"}, nil),
+		store.EXPECT().UpdateDelivery(ctx, gomock.Any()).DoAndReturn(func(_ context.Context, d Delivery) error {
+			require.Equal(t, StatusDelivered, d.Status)
+			require.Equal(t, 1, d.Attempts)
+			require.Equal(t, http.StatusNoContent, d.LastStatus)
+			require.NotNil(t, d.DeliveredAt)
+			return nil
+		}),
+		store.EXPECT().ClaimDelivery(ctx, gomock.Any(), gomock.Any()).Return(nil, shortener.ErrNotFound),
+	)
+
+	delivered, failed, err := dispatcher.Run(ctx)
+
+	require.Nil(t, err)
+	require.Equal(t, 1, delivered)
+	require.Equal(t, 0, failed)
+	require.Len(t, rc.received, 1)
+	require.Equal(t, `{"type":"link.created"}`, rc.bodies[0])
+	require.Equal(t, shortener.EventLinkCreated, rc.received[0].Header.Get(HeaderEvent))
+	require.Equal(t, "d1", rc.received[0].Header.Get(HeaderDelivery))
+}
+
+func TestDispatcher_RunRetry(t *testing.T) {
+	rc := &receiver{secret: "secret", status: http.StatusServiceUnavailable}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		attempts int
+		status   string
+		wait     time.Duration
+	}{
+		{name: "first failure", attempts: 0, status: StatusPending, wait: time.Minute},
+		{name: "second failure", attempts: 1, status: StatusPending, wait: 2 * time.Minute},
+		{name: "dead letter", attempts: 2, status: StatusDead},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dispatcher, store := MakeTestDispatcher(t, AllowPrivate())
+			ctx := context.Background()
+			delivery := &Delivery{Id: "d1", EndpointId: "e1", Payload: []byte(`{}`), Status: StatusPending, Attempts: tt.attempts}
+
+			store.EXPECT().ClaimDelivery(ctx, gomock.Any(), gomock.Any()).Return(delivery, nil)
+			store.EXPECT().FindEndpoint(ctx, "e1").Return(&Endpoint{Id: "e1", Url: server.URL, Secret: "secret"}, nil)
+			store.EXPECT().UpdateDelivery(ctx, gomock.Any()).DoAndReturn(func(_ context.Context, d Delivery) error {
+				require.Equal(t, tt.status, d.Status)
+				require.Equal(t, tt.attempts+1, d.Attempts)
+				require.Equal(t, http.StatusServiceUnavailable, d.LastStatus)
+				require.Equal(t, "endpoint responded 503", d.LastError)
+				if tt.status == StatusPending {
+					require.WithinDuration(t, d.LastAttemptAt.Add(tt.wait), d.NextAttemptAt, time.Millisecond)
+				}
+				return nil
+			})
+			store.EXPECT().ClaimDelivery(ctx, gomock.Any(), gomock.Any()).Return(nil, shortener.ErrNotFound)
+
+			delivered, failed, err := dispatcher.Run(ctx)
+
+			require.Nil(t, err)
+			require.Equal(t, 0, delivered)
+			require.Equal(t, 1, failed)
+		})
+	}
+}
+
+func TestDispatcher_RunRemovedEndpoint(t *testing.T) {
+	dispatcher, store := MakeTestDispatcher(t)
+	ctx := context.Background()
+	delivery := &Delivery{Id: "d1", EndpointId: "e1", Status: StatusPending}
+
+	store.EXPECT().ClaimDelivery(ctx, gomock.Any(), gomock.Any()).Return(delivery, nil)
+	store.EXPECT().FindEndpoint(ctx, "e1").Return(nil, shortener.ErrNotFound)
+	store.EXPECT().UpdateDelivery(ctx, gomock.Any()).DoAndReturn(func(_ context.Context, d Delivery) error {
+		require.Equal(t, StatusDead, d.Status)
+		require.Equal(t, "endpoint removed", d.LastError)
+		return nil
+	})
+	store.EXPECT().ClaimDelivery(ctx, gomock.Any(), gomock.Any()).Return(nil, shortener.ErrNotFound)
+
+	_, failed, err := dispatcher.Run(ctx)
+
+	require.Nil(t, err)
+	require.Equal(t, 1, failed)
+}
+
+func TestDispatcher_RunPrivate(t *testing.T) {
+	rc := &receiver{secret: "secret", status: http.StatusOK}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	dispatcher, store := MakeTestDispatcher(t)
+	ctx := context.Background()
+	delivery := &Delivery{Id: "d1", EndpointId: "e1", Payload: []byte(`{}`), Status: StatusPending}
+
+	store.EXPECT().ClaimDelivery(ctx, gomock.Any(), gomock.Any()).Return(delivery, nil)
+	store.EXPECT().FindEndpoint(ctx, "e1").Return(&Endpoint{Id: "e1", Url: server.URL, Secret: "secret"}, nil)
+	store.EXPECT().UpdateDelivery(ctx, gomock.Any()).DoAndReturn(func(_ context.Context, d Delivery) error {
+		require.Equal(t, StatusPending, d.Status)
+		require.Contains(t, d.LastError, "is not public")
+		return nil
+	})
+	store.EXPECT().ClaimDelivery(ctx, gomock.Any(), gomock.Any()).Return(nil, shortener.ErrNotFound)
+
+	_, failed, err := dispatcher.Run(ctx)
+
+	require.Nil(t, err)
+	require.Equal(t, 1, failed)
+	require.Empty(t, rc.received)
+}
+
+func TestDispatcher_Wait(t *testing.T) {
+	dispatcher, _ := MakeTestDispatcher(t)
+
+	require.Equal(t, time.Minute, dispatcher.wait(1))
+	require.Equal(t, 4*time.Minute, dispatcher.wait(3))
+	require.Equal(t, maxBackoff, dispatcher.wait(30))
+}
@@ -0,0 +1,44 @@
+package webhook
+
+import (
+	"context"
+	"time"
+
+	"github.com/gsiragusa/short-to-me/audit"
+	"github.com/gsiragusa/short-to-me/errors"
+)
+
+//go:generate mockgen -source=interfaces.go -destination=interfaces_mock.go -package=webhook
+type Service interface {
+	// CreateEndpoint registers url in the workspace of ctx, returning the endpoint with its secret
+	CreateEndpoint(ctx context.Context, url string, events []string) (*Endpoint, *errors.Error)
+	ListEndpoints(ctx context.Context) ([]Endpoint, *errors.Error)
+	DeleteEndpoint(ctx context.Context, id string) *errors.Error
+	// Deliveries lists the log of the deliveries, from the most recent
+	Deliveries(ctx context.Context, filter Filter) ([]Delivery, *errors.Error)
+	// Redeliver sends a delivery again, whatever its status, resetting its attempts
+	Redeliver(ctx context.Context, id string) (*Delivery, *errors.Error)
+}
+
+// Store keeps the endpoints and the deliveries. The lookups are restricted to the workspace of ctx,
+// if any, and return shortener.ErrNotFound for the missing documents
+type Store interface {
+	InsertEndpoint(ctx context.Context, endpoint Endpoint) error
+	ListEndpoints(ctx context.Context) ([]Endpoint, error)
+	FindEndpoint(ctx context.Context, id string) (*Endpoint, error)
+	DeleteEndpoint(ctx context.Context, id string) error
+	// InsertDelivery queues a delivery, shortener.ErrDuplicate when its id is taken
+	InsertDelivery(ctx context.Context, delivery Delivery) error
+	// ClaimDelivery returns a pending delivery due at now, postponing it by lease so that the other
+	// dispatchers don't send it at the same time
+	ClaimDelivery(ctx context.Context, now time.Time, lease time.Duration) (*Delivery, error)
+	UpdateDelivery(ctx context.Context, delivery Delivery) error
+	FindDeliveries(ctx context.Context, filter Filter) ([]Delivery, error)
+	// RedeliverDelivery makes a delivery pending and due at now, with no attempts
+	RedeliverDelivery(ctx context.Context, id string, now time.Time) (*Delivery, error)
+}
+
+// Auditor records the management of the endpoints and the deliveries in the audit log
+type Auditor interface {
+	InsertAudit(ctx context.Context, entry audit.Entry) error
+}
@@ -0,0 +1,175 @@
+package webhook
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	neturl "net/url"
+	"time"
+
+	"github.com/gsiragusa/short-to-me/audit"
+	"github.com/gsiragusa/short-to-me/errors"
+	"github.com/gsiragusa/short-to-me/shortener"
+	"github.com/gsiragusa/short-to-me/tenant"
+	"github.com/sirupsen/logrus"
+)
+
+// maxEndpoints is the number of endpoints a workspace can register
+const maxEndpoints = 20
+
+var (
+	errorNotFound       = errors.NewErrorNotFound()
+	internalServerError = errors.NewInternalServerError()
+)
+
+type service struct {
+	le      *logrus.Logger
+	store   Store
+	auditor Auditor
+}
+
+// NewService returns the Service managing the endpoints and the deliveries kept by store, recording
+// the changes with auditor
+func NewService(le *logrus.Logger, store Store, auditor Auditor) Service {
+	return &service{le: le, store: store, auditor: auditor}
+}
+
+// service method that registers an endpoint in the workspace of ctx, with a new secret
+func (s *service) CreateEndpoint(ctx context.Context, url string, events []string) (*Endpoint, *errors.Error) {
+	workspace, ok := tenant.WorkspaceFrom(ctx)
+	if !ok {
+		workspace = tenant.Default
+	}
+	le := s.le.WithField("workspace", workspace).WithField("url", url)
+
+	e := errors.NewErrorBadRequest()
+	u, err := neturl.Parse(url)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		e = e.WithDetail("url", "must be an absolute http or https url")
+	}
+	for _, event := range events {
+		if !known(event) {
+			e = e.WithDetail("events", "must be link.created, link.updated, link.deleted, link.expired or link.clicks")
+			break
+		}
+	}
+	if len(e.Details) > 0 {
+		le.Error("invalid webhook endpoint")
+		return nil, &e
+	}
+
+	existing, err := s.store.ListEndpoints(tenant.WithWorkspace(ctx, workspace))
+	if err != nil {
+		le.WithError(err).Error("unable to list the webhook endpoints")
+		return nil, &internalServerError
+	}
+	if len(existing) >= maxEndpoints {
+		le.Error("too many webhook endpoints")
+		e := errors.NewErrorBadRequest().WithDetail("url", "the workspace has too many endpoints")
+		return nil, &e
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		le.WithError(err).Error("unable to generate the webhook secret")
+		return nil, &internalServerError
+	}
+	res := &Endpoint{
+		Id:        audit.NewId(),
+		Workspace: workspace,
+		Url:       url,
+		Events:    events,
+		Secret:    hex.EncodeToString(secret),
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := s.store.InsertEndpoint(ctx, *res); err != nil {
+		le.WithError(err).Error("unable to store the webhook endpoint")
+		return nil, &internalServerError
+	}
+	le.Infof("webhook endpoint created: %s", res.Id)
+	// the secret is not recorded
+	after := *res
+	after.Secret = ""
+	s.record(ctx, audit.ActionCreate, audit.ResourceWebhook, res.Id, workspace, &after)
+	return res, nil
+}
+
+// service method that lists the endpoints of the workspace of ctx, without their secret
+func (s *service) ListEndpoints(ctx context.Context) ([]Endpoint, *errors.Error) {
+	res, err := s.store.ListEndpoints(ctx)
+	if err != nil {
+		s.le.WithError(err).Error("unable to list the webhook endpoints")
+		return nil, &internalServerError
+	}
+	for i := range res {
+		res[i].Secret = ""
+	}
+	return res, nil
+}
+
+// service method that removes an endpoint, its pending deliveries are dead-lettered when they are due
+func (s *service) DeleteEndpoint(ctx context.Context, id string) *errors.Error {
+	le := s.le.WithField("endpoint", id)
+	err := s.store.DeleteEndpoint(ctx, id)
+	if err == shortener.ErrNotFound {
+		le.Error("webhook endpoint was not found")
+		return &errorNotFound
+	}
+	if err != nil {
+		le.WithError(err).Error("unable to delete the webhook endpoint")
+		return &internalServerError
+	}
+	le.Info("webhook endpoint deleted")
+	workspace, _ := tenant.WorkspaceFrom(ctx)
+	s.record(ctx, audit.ActionDelete, audit.ResourceWebhook, id, workspace, nil)
+	return nil
+}
+
+// service method that lists the deliveries of the workspace of ctx
+func (s *service) Deliveries(ctx context.Context, filter Filter) ([]Delivery, *errors.Error) {
+	res, err := s.store.FindDeliveries(ctx, filter)
+	if err != nil {
+		s.le.WithError(err).Error("unable to list the webhook deliveries")
+		return nil, &internalServerError
+	}
+	return res, nil
+}
+
+// service method that schedules a delivery again, the dead ones included
+func (s *service) Redeliver(ctx context.Context, id string) (*Delivery, *errors.Error) {
+	le := s.le.WithField("delivery", id)
+	res, err := s.store.RedeliverDelivery(ctx, id, time.Now().UTC())
+	if err == shortener.ErrNotFound {
+		le.Error("webhook delivery was not found")
+		return nil, &errorNotFound
+	}
+	if err != nil {
+		le.WithError(err).Error("unable to redeliver the webhook")
+		return nil, &internalServerError
+	}
+	le.Info("webhook redelivery scheduled")
+	s.record(ctx, audit.ActionRedeliver, audit.ResourceDelivery, id, res.Workspace, nil)
+	return res, nil
+}
+
+// record appends the entry of an operation on resource id to the audit log, with the endpoint
+// after the operation if any
+func (s *service) record(ctx context.Context, action, resource, id, workspace string, after interface{}) {
+	audit.Record(ctx, s.le, s.auditor, audit.Entry{
+		Action:     action,
+		Workspace:  workspace,
+		Resource:   resource,
+		ResourceId: id,
+		After:      audit.Snapshot(after),
+	})
+}
+
+// known reports whether event is one of the Events
+func known(event string) bool {
+	for _, e := range Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
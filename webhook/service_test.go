@@ -0,0 +1,129 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/gsiragusa/short-to-me/audit"
+	errs "github.com/gsiragusa/short-to-me/errors"
+	"github.com/gsiragusa/short-to-me/shortener"
+	"github.com/gsiragusa/short-to-me/tenant"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func MakeTestService(t *testing.T) (Service, *MockStore, *MockAuditor) {
+	log := logrus.New()
+	log.Out = ioutil.Discard // silent logger
+
+	ctrl := gomock.NewController(t)
+	t.Cleanup(ctrl.Finish)
+	store := NewMockStore(ctrl)
+	auditor := NewMockAuditor(ctrl)
+
+	return NewService(log, store, auditor), store, auditor
+}
+
+func TestService_CreateEndpoint(t *testing.T) {
+	svc, store, auditor := MakeTestService(t)
+	ctx := tenant.WithWorkspace(context.Background(), "team-a")
+
+	var entry audit.Entry
+	store.EXPECT().ListEndpoints(gomock.Any()).Return([]Endpoint{}, nil)
+	store.EXPECT().InsertEndpoint(ctx, gomock.Any()).Return(nil)
+	auditor.EXPECT().InsertAudit(ctx, gomock.Any()).Do(func(_ context.Context, e audit.Entry) { entry = e })
+
+	res, err := svc.CreateEndpoint(ctx, "https://hooks.test.com/stm", []string{shortener.EventLinkCreated})
+
+	require.Nil(t, err)
+	require.Equal(t, "team-a", res.Workspace)
+	require.Equal(t, "https://hooks.test.com/stm", res.Url)
+	require.Equal(t, []string{shortener.EventLinkCreated}, res.Events)
+	require.Len(t, res.Secret, 64)
+	require.NotEmpty(t, res.Id)
+
+	// the creation is recorded without the secret
+	require.Equal(t, audit.ActionCreate, entry.Action)
+	require.Equal(t, audit.ResourceWebhook, entry.Resource)
+	require.Equal(t, res.Id, entry.ResourceId)
+	require.Equal(t, "team-a", entry.Workspace)
+	require.NotContains(t, string(entry.After), res.Secret)
+}
+
+func TestService_CreateEndpointInvalid(t *testing.T) {
+	svc, _, _ := MakeTestService(t)
+	ctx := context.Background()
+
+	_, err := svc.CreateEndpoint(ctx, "ftp://hooks.test.com", []string{"link.visited"})
+
+	expected := errs.NewErrorBadRequest().
+		WithDetail("url", "must be an absolute http or https url").
+		WithDetail("events", "must be link.created, link.updated, link.deleted, link.expired or link.clicks")
+	require.Equal(t, &expected, err)
+}
+
+func TestService_CreateEndpointTooMany(t *testing.T) {
+	svc, store, _ := MakeTestService(t)
+	ctx := context.Background()
+
+	store.EXPECT().ListEndpoints(gomock.Any()).Return(make([]Endpoint, maxEndpoints), nil)
+
+	_, err := svc.CreateEndpoint(ctx, "https://hooks.test.com", nil)
+
+	expected := errs.NewErrorBadRequest().WithDetail("url", "the workspace has too many endpoints")
+	require.Equal(t, &expected, err)
+}
+
+func TestService_ListEndpoints(t *testing.T) {
+	svc, store, _ := MakeTestService(t)
+	ctx := context.Background()
+
+	store.EXPECT().ListEndpoints(ctx).Return([]Endpoint{{Id: "e1", Secret: "secret"}}, nil)
+
+	res, err := svc.ListEndpoints(ctx)
+
+	require.Nil(t, err)
+	require.Equal(t, []Endpoint{{Id: "e1"}}, res)
+}
+
+func TestService_DeleteEndpoint(t *testing.T) {
+	svc, store, auditor := MakeTestService(t)
+	ctx := context.Background()
+
+	store.EXPECT().DeleteEndpoint(ctx, "e1").Return(nil)
+	store.EXPECT().DeleteEndpoint(ctx, "e2").Return(shortener.ErrNotFound)
+	auditor.EXPECT().InsertAudit(ctx, gomock.Any()).Do(func(_ context.Context, e audit.Entry) {
+		require.Equal(t, audit.ActionDelete, e.Action)
+		require.Equal(t, "e1", e.ResourceId)
+	})
+
+	require.Nil(t, svc.DeleteEndpoint(ctx, "e1"))
+	require.Equal(t, &errorNotFound, svc.DeleteEndpoint(ctx, "e2"))
+}
+
+func TestService_Redeliver(t *testing.T) {
+	svc, store, auditor := MakeTestService(t)
+	ctx := context.Background()
+	delivery := &Delivery{Id: "d1", Workspace: "team-a", Status: StatusPending}
+
+	store.EXPECT().RedeliverDelivery(ctx, "d1", gomock.Any()).Return(delivery, nil)
+	store.EXPECT().RedeliverDelivery(ctx, "d2", gomock.Any()).Return(nil, shortener.ErrNotFound)
+	// a failure to record doesn't fail the redelivery
+	auditor.EXPECT().InsertAudit(ctx, gomock.Any()).Do(func(_ context.Context, e audit.Entry) {
+		require.Equal(t, audit.ActionRedeliver, e.Action)
+		require.Equal(t, audit.ResourceDelivery, e.Resource)
+		require.Equal(t, "team-a", e.Workspace)
+	}).Return(errors.New("unavailable"))
+
+	res, err := svc.Redeliver(ctx, "d1")
+
+	require.Nil(t, err)
+	require.Equal(t, delivery, res)
+
+	_, err = svc.Redeliver(ctx, "d2")
+
+	require.Equal(t, &errorNotFound, err)
+}
@@ -0,0 +1,109 @@
+// Package webhook delivers the events of the short urls to the endpoints registered by the
+// workspaces. The deliveries are signed with the secret of the endpoint, retried with an exponential
+// backoff and dead-lettered after too many failures, and they are kept in a log to be redelivered
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/gsiragusa/short-to-me/shortener"
+)
+
+// Headers of the deliveries
+const (
+	HeaderEvent     = "X-Stm-Event"
+	HeaderDelivery  = "X-Stm-Delivery"
+	HeaderTimestamp = "X-Stm-Timestamp"
+	// HeaderSignature is sha256= followed by the hex HMAC-SHA256 of the timestamp, a dot and the body,
+	// keyed by the secret of the endpoint
+	HeaderSignature = "X-Stm-Signature"
+)
+
+// Statuses of the deliveries
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	// StatusDead is a delivery given up after too many failures, until it is redelivered
+	StatusDead = "dead"
+)
+
+// Events are the events the endpoints can subscribe to
+var Events = []string{
+	shortener.EventLinkCreated,
+	shortener.EventLinkUpdated,
+	shortener.EventLinkDeleted,
+	shortener.EventLinkExpired,
+	shortener.EventLinkClicks,
+	shortener.EventLinkClicked,
+}
+
+// Endpoint is an url of a workspace receiving its events
+type Endpoint struct {
+	Id        string `json:"id" bson:"_id"`
+	Workspace string `json:"workspace" bson:"workspace"`
+	Url       string `json:"url" bson:"url"`
+	// Events are the events sent to the endpoint, all of them when empty
+	Events []string `json:"events,omitempty" bson:"events,omitempty"`
+	// Secret signs the deliveries, it is only returned when the endpoint is created
+	Secret    string    `json:"secret,omitempty" bson:"secret"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+// Subscribed reports whether the endpoint receives the events of type event
+func (e *Endpoint) Subscribed(event string) bool {
+	if len(e.Events) == 0 {
+		return true
+	}
+	for _, ev := range e.Events {
+		if ev == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Delivery is an event sent to an endpoint, with the outcome of its last attempt
+type Delivery struct {
+	Id         string `json:"id" bson:"_id"`
+	EndpointId string `json:"endpoint_id" bson:"endpoint_id"`
+	Workspace  string `json:"workspace" bson:"workspace"`
+	Event      string `json:"event" bson:"event"`
+	// Payload is the body of the requests, the event encoded in json
+	Payload       json.RawMessage `json:"payload" bson:"payload"`
+	Status        string          `json:"status" bson:"status"`
+	Attempts      int             `json:"attempts" bson:"attempts"`
+	NextAttemptAt time.Time       `json:"next_attempt_at" bson:"next_attempt_at"`
+	LastAttemptAt *time.Time      `json:"last_attempt_at,omitempty" bson:"last_attempt_at,omitempty"`
+	// LastStatus is the status code of the last response, LastError why the last attempt failed
+	LastStatus  int        `json:"last_status,omitempty" bson:"last_status,omitempty"`
+	LastError   string     `json:"last_error,omitempty" bson:"last_error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at" bson:"created_at"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty" bson:"delivered_at,omitempty"`
+}
+
+// Filter selects the deliveries of the log
+type Filter struct {
+	EndpointId string
+	Status     string
+	Offset     int64
+	Limit      int64
+}
+
+// Sign returns the signature of the body sent at timestamp, as in the HeaderSignature header
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature is the signature of the body sent at timestamp. The receivers
+// should also refuse the old timestamps, so that the deliveries can't be replayed
+func Verify(secret, timestamp string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}